@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/safe-waters/retro-simply/backend/pkg/auth"
+	"github.com/safe-waters/retro-simply/backend/pkg/broker"
+	"github.com/safe-waters/retro-simply/backend/pkg/data"
+	"github.com/safe-waters/retro-simply/backend/pkg/handlers"
+	"github.com/safe-waters/retro-simply/backend/pkg/middleware"
+	"github.com/safe-waters/retro-simply/backend/pkg/static"
+	"github.com/safe-waters/retro-simply/backend/pkg/store"
+	"go.opentelemetry.io/otel"
+)
+
+var tr = otel.Tracer("cmd/allinone")
+
+const shutdownTimeout = 25 * time.Second
+
+func getEnvStr(k, def string) string {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+
+	return v
+}
+
+func getEnvInt(k string, def int) int {
+	vs := os.Getenv(k)
+	if vs == "" {
+		return def
+	}
+
+	v, err := strconv.Atoi(vs)
+	if err != nil {
+		log.Fatalf("'%s' environment variable cannot be parsed to an integer", k)
+	}
+
+	return v
+}
+
+func getEnvDuration(k string, def time.Duration) time.Duration {
+	vs := os.Getenv(k)
+	if vs == "" {
+		return def
+	}
+
+	v, err := time.ParseDuration(vs)
+	if err != nil {
+		log.Fatalf("'%s' environment variable cannot be parsed to a duration", k)
+	}
+
+	return v
+}
+
+func mustNewBackend() store.Backend {
+	switch b := getEnvStr("DATA_STORE_BACKEND", store.BackendFile); b {
+	case store.BackendMemory:
+		return store.NewMemory()
+	case store.BackendFile:
+		f, err := store.NewFile(getEnvStr("DATA_STORE_PATH", "retro-simply.db"))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return f
+	default:
+		log.Fatalf("unknown data store backend '%s'", b)
+	}
+
+	return nil
+}
+
+func mustNewBroker() *broker.Memory {
+	p, err := broker.ParseSlowConsumerPolicy(
+		getEnvStr("BROKER_SLOW_CONSUMER_POLICY", "drop"),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return broker.NewMemory(getEnvInt("BROKER_BUFFER", 256), p)
+}
+
+// mustGetSecret returns the secret of the default key. Without SECRET, a
+// random one is used, so tokens signed with it do not survive restarts.
+func mustGetSecret() []byte {
+	if s := os.Getenv("SECRET"); s != "" {
+		return []byte(s)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Print("SECRET not set, using a random secret")
+
+	return []byte(hex.EncodeToString(b))
+}
+
+// mustNewJWT returns the JWT signer. JWT_KEYS adds keys from files, and
+// JWT_SIGNING_KEY picks the one that signs tokens, which is the default
+// key made from SECRET unless it is set.
+func mustNewJWT() *auth.JWT {
+	ks, err := auth.LoadKeys(os.Getenv("JWT_KEYS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	signing := getEnvStr("JWT_SIGNING_KEY", auth.DefaultKeyId)
+
+	if os.Getenv("SECRET") != "" || signing == auth.DefaultKeyId {
+		ks = append(ks, auth.NewHMACKey(auth.DefaultKeyId, mustGetSecret()))
+	}
+
+	kr, err := auth.NewKeyring(signing, ks...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return auth.NewKeyringJWT(kr)
+}
+
+func applyMiddleware(
+	h http.Handler,
+	mwfs ...func(next http.Handler) http.Handler,
+) http.Handler {
+	for i := len(mwfs) - 1; i >= 0; i-- {
+		h = mwfs[i](h)
+	}
+
+	return h
+}
+
+// direct does the work of the worker as soon as a client sends a message,
+// instead of queueing it, since there is only one process. Like the
+// worker, it broadcasts what was stored, and drops messages that cannot
+// be stored rather than disconnecting their client.
+type direct struct {
+	s *store.S
+	b *broker.Memory
+}
+
+func (d *direct) Publish(ctx context.Context, _ string, st *data.State) error {
+	ctx, span := tr.Start(ctx, "allinone store state")
+	defer span.End()
+
+	ms, rs, err := d.s.StoreState(ctx, st)
+	if err != nil {
+		span.RecordError(err)
+		return nil
+	}
+
+	if err := d.b.Publish(ctx, ms.RoomId, ms); err != nil {
+		span.RecordError(err)
+	}
+
+	for _, r := range rs {
+		if err := d.b.PublishRejection(ctx, r.RoomId, r); err != nil {
+			span.RecordError(err)
+		}
+	}
+
+	return nil
+}
+
+func (d *direct) PublishOperation(
+	ctx context.Context,
+	_ string,
+	op *data.Operation,
+) error {
+	ctx, span := tr.Start(ctx, "allinone apply operation")
+	defer span.End()
+
+	if _, err := d.s.ApplyOperation(ctx, op); err != nil {
+		span.RecordError(err)
+
+		switch err.(type) {
+		case data.VoteRejectedError, data.ForbiddenError:
+			if err := d.b.PublishRejection(ctx, op.RoomId, op.Reject(err)); err != nil {
+				span.RecordError(err)
+			}
+		}
+
+		return nil
+	}
+
+	if err := d.b.PublishOperation(ctx, op.RoomId, op); err != nil {
+		span.RecordError(err)
+	}
+
+	return nil
+}
+
+// The all in one mode runs the api, the work of the worker and the
+// frontend in one process, with an in process broker and an embedded
+// store, so nothing else has to run. Traces are not exported.
+func main() {
+	var (
+		port      = getEnvStr("PORT", "8080")
+		version   = getEnvStr("VERSION", "v1")
+		staticDir = getEnvStr("STATIC_DIR", "../frontend/dist")
+	)
+
+	d := mustNewBackend()
+	if c, ok := d.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	s := store.New(d, store.Retention{
+		Idle:   getEnvDuration("RETENTION_IDLE", 30*24*time.Hour),
+		MaxAge: getEnvDuration("RETENTION_MAX_AGE", 0),
+	})
+	b := mustNewBroker()
+
+	j := mustNewJWT()
+	pm := auth.NewPasswordManager()
+
+	fh, err := static.New(os.DirFS(staticDir))
+	if err != nil {
+		log.Fatalf(
+			"cannot serve the frontend from '%s', build it or set STATIC_DIR: %v",
+			staticDir,
+			err,
+		)
+	}
+
+	apiRoute := fmt.Sprintf("/api/%s", version)
+	regRoute := fmt.Sprintf("%s/registration/", apiRoute)
+	retRoute := fmt.Sprintf("%s/retrospectives/", apiRoute)
+	expRoute := fmt.Sprintf("%s/expiry/", apiRoute)
+	preRoute := fmt.Sprintf("%s/presence/", apiRoute)
+	actRoute := fmt.Sprintf("%s/actions/", apiRoute)
+
+	// Behind a reverse proxy, clients are told apart by the address it
+	// forwards.
+	lim := middleware.NewLimiter(
+		store.NewLimiter(d, store.DefaultAddrLimit),
+		store.NewLimiter(d, store.DefaultRoomLimit),
+		os.Getenv("TRUST_PROXY") == "true",
+	)
+
+	reg := applyMiddleware(
+		handlers.NewRegistration(
+			regRoute,
+			s,
+			j,
+			pm,
+			b,
+			lim,
+		),
+		middleware.MethodTypeFunc(http.MethodPost),
+		lim.Func,
+		middleware.JSONContentTypeFunc,
+	)
+
+	rt := handlers.NewRetrospective(
+		s,
+		s,
+		b,
+		&direct{s: s, b: b},
+		"",
+	)
+
+	ret := applyMiddleware(
+		rt,
+		middleware.MethodTypeFunc(http.MethodGet),
+		middleware.AuthFunc(j, s, retRoute),
+	)
+
+	exp := applyMiddleware(
+		handlers.NewExpiry(s),
+		middleware.MethodTypeFunc(http.MethodGet),
+		middleware.AuthFunc(j, s, expRoute),
+		middleware.JSONContentTypeFunc,
+	)
+
+	pre := applyMiddleware(
+		handlers.NewPresence(s),
+		middleware.MethodTypeFunc(http.MethodGet),
+		middleware.AuthFunc(j, s, preRoute),
+		middleware.JSONContentTypeFunc,
+	)
+
+	act := applyMiddleware(
+		handlers.NewActions(s),
+		middleware.AuthFunc(j, s, actRoute),
+		middleware.JSONContentTypeFunc,
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle(regRoute, reg)
+	mux.Handle(retRoute, ret)
+	mux.Handle(expRoute, exp)
+	mux.Handle(preRoute, pre)
+	mux.Handle(actRoute, act)
+	mux.Handle("/", fh)
+
+	srv := &http.Server{Addr: fmt.Sprintf(":%s", port), Handler: mux}
+
+	ctx, stop := signal.NotifyContext(
+		context.Background(),
+		os.Interrupt,
+		syscall.SIGTERM,
+	)
+	defer stop()
+
+	go s.SweepEvery(ctx, getEnvDuration("SWEEP_INTERVAL", time.Hour))
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	log.Printf("serving retro simply on http://localhost:%s", port)
+
+	select {
+	case err := <-errCh:
+		if err != http.ErrServerClosed {
+			log.Printf("server stopped: %v", err)
+		}
+	case <-ctx.Done():
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("failed to shut down server: %v", err)
+	}
+
+	if err := rt.Shutdown(ctx); err != nil {
+		log.Printf("failed to close clients: %v", err)
+	}
+}
@@ -3,9 +3,13 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/safe-waters/retro-simply/backend/pkg/auth"
@@ -13,11 +17,16 @@ import (
 	"github.com/safe-waters/retro-simply/backend/pkg/client"
 	"github.com/safe-waters/retro-simply/backend/pkg/handlers"
 	"github.com/safe-waters/retro-simply/backend/pkg/middleware"
+	"github.com/safe-waters/retro-simply/backend/pkg/queue"
 	"github.com/safe-waters/retro-simply/backend/pkg/store"
 	"github.com/safe-waters/retro-simply/backend/pkg/tracer_provider"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
+// shutdownTimeout must be shorter than the grace period of the container,
+// so clients are closed before the process is killed.
+const shutdownTimeout = 25 * time.Second
+
 func mustGetEnvStr(k string) string {
 	v := os.Getenv(k)
 	if v == "" {
@@ -43,6 +52,22 @@ func mustGetEnvInt(k string) int {
 	return v
 }
 
+func mustGetEnvDuration(k string) time.Duration {
+	vs := mustGetEnvStr(k)
+
+	v, err := time.ParseDuration(vs)
+	if err != nil {
+		panic(
+			fmt.Sprintf(
+				"'%s' environment variable cannot be parsed to a duration",
+				k,
+			),
+		)
+	}
+
+	return v
+}
+
 func mustNewRedisClient(url string, poolSize int) *client.C {
 	c, err := client.New(url, poolSize)
 	if err != nil {
@@ -66,6 +91,62 @@ loop:
 	return c
 }
 
+// mustNewBackend returns the data store backend selected by
+// DATA_STORE_BACKEND. The api and the worker share the data store, so only
+// the redis backend can be selected - the memory and file backends are
+// only for the allinone binary.
+func mustNewBackend() store.Backend {
+	switch b := mustGetEnvStr("DATA_STORE_BACKEND"); b {
+	case store.BackendRedis:
+		return store.NewRedis(
+			mustNewRedisClient(
+				mustGetEnvStr("DATA_STORE_URL"),
+				mustGetEnvInt("DATA_STORE_POOL_SIZE"),
+			),
+		)
+	case store.BackendMemory, store.BackendFile:
+		panic(
+			fmt.Sprintf(
+				"data store backend '%s' cannot be shared between processes, "+
+					"use allinone",
+				b,
+			),
+		)
+	default:
+		panic(fmt.Sprintf("unknown data store backend '%s'", b))
+	}
+}
+
+// mustNewJWT returns the JWT signer. JWT_KEYS adds keys from files, and
+// JWT_SIGNING_KEY picks the one that signs tokens, which is the default
+// key made from SECRET unless it is set. Keys are rotated by adding the
+// new key to every instance before signing with it.
+func mustNewJWT() *auth.JWT {
+	ks, err := auth.LoadKeys(os.Getenv("JWT_KEYS"))
+	if err != nil {
+		panic(err)
+	}
+
+	signing := os.Getenv("JWT_SIGNING_KEY")
+	if signing == "" {
+		signing = auth.DefaultKeyId
+	}
+
+	if os.Getenv("SECRET") != "" || signing == auth.DefaultKeyId {
+		ks = append(
+			ks,
+			auth.NewHMACKey(auth.DefaultKeyId, []byte(mustGetEnvStr("SECRET"))),
+		)
+	}
+
+	kr, err := auth.NewKeyring(signing, ks...)
+	if err != nil {
+		panic(err)
+	}
+
+	return auth.NewKeyringJWT(kr)
+}
+
 func applyMiddleware(
 	h http.Handler,
 	mwfs ...func(next http.Handler) http.Handler,
@@ -80,31 +161,48 @@ func applyMiddleware(
 func main() {
 	var (
 		otelURL = mustGetEnvStr("OTEL_AGENT_URL")
-		dURL    = mustGetEnvStr("DATA_STORE_URL")
 		bURL    = mustGetEnvStr("BROKER_URL")
 		qURL    = mustGetEnvStr("QUEUE_URL")
 		port    = mustGetEnvStr("PORT")
 		version = mustGetEnvStr("VERSION")
-		secret  = mustGetEnvStr("SECRET")
-		dPool   = mustGetEnvInt("DATA_STORE_POOL_SIZE")
 		bPool   = mustGetEnvInt("BROKER_POOL_SIZE")
 		qPool   = mustGetEnvInt("QUEUE_POOL_SIZE")
 		qKey    = mustGetEnvStr("QUEUE_KEY")
+		qGroup  = mustGetEnvStr("QUEUE_GROUP")
 	)
 
 	shutdown := tracer_provider.Initialize(otelURL, "api")
 	defer shutdown()
 
-	s := store.New(mustNewRedisClient(dURL, dPool))
+	d := mustNewBackend()
+	if c, ok := d.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	s := store.New(d, store.Retention{
+		Idle:   mustGetEnvDuration("RETENTION_IDLE"),
+		MaxAge: mustGetEnvDuration("RETENTION_MAX_AGE"),
+	})
 	b := broker.New(mustNewRedisClient(bURL, bPool))
-	q := broker.New(mustNewRedisClient(qURL, qPool))
+	q := queue.New(mustNewRedisClient(qURL, qPool), qGroup, "api")
 
-	j := auth.NewJWT([]byte(secret))
+	j := mustNewJWT()
 	pm := auth.NewPasswordManager()
 
 	apiRoute := fmt.Sprintf("/api/%s", version)
 	regRoute := fmt.Sprintf("%s/registration/", apiRoute)
 	retRoute := fmt.Sprintf("%s/retrospectives/", apiRoute)
+	expRoute := fmt.Sprintf("%s/expiry/", apiRoute)
+	preRoute := fmt.Sprintf("%s/presence/", apiRoute)
+	actRoute := fmt.Sprintf("%s/actions/", apiRoute)
+
+	// Behind a reverse proxy, clients are told apart by the address it
+	// forwards.
+	lim := middleware.NewLimiter(
+		store.NewLimiter(d, store.DefaultAddrLimit),
+		store.NewLimiter(d, store.DefaultRoomLimit),
+		os.Getenv("TRUST_PROXY") == "true",
+	)
 
 	reg := applyMiddleware(
 		handlers.NewRegistration(
@@ -112,24 +210,88 @@ func main() {
 			s,
 			j,
 			pm,
+			b,
+			lim,
 		),
 		middleware.MethodTypeFunc(http.MethodPost),
+		lim.Func,
 		middleware.JSONContentTypeFunc,
 	)
 
+	rt := handlers.NewRetrospective(
+		s,
+		s,
+		b,
+		q,
+		qKey,
+	)
+
 	ret := applyMiddleware(
-		handlers.NewRetrospective(
-			s,
-			b,
-			q,
-			qKey,
-		),
+		rt,
 		middleware.MethodTypeFunc(http.MethodGet),
-		middleware.AuthFunc(j, retRoute),
+		middleware.AuthFunc(j, s, retRoute),
 	)
 
-	http.Handle(regRoute, otelhttp.NewHandler(reg, regRoute))
-	http.Handle(retRoute, otelhttp.NewHandler(ret, retRoute))
+	exp := applyMiddleware(
+		handlers.NewExpiry(s),
+		middleware.MethodTypeFunc(http.MethodGet),
+		middleware.AuthFunc(j, s, expRoute),
+		middleware.JSONContentTypeFunc,
+	)
 
-	http.ListenAndServe(fmt.Sprintf(":%s", port), nil)
+	pre := applyMiddleware(
+		handlers.NewPresence(s),
+		middleware.MethodTypeFunc(http.MethodGet),
+		middleware.AuthFunc(j, s, preRoute),
+		middleware.JSONContentTypeFunc,
+	)
+
+	act := applyMiddleware(
+		handlers.NewActions(s),
+		middleware.AuthFunc(j, s, actRoute),
+		middleware.JSONContentTypeFunc,
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle(regRoute, otelhttp.NewHandler(reg, regRoute))
+	mux.Handle(retRoute, otelhttp.NewHandler(ret, retRoute))
+	mux.Handle(expRoute, otelhttp.NewHandler(exp, expRoute))
+	mux.Handle(preRoute, otelhttp.NewHandler(pre, preRoute))
+	mux.Handle(actRoute, otelhttp.NewHandler(act, actRoute))
+
+	srv := &http.Server{Addr: fmt.Sprintf(":%s", port), Handler: mux}
+
+	ctx, stop := signal.NotifyContext(
+		context.Background(),
+		os.Interrupt,
+		syscall.SIGTERM,
+	)
+	defer stop()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != http.ErrServerClosed {
+			log.Printf("server stopped: %v", err)
+		}
+	case <-ctx.Done():
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Stop accepting connections first, then close the websockets, which
+	// the http server does not track once they are hijacked.
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("failed to shut down server: %v", err)
+	}
+
+	if err := rt.Shutdown(ctx); err != nil {
+		log.Printf("failed to close clients: %v", err)
+	}
 }
@@ -216,7 +216,10 @@ func main() {
 							for {
 								<-writeTicker.C
 
-								if err := c.WriteJSON(stateToSend); err != nil {
+								if err := c.WriteJSON(&data.Message{
+									Type:  data.MessageState,
+									State: &stateToSend,
+								}); err != nil {
 									fmt.Println("err writing: ", err)
 									return
 								}
@@ -224,11 +227,11 @@ func main() {
 						}()
 
 						go func() {
-							var stateToReceive data.State
+							var messageToReceive data.Message
 
 							for {
 								if err := c.ReadJSON(
-									&stateToReceive,
+									&messageToReceive,
 								); err != nil {
 									fmt.Println("err reading: ", err)
 									return
@@ -2,22 +2,38 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/safe-waters/retro-simply/backend/pkg/broker"
 	"github.com/safe-waters/retro-simply/backend/pkg/client"
 	"github.com/safe-waters/retro-simply/backend/pkg/data"
+	"github.com/safe-waters/retro-simply/backend/pkg/pool"
+	"github.com/safe-waters/retro-simply/backend/pkg/queue"
 	"github.com/safe-waters/retro-simply/backend/pkg/store"
 	"github.com/safe-waters/retro-simply/backend/pkg/tracer_provider"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var tr = otel.Tracer("cmd/worker")
 
+// shutdownTimeout bounds how long in-flight merges are drained on shutdown.
+// It must be shorter than the grace period of the container. Deliveries
+// that are not handled in time stay unacknowledged and are delivered again.
+const shutdownTimeout = 25 * time.Second
+
 func mustGetEnvStr(k string) string {
 	v := os.Getenv(k)
 	if v == "" {
@@ -43,6 +59,22 @@ func mustGetEnvInt(k string) int {
 	return v
 }
 
+func mustGetEnvDuration(k string) time.Duration {
+	vs := mustGetEnvStr(k)
+
+	v, err := time.ParseDuration(vs)
+	if err != nil {
+		panic(
+			fmt.Sprintf(
+				"'%s' environment variable cannot be parsed to a duration",
+				k,
+			),
+		)
+	}
+
+	return v
+}
+
 func mustNewRedisClient(url string, poolSize int) *client.C {
 	c, err := client.New(url, poolSize)
 	if err != nil {
@@ -66,8 +98,106 @@ loop:
 	return c
 }
 
-func storeState(ctx context.Context, st *data.State, s *store.S) {
-	ctx, span := tr.Start(ctx, "worker store state")
+// mustNewBackend returns the data store backend selected by
+// DATA_STORE_BACKEND. The api and the worker share the data store, so only
+// the redis backend can be selected - the memory and file backends are
+// only for the allinone binary.
+func mustNewBackend() store.Backend {
+	switch b := mustGetEnvStr("DATA_STORE_BACKEND"); b {
+	case store.BackendRedis:
+		return store.NewRedis(
+			mustNewRedisClient(
+				mustGetEnvStr("DATA_STORE_URL"),
+				mustGetEnvInt("DATA_STORE_POOL_SIZE"),
+			),
+		)
+	case store.BackendMemory, store.BackendFile:
+		panic(
+			fmt.Sprintf(
+				"data store backend '%s' cannot be shared between processes, "+
+					"use allinone",
+				b,
+			),
+		)
+	default:
+		panic(fmt.Sprintf("unknown data store backend '%s'", b))
+	}
+}
+
+func storeStates(
+	ctx context.Context,
+	sts []*data.State,
+	s *store.S,
+	b *broker.B,
+) error {
+	ctx, span := tr.Start(ctx, "worker store states")
+	defer span.End()
+
+	ms, rs, err := s.StoreStates(ctx, sts...)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	// Broadcast the merged state rather than the states sent by clients,
+	// so every client converges on what was stored.
+	if err := b.Publish(ctx, ms.RoomId, ms); err != nil {
+		span.RecordError(err)
+	}
+
+	for _, r := range rs {
+		if err := b.PublishRejection(ctx, r.RoomId, r); err != nil {
+			span.RecordError(err)
+		}
+	}
+
+	return nil
+}
+
+func applyOperation(
+	ctx context.Context,
+	op *data.Operation,
+	s *store.S,
+	b *broker.B,
+) error {
+	ctx, span := tr.Start(ctx, "worker apply operation")
+	defer span.End()
+
+	if _, err := s.ApplyOperation(ctx, op); err != nil {
+		span.RecordError(err)
+
+		// A rejected vote or a forbidden operation is told to its
+		// author, and there is nothing left to retry.
+		switch err.(type) {
+		case data.VoteRejectedError, data.ForbiddenError:
+			if err := b.PublishRejection(ctx, op.RoomId, op.Reject(err)); err != nil {
+				span.RecordError(err)
+			}
+
+			return nil
+		}
+
+		return err
+	}
+
+	// Only operations that were applied are broadcast, with the revision
+	// of the stored state that includes them.
+	if err := b.PublishOperation(ctx, op.RoomId, op); err != nil {
+		span.RecordError(err)
+	}
+
+	return nil
+}
+
+// handle stores a batch of deliveries for the same room and acknowledges
+// them. Batches that fail for a transient reason are left unacknowledged,
+// so they are delivered again - deliveries that can never succeed are dead
+// lettered instead.
+func handle(ctx context.Context, ds []*queue.Delivery, s *store.S, b *broker.B) {
+	var pr propagation.TraceContext
+	ctx = pr.Extract(ctx, propagation.HeaderCarrier(ds[0].Header))
+
+	ctx, span := tr.Start(ctx, "worker handle")
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 
 	defer func() {
@@ -75,40 +205,194 @@ func storeState(ctx context.Context, st *data.State, s *store.S) {
 		span.End()
 	}()
 
-	_, err := s.StoreState(ctx, st)
+	err := process(ctx, ds, s, b)
+
+	// A coalesced batch is stored in a single transaction, so one state
+	// that can never be stored fails the states of every other client in
+	// it. The batch is stored again one state at a time, so only that
+	// state is dead lettered.
+	if len(ds) > 1 && permanent(err) {
+		for _, d := range ds {
+			settle(ctx, []*queue.Delivery{d}, process(ctx, []*queue.Delivery{d}, s, b))
+		}
+
+		return
+	}
+
+	settle(ctx, ds, err)
+}
+
+// process applies the operation or stores the states of the deliveries.
+func process(ctx context.Context, ds []*queue.Delivery, s *store.S, b *broker.B) error {
+	// Deliveries are handled at least once, so the store is told which
+	// queue entry each comes from to only store it once.
+	switch {
+	case ds[0].Operation != nil:
+		ds[0].Operation.DeliveryId = ds[0].Id
+		return applyOperation(ctx, ds[0].Operation, s, b)
+	case ds[0].State != nil:
+		sts := make([]*data.State, 0, len(ds))
+		for _, d := range ds {
+			d.State.DeliveryId = d.Id
+			sts = append(sts, d.State)
+		}
+
+		return storeStates(ctx, sts, s, b)
+	default:
+		return data.OperationInvalidError{Err: errors.New("empty message")}
+	}
+}
+
+// permanent returns whether handling the deliveries again can never
+// succeed.
+func permanent(err error) bool {
+	switch err.(type) {
+	case data.OperationInvalidError,
+		data.TemplateInvalidError,
+		store.RoomExpiredError,
+		store.DataDoesNotExistError:
+		return true
+	}
+
+	return false
+}
+
+// settle acknowledges, dead letters or releases the deliveries, depending
+// on the error they were handled with.
+func settle(ctx context.Context, ds []*queue.Delivery, err error) {
+	span := trace.SpanFromContext(ctx)
+
+	for _, d := range ds {
+		switch {
+		case err == nil, isAlreadyStored(err):
+			if err := d.Ack(ctx); err != nil {
+				span.RecordError(err)
+			}
+		case permanent(err):
+			if err := d.DeadLetter(ctx, err); err != nil {
+				span.RecordError(err)
+			}
+		default:
+			span.RecordError(err)
+			d.Release()
+		}
+	}
+}
+
+func isAlreadyStored(err error) bool {
+	_, ok := err.(store.AlreadyStoredError)
+	return ok
+}
+
+type stats struct {
+	pool.Stats
+	StoreRetries uint64 `json:"storeRetries"`
+}
+
+func serveStats(p *pool.P, s *store.S) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		_ = json.NewEncoder(w).Encode(
+			&stats{Stats: p.Stats(), StoreRetries: s.Retries()},
+		)
+	}
+}
+
+func consumerName() string {
+	h, err := os.Hostname()
 	if err != nil {
-		span.RecordError(err)
+		return uuid.New().String()
 	}
+
+	return h
 }
 
 func main() {
 	var (
 		otelURL = mustGetEnvStr("OTEL_AGENT_URL")
-		dURL    = mustGetEnvStr("DATA_STORE_URL")
-		dPool   = mustGetEnvInt("DATA_STORE_POOL_SIZE")
+		bURL    = mustGetEnvStr("BROKER_URL")
+		bPool   = mustGetEnvInt("BROKER_POOL_SIZE")
 		qURL    = mustGetEnvStr("QUEUE_URL")
 		qPool   = mustGetEnvInt("QUEUE_POOL_SIZE")
 		qKey    = mustGetEnvStr("QUEUE_KEY")
+		qGroup  = mustGetEnvStr("QUEUE_GROUP")
+		size    = mustGetEnvInt("POOL_SIZE")
+		sweep   = mustGetEnvDuration("SWEEP_INTERVAL")
+		mPort   = mustGetEnvStr("METRICS_PORT")
 	)
 
 	shutdown := tracer_provider.Initialize(otelURL, "worker")
 	defer shutdown()
 
-	q := broker.New(mustNewRedisClient(qURL, qPool))
-	s := store.New(mustNewRedisClient(dURL, dPool))
+	q := queue.New(mustNewRedisClient(qURL, qPool), qGroup, consumerName())
+	d := mustNewBackend()
+	if c, ok := d.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	s := store.New(d, store.Retention{
+		Idle:   mustGetEnvDuration("RETENTION_IDLE"),
+		MaxAge: mustGetEnvDuration("RETENTION_MAX_AGE"),
+	})
+	b := broker.New(mustNewRedisClient(bURL, bPool))
+
+	p := pool.New(size, func(ctx context.Context, ds []*queue.Delivery) {
+		handle(ctx, ds, s, b)
+	})
+	// Merges are not tied to the signal, so the ones in flight when it
+	// arrives are finished rather than abandoned.
+	p.Run(context.Background())
+
+	mSrv := &http.Server{
+		Addr:    fmt.Sprintf(":%s", mPort),
+		Handler: serveStats(p, s),
+	}
+
+	go func() {
+		if err := mSrv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Printf("metrics server stopped: %v", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(
+		context.Background(),
+		os.Interrupt,
+		syscall.SIGTERM,
+	)
+	defer stop()
+
+	go s.SweepEvery(ctx, sweep)
 
-	msgs, err := q.Subscribe(context.Background(), qKey)
+	// The deliveries channel closes once the signal is received.
+	ds, err := q.Consume(ctx, qKey)
 	if err != nil {
 		panic(err)
 	}
 
-	for m := range msgs {
-		var pr propagation.TraceContext
-		ctx := pr.Extract(
-			context.Background(),
-			propagation.HeaderCarrier(m.Header),
-		)
+	for d := range ds {
+		if err := p.Submit(ctx, d); err != nil {
+			break
+		}
+	}
 
-		go storeState(ctx, m.State, s)
+	p.Close()
+
+	done := make(chan struct{})
+
+	go func() {
+		p.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		log.Printf("timed out draining pool: %+v", p.Stats())
 	}
+
+	sCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_ = mSrv.Shutdown(sCtx)
 }
@@ -7,6 +7,7 @@ require (
 	github.com/go-redis/redis/v8 v8.8.2
 	github.com/google/uuid v1.2.0
 	github.com/gorilla/websocket v1.4.2
+	go.etcd.io/bbolt v1.3.6
 	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.20.0
 	go.opentelemetry.io/otel v0.20.0
 	go.opentelemetry.io/otel/exporters/otlp v0.20.0
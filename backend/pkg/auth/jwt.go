@@ -13,27 +13,56 @@ import (
 
 var jTr = otel.Tracer("pkg/auth/jwt")
 
-type JWT struct{ secret []byte }
+type JWT struct{ kr *Keyring }
 
-func NewJWT(secret []byte) *JWT { return &JWT{secret: secret} }
+// NewJWT signs and verifies tokens with a single secret.
+func NewJWT(secret []byte) *JWT {
+	kr, _ := NewKeyring(DefaultKeyId, NewHMACKey(DefaultKeyId, secret))
+	return &JWT{kr: kr}
+}
+
+func NewKeyringJWT(kr *Keyring) *JWT { return &JWT{kr: kr} }
 
 type ComparisonClaims struct {
 	RoomId string `json:"roomId"`
+	// Generation is the room's token generation when the token was issued.
+	// Rotating the generation invalidates every token issued before.
+	Generation string `json:"gen,omitempty"`
+}
+
+func NewComparisonClaims(rId, gen string) *ComparisonClaims {
+	return &ComparisonClaims{RoomId: rId, Generation: gen}
+}
+
+// RoleFacilitator is the role of the participant who runs the room.
+const RoleFacilitator = "facilitator"
+
+// Participant is who joined a room with a token. Tokens issued before
+// participants had ids do not have one.
+type Participant struct {
+	ParticipantId string `json:"pid,omitempty"`
+	DisplayName   string `json:"name,omitempty"`
+	// Role is the participant's role when the token was issued. The
+	// facilitator can be transferred afterwards, so permissions are
+	// checked against the room's stored facilitator.
+	Role string `json:"role,omitempty"`
 }
 
-func NewComparisonClaims(rId string) *ComparisonClaims {
-	return &ComparisonClaims{RoomId: rId}
+func NewParticipant(pId, name string) *Participant {
+	return &Participant{ParticipantId: pId, DisplayName: name}
 }
 
 type Claims struct {
 	*ComparisonClaims
 	*jwt.StandardClaims
+	*Participant
 }
 
-func NewClaims(rId string, exp time.Time) *Claims {
+func NewClaims(rId, gen string, p *Participant, exp time.Time) *Claims {
 	return &Claims{
-		ComparisonClaims: &ComparisonClaims{RoomId: rId},
+		ComparisonClaims: NewComparisonClaims(rId, gen),
 		StandardClaims:   &jwt.StandardClaims{ExpiresAt: exp.Unix()},
+		Participant:      p,
 	}
 }
 
@@ -45,8 +74,7 @@ func (j *JWT) SetToken(
 	_, span := jTr.Start(ctx, "auth set token")
 	defer span.End()
 
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, c)
-	signedT, err := t.SignedString(j.secret)
+	signedT, err := j.kr.sign(c)
 	if err != nil {
 		span.RecordError(err)
 		return err
@@ -69,42 +97,30 @@ func (j *JWT) ValidateToken(
 	ctx context.Context,
 	r *http.Request,
 	cc *ComparisonClaims,
-) error {
+) (*Claims, error) {
 	_, span := jTr.Start(ctx, "auth validate token")
 	defer span.End()
 
 	ck, err := r.Cookie("token")
 	if err != nil {
 		span.RecordError(err)
-		return err
+		return nil, err
 	}
 
 	signedCk := ck.Value
 	c := &Claims{}
 
-	t, err := jwt.ParseWithClaims(
-		signedCk,
-		c,
-		func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf(
-					"invalid signing method '%T'", token.Method,
-				)
-			}
-
-			return j.secret, nil
-		},
-	)
+	t, err := jwt.ParseWithClaims(signedCk, c, j.kr.verificationKey)
 	if err != nil {
 		span.RecordError(err)
-		return err
+		return nil, err
 	}
 
 	if !t.Valid {
 		err := errors.New("invalid token")
 		span.RecordError(err)
 
-		return err
+		return nil, err
 	}
 
 	c, ok := t.Claims.(*Claims)
@@ -112,7 +128,7 @@ func (j *JWT) ValidateToken(
 		err := errors.New("invalid claims")
 		span.RecordError(err)
 
-		return err
+		return nil, err
 	}
 
 	if c.RoomId != cc.RoomId {
@@ -123,8 +139,19 @@ func (j *JWT) ValidateToken(
 		)
 		span.RecordError(err)
 
-		return err
+		return nil, err
 	}
 
-	return nil
+	if c.Generation != cc.Generation {
+		err := fmt.Errorf("token for room '%s' was revoked", cc.RoomId)
+		span.RecordError(err)
+
+		return nil, err
+	}
+
+	if c.Participant == nil {
+		c.Participant = &Participant{}
+	}
+
+	return c, nil
 }
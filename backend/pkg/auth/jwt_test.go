@@ -17,6 +17,13 @@ var (
 	expired = time.Now().UTC().Add(time.Hour * -1)
 	secret  = []byte("secret")
 	rId     = "test"
+	gen     = "gen"
+	p       = auth.NewParticipant("pid", "name")
+	f       = &auth.Participant{
+		ParticipantId: "fid",
+		DisplayName:   "name",
+		Role:          auth.RoleFacilitator,
+	}
 )
 
 func TestSetToken(t *testing.T) {
@@ -32,20 +39,88 @@ func TestValidateToken(t *testing.T) {
 	res, j, c := setToken(t, future)
 	expectCookie(t, res, future)
 
-	cc := auth.NewComparisonClaims(c.RoomId)
+	cc := auth.NewComparisonClaims(c.RoomId, c.Generation)
 	ck := res.Result().Cookies()[0]
 	if err := validateToken(t, ck, j, cc); err != nil {
 		t.Fatal(err)
 	}
 }
 
+func TestValidateTokenParticipant(t *testing.T) {
+	t.Parallel()
+
+	j := auth.NewJWT([]byte(secret))
+
+	tests := []struct {
+		Name        string
+		Participant *auth.Participant
+		Expected    auth.Participant
+	}{
+		{Name: "Participant", Participant: p, Expected: *p},
+		{Name: "Facilitator", Participant: f, Expected: *f},
+		// tokens issued before participants had ids are still valid
+		{Name: "Legacy", Participant: nil, Expected: auth.Participant{}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			res := httptest.NewRecorder()
+
+			c := auth.NewClaims(rId, gen, test.Participant, future)
+			if err := j.SetToken(context.Background(), res, c); err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.NewRequest("", "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.AddCookie(res.Result().Cookies()[0])
+
+			got, err := j.ValidateToken(
+				context.Background(),
+				req,
+				c.ComparisonClaims,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if *got.Participant != test.Expected {
+				t.Fatalf(
+					"expected participant %v, got: %v",
+					test.Expected,
+					*got.Participant,
+				)
+			}
+		})
+	}
+}
+
 func TestValidateInvalidComparisonClaims(t *testing.T) {
 	t.Parallel()
 
 	res, j, c := setToken(t, future)
 	expectCookie(t, res, future)
 
-	cc := auth.NewComparisonClaims(fmt.Sprintf("wrong%s", c.RoomId))
+	cc := auth.NewComparisonClaims(fmt.Sprintf("wrong%s", c.RoomId), c.Generation)
+	ck := res.Result().Cookies()[0]
+	if err := validateToken(t, ck, j, cc); err == nil {
+		t.FailNow()
+	}
+}
+
+func TestValidateRevokedGeneration(t *testing.T) {
+	t.Parallel()
+
+	res, j, c := setToken(t, future)
+	expectCookie(t, res, future)
+
+	cc := auth.NewComparisonClaims(c.RoomId, fmt.Sprintf("new%s", c.Generation))
 	ck := res.Result().Cookies()[0]
 	if err := validateToken(t, ck, j, cc); err == nil {
 		t.FailNow()
@@ -58,7 +133,7 @@ func TestValidateInvalidSignature(t *testing.T) {
 	res, j, c := setToken(t, future)
 	expectCookie(t, res, future)
 
-	cc := auth.NewComparisonClaims(c.RoomId)
+	cc := auth.NewComparisonClaims(c.RoomId, c.Generation)
 	ck := res.Result().Cookies()[0]
 
 	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, c)
@@ -79,7 +154,7 @@ func TestValidateExpiredToken(t *testing.T) {
 	res, j, c := setToken(t, expired)
 	expectCookie(t, res, expired)
 
-	cc := auth.NewComparisonClaims(c.RoomId)
+	cc := auth.NewComparisonClaims(c.RoomId, c.Generation)
 	ck := res.Result().Cookies()[0]
 	if err := validateToken(t, ck, j, cc); err == nil {
 		t.FailNow()
@@ -95,7 +170,7 @@ func setToken(
 	j := auth.NewJWT([]byte(secret))
 	res := httptest.NewRecorder()
 
-	c := auth.NewClaims(rId, expiration)
+	c := auth.NewClaims(rId, gen, p, expiration)
 	j.SetToken(context.Background(), res, c)
 
 	return res, j, c
@@ -116,7 +191,9 @@ func validateToken(
 
 	req.AddCookie(cookie)
 
-	return jwtAuth.ValidateToken(context.Background(), req, comparisonClaims)
+	_, err = jwtAuth.ValidateToken(context.Background(), req, comparisonClaims)
+
+	return err
 }
 
 func expectCookie(
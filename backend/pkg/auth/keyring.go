@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// DefaultKeyId is the id of the key made from the SECRET environment
+// variable. Tokens issued before keys had ids do not have a 'kid' header,
+// so they are verified with it.
+const DefaultKeyId = "default"
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Key signs and verifies tokens. Keys loaded from a public key can only
+// verify tokens.
+type Key struct {
+	Id     string
+	Method jwt.SigningMethod
+	sign   interface{}
+	verify interface{}
+}
+
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{
+		Id:     id,
+		Method: jwt.SigningMethodHS256,
+		sign:   secret,
+		verify: secret,
+	}
+}
+
+// CanSign returns whether the key has what it takes to sign tokens.
+func (k *Key) CanSign() bool { return k.sign != nil }
+
+// LoadKey reads a key from a file. HS256 files contain the secret, while
+// RS256 and EdDSA files contain a PEM encoded private key, or a public key
+// to only verify tokens.
+func LoadKey(id, alg, path string) (*Key, error) {
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch alg {
+	case AlgHS256:
+		secret := bytes.TrimSpace(byt)
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("secret of key '%s' is empty", id)
+		}
+
+		return NewHMACKey(id, secret), nil
+	case AlgRS256, AlgEdDSA:
+		return parsePEMKey(id, alg, byt)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm '%s' for key '%s'", alg, id)
+	}
+}
+
+func parsePEMKey(id, alg string, byt []byte) (*Key, error) {
+	b, _ := pem.Decode(byt)
+	if b == nil {
+		return nil, fmt.Errorf("key '%s' is not PEM encoded", id)
+	}
+
+	var (
+		key interface{}
+		err error
+	)
+
+	switch b.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(b.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(b.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(b.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(b.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block '%s'", b.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse key '%s': %w", id, err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if alg == AlgRS256 {
+			return &Key{
+				Id:     id,
+				Method: jwt.SigningMethodRS256,
+				sign:   k,
+				verify: &k.PublicKey,
+			}, nil
+		}
+	case *rsa.PublicKey:
+		if alg == AlgRS256 {
+			return &Key{
+				Id:     id,
+				Method: jwt.SigningMethodRS256,
+				verify: k,
+			}, nil
+		}
+	case ed25519.PrivateKey:
+		if alg == AlgEdDSA {
+			return &Key{
+				Id:     id,
+				Method: SigningMethodEdDSA,
+				sign:   k,
+				verify: k.Public(),
+			}, nil
+		}
+	case ed25519.PublicKey:
+		if alg == AlgEdDSA {
+			return &Key{
+				Id:     id,
+				Method: SigningMethodEdDSA,
+				verify: k,
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf(
+		"key '%s' of type '%T' cannot be used with '%s'",
+		id,
+		key,
+		alg,
+	)
+}
+
+// LoadKeys reads the keys of a comma separated list of 'id:alg:path'.
+func LoadKeys(spec string) ([]*Key, error) {
+	var ks []*Key
+
+	for _, e := range strings.Split(spec, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+
+		parts := strings.SplitN(e, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid key '%s', expected 'id:alg:path'", e)
+		}
+
+		k, err := LoadKey(parts[0], parts[1], parts[2])
+		if err != nil {
+			return nil, err
+		}
+
+		ks = append(ks, k)
+	}
+
+	return ks, nil
+}
+
+// Keyring signs tokens with a single key, and verifies them with the key
+// named by their 'kid' header, so keys can be rotated without invalidating
+// the tokens signed with the previous key.
+type Keyring struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewKeyring returns a keyring that signs with the key named signingId,
+// which must be one of the keys.
+func NewKeyring(signingId string, keys ...*Key) (*Keyring, error) {
+	kr := &Keyring{keys: map[string]*Key{}}
+
+	for _, k := range keys {
+		if k.Id == "" {
+			return nil, errors.New("key id cannot be empty")
+		}
+
+		if _, ok := kr.keys[k.Id]; ok {
+			return nil, fmt.Errorf("duplicate key '%s'", k.Id)
+		}
+
+		kr.keys[k.Id] = k
+	}
+
+	s, ok := kr.keys[signingId]
+	if !ok {
+		return nil, fmt.Errorf("signing key '%s' not found", signingId)
+	}
+
+	if !s.CanSign() {
+		return nil, fmt.Errorf("key '%s' can only verify tokens", signingId)
+	}
+
+	kr.signing = s
+
+	return kr, nil
+}
+
+func (kr *Keyring) sign(c jwt.Claims) (string, error) {
+	t := jwt.NewWithClaims(kr.signing.Method, c)
+	t.Header["kid"] = kr.signing.Id
+
+	return t.SignedString(kr.signing.sign)
+}
+
+func (kr *Keyring) verificationKey(t *jwt.Token) (interface{}, error) {
+	kid := DefaultKeyId
+	if v, ok := t.Header["kid"]; ok {
+		if kid, ok = v.(string); !ok {
+			return nil, fmt.Errorf("invalid key id '%v'", v)
+		}
+	}
+
+	k, ok := kr.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key '%s'", kid)
+	}
+
+	// The algorithm is checked against the key's, so a token cannot pick
+	// an algorithm that would verify with, for instance, a public key used
+	// as an HMAC secret.
+	if t.Method.Alg() != k.Method.Alg() {
+		return nil, fmt.Errorf(
+			"invalid signing method '%s' for key '%s'",
+			t.Method.Alg(),
+			kid,
+		)
+	}
+
+	return k.verify, nil
+}
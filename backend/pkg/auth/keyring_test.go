@@ -0,0 +1,320 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/safe-waters/retro-simply/backend/pkg/auth"
+)
+
+type testKeys struct {
+	hmac        string
+	rsaPKCS8    string
+	rsaPKCS1    string
+	rsaPublic   string
+	ed25519     string
+	ed25519Pub  string
+	rsaPubBytes []byte
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	write := func(name string, byt []byte) string {
+		t.Helper()
+
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, byt, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	writePEM := func(name, typ string, byt []byte) string {
+		t.Helper()
+
+		return write(name, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: byt}))
+	}
+
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rPKCS8, err := x509.MarshalPKCS8PrivateKey(rk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rPub, err := x509.MarshalPKIXPublicKey(&rk.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	epk, esk, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ePKCS8, err := x509.MarshalPKCS8PrivateKey(esk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ePub, err := x509.MarshalPKIXPublicKey(epk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks := &testKeys{
+		hmac:       write("hmac", []byte("secret\n")),
+		rsaPKCS8:   writePEM("rsa.pem", "PRIVATE KEY", rPKCS8),
+		rsaPKCS1:   writePEM("rsa1.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rk)),
+		rsaPublic:  writePEM("rsa.pub", "PUBLIC KEY", rPub),
+		ed25519:    writePEM("ed25519.pem", "PRIVATE KEY", ePKCS8),
+		ed25519Pub: writePEM("ed25519.pub", "PUBLIC KEY", ePub),
+	}
+
+	ks.rsaPubBytes, err = ioutil.ReadFile(ks.rsaPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ks
+}
+
+func TestKeyringAlgorithms(t *testing.T) {
+	t.Parallel()
+
+	ks := newTestKeys(t)
+
+	tests := []struct {
+		Name string
+		Alg  string
+		Path string
+	}{
+		{Name: "HS256", Alg: auth.AlgHS256, Path: ks.hmac},
+		{Name: "RS256 PKCS8", Alg: auth.AlgRS256, Path: ks.rsaPKCS8},
+		{Name: "RS256 PKCS1", Alg: auth.AlgRS256, Path: ks.rsaPKCS1},
+		{Name: "EdDSA", Alg: auth.AlgEdDSA, Path: ks.ed25519},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			k, err := auth.LoadKey("key", test.Alg, test.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			j := newKeyringJWT(t, "key", k)
+
+			if err := signAndValidate(t, j, j); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	t.Parallel()
+
+	ks := newTestKeys(t)
+
+	old := auth.NewHMACKey(auth.DefaultKeyId, secret)
+
+	ed, err := auth.LoadKey("new", auth.AlgEdDSA, ks.ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldJ := newKeyringJWT(t, auth.DefaultKeyId, old)
+	rotatingJ := newKeyringJWT(t, "new", ed, old)
+	newJ := newKeyringJWT(t, "new", ed)
+
+	// tokens signed with the previous key are valid while it is kept to
+	// verify them
+	if err := signAndValidate(t, oldJ, rotatingJ); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := signAndValidate(t, rotatingJ, newJ); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := signAndValidate(t, oldJ, newJ); err == nil {
+		t.Fatal("expected token signed with a removed key to be invalid")
+	}
+
+	// tokens issued before keys had ids are verified with the default key
+	c := auth.NewClaims(rId, gen, nil, future)
+
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ck := &http.Cookie{Name: "token", Value: legacy}
+	if err := validateToken(t, ck, rotatingJ, c.ComparisonClaims); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validateToken(t, ck, newJ, c.ComparisonClaims); err == nil {
+		t.Fatal("expected token without a key id to be invalid")
+	}
+}
+
+func TestKeyringPublicKey(t *testing.T) {
+	t.Parallel()
+
+	ks := newTestKeys(t)
+
+	sk, err := auth.LoadKey("ed", auth.AlgEdDSA, ks.ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pk, err := auth.LoadKey("ed", auth.AlgEdDSA, ks.ed25519Pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pk.CanSign() {
+		t.Fatal("expected a public key to only verify")
+	}
+
+	if _, err := auth.NewKeyring("ed", pk); err == nil {
+		t.Fatal("expected a public key not to be a signing key")
+	}
+
+	signer := newKeyringJWT(t, "ed", sk)
+	verifier := newKeyringJWT(
+		t,
+		auth.DefaultKeyId,
+		auth.NewHMACKey(auth.DefaultKeyId, secret),
+		pk,
+	)
+
+	if err := signAndValidate(t, signer, verifier); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyringAlgorithmMismatch(t *testing.T) {
+	t.Parallel()
+
+	ks := newTestKeys(t)
+
+	pk, err := auth.LoadKey("rsa", auth.AlgRS256, ks.rsaPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j := newKeyringJWT(t, auth.DefaultKeyId, auth.NewHMACKey(auth.DefaultKeyId, secret), pk)
+
+	// a token signed with the public key as an HMAC secret must not verify
+	c := auth.NewClaims(rId, gen, p, future)
+
+	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, c)
+	tok.Header["kid"] = "rsa"
+
+	forged, err := tok.SignedString(ks.rsaPubBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ck := &http.Cookie{Name: "token", Value: forged}
+	if err := validateToken(t, ck, j, c.ComparisonClaims); err == nil {
+		t.Fatal("expected token with the wrong algorithm to be invalid")
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	t.Parallel()
+
+	ks := newTestKeys(t)
+
+	tests := []struct {
+		Name    string
+		Spec    string
+		NumKeys int
+		Err     bool
+	}{
+		{Name: "Empty", Spec: "", NumKeys: 0},
+		{
+			Name:    "Many",
+			Spec:    "a:HS256:" + ks.hmac + ", b:EdDSA:" + ks.ed25519Pub,
+			NumKeys: 2,
+		},
+		{Name: "Missing Path", Spec: "a:HS256", Err: true},
+		{Name: "Unknown Algorithm", Spec: "a:ES256:" + ks.hmac, Err: true},
+		{Name: "Wrong Algorithm", Spec: "a:RS256:" + ks.ed25519, Err: true},
+		{Name: "Not PEM", Spec: "a:EdDSA:" + ks.hmac, Err: true},
+		{Name: "Missing File", Spec: "a:HS256:/does/not/exist", Err: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := auth.LoadKeys(test.Spec)
+			if test.Err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != test.NumKeys {
+				t.Fatalf("expected %d keys, got: %d", test.NumKeys, len(got))
+			}
+		})
+	}
+}
+
+func newKeyringJWT(t *testing.T, signingId string, keys ...*auth.Key) *auth.JWT {
+	t.Helper()
+
+	kr, err := auth.NewKeyring(signingId, keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return auth.NewKeyringJWT(kr)
+}
+
+func signAndValidate(t *testing.T, signer, validator *auth.JWT) error {
+	t.Helper()
+
+	res := httptest.NewRecorder()
+
+	c := auth.NewClaims(rId, gen, p, future)
+	if err := signer.SetToken(context.Background(), res, c); err != nil {
+		t.Fatal(err)
+	}
+
+	expectCookie(t, res, future)
+
+	return validateToken(t, res.Result().Cookies()[0], validator, c.ComparisonClaims)
+}
@@ -13,8 +13,19 @@ import (
 
 var tr = otel.Tracer("pkg/broker")
 
+// Disconnect asks every client in the room to disconnect, for instance
+// once the room is deleted.
+type Disconnect struct {
+	RoomId string
+	Reason string
+}
+
 type Message struct {
-	State *data.State
+	State      *data.State
+	Operation  *data.Operation
+	Disconnect *Disconnect
+	Presence   *data.Presence
+	Rejection  *data.Rejection
 	// Since redis' pubsub protocol does not have headers like the
 	// HTTP protocol, use the span context to set the same headers that
 	// would be in an HTTP request. Specifically, the 'traceparent' header
@@ -24,6 +35,23 @@ type Message struct {
 	Header http.Header
 }
 
+func (m *Message) RoomId() string {
+	switch {
+	case m.State != nil:
+		return m.State.RoomId
+	case m.Operation != nil:
+		return m.Operation.RoomId
+	case m.Disconnect != nil:
+		return m.Disconnect.RoomId
+	case m.Presence != nil:
+		return m.Presence.RoomId
+	case m.Rejection != nil:
+		return m.Rejection.RoomId
+	default:
+		return ""
+	}
+}
+
 type PubSuber interface {
 	Publish(ctx context.Context, channel string, message interface{}) client.Err
 	Subscribe(ctx context.Context, channels ...string) client.PubSubChannel
@@ -37,18 +65,74 @@ func (b *B) Publish(ctx context.Context, rId string, s *data.State) error {
 	ctx, span := tr.Start(ctx, "broker publish")
 	defer span.End()
 
-	m := &Message{State: s, Header: http.Header{}}
+	if err := b.publish(ctx, rId, &Message{State: s}); err != nil {
+		span.RecordError(err)
+		return err
+	}
 
-	var pr propagation.TraceContext
-	pr.Inject(ctx, propagation.HeaderCarrier(m.Header))
+	return nil
+}
 
-	byt, err := json.Marshal(m)
-	if err != nil {
+func (b *B) PublishOperation(
+	ctx context.Context,
+	rId string,
+	op *data.Operation,
+) error {
+	ctx, span := tr.Start(ctx, "broker publish operation")
+	defer span.End()
+
+	if err := b.publish(ctx, rId, &Message{Operation: op}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+func (b *B) PublishDisconnect(ctx context.Context, rId, reason string) error {
+	ctx, span := tr.Start(ctx, "broker publish disconnect")
+	defer span.End()
+
+	if err := b.publish(
+		ctx,
+		rId,
+		&Message{Disconnect: &Disconnect{RoomId: rId, Reason: reason}},
+	); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// PublishPresence tells the room that a participant joined or left.
+func (b *B) PublishPresence(
+	ctx context.Context,
+	rId string,
+	p *data.Presence,
+) error {
+	ctx, span := tr.Start(ctx, "broker publish presence")
+	defer span.End()
+
+	if err := b.publish(ctx, rId, &Message{Presence: p}); err != nil {
 		span.RecordError(err)
 		return err
 	}
 
-	if err = b.ps.Publish(ctx, rId, byt).Err(); err != nil {
+	return nil
+}
+
+// PublishRejection tells a participant of the room that a vote of theirs
+// was not counted.
+func (b *B) PublishRejection(
+	ctx context.Context,
+	rId string,
+	r *data.Rejection,
+) error {
+	ctx, span := tr.Start(ctx, "broker publish rejection")
+	defer span.End()
+
+	if err := b.publish(ctx, rId, &Message{Rejection: r}); err != nil {
 		span.RecordError(err)
 		return err
 	}
@@ -56,6 +140,20 @@ func (b *B) Publish(ctx context.Context, rId string, s *data.State) error {
 	return nil
 }
 
+func (b *B) publish(ctx context.Context, rId string, m *Message) error {
+	m.Header = http.Header{}
+
+	var pr propagation.TraceContext
+	pr.Inject(ctx, propagation.HeaderCarrier(m.Header))
+
+	byt, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return b.ps.Publish(ctx, rId, byt).Err()
+}
+
 func (b *B) Subscribe(
 	ctx context.Context,
 	rId string,
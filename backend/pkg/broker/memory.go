@@ -0,0 +1,269 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/safe-waters/retro-simply/backend/pkg/data"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// SlowConsumerPolicy decides what happens to a message for a subscriber
+// whose buffer is full.
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerDrop drops the message for the subscriber. Clients
+	// resync from the gap in revisions.
+	SlowConsumerDrop SlowConsumerPolicy = iota
+	// SlowConsumerDisconnect closes the subscriber's channel, so its
+	// client reconnects and starts over from a snapshot.
+	SlowConsumerDisconnect
+	// SlowConsumerBlock makes the publisher wait until the subscriber has
+	// room, which slows the room down to its slowest subscriber.
+	SlowConsumerBlock
+)
+
+var slowConsumerPolicies = map[string]SlowConsumerPolicy{
+	"drop":       SlowConsumerDrop,
+	"disconnect": SlowConsumerDisconnect,
+	"block":      SlowConsumerBlock,
+}
+
+func ParseSlowConsumerPolicy(s string) (SlowConsumerPolicy, error) {
+	p, ok := slowConsumerPolicies[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown slow consumer policy '%s'", s)
+	}
+
+	return p, nil
+}
+
+type memorySub struct {
+	// mu guards sending on mCh against it being closed.
+	mu     *sync.RWMutex
+	mCh    chan *Message
+	closed bool
+	// done is closed as soon as the subscription ends, so a blocked
+	// publisher stops waiting for it.
+	done   chan struct{}
+	cancel context.CancelFunc
+}
+
+// Memory is a broker that fans messages out to the subscribers of a room
+// in the same process, for tests and deployments that run on a single
+// node. Every subscriber has its own buffer, so a slow subscriber does not
+// hold up the others unless the policy is SlowConsumerBlock.
+type Memory struct {
+	mu     *sync.RWMutex
+	subs   map[string]map[*memorySub]struct{}
+	buffer int
+	policy SlowConsumerPolicy
+	// slow counts the messages that found a subscriber's buffer full.
+	slow uint64
+}
+
+func NewMemory(buffer int, policy SlowConsumerPolicy) *Memory {
+	return &Memory{
+		mu:     &sync.RWMutex{},
+		subs:   map[string]map[*memorySub]struct{}{},
+		buffer: buffer,
+		policy: policy,
+	}
+}
+
+func (m *Memory) SlowConsumers() uint64 { return atomic.LoadUint64(&m.slow) }
+
+func (m *Memory) Publish(ctx context.Context, rId string, s *data.State) error {
+	ctx, span := tr.Start(ctx, "broker publish")
+	defer span.End()
+
+	if err := m.publish(ctx, rId, &Message{State: s}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *Memory) PublishOperation(
+	ctx context.Context,
+	rId string,
+	op *data.Operation,
+) error {
+	ctx, span := tr.Start(ctx, "broker publish operation")
+	defer span.End()
+
+	if err := m.publish(ctx, rId, &Message{Operation: op}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *Memory) PublishDisconnect(ctx context.Context, rId, reason string) error {
+	ctx, span := tr.Start(ctx, "broker publish disconnect")
+	defer span.End()
+
+	if err := m.publish(
+		ctx,
+		rId,
+		&Message{Disconnect: &Disconnect{RoomId: rId, Reason: reason}},
+	); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// PublishPresence tells the room that a participant joined or left.
+func (m *Memory) PublishPresence(
+	ctx context.Context,
+	rId string,
+	p *data.Presence,
+) error {
+	ctx, span := tr.Start(ctx, "broker publish presence")
+	defer span.End()
+
+	if err := m.publish(ctx, rId, &Message{Presence: p}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// PublishRejection tells a participant of the room that a vote of theirs
+// was not counted.
+func (m *Memory) PublishRejection(
+	ctx context.Context,
+	rId string,
+	r *data.Rejection,
+) error {
+	ctx, span := tr.Start(ctx, "broker publish rejection")
+	defer span.End()
+
+	if err := m.publish(ctx, rId, &Message{Rejection: r}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *Memory) publish(ctx context.Context, rId string, msg *Message) error {
+	msg.Header = http.Header{}
+
+	var pr propagation.TraceContext
+	pr.Inject(ctx, propagation.HeaderCarrier(msg.Header))
+
+	// Every subscriber gets its own copy, like it would from Redis, so
+	// none of them can change what the others receive.
+	byt, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	subs := make([]*memorySub, 0, len(m.subs[rId]))
+	for s := range m.subs[rId] {
+		subs = append(subs, s)
+	}
+	m.mu.RUnlock()
+
+	for _, s := range subs {
+		c := &Message{}
+		if err := json.Unmarshal(byt, c); err != nil {
+			return err
+		}
+
+		if err := m.send(ctx, s, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) send(ctx context.Context, s *memorySub, msg *Message) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil
+	}
+
+	select {
+	case s.mCh <- msg:
+		return nil
+	default:
+	}
+
+	atomic.AddUint64(&m.slow, 1)
+
+	switch m.policy {
+	case SlowConsumerDisconnect:
+		s.cancel()
+	case SlowConsumerBlock:
+		select {
+		case s.mCh <- msg:
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Subscribe returns the messages published to the room until the context
+// is done, or until the subscriber is disconnected for being too slow.
+func (m *Memory) Subscribe(
+	ctx context.Context,
+	rId string,
+) (<-chan *Message, error) {
+	ctx, span := tr.Start(ctx, "broker subscribe")
+	defer span.End()
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	s := &memorySub{
+		mu:     &sync.RWMutex{},
+		mCh:    make(chan *Message, m.buffer),
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+
+	m.mu.Lock()
+	if m.subs[rId] == nil {
+		m.subs[rId] = map[*memorySub]struct{}{}
+	}
+	m.subs[rId][s] = struct{}{}
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		close(s.done)
+
+		m.mu.Lock()
+		delete(m.subs[rId], s)
+		if len(m.subs[rId]) == 0 {
+			delete(m.subs, rId)
+		}
+		m.mu.Unlock()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.closed = true
+		close(s.mCh)
+	}()
+
+	return s.mCh, nil
+}
@@ -0,0 +1,262 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/safe-waters/retro-simply/backend/pkg/data"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func newState(t *testing.T, rev int) *data.State {
+	var s data.State
+	if err := json.Unmarshal([]byte(baseState), &s); err != nil {
+		t.Error(err)
+	}
+
+	s.Revision = uint64(rev)
+
+	return &s
+}
+
+func receive(t *testing.T, mCh <-chan *Message) *Message {
+	t.Helper()
+
+	select {
+	case m, ok := <-mCh:
+		if !ok {
+			t.Fatal("expected message, channel closed")
+		}
+
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected message")
+	}
+
+	return nil
+}
+
+func expectNoMessage(t *testing.T, mCh <-chan *Message) {
+	t.Helper()
+
+	select {
+	case m, ok := <-mCh:
+		if ok {
+			t.Fatalf("expected no message, got: %+v", m)
+		}
+
+		t.Fatal("expected channel to stay open")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemory(t *testing.T) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	b := NewMemory(10, SlowConsumerDrop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var subs []<-chan *Message
+
+	for i := 0; i < 2; i++ {
+		mCh, err := b.Subscribe(ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		subs = append(subs, mCh)
+	}
+
+	other, err := b.Subscribe(ctx, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pCtx, span := otel.Tracer("test").Start(context.Background(), "publish")
+	defer span.End()
+
+	op := &data.Operation{Type: data.OperationVote, RoomId: "test", CardId: "a-pk-0"}
+	if err := b.PublishOperation(pCtx, "test", op); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []*Message
+
+	for _, mCh := range subs {
+		m := receive(t, mCh)
+		expectState(t, op, m.Operation)
+
+		tp := m.Header.Get("traceparent")
+		if !strings.Contains(tp, span.SpanContext().TraceID().String()) {
+			t.Fatalf("expected traceparent of the publisher's trace, got: '%s'", tp)
+		}
+
+		got = append(got, m)
+	}
+
+	if got[0].Operation == got[1].Operation {
+		t.Fatal("expected every subscriber to get its own copy")
+	}
+
+	expectNoMessage(t, other)
+
+	if err := b.PublishDisconnect(pCtx, "test", "room deleted"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mCh := range subs {
+		m := receive(t, mCh)
+		if m.Disconnect == nil || m.RoomId() != "test" || m.Disconnect.Reason != "room deleted" {
+			t.Fatalf("expected disconnect from room 'test', got: %+v", m.Disconnect)
+		}
+	}
+
+	expectNoMessage(t, other)
+
+	p := &data.Presence{
+		RoomId:      "test",
+		Event:       data.PresenceJoin,
+		Participant: &data.Participant{Id: "pid", DisplayName: "name"},
+	}
+	if err := b.PublishPresence(pCtx, "test", p); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mCh := range subs {
+		m := receive(t, mCh)
+		expectState(t, p, m.Presence)
+	}
+
+	expectNoMessage(t, other)
+
+	r := &data.Rejection{
+		RoomId:        "test",
+		ParticipantId: "pid",
+		CardId:        "a-pk-0",
+		Reason:        "no votes left",
+	}
+	if err := b.PublishRejection(pCtx, "test", r); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mCh := range subs {
+		m := receive(t, mCh)
+		expectState(t, r, m.Rejection)
+	}
+
+	expectNoMessage(t, other)
+
+	cancel()
+
+	for _, mCh := range append(subs, other) {
+		expectCloseMessageChannel(t, mCh)
+	}
+}
+
+func TestMemorySlowConsumer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name   string
+		Policy SlowConsumerPolicy
+	}{
+		{Name: "drop", Policy: SlowConsumerDrop},
+		{Name: "disconnect", Policy: SlowConsumerDisconnect},
+		{Name: "block", Policy: SlowConsumerBlock},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			b := NewMemory(1, test.Policy)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			slow, err := b.Subscribe(ctx, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fast, err := b.Subscribe(ctx, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			published := make(chan struct{})
+
+			go func() {
+				defer close(published)
+
+				for i := 1; i <= 2; i++ {
+					if err := b.Publish(ctx, "test", newState(t, i)); err != nil {
+						t.Error(err)
+						return
+					}
+
+					// the fast subscriber keeps up
+					select {
+					case m := <-fast:
+						if m.State.Revision != uint64(i) {
+							t.Errorf("expected revision %d, got: %d", i, m.State.Revision)
+						}
+					case <-time.After(5 * time.Second):
+						t.Error("expected fast subscriber to get a message")
+					}
+				}
+			}()
+
+			if test.Policy != SlowConsumerBlock {
+				<-published
+			}
+
+			if m := receive(t, slow); m.State.Revision != 1 {
+				t.Fatalf("expected revision 1, got: %d", m.State.Revision)
+			}
+
+			switch test.Policy {
+			case SlowConsumerDrop:
+				expectNoMessage(t, slow)
+			case SlowConsumerDisconnect:
+				expectCloseMessageChannel(t, slow)
+			case SlowConsumerBlock:
+				if m := receive(t, slow); m.State.Revision != 2 {
+					t.Fatalf("expected revision 2, got: %d", m.State.Revision)
+				}
+
+				<-published
+			}
+
+			if n := b.SlowConsumers(); n != 1 && test.Policy != SlowConsumerBlock {
+				t.Fatalf("expected 1 slow consumer, got: %d", n)
+			}
+		})
+	}
+}
+
+func TestParseSlowConsumerPolicy(t *testing.T) {
+	t.Parallel()
+
+	for s, expected := range slowConsumerPolicies {
+		p, err := ParseSlowConsumerPolicy(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p != expected {
+			t.Fatalf("expected policy %d for '%s', got: %d", expected, s, p)
+		}
+	}
+
+	if _, err := ParseSlowConsumerPolicy("wait"); err == nil {
+		t.Fatal("expected error for unknown policy")
+	}
+}
@@ -30,6 +30,31 @@ type BoolResult interface {
 	Err
 }
 
+type IntResult interface {
+	Result() (int64, error)
+	Err
+}
+
+type ScanResult interface {
+	Result() ([]string, uint64, error)
+	Err
+}
+
+type XStreamSliceResult interface {
+	Result() ([]redis.XStream, error)
+	Err
+}
+
+type XMessageSliceResult interface {
+	Result() ([]redis.XMessage, error)
+	Err
+}
+
+type XPendingExtResult interface {
+	Result() ([]redis.XPendingExt, error)
+	Err
+}
+
 type C struct {
 	*redis.Client
 }
@@ -92,3 +117,98 @@ func (c *C) SetNX(
 
 	return c.Client.SetNX(ctx, key, value, expiration)
 }
+
+func (c *C) PExpire(
+	ctx context.Context,
+	key string,
+	expiration time.Duration,
+) BoolResult {
+	ctx, span := tr.Start(ctx, "client pexpire")
+	defer span.End()
+
+	return c.Client.PExpire(ctx, key, expiration)
+}
+
+func (c *C) Persist(ctx context.Context, key string) BoolResult {
+	ctx, span := tr.Start(ctx, "client persist")
+	defer span.End()
+
+	return c.Client.Persist(ctx, key)
+}
+
+func (c *C) Del(ctx context.Context, keys ...string) IntResult {
+	ctx, span := tr.Start(ctx, "client del")
+	defer span.End()
+
+	return c.Client.Del(ctx, keys...)
+}
+
+func (c *C) Scan(
+	ctx context.Context,
+	cursor uint64,
+	match string,
+	count int64,
+) ScanResult {
+	ctx, span := tr.Start(ctx, "client scan")
+	defer span.End()
+
+	return c.Client.Scan(ctx, cursor, match, count)
+}
+
+func (c *C) XAdd(ctx context.Context, a *redis.XAddArgs) StrResult {
+	ctx, span := tr.Start(ctx, "client xadd")
+	defer span.End()
+
+	return c.Client.XAdd(ctx, a)
+}
+
+func (c *C) XGroupCreateMkStream(
+	ctx context.Context,
+	stream string,
+	group string,
+	start string,
+) Err {
+	ctx, span := tr.Start(ctx, "client xgroup create mkstream")
+	defer span.End()
+
+	return c.Client.XGroupCreateMkStream(ctx, stream, group, start)
+}
+
+func (c *C) XReadGroup(
+	ctx context.Context,
+	a *redis.XReadGroupArgs,
+) XStreamSliceResult {
+	ctx, span := tr.Start(ctx, "client xreadgroup")
+	defer span.End()
+
+	return c.Client.XReadGroup(ctx, a)
+}
+
+func (c *C) XAck(
+	ctx context.Context,
+	stream string,
+	group string,
+	ids ...string,
+) IntResult {
+	ctx, span := tr.Start(ctx, "client xack")
+	defer span.End()
+
+	return c.Client.XAck(ctx, stream, group, ids...)
+}
+
+func (c *C) XPendingExt(
+	ctx context.Context,
+	a *redis.XPendingExtArgs,
+) XPendingExtResult {
+	ctx, span := tr.Start(ctx, "client xpending ext")
+	defer span.End()
+
+	return c.Client.XPendingExt(ctx, a)
+}
+
+func (c *C) XClaim(ctx context.Context, a *redis.XClaimArgs) XMessageSliceResult {
+	ctx, span := tr.Start(ctx, "client xclaim")
+	defer span.End()
+
+	return c.Client.XClaim(ctx, a)
+}
@@ -6,10 +6,19 @@ import (
 	"fmt"
 )
 
+const (
+	ActionUpVote = "upVote"
+	// ActionUnVote retracts a vote of the participant.
+	ActionUnVote = "unVote"
+)
+
 type Action struct {
 	Title   string     `json:"title"`
 	OldCard *RetroCard `json:"oldCard"`
 	NewCard *RetroCard `json:"newCard"`
+	// AuthorId is the id of the participant who took the action. It is
+	// assigned by the server.
+	AuthorId string `json:"authorId,omitempty"`
 }
 
 func (a *Action) UnmarshalJSON(data []byte) error {
@@ -19,12 +28,8 @@ func (a *Action) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
-	if a.Title != "upVote" {
-		return fmt.Errorf("invalid action title '%s'", a.Title)
-	}
-
 	switch a.Title {
-	case "upVote":
+	case ActionUpVote, ActionUnVote:
 		if a.OldCard == nil {
 			return errors.New("old card is nil")
 		}
@@ -32,7 +37,22 @@ func (a *Action) UnmarshalJSON(data []byte) error {
 		if a.NewCard == nil {
 			return errors.New("new card is nil")
 		}
+	default:
+		return fmt.Errorf("invalid action title '%s'", a.Title)
 	}
 
 	return nil
 }
+
+func (a *Action) redact(pId string) {
+	if a == nil {
+		return
+	}
+
+	if a.AuthorId != pId {
+		a.AuthorId = ""
+	}
+
+	a.OldCard.redact(pId)
+	a.NewCard.redact(pId)
+}
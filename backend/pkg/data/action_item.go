@@ -0,0 +1,213 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Statuses of an action item.
+const (
+	ActionItemOpen       = "open"
+	ActionItemInProgress = "inProgress"
+	ActionItemDone       = "done"
+)
+
+// MaxActionItemTitleLength is the maximum number of characters in the
+// title of an action item.
+const MaxActionItemTitleLength = 500
+
+// MaxActionItems is the maximum number of action items a team keeps.
+const MaxActionItems = 200
+
+// ActionItem is something a team agreed to do in a retrospective. Action
+// items belong to the team rather than to a room, so they are tracked
+// across the team's retrospectives.
+type ActionItem struct {
+	Id    string `json:"id"`
+	Title string `json:"title"`
+	// Assignee is the name of who does the action item, since participants
+	// get a new id in every room.
+	Assignee string     `json:"assignee"`
+	DueDate  *time.Time `json:"dueDate"`
+	Status   string     `json:"status"`
+	// CardId is the id of the card the action item came from, if any.
+	CardId string `json:"cardId"`
+	// RoomId is the id of the room the action item was created in. It is
+	// assigned by the server, as are the times below.
+	RoomId    string    `json:"roomId"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Editor is a participant changing the action items of their team.
+type Editor struct {
+	Name string
+	// Facilitator reports whether the participant facilitates their room.
+	Facilitator bool
+}
+
+// ActionItems are the action items of the team of a room.
+type ActionItems struct {
+	RoomId      string        `json:"roomId"`
+	ActionItems []*ActionItem `json:"actionItems"`
+}
+
+func (a *ActionItem) UnmarshalJSON(data []byte) error {
+	type target ActionItem
+
+	if err := json.Unmarshal(data, (*target)(a)); err != nil {
+		return err
+	}
+
+	a.Title = strings.TrimSpace(a.Title)
+	a.Assignee = strings.TrimSpace(a.Assignee)
+
+	if a.Title == "" {
+		return ActionItemInvalidError{errors.New("title cannot be empty")}
+	}
+
+	if utf8.RuneCountInString(a.Title) > MaxActionItemTitleLength {
+		return ActionItemInvalidError{
+			fmt.Errorf(
+				"invalid title - it may contain at most %d characters",
+				MaxActionItemTitleLength,
+			),
+		}
+	}
+
+	if utf8.RuneCountInString(a.Assignee) > MaxDisplayNameLength {
+		return ActionItemInvalidError{
+			fmt.Errorf(
+				"invalid assignee - it may contain at most %d characters",
+				MaxDisplayNameLength,
+			),
+		}
+	}
+
+	if strings.IndexFunc(a.Assignee, unicode.IsControl) >= 0 {
+		return ActionItemInvalidError{
+			errors.New("invalid assignee - it may not contain control characters"),
+		}
+	}
+
+	switch a.Status {
+	case "":
+		a.Status = ActionItemOpen
+	case ActionItemOpen, ActionItemInProgress, ActionItemDone:
+	default:
+		return ActionItemInvalidError{fmt.Errorf("invalid status '%s'", a.Status)}
+	}
+
+	return nil
+}
+
+// CheckCard returns an ActionItemInvalidError if the action item comes from
+// a card that is not on the board.
+func (a *ActionItem) CheckCard(s *State) error {
+	if a.CardId == "" {
+		return nil
+	}
+
+	if r, _ := s.findCard(a.CardId); r == nil || r.IsDeleted {
+		return ActionItemInvalidError{
+			fmt.Errorf("retro card '%s' does not exist", a.CardId),
+		}
+	}
+
+	return nil
+}
+
+// AddActionItem adds the action item to the team's. A team that has
+// MaxActionItems forgets its oldest done action item to make room, and
+// gets an ActionItemInvalidError if none is done.
+func AddActionItem(as []*ActionItem, a *ActionItem) ([]*ActionItem, error) {
+	if len(as) < MaxActionItems {
+		return append(as, a), nil
+	}
+
+	for i, ea := range as {
+		if !ea.IsOpen() {
+			return append(append(as[:i:i], as[i+1:]...), a), nil
+		}
+	}
+
+	return nil, ActionItemInvalidError{
+		fmt.Errorf("a team can have at most %d open action items", MaxActionItems),
+	}
+}
+
+// IsOpen reports whether the action item is still to be done.
+func (a *ActionItem) IsOpen() bool { return a.Status != ActionItemDone }
+
+// Update changes what participants may change of the action item to the
+// values of u. The rest is assigned by the server, so it is kept.
+func (a *ActionItem) Update(u *ActionItem, now time.Time) {
+	a.Title = u.Title
+	a.Assignee = u.Assignee
+	a.DueDate = u.DueDate
+	a.Status = u.Status
+	a.UpdatedAt = now
+}
+
+// CheckEditor returns a ForbiddenError unless the editor is the action
+// item's assignee or a facilitator, who may change and delete it.
+func (a *ActionItem) CheckEditor(e Editor) error {
+	if e.Facilitator || (e.Name != "" && e.Name == a.Assignee) {
+		return nil
+	}
+
+	return ForbiddenError{
+		fmt.Errorf(
+			"only its assignee or a facilitator can change action item '%s'",
+			a.Id,
+		),
+	}
+}
+
+// CarryActionItems adds a card for every open action item to the last
+// column of the board, which is the actions column of every template. The
+// cards share the id of their action item, so clients can tell which
+// action item a card stands for.
+func (s *State) CarryActionItems(as []*ActionItem, now time.Time) {
+	if len(s.Columns) == 0 {
+		return
+	}
+
+	c := s.Columns[len(s.Columns)-1]
+
+	var g *Group
+	for _, cg := range c.Groups {
+		if cg.Id == "default" {
+			g = cg
+			break
+		}
+	}
+
+	if g == nil {
+		return
+	}
+
+	for _, a := range as {
+		if !a.IsOpen() {
+			continue
+		}
+
+		id := fmt.Sprintf("%s-pk-0", a.Id)
+		if r, _ := s.findCard(id); r != nil {
+			continue
+		}
+
+		g.RetroCards = append(g.RetroCards, &RetroCard{
+			Id:           id,
+			ColumnId:     c.Id,
+			Message:      a.Title,
+			GroupId:      g.Id,
+			LastModified: int(now.UnixNano() / int64(time.Millisecond)),
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActionItemUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name   string
+		Item   string
+		Valid  bool
+		Status string
+	}{
+		{
+			Name:   "Open By Default",
+			Item:   `{"title": "fix the build"}`,
+			Valid:  true,
+			Status: ActionItemOpen,
+		},
+		{
+			Name:   "Done",
+			Item:   `{"title": "fix the build", "status": "done", "dueDate": "2026-10-20T00:00:00Z"}`,
+			Valid:  true,
+			Status: ActionItemDone,
+		},
+		{
+			Name:  "Empty Title",
+			Item:  `{"title": "  "}`,
+			Valid: false,
+		},
+		{
+			Name:  "Long Title",
+			Item:  `{"title": "` + strings.Repeat("a", MaxActionItemTitleLength+1) + `"}`,
+			Valid: false,
+		},
+		{
+			Name:  "Long Assignee",
+			Item:  `{"title": "t", "assignee": "` + strings.Repeat("a", MaxDisplayNameLength+1) + `"}`,
+			Valid: false,
+		},
+		{
+			Name:  "Unknown Status",
+			Item:  `{"title": "t", "status": "later"}`,
+			Valid: false,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			var a ActionItem
+			err := json.Unmarshal([]byte(test.Item), &a)
+
+			if !test.Valid {
+				if _, ok := err.(ActionItemInvalidError); !ok {
+					t.Fatalf("expected ActionItemInvalidError, got: %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if a.Status != test.Status {
+				t.Fatalf("expected status '%s', got: '%s'", test.Status, a.Status)
+			}
+		})
+	}
+}
+
+func TestStateCarryActionItems(t *testing.T) {
+	t.Parallel()
+
+	s := newTestState(t)
+	s.CarryActionItems([]*ActionItem{
+		{Id: "open", Title: "open", Status: ActionItemOpen},
+		{Id: "started", Title: "started", Status: ActionItemInProgress},
+		{Id: "done", Title: "done", Status: ActionItemDone},
+	}, time.Now())
+
+	rs := s.Columns[len(s.Columns)-1].Groups[0].RetroCards
+	if len(rs) != 2 {
+		t.Fatalf("expected 2 cards, got: %d", len(rs))
+	}
+
+	for i, id := range []string{"open-pk-0", "started-pk-0"} {
+		if rs[i].Id != id || rs[i].ColumnId != "3" || rs[i].LastModified == 0 {
+			t.Fatalf("expected card '%s' in the actions column, got: %+v", id, rs[i])
+		}
+	}
+}
+
+func TestAddActionItem(t *testing.T) {
+	t.Parallel()
+
+	as := []*ActionItem{}
+	for i := 0; i < MaxActionItems; i++ {
+		as = append(as, &ActionItem{Id: strconv.Itoa(i), Status: ActionItemOpen})
+	}
+
+	if _, err := AddActionItem(as, &ActionItem{Id: "new"}); !errors.As(err, &ActionItemInvalidError{}) {
+		t.Fatalf("expected ActionItemInvalidError, got: %v", err)
+	}
+
+	as[1].Status = ActionItemDone
+	as[2].Status = ActionItemDone
+
+	as, err := AddActionItem(as, &ActionItem{Id: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(as) != MaxActionItems || as[1].Id != "2" || as[len(as)-1].Id != "new" {
+		t.Fatalf("expected the oldest done action item to be forgotten, got: %d items", len(as))
+	}
+}
+
+func TestActionItemCheckCard(t *testing.T) {
+	t.Parallel()
+
+	s := newTestState(t)
+	s.CarryActionItems([]*ActionItem{
+		{Id: "open", Title: "open", Status: ActionItemOpen},
+	}, time.Now())
+
+	for _, cId := range []string{"", "open-pk-0"} {
+		if err := (&ActionItem{CardId: cId}).CheckCard(s); err != nil {
+			t.Fatalf("expected card '%s' to be valid, got: %v", cId, err)
+		}
+	}
+
+	err := (&ActionItem{CardId: "missing-pk-0"}).CheckCard(s)
+	if !errors.As(err, &ActionItemInvalidError{}) {
+		t.Fatalf("expected ActionItemInvalidError, got: %v", err)
+	}
+}
+
+func TestActionItemCheckEditor(t *testing.T) {
+	t.Parallel()
+
+	a := &ActionItem{Id: "a", Assignee: "Ada"}
+
+	for _, e := range []Editor{{Name: "Ada"}, {Facilitator: true}} {
+		if err := a.CheckEditor(e); err != nil {
+			t.Fatalf("expected %+v to edit the action item, got: %v", e, err)
+		}
+	}
+
+	for _, e := range []Editor{{Name: "Grace"}, {}} {
+		if err := a.CheckEditor(e); !errors.As(err, &ForbiddenError{}) {
+			t.Fatalf("expected ForbiddenError for %+v, got: %v", e, err)
+		}
+	}
+
+	// nobody is the assignee of an unassigned action item
+	if err := (&ActionItem{Id: "b"}).CheckEditor(Editor{}); !errors.As(err, &ForbiddenError{}) {
+		t.Fatalf("expected ForbiddenError, got: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package data
+
+// maxDeliveries bounds how many queue entry ids a state remembers. Entries
+// are delivered again within minutes, long before this many other entries
+// of the same room are stored.
+const maxDeliveries = 100
+
+// Delivered returns whether the queue entry was already stored into the
+// state. Queue entries are delivered at least once, so the store skips the
+// ones delivered again. An empty id was not delivered by a queue.
+func (s *State) Delivered(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	for _, d := range s.Deliveries {
+		if d == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddDelivery records that the queue entry was stored into the state,
+// forgetting the oldest entries beyond maxDeliveries.
+func (s *State) AddDelivery(id string) {
+	if id == "" {
+		return
+	}
+
+	s.Deliveries = append(s.Deliveries, id)
+
+	if n := len(s.Deliveries) - maxDeliveries; n > 0 {
+		s.Deliveries = append([]string(nil), s.Deliveries[n:]...)
+	}
+}
@@ -0,0 +1,34 @@
+package data
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDeliveries(t *testing.T) {
+	t.Parallel()
+
+	var s State
+
+	if s.Delivered("") {
+		t.Fatal("expected an empty id not to be delivered")
+	}
+
+	for i := 0; i < maxDeliveries+1; i++ {
+		s.AddDelivery(fmt.Sprintf("%d-0", i))
+	}
+
+	s.AddDelivery("")
+
+	if len(s.Deliveries) != maxDeliveries {
+		t.Fatalf("expected %d deliveries, got: %d", maxDeliveries, len(s.Deliveries))
+	}
+
+	if s.Delivered("0-0") {
+		t.Fatal("expected the oldest delivery to be forgotten")
+	}
+
+	if !s.Delivered(fmt.Sprintf("%d-0", maxDeliveries)) {
+		t.Fatal("expected the last delivery to be remembered")
+	}
+}
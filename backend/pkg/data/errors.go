@@ -1,10 +1,34 @@
 package data
 
 type (
-	PasswordInvalidError struct{ Err error }
-	RoomIdInvalidError   struct{ Err error }
+	PasswordInvalidError   struct{ Err error }
+	RoomIdInvalidError     struct{ Err error }
+	TemplateInvalidError   struct{ Err error }
+	OperationInvalidError  struct{ Err error }
+	RetentionInvalidError  struct{ Err error }
+	NameInvalidError       struct{ Err error }
+	VotingInvalidError     struct{ Err error }
+	VoteRejectedError      struct{ Err error }
+	ForbiddenError         struct{ Err error }
+	ActionItemInvalidError struct{ Err error }
 )
 
 func (p PasswordInvalidError) Error() string { return p.Err.Error() }
 
 func (r RoomIdInvalidError) Error() string { return r.Err.Error() }
+
+func (t TemplateInvalidError) Error() string { return t.Err.Error() }
+
+func (o OperationInvalidError) Error() string { return o.Err.Error() }
+
+func (r RetentionInvalidError) Error() string { return r.Err.Error() }
+
+func (n NameInvalidError) Error() string { return n.Err.Error() }
+
+func (v VotingInvalidError) Error() string { return v.Err.Error() }
+
+func (v VoteRejectedError) Error() string { return v.Err.Error() }
+
+func (f ForbiddenError) Error() string { return f.Err.Error() }
+
+func (a ActionItemInvalidError) Error() string { return a.Err.Error() }
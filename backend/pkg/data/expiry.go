@@ -0,0 +1,89 @@
+package data
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// MaxRetentionDays bounds how long a room can be kept after its last
+// activity when it is created.
+const MaxRetentionDays = 365
+
+// Expiry tracks when a room expires. A room expires once it has been idle
+// for Idle, or once it is MaxAge old, whichever comes first. A zero
+// duration never expires. Its durations are sent in seconds.
+type Expiry struct {
+	CreatedAt    time.Time     `json:"createdAt"`
+	LastActivity time.Time     `json:"lastActivity"`
+	Idle         time.Duration `json:"idle"`
+	MaxAge       time.Duration `json:"maxAge"`
+	// ExpiresAt is derived from the fields above, and is nil for rooms
+	// that never expire.
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+func (e *Expiry) MarshalJSON() ([]byte, error) {
+	type target Expiry
+
+	return json.Marshal(&struct {
+		*target
+		Idle   int64 `json:"idle"`
+		MaxAge int64 `json:"maxAge"`
+	}{
+		target: (*target)(e),
+		Idle:   toSeconds(e.Idle),
+		MaxAge: toSeconds(e.MaxAge),
+	})
+}
+
+func (e *Expiry) UnmarshalJSON(data []byte) error {
+	type target Expiry
+
+	v := &struct {
+		*target
+		Idle   int64 `json:"idle"`
+		MaxAge int64 `json:"maxAge"`
+	}{target: (*target)(e)}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+
+	e.Idle = fromSeconds(v.Idle)
+	e.MaxAge = fromSeconds(v.MaxAge)
+
+	return nil
+}
+
+func NewExpiry(now time.Time, idle, maxAge time.Duration) *Expiry {
+	e := &Expiry{CreatedAt: now, Idle: idle, MaxAge: maxAge}
+	e.Touch(now)
+
+	return e
+}
+
+// Touch records activity in the room, pushing back when it expires.
+func (e *Expiry) Touch(now time.Time) {
+	e.LastActivity = now
+	e.ExpiresAt = nil
+
+	var at time.Time
+
+	if e.Idle > 0 {
+		at = e.LastActivity.Add(e.Idle)
+	}
+
+	if e.MaxAge > 0 {
+		if m := e.CreatedAt.Add(e.MaxAge); at.IsZero() || m.Before(at) {
+			at = m
+		}
+	}
+
+	if !at.IsZero() {
+		e.ExpiresAt = &at
+	}
+}
+
+func (e *Expiry) Expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !now.Before(*e.ExpiresAt)
+}
@@ -0,0 +1,119 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestExpiry(t *testing.T) {
+	t.Parallel()
+
+	created := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		Name     string
+		Idle     time.Duration
+		MaxAge   time.Duration
+		Activity time.Time
+		Expected time.Time
+	}{
+		{
+			Name:     "Never",
+			Activity: created.Add(day),
+		},
+		{
+			Name:     "Idle",
+			Idle:     day,
+			Activity: created.Add(2 * day),
+			Expected: created.Add(3 * day),
+		},
+		{
+			Name:     "Max Age",
+			MaxAge:   7 * day,
+			Activity: created.Add(2 * day),
+			Expected: created.Add(7 * day),
+		},
+		{
+			Name:     "Idle Before Max Age",
+			Idle:     day,
+			MaxAge:   7 * day,
+			Activity: created.Add(2 * day),
+			Expected: created.Add(3 * day),
+		},
+		{
+			Name:     "Max Age Before Idle",
+			Idle:     day,
+			MaxAge:   7 * day,
+			Activity: created.Add(6*day + time.Hour),
+			Expected: created.Add(7 * day),
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			e := NewExpiry(created, test.Idle, test.MaxAge)
+			e.Touch(test.Activity)
+
+			if test.Expected.IsZero() {
+				if e.ExpiresAt != nil {
+					t.Fatalf("expected no expiry, got: %v", e.ExpiresAt)
+				}
+
+				if e.Expired(created.Add(365 * day)) {
+					t.Fatal("expected room to never expire")
+				}
+
+				return
+			}
+
+			if e.ExpiresAt == nil || !e.ExpiresAt.Equal(test.Expected) {
+				t.Fatalf("expected expiry at %v, got: %v", test.Expected, e.ExpiresAt)
+			}
+
+			if e.Expired(test.Expected.Add(-time.Second)) {
+				t.Fatal("expected room to not be expired before its expiry")
+			}
+
+			if !e.Expired(test.Expected) {
+				t.Fatal("expected room to be expired at its expiry")
+			}
+		})
+	}
+}
+
+func TestExpiryJSON(t *testing.T) {
+	t.Parallel()
+
+	e := NewExpiry(time.Now(), 30*24*time.Hour, time.Hour)
+
+	byt, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent struct {
+		Idle   int64 `json:"idle"`
+		MaxAge int64 `json:"maxAge"`
+	}
+	if err := json.Unmarshal(byt, &sent); err != nil {
+		t.Fatal(err)
+	}
+
+	if sent.Idle != 30*24*60*60 || sent.MaxAge != 60*60 {
+		t.Fatalf("expected durations in seconds, got: %s", byt)
+	}
+
+	var got Expiry
+	if err := json.Unmarshal(byt, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Idle != e.Idle || got.MaxAge != e.MaxAge || !got.ExpiresAt.Equal(*e.ExpiresAt) {
+		t.Fatalf("expected the expiry to be decoded from seconds, got: %+v", got)
+	}
+}
@@ -0,0 +1,102 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Facilitates reports whether the participant runs the room. Every
+// participant runs rooms without a facilitator.
+func (s *State) Facilitates(pId string) bool {
+	return s.FacilitatorId == "" || s.FacilitatorId == pId
+}
+
+// authorize returns a ForbiddenError if the operation's author is not
+// allowed to make it. The phase of the room gates everyone, while only the
+// facilitator controls the room and deletes the cards of others, and only
+// votes are allowed while the board is locked. Private cards are only
+// changed by their author, since no one else knows their message.
+func (o *Operation) authorize(s *State) error {
+	if err := s.CheckPhase(o.Type); err != nil {
+		return err
+	}
+
+	if o.Type == OperationEditCard || o.Type == OperationMoveCard {
+		if c, _ := s.findCard(o.CardId); c != nil && c.Private && !owns(o.AuthorId, c) {
+			return ForbiddenError{
+				errors.New("only the author can change a private card"),
+			}
+		}
+	}
+
+	if s.Facilitates(o.AuthorId) {
+		return nil
+	}
+
+	switch o.Type {
+	case OperationRevealVotes,
+		OperationHideVotes,
+		OperationLockBoard,
+		OperationUnlockBoard,
+		OperationTransferFacilitator,
+		OperationChangePhase,
+		OperationStartTimer,
+		OperationPauseTimer,
+		OperationResumeTimer,
+		OperationAddTime,
+		OperationResetTimer:
+		return ForbiddenError{
+			fmt.Errorf("only the facilitator can '%s'", o.Type),
+		}
+	case OperationVote, OperationUnvote:
+		return nil
+	}
+
+	if s.Locked {
+		return ForbiddenError{errors.New("the board is locked")}
+	}
+
+	if o.Type == OperationDeleteCard {
+		if c, _ := s.findCard(o.CardId); c != nil && !owns(o.AuthorId, c) {
+			return ForbiddenError{
+				errors.New("only the facilitator can delete the cards of others"),
+			}
+		}
+	}
+
+	return nil
+}
+
+// KeepOthersCards undoes the deletions of cards the participant did not
+// write, which the merge of a state they sent made. Cards that were moved
+// are deleted too, but the next card of their chain takes their place, so
+// moves are kept.
+func (s *State) KeepOthersCards(prev *State, pId string) {
+	if prev.Facilitates(pId) {
+		return
+	}
+
+	for _, c := range s.Columns {
+		for _, g := range c.Groups {
+			for _, r := range g.RetroCards {
+				if !r.IsDeleted || owns(pId, r) {
+					continue
+				}
+
+				if p, _ := prev.findCard(r.Id); p == nil || p.IsDeleted {
+					continue
+				}
+
+				if n, _ := s.findCard(nextCardId(r.Id)); n != nil {
+					continue
+				}
+
+				r.IsDeleted = false
+			}
+		}
+	}
+}
+
+func owns(pId string, r *RetroCard) bool {
+	return pId != "" && r.AuthorId == pId
+}
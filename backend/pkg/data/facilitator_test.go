@@ -0,0 +1,199 @@
+package data
+
+import "testing"
+
+func TestOperationAuthorize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name    string
+		Op      *Operation
+		Author  string
+		Locked  bool
+		Allowed bool
+	}{
+		{
+			Name:    "Facilitator Reveals Votes",
+			Op:      &Operation{Type: OperationRevealVotes},
+			Author:  "facilitator",
+			Allowed: true,
+		},
+		{
+			Name:    "Participant Reveals Votes",
+			Op:      &Operation{Type: OperationRevealVotes},
+			Author:  "author",
+			Allowed: false,
+		},
+		{
+			Name:    "Participant Hides Votes",
+			Op:      &Operation{Type: OperationHideVotes},
+			Author:  "author",
+			Allowed: false,
+		},
+		{
+			Name:    "Participant Locks Board",
+			Op:      &Operation{Type: OperationLockBoard},
+			Author:  "author",
+			Allowed: false,
+		},
+		{
+			Name:    "Participant Transfers Facilitator",
+			Op:      &Operation{Type: OperationTransferFacilitator, ParticipantId: "author"},
+			Author:  "author",
+			Allowed: false,
+		},
+		{
+			Name:    "Author Deletes Card",
+			Op:      &Operation{Type: OperationDeleteCard, CardId: "card-pk-0", LastModified: 2},
+			Author:  "author",
+			Allowed: true,
+		},
+		{
+			Name:    "Participant Deletes Card Of Other",
+			Op:      &Operation{Type: OperationDeleteCard, CardId: "card-pk-0", LastModified: 2},
+			Author:  "other",
+			Allowed: false,
+		},
+		{
+			Name:    "Facilitator Deletes Card Of Other",
+			Op:      &Operation{Type: OperationDeleteCard, CardId: "card-pk-0", LastModified: 2},
+			Author:  "facilitator",
+			Allowed: true,
+		},
+		{
+			Name:    "Participant Edits Locked Board",
+			Op:      &Operation{Type: OperationEditCard, CardId: "card-pk-0", Message: "bye", LastModified: 2},
+			Author:  "author",
+			Locked:  true,
+			Allowed: false,
+		},
+		{
+			Name:    "Participant Votes On Locked Board",
+			Op:      &Operation{Type: OperationVote, CardId: "card-pk-0"},
+			Author:  "other",
+			Locked:  true,
+			Allowed: true,
+		},
+		{
+			Name:    "Facilitator Edits Locked Board",
+			Op:      &Operation{Type: OperationEditCard, CardId: "card-pk-0", Message: "bye", LastModified: 2},
+			Author:  "facilitator",
+			Locked:  true,
+			Allowed: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			s := newTestState(t)
+			s.FacilitatorId = "facilitator"
+			s.Locked = test.Locked
+
+			test.Op.RoomId = s.RoomId
+			test.Op.AuthorId = test.Author
+
+			err := test.Op.Apply(s, &Voting{})
+			switch err.(type) {
+			case nil:
+				if !test.Allowed {
+					t.Fatal("expected the operation to be forbidden")
+				}
+			case ForbiddenError:
+				if test.Allowed {
+					t.Fatalf("expected the operation to be allowed, got: %v", err)
+				}
+			default:
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestOperationAuthorizeWithoutFacilitator(t *testing.T) {
+	t.Parallel()
+
+	// rooms created before rooms had facilitators are run by everyone
+	s := newTestState(t)
+
+	for _, op := range []*Operation{
+		{Type: OperationLockBoard},
+		{Type: OperationDeleteCard, CardId: "card-pk-0", LastModified: 2},
+	} {
+		op.RoomId = s.RoomId
+		op.AuthorId = "other"
+
+		if err := op.Apply(s, &Voting{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOperationTransferFacilitator(t *testing.T) {
+	t.Parallel()
+
+	s := newTestState(t)
+	s.FacilitatorId = "facilitator"
+
+	op := &Operation{
+		Type:          OperationTransferFacilitator,
+		RoomId:        s.RoomId,
+		AuthorId:      "facilitator",
+		ParticipantId: "author",
+	}
+	if err := op.Apply(s, &Voting{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Facilitates("author") || s.Facilitates("facilitator") {
+		t.Fatalf("expected 'author' to facilitate, got: '%s'", s.FacilitatorId)
+	}
+}
+
+func TestStateKeepOthersCards(t *testing.T) {
+	t.Parallel()
+
+	prev := newTestState(t)
+	prev.FacilitatorId = "facilitator"
+	prev.Columns[0].Groups[0].RetroCards = append(
+		prev.Columns[0].Groups[0].RetroCards,
+		&RetroCard{Id: "moved-pk-0", ColumnId: "0", GroupId: "default", AuthorId: "author"},
+		&RetroCard{Id: "own-pk-0", ColumnId: "0", GroupId: "default", AuthorId: "other"},
+	)
+
+	s := newTestState(t)
+	s.Columns[0].Groups[0].RetroCards = append(
+		s.Columns[0].Groups[0].RetroCards,
+		&RetroCard{Id: "moved-pk-0", ColumnId: "0", GroupId: "default", AuthorId: "author"},
+		&RetroCard{Id: "own-pk-0", ColumnId: "0", GroupId: "default", AuthorId: "other"},
+	)
+	s.Columns[0].Groups[1].RetroCards = []*RetroCard{
+		{Id: "moved-pk-1", ColumnId: "0", GroupId: "other", AuthorId: "author"},
+	}
+
+	for _, r := range s.Columns[0].Groups[0].RetroCards {
+		r.IsDeleted = true
+	}
+
+	s.KeepOthersCards(prev, "other")
+
+	for _, e := range []struct {
+		id      string
+		deleted bool
+	}{
+		{id: "card-pk-0", deleted: false},
+		{id: "moved-pk-0", deleted: true},
+		{id: "own-pk-0", deleted: true},
+	} {
+		r, err := s.findCard(e.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if r.IsDeleted != e.deleted {
+			t.Fatalf("expected card '%s' deleted to be %t", e.id, e.deleted)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// MessageSnapshot carries the whole board and is only sent when a
+	// client connects.
+	MessageSnapshot = "snapshot"
+	// MessageState carries a whole board sent by a client, which is merged
+	// with the stored board. It is legacy - clients send operations.
+	MessageState = "state"
+	// MessageOperation carries a single change to the board.
+	MessageOperation = "operation"
+	// MessagePresence carries who is connected to the room. It is only
+	// sent by the server.
+	MessagePresence = "presence"
+	// MessageBallot carries how the participant voted. It is only sent by
+	// the server, to the participant.
+	MessageBallot = "ballot"
+	// MessageRejection carries a vote of the participant that was not
+	// counted. It is only sent by the server, to the participant.
+	MessageRejection = "rejection"
+)
+
+// Message is the envelope of everything sent over a retrospective's
+// websocket, in both directions.
+type Message struct {
+	Type      string     `json:"type"`
+	State     *State     `json:"state,omitempty"`
+	Operation *Operation `json:"operation,omitempty"`
+	Presence  *Presence  `json:"presence,omitempty"`
+	Ballot    *Ballot    `json:"ballot,omitempty"`
+	Rejection *Rejection `json:"rejection,omitempty"`
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type target Message
+
+	if err := json.Unmarshal(data, (*target)(m)); err != nil {
+		return err
+	}
+
+	switch m.Type {
+	case MessageSnapshot, MessageState:
+		if m.State == nil {
+			return errors.New("state is nil")
+		}
+	case MessageOperation:
+		if m.Operation == nil {
+			return errors.New("operation is nil")
+		}
+	case MessagePresence:
+		if m.Presence == nil {
+			return errors.New("presence is nil")
+		}
+	case MessageBallot:
+		if m.Ballot == nil {
+			return errors.New("ballot is nil")
+		}
+	case MessageRejection:
+		if m.Rejection == nil {
+			return errors.New("rejection is nil")
+		}
+	default:
+		return fmt.Errorf("invalid message type '%s'", m.Type)
+	}
+
+	return nil
+}
+
+func (m *Message) RoomId() string {
+	switch {
+	case m.State != nil:
+		return m.State.RoomId
+	case m.Operation != nil:
+		return m.Operation.RoomId
+	case m.Presence != nil:
+		return m.Presence.RoomId
+	case m.Ballot != nil:
+		return m.Ballot.RoomId
+	case m.Rejection != nil:
+		return m.Rejection.RoomId
+	default:
+		return ""
+	}
+}
+
+// Redact removes every author id that is not the participant's, so
+// participants of anonymous rooms only know which cards they wrote.
+func (m *Message) Redact(pId string) {
+	if m.State != nil {
+		m.State.redact(pId)
+	}
+
+	if m.Operation != nil {
+		m.Operation.redact(pId)
+	}
+}
+
+// HideBallots removes the ballots of the state, which are sent to each
+// participant on their own.
+func (m *Message) HideBallots() {
+	if m.State != nil {
+		m.State.Ballots = nil
+	}
+}
+
+// Revision returns the revision of the stored state the message results
+// in, or 0 if the message has not been stored yet.
+func (m *Message) Revision() uint64 {
+	switch {
+	case m.State != nil:
+		return m.State.Revision
+	case m.Operation != nil:
+		return m.Operation.Revision
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,495 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OperationAddCard     = "addCard"
+	OperationEditCard    = "editCard"
+	OperationMoveCard    = "moveCard"
+	OperationDeleteCard  = "deleteCard"
+	OperationVote        = "vote"
+	OperationUnvote      = "unvote"
+	OperationCreateGroup = "createGroup"
+	OperationRenameGroup = "renameGroup"
+	// OperationRevealVotes ends the secret voting of the room, and
+	// OperationHideVotes starts a new round of it.
+	OperationRevealVotes = "revealVotes"
+	OperationHideVotes   = "hideVotes"
+	// OperationLockBoard and OperationUnlockBoard stop and let
+	// participants change the board again.
+	OperationLockBoard   = "lockBoard"
+	OperationUnlockBoard = "unlockBoard"
+	// OperationTransferFacilitator makes another participant in the room
+	// the facilitator of the room.
+	OperationTransferFacilitator = "transferFacilitator"
+	// OperationChangePhase moves the room to another phase.
+	OperationChangePhase = "changePhase"
+	// Timer operations control the countdown timer of the room.
+	OperationStartTimer  = "startTimer"
+	OperationPauseTimer  = "pauseTimer"
+	OperationResumeTimer = "resumeTimer"
+	OperationAddTime     = "addTime"
+	OperationResetTimer  = "resetTimer"
+)
+
+// Operation is a single change to a board. Operations are small compared
+// to the whole state, so they are what clients send and what is broadcast
+// to the room.
+type Operation struct {
+	Type         string     `json:"type"`
+	RoomId       string     `json:"roomId"`
+	CardId       string     `json:"cardId,omitempty"`
+	GroupId      string     `json:"groupId,omitempty"`
+	ColumnId     string     `json:"columnId,omitempty"`
+	Message      string     `json:"message,omitempty"`
+	Title        string     `json:"title,omitempty"`
+	LastModified int        `json:"lastModified,omitempty"`
+	Card         *RetroCard `json:"card,omitempty"`
+	Group        *Group     `json:"group,omitempty"`
+	// ParticipantId is the participant an operation is about, like the
+	// new facilitator of the room.
+	ParticipantId string `json:"participantId,omitempty"`
+	Phase         string `json:"phase,omitempty"`
+	// Private makes the brainstorm private when changing the phase to it.
+	// Operations on private cards are marked private by the server.
+	Private bool `json:"private,omitempty"`
+	// Duration is how long to start the timer for, or how much time to
+	// add to it. It is sent in seconds.
+	Duration time.Duration `json:"duration,omitempty"`
+	// Timer is the timer once a timer operation is applied. It is
+	// assigned by the server.
+	Timer *Timer `json:"timer,omitempty"`
+	// AuthorId is the id of the participant who sent the operation. It is
+	// assigned by the server.
+	AuthorId string `json:"authorId,omitempty"`
+	// Revision is the revision of the stored state once the operation has
+	// been applied. It is assigned by the store.
+	Revision uint64 `json:"revision,omitempty"`
+	// DeliveryId is the id of the queue entry the operation was delivered
+	// in. It is assigned by the worker, and is neither stored nor sent.
+	DeliveryId string `json:"-"`
+}
+
+func (o *Operation) MarshalJSON() ([]byte, error) {
+	type target Operation
+
+	return json.Marshal(&struct {
+		*target
+		Duration int64 `json:"duration,omitempty"`
+	}{target: (*target)(o), Duration: toSeconds(o.Duration)})
+}
+
+func (o *Operation) UnmarshalJSON(data []byte) error {
+	type target Operation
+
+	v := &struct {
+		*target
+		Duration int64 `json:"duration,omitempty"`
+	}{target: (*target)(o)}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+
+	o.Duration = fromSeconds(v.Duration)
+
+	if o.RoomId == "" {
+		return errors.New("room id is empty")
+	}
+
+	switch o.Type {
+	case OperationAddCard:
+		if o.Card == nil {
+			return errors.New("card is nil")
+		}
+	case OperationEditCard:
+		if o.CardId == "" {
+			return errors.New("card id is empty")
+		}
+
+		if o.Message == "" {
+			return errors.New("message is empty")
+		}
+
+		if o.LastModified == 0 {
+			return errors.New("last modified is empty")
+		}
+	case OperationMoveCard:
+		if o.CardId == "" {
+			return errors.New("card id is empty")
+		}
+
+		if o.Card == nil {
+			return errors.New("card is nil")
+		}
+
+		if o.Card.Id != nextCardId(o.CardId) {
+			return fmt.Errorf(
+				"got moved card id '%s', expected '%s'",
+				o.Card.Id,
+				nextCardId(o.CardId),
+			)
+		}
+	case OperationDeleteCard:
+		if o.CardId == "" {
+			return errors.New("card id is empty")
+		}
+
+		if o.LastModified == 0 {
+			return errors.New("last modified is empty")
+		}
+	case OperationVote, OperationUnvote:
+		if o.CardId == "" {
+			return errors.New("card id is empty")
+		}
+	case OperationCreateGroup:
+		if o.Group == nil {
+			return errors.New("group is nil")
+		}
+
+		if len(o.Group.RetroCards) != 0 {
+			return errors.New("new group must not contain retro cards")
+		}
+	case OperationRenameGroup:
+		if o.ColumnId == "" {
+			return errors.New("column id is empty")
+		}
+
+		if o.GroupId == "" {
+			return errors.New("group id is empty")
+		}
+
+		if o.Title == "" {
+			return errors.New("title is empty")
+		}
+	case OperationRevealVotes,
+		OperationHideVotes,
+		OperationLockBoard,
+		OperationUnlockBoard:
+	case OperationTransferFacilitator:
+		if o.ParticipantId == "" {
+			return errors.New("participant id is empty")
+		}
+	case OperationChangePhase:
+		if !validPhase(o.Phase) {
+			return fmt.Errorf("invalid phase '%s'", o.Phase)
+		}
+	case OperationStartTimer, OperationAddTime:
+		if err := validTimerDuration(o.Duration); err != nil {
+			return err
+		}
+	case OperationPauseTimer, OperationResumeTimer, OperationResetTimer:
+	default:
+		return fmt.Errorf("invalid operation type '%s'", o.Type)
+	}
+
+	return nil
+}
+
+// Apply changes the state according to the operation. It returns an
+// OperationInvalidError if the operation does not fit the state, for
+// instance when it refers to a card that does not exist, a
+// VoteRejectedError if the room's voting does not allow a vote, and a
+// ForbiddenError if its author is not allowed to make it.
+func (o *Operation) Apply(s *State, v *Voting) error {
+	if o.RoomId != s.RoomId {
+		return OperationInvalidError{
+			fmt.Errorf("got room id '%s', expected '%s'", o.RoomId, s.RoomId),
+		}
+	}
+
+	if err := o.authorize(s); err != nil {
+		return err
+	}
+
+	switch o.Type {
+	case OperationAddCard:
+		if c, _ := s.findCard(o.Card.Id); c != nil {
+			return OperationInvalidError{
+				fmt.Errorf("retro card '%s' already exists", o.Card.Id),
+			}
+		}
+
+		g, err := s.findGroup(o.Card.ColumnId, o.Card.GroupId)
+		if err != nil {
+			return err
+		}
+
+		// Clients of private brainstorms are sent the private cards of
+		// others without a message, but new cards need one.
+		if o.Card.Message == "" {
+			return OperationInvalidError{errors.New("message is empty")}
+		}
+
+		// New cards have no votes, whatever the client sent.
+		o.Card.AuthorId = o.AuthorId
+		o.Card.NumVotes = 0
+		o.Card.Private = s.Private
+		o.Private = s.Private
+		g.RetroCards = append([]*RetroCard{o.Card}, g.RetroCards...)
+	case OperationEditCard:
+		c, err := s.findLiveCard(o.CardId)
+		if err != nil {
+			return err
+		}
+
+		c.Message = o.Message
+		c.LastModified = o.LastModified
+		o.Private = c.Private
+	case OperationMoveCard:
+		c, err := s.findLiveCard(o.CardId)
+		if err != nil {
+			return err
+		}
+
+		if nc, _ := s.findCard(o.Card.Id); nc != nil {
+			return OperationInvalidError{
+				fmt.Errorf("retro card '%s' already exists", o.Card.Id),
+			}
+		}
+
+		g, err := s.findGroup(o.Card.ColumnId, o.Card.GroupId)
+		if err != nil {
+			return err
+		}
+
+		// Only the author of a private card moves it, and they may not
+		// send its message along, but every other card needs one.
+		if o.Card.Message == "" {
+			if !c.Private || !owns(o.AuthorId, c) {
+				return OperationInvalidError{errors.New("message is empty")}
+			}
+
+			o.Card.Message = c.Message
+		}
+
+		// A moved card keeps the votes and the author of the card chain it
+		// belongs to.
+		o.Card.NumVotes = c.NumVotes
+		o.Card.AuthorId = c.AuthorId
+		o.Card.Private = c.Private
+		o.Private = c.Private
+		c.IsDeleted = true
+		c.LastModified = o.Card.LastModified
+
+		g.RetroCards = append([]*RetroCard{o.Card}, g.RetroCards...)
+	case OperationDeleteCard:
+		c, err := s.findLiveCard(o.CardId)
+		if err != nil {
+			return err
+		}
+
+		c.IsDeleted = true
+		c.LastModified = o.LastModified
+	case OperationVote:
+		if err := s.Vote(o.AuthorId, o.CardId, v); err != nil {
+			return err
+		}
+	case OperationUnvote:
+		if err := s.Unvote(o.AuthorId, o.CardId); err != nil {
+			return err
+		}
+	case OperationCreateGroup:
+		col, err := s.findColumn(o.Group.ColumnId)
+		if err != nil {
+			return err
+		}
+
+		for _, g := range col.Groups {
+			if g.Id == o.Group.Id {
+				return OperationInvalidError{
+					fmt.Errorf("group '%s' already exists", o.Group.Id),
+				}
+			}
+		}
+
+		col.Groups = append(col.Groups, o.Group)
+	case OperationRenameGroup:
+		g, err := s.findGroup(o.ColumnId, o.GroupId)
+		if err != nil {
+			return err
+		}
+
+		g.Title = o.Title
+	case OperationRevealVotes:
+		s.VotesRevealed = true
+	case OperationHideVotes:
+		s.VotesRevealed = false
+	case OperationLockBoard:
+		s.Locked = true
+	case OperationUnlockBoard:
+		s.Locked = false
+	case OperationTransferFacilitator:
+		s.FacilitatorId = o.ParticipantId
+	case OperationChangePhase:
+		if err := s.ChangePhase(
+			o.Phase,
+			o.AuthorId,
+			o.Private,
+			time.Now().UTC(),
+		); err != nil {
+			return err
+		}
+	case OperationStartTimer:
+		s.StartTimer(o.Duration, time.Now().UTC())
+	case OperationPauseTimer:
+		if err := s.PauseTimer(time.Now().UTC()); err != nil {
+			return err
+		}
+	case OperationResumeTimer:
+		if err := s.ResumeTimer(time.Now().UTC()); err != nil {
+			return err
+		}
+	case OperationAddTime:
+		if err := s.AddTime(o.Duration, time.Now().UTC()); err != nil {
+			return err
+		}
+	case OperationResetTimer:
+		s.ResetTimer()
+	}
+
+	// Clients count down to the end of the timer the server decided.
+	switch o.Type {
+	case OperationStartTimer,
+		OperationPauseTimer,
+		OperationResumeTimer,
+		OperationAddTime,
+		OperationResetTimer:
+		o.Timer = nil
+		if s.Timer != nil {
+			t := *s.Timer
+			o.Timer = &t
+		}
+	}
+
+	return nil
+}
+
+// Reject returns the rejection of the operation, for its author.
+func (o *Operation) Reject(err error) *Rejection {
+	return &Rejection{
+		RoomId:        o.RoomId,
+		ParticipantId: o.AuthorId,
+		Operation:     o.Type,
+		CardId:        o.CardId,
+		Reason:        err.Error(),
+	}
+}
+
+func (o *Operation) redact(pId string) {
+	if o.AuthorId != pId {
+		o.AuthorId = ""
+	}
+
+	o.Card.redact(pId)
+}
+
+func (s *State) findColumn(cId string) (*Column, error) {
+	for _, c := range s.Columns {
+		if c.Id == cId {
+			return c, nil
+		}
+	}
+
+	return nil, OperationInvalidError{fmt.Errorf("column '%s' does not exist", cId)}
+}
+
+func (s *State) findGroup(cId, gId string) (*Group, error) {
+	c, err := s.findColumn(cId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, g := range c.Groups {
+		if g.Id == gId {
+			return g, nil
+		}
+	}
+
+	return nil, OperationInvalidError{
+		fmt.Errorf("group '%s' does not exist in column '%s'", gId, cId),
+	}
+}
+
+func (s *State) findCard(rId string) (*RetroCard, error) {
+	for _, c := range s.Columns {
+		for _, g := range c.Groups {
+			for _, r := range g.RetroCards {
+				if r.Id == rId {
+					return r, nil
+				}
+			}
+		}
+	}
+
+	return nil, OperationInvalidError{fmt.Errorf("retro card '%s' does not exist", rId)}
+}
+
+func (s *State) findLiveCard(rId string) (*RetroCard, error) {
+	r, err := s.findCard(rId)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.IsDeleted {
+		return nil, OperationInvalidError{fmt.Errorf("retro card '%s' is deleted", rId)}
+	}
+
+	return r, nil
+}
+
+// cardChain returns every card that shares the uuid of the card id.
+//
+// Card ids are of the form "{uuid}-pk-{number}". Moving a card deletes it
+// and creates the "next" card in the new group by incrementing the number
+// after pk, so the cards of a chain are really the same card, and share
+// their votes.
+func (s *State) cardChain(rId string) []*RetroCard {
+	prefix := cardChainId(rId)
+
+	var cs []*RetroCard
+
+	for _, c := range s.Columns {
+		for _, g := range c.Groups {
+			for _, r := range g.RetroCards {
+				if cardChainId(r.Id) == prefix {
+					cs = append(cs, r)
+				}
+			}
+		}
+	}
+
+	return cs
+}
+
+const pkPrefix = "-pk-"
+
+func cardChainId(rId string) string {
+	i := strings.LastIndex(rId, pkPrefix)
+	if i < 0 {
+		return rId
+	}
+
+	return rId[:i]
+}
+
+func nextCardId(rId string) string {
+	i := strings.LastIndex(rId, pkPrefix)
+	if i < 0 {
+		return ""
+	}
+
+	pk, err := strconv.Atoi(rId[i+len(pkPrefix):])
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s%s%d", rId[:i], pkPrefix, pk+1)
+}
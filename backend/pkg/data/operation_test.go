@@ -0,0 +1,439 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func newTestState(t *testing.T) *State {
+	t.Helper()
+
+	s := DefaultTemplate().NewState("test")
+	s.Columns[0].Groups[0].RetroCards = []*RetroCard{
+		{
+			Id:           "card-pk-0",
+			ColumnId:     "0",
+			Message:      "hello",
+			GroupId:      "default",
+			LastModified: 1,
+			AuthorId:     "author",
+		},
+	}
+	s.Columns[0].Groups = append(s.Columns[0].Groups, &Group{
+		Id:         "other",
+		ColumnId:   "0",
+		Title:      "other",
+		RetroCards: []*RetroCard{},
+	})
+
+	return s
+}
+
+func TestOperationUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name  string
+		Op    string
+		Valid bool
+	}{
+		{
+			Name:  "Vote",
+			Op:    `{"type": "vote", "roomId": "test", "cardId": "card-pk-0"}`,
+			Valid: true,
+		},
+		{
+			Name:  "Vote Without Card",
+			Op:    `{"type": "vote", "roomId": "test"}`,
+			Valid: false,
+		},
+		{
+			Name:  "Unvote",
+			Op:    `{"type": "unvote", "roomId": "test", "cardId": "card-pk-0"}`,
+			Valid: true,
+		},
+		{
+			Name:  "Transfer Facilitator",
+			Op:    `{"type": "transferFacilitator", "roomId": "test", "participantId": "other"}`,
+			Valid: true,
+		},
+		{
+			Name:  "Transfer Facilitator Without Participant",
+			Op:    `{"type": "transferFacilitator", "roomId": "test"}`,
+			Valid: false,
+		},
+		{
+			Name:  "Change Phase",
+			Op:    `{"type": "changePhase", "roomId": "test", "phase": "brainstorm"}`,
+			Valid: true,
+		},
+		{
+			Name:  "Change To Unknown Phase",
+			Op:    `{"type": "changePhase", "roomId": "test", "phase": "lunch"}`,
+			Valid: false,
+		},
+		{
+			Name:  "Start Timer",
+			Op:    `{"type": "startTimer", "roomId": "test", "duration": 300}`,
+			Valid: true,
+		},
+		{
+			Name:  "Start Timer For Too Long",
+			Op:    `{"type": "startTimer", "roomId": "test", "duration": 86401}`,
+			Valid: false,
+		},
+		{
+			Name:  "Start Timer Without Duration",
+			Op:    `{"type": "startTimer", "roomId": "test"}`,
+			Valid: false,
+		},
+		{
+			Name:  "Pause Timer",
+			Op:    `{"type": "pauseTimer", "roomId": "test"}`,
+			Valid: true,
+		},
+		{
+			Name:  "Unknown Type",
+			Op:    `{"type": "wrong", "roomId": "test", "cardId": "card-pk-0"}`,
+			Valid: false,
+		},
+		{
+			Name:  "Missing Room",
+			Op:    `{"type": "vote", "cardId": "card-pk-0"}`,
+			Valid: false,
+		},
+		{
+			Name:  "Edit Without Message",
+			Op:    `{"type": "editCard", "roomId": "test", "cardId": "card-pk-0", "lastModified": 2}`,
+			Valid: false,
+		},
+		{
+			Name: "Move To Wrong Id",
+			Op: `{"type": "moveCard", "roomId": "test", "cardId": "card-pk-0", "card": {
+				"id": "card-pk-2", "columnId": "0", "message": "hello",
+				"groupId": "other", "lastModified": 2
+			}}`,
+			Valid: false,
+		},
+		{
+			Name:  "Create Group With Cards",
+			Op:    `{"type": "createGroup", "roomId": "test", "group": {"id": "g", "columnId": "0", "title": "g", "retroCards": [{"id": "c-pk-0", "columnId": "0", "message": "m", "groupId": "g", "lastModified": 1}]}}`,
+			Valid: false,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			var op Operation
+			err := json.Unmarshal([]byte(test.Op), &op)
+
+			if test.Valid && err != nil {
+				t.Fatal(err)
+			}
+
+			if !test.Valid && err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestOperationApply(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name   string
+		Op     *Operation
+		Valid  bool
+		Expect func(t *testing.T, s *State)
+	}{
+		{
+			Name: "Add Card",
+			Op: &Operation{
+				Type:   OperationAddCard,
+				RoomId: "test",
+				Card: &RetroCard{
+					Id:           "new-pk-0",
+					ColumnId:     "1",
+					Message:      "new",
+					GroupId:      "default",
+					LastModified: 2,
+					AuthorId:     "forged",
+					NumVotes:     5,
+				},
+				AuthorId: "other",
+			},
+			Valid: true,
+			Expect: func(t *testing.T, s *State) {
+				if len(s.Columns[1].Groups[0].RetroCards) != 1 {
+					t.Fatal("expected card to be added")
+				}
+
+				if a := s.Columns[1].Groups[0].RetroCards[0].AuthorId; a != "other" {
+					t.Fatalf("expected author 'other', got: '%s'", a)
+				}
+
+				if n := s.Columns[1].Groups[0].RetroCards[0].NumVotes; n != 0 {
+					t.Fatalf("expected a new card without votes, got: %d", n)
+				}
+			},
+		},
+		{
+			Name: "Add Existing Card",
+			Op: &Operation{
+				Type:   OperationAddCard,
+				RoomId: "test",
+				Card: &RetroCard{
+					Id:           "card-pk-0",
+					ColumnId:     "1",
+					Message:      "new",
+					GroupId:      "default",
+					LastModified: 2,
+				},
+			},
+			Valid: false,
+		},
+		{
+			Name: "Edit Card",
+			Op: &Operation{
+				Type:         OperationEditCard,
+				RoomId:       "test",
+				CardId:       "card-pk-0",
+				Message:      "edited",
+				LastModified: 2,
+			},
+			Valid: true,
+			Expect: func(t *testing.T, s *State) {
+				if s.Columns[0].Groups[0].RetroCards[0].Message != "edited" {
+					t.Fatal("expected card to be edited")
+				}
+			},
+		},
+		{
+			Name: "Move Card",
+			Op: &Operation{
+				Type:   OperationMoveCard,
+				RoomId: "test",
+				CardId: "card-pk-0",
+				Card: &RetroCard{
+					Id:           "card-pk-1",
+					ColumnId:     "0",
+					Message:      "hello",
+					GroupId:      "other",
+					LastModified: 2,
+					AuthorId:     "forged",
+				},
+				AuthorId: "other",
+			},
+			Valid: true,
+			Expect: func(t *testing.T, s *State) {
+				if !s.Columns[0].Groups[0].RetroCards[0].IsDeleted {
+					t.Fatal("expected old card to be deleted")
+				}
+
+				if len(s.Columns[0].Groups[1].RetroCards) != 1 {
+					t.Fatal("expected card to be moved")
+				}
+
+				// the moved card keeps the author of the card chain
+				if a := s.Columns[0].Groups[1].RetroCards[0].AuthorId; a != "author" {
+					t.Fatalf("expected author 'author', got: '%s'", a)
+				}
+			},
+		},
+		{
+			Name: "Delete Card",
+			Op: &Operation{
+				Type:         OperationDeleteCard,
+				RoomId:       "test",
+				CardId:       "card-pk-0",
+				LastModified: 2,
+			},
+			Valid: true,
+			Expect: func(t *testing.T, s *State) {
+				if !s.Columns[0].Groups[0].RetroCards[0].IsDeleted {
+					t.Fatal("expected card to be deleted")
+				}
+			},
+		},
+		{
+			Name: "Vote",
+			Op: &Operation{
+				Type:   OperationVote,
+				RoomId: "test",
+				CardId: "card-pk-0",
+			},
+			Valid: true,
+			Expect: func(t *testing.T, s *State) {
+				if s.Columns[0].Groups[0].RetroCards[0].NumVotes != 1 {
+					t.Fatal("expected card to have 1 vote")
+				}
+			},
+		},
+		{
+			Name: "Vote Missing Card",
+			Op: &Operation{
+				Type:   OperationVote,
+				RoomId: "test",
+				CardId: "missing-pk-0",
+			},
+			Valid: false,
+		},
+		{
+			Name: "Create Group",
+			Op: &Operation{
+				Type:   OperationCreateGroup,
+				RoomId: "test",
+				Group: &Group{
+					Id:         "new",
+					ColumnId:   "1",
+					Title:      "new",
+					RetroCards: []*RetroCard{},
+				},
+			},
+			Valid: true,
+			Expect: func(t *testing.T, s *State) {
+				if len(s.Columns[1].Groups) != 2 {
+					t.Fatal("expected group to be created")
+				}
+			},
+		},
+		{
+			Name: "Rename Group",
+			Op: &Operation{
+				Type:     OperationRenameGroup,
+				RoomId:   "test",
+				ColumnId: "0",
+				GroupId:  "other",
+				Title:    "renamed",
+			},
+			Valid: true,
+			Expect: func(t *testing.T, s *State) {
+				if s.Columns[0].Groups[1].Title != "renamed" {
+					t.Fatal("expected group to be renamed")
+				}
+			},
+		},
+		{
+			Name: "Reveal Votes",
+			Op: &Operation{
+				Type:   OperationRevealVotes,
+				RoomId: "test",
+			},
+			Valid: true,
+			Expect: func(t *testing.T, s *State) {
+				if !s.VotesRevealed {
+					t.Fatal("expected votes to be revealed")
+				}
+			},
+		},
+		{
+			Name: "Wrong Room",
+			Op: &Operation{
+				Type:   OperationVote,
+				RoomId: "wrong",
+				CardId: "card-pk-0",
+			},
+			Valid: false,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			s := newTestState(t)
+			err := test.Op.Apply(s, &Voting{})
+
+			if !test.Valid {
+				if _, ok := err.(OperationInvalidError); !ok {
+					t.Fatalf("expected OperationInvalidError, got: %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			test.Expect(t, s)
+		})
+	}
+}
+
+func TestOperationHideVotes(t *testing.T) {
+	t.Parallel()
+
+	s := newTestState(t)
+
+	// votes can be revealed and hidden again for every round of voting
+	for i := 0; i < 2; i++ {
+		for _, typ := range []string{OperationRevealVotes, OperationHideVotes} {
+			op := &Operation{Type: typ, RoomId: "test"}
+			if err := op.Apply(s, &Voting{Secret: true}); err != nil {
+				t.Fatal(err)
+			}
+
+			if s.VotesRevealed != (typ == OperationRevealVotes) {
+				t.Fatalf("expected votes revealed to be %t after '%s'", !s.VotesRevealed, typ)
+			}
+		}
+	}
+}
+
+func TestMessageRedact(t *testing.T) {
+	t.Parallel()
+
+	s := newTestState(t)
+	s.Columns[1].Groups[0].RetroCards = []*RetroCard{
+		{
+			Id:           "mine-pk-0",
+			ColumnId:     "1",
+			Message:      "mine",
+			GroupId:      "default",
+			LastModified: 1,
+			AuthorId:     "me",
+		},
+	}
+	s.Action = &Action{
+		Title:    "upVote",
+		OldCard:  &RetroCard{AuthorId: "author"},
+		NewCard:  &RetroCard{AuthorId: "author"},
+		AuthorId: "author",
+	}
+
+	m := &Message{Type: MessageState, State: s}
+	m.Redact("me")
+
+	if a := s.Columns[0].Groups[0].RetroCards[0].AuthorId; a != "" {
+		t.Fatalf("expected the author of another card to be removed, got: '%s'", a)
+	}
+
+	if a := s.Columns[1].Groups[0].RetroCards[0].AuthorId; a != "me" {
+		t.Fatalf("expected the author of the participant's card, got: '%s'", a)
+	}
+
+	if s.Action.AuthorId != "" || s.Action.OldCard.AuthorId != "" || s.Action.NewCard.AuthorId != "" {
+		t.Fatalf("expected the authors of the action to be removed, got: %+v", s.Action)
+	}
+
+	op := &Message{
+		Type: MessageOperation,
+		Operation: &Operation{
+			Type:     OperationAddCard,
+			RoomId:   "test",
+			Card:     &RetroCard{AuthorId: "author"},
+			AuthorId: "author",
+		},
+	}
+	op.Redact("me")
+
+	if op.Operation.AuthorId != "" || op.Operation.Card.AuthorId != "" {
+		t.Fatalf("expected the authors of the operation to be removed, got: %+v", op.Operation)
+	}
+}
@@ -0,0 +1,9 @@
+package data
+
+// Participant is someone who joined a room.
+type Participant struct {
+	Id          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	// Role is only told to the participant when they join.
+	Role string `json:"role,omitempty"`
+}
@@ -0,0 +1,171 @@
+package data
+
+import (
+	"fmt"
+	"time"
+)
+
+// Phases of a retrospective, in the order they are run.
+const (
+	PhaseBrainstorm = "brainstorm"
+	PhaseGroup      = "group"
+	PhaseVote       = "vote"
+	PhaseDiscuss    = "discuss"
+	PhaseActions    = "actions"
+)
+
+var phases = []string{
+	PhaseBrainstorm,
+	PhaseGroup,
+	PhaseVote,
+	PhaseDiscuss,
+	PhaseActions,
+}
+
+// phaseOperations are the operations that change the board which each
+// phase allows. Operations that control the room are allowed in every
+// phase.
+var phaseOperations = map[string]map[string]bool{
+	PhaseBrainstorm: {
+		OperationAddCard:     true,
+		OperationEditCard:    true,
+		OperationMoveCard:    true,
+		OperationDeleteCard:  true,
+		OperationCreateGroup: true,
+		OperationRenameGroup: true,
+	},
+	PhaseGroup: {
+		OperationEditCard:    true,
+		OperationMoveCard:    true,
+		OperationDeleteCard:  true,
+		OperationCreateGroup: true,
+		OperationRenameGroup: true,
+	},
+	PhaseVote: {
+		OperationVote:   true,
+		OperationUnvote: true,
+	},
+	PhaseDiscuss: {},
+	PhaseActions: {
+		OperationAddCard:    true,
+		OperationEditCard:   true,
+		OperationMoveCard:   true,
+		OperationDeleteCard: true,
+	},
+}
+
+// boardOperations are the operations that phases gate.
+var boardOperations = map[string]bool{
+	OperationAddCard:     true,
+	OperationEditCard:    true,
+	OperationMoveCard:    true,
+	OperationDeleteCard:  true,
+	OperationCreateGroup: true,
+	OperationRenameGroup: true,
+	OperationVote:        true,
+	OperationUnvote:      true,
+}
+
+// PhaseChange is a transition of the room from one phase to the next.
+type PhaseChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// ParticipantId is the id of the participant who changed the phase.
+	ParticipantId string    `json:"participantId"`
+	At            time.Time `json:"at"`
+}
+
+func validPhase(p string) bool {
+	_, ok := phaseOperations[p]
+	return ok
+}
+
+// CheckPhase returns a ForbiddenError if the room's phase does not allow
+// the operation. Rooms without a phase allow every operation.
+func (s *State) CheckPhase(t string) error {
+	if s.Phase == "" || !boardOperations[t] || phaseOperations[s.Phase][t] {
+		return nil
+	}
+
+	return ForbiddenError{
+		fmt.Errorf("'%s' is not allowed during the '%s' phase", t, s.Phase),
+	}
+}
+
+// CheckGroups returns a ForbiddenError if the state, sent by a client to
+// be merged into this one, creates or renames a group the room's phase
+// does not allow, as the operations would.
+func (s *State) CheckGroups(st *State) error {
+	for _, c := range st.Columns {
+		for _, g := range c.Groups {
+			prev, err := s.findGroup(c.Id, g.Id)
+			switch {
+			case err != nil:
+				if err := s.CheckPhase(OperationCreateGroup); err != nil {
+					return err
+				}
+			case prev.Title != g.Title:
+				if err := s.CheckPhase(OperationRenameGroup); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// AllowsCard reports whether the room's phase allows the card to be added
+// to the board. Cards that continue a chain of the board were moved, so
+// they are always allowed.
+func (s *State) AllowsCard(r *RetroCard) bool {
+	return s.CheckPhase(OperationAddCard) == nil || len(s.cardChain(r.Id)) > 0
+}
+
+// ChangePhase moves the room to the phase and records the transition.
+// Rooms start with the first phase, and then move to the next phase or go
+// back to the previous one. It returns a ForbiddenError for any other
+// transition.
+//
+// A brainstorm that is private keeps the cards written during it private
+// until it ends, when every card is revealed.
+func (s *State) ChangePhase(to, pId string, private bool, now time.Time) error {
+	if !s.canChangePhase(to) {
+		return ForbiddenError{
+			fmt.Errorf("cannot change the phase from '%s' to '%s'", s.Phase, to),
+		}
+	}
+
+	s.PhaseHistory = append(s.PhaseHistory, &PhaseChange{
+		From:          s.Phase,
+		To:            to,
+		ParticipantId: pId,
+		At:            now,
+	})
+	s.Phase = to
+
+	if to == PhaseBrainstorm {
+		s.Private = private
+	} else if s.Private {
+		s.revealCards()
+	}
+
+	return nil
+}
+
+func (s *State) canChangePhase(to string) bool {
+	if s.Phase == "" {
+		return to == phases[0]
+	}
+
+	for i, p := range phases {
+		if p != s.Phase {
+			continue
+		}
+
+		return (i+1 < len(phases) && phases[i+1] == to) ||
+			(i > 0 && phases[i-1] == to)
+	}
+
+	return false
+}
@@ -0,0 +1,258 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateChangePhase(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name  string
+		From  string
+		To    string
+		Valid bool
+	}{
+		{Name: "Start", From: "", To: PhaseBrainstorm, Valid: true},
+		{Name: "Start Later", From: "", To: PhaseVote, Valid: false},
+		{Name: "Next", From: PhaseGroup, To: PhaseVote, Valid: true},
+		{Name: "Previous", From: PhaseVote, To: PhaseGroup, Valid: true},
+		{Name: "Skip", From: PhaseBrainstorm, To: PhaseVote, Valid: false},
+		{Name: "Same", From: PhaseDiscuss, To: PhaseDiscuss, Valid: false},
+		{Name: "Past Last", From: PhaseActions, To: PhaseBrainstorm, Valid: false},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			now := time.Now().UTC()
+
+			s := newTestState(t)
+			s.Phase = test.From
+
+			err := s.ChangePhase(test.To, "facilitator", false, now)
+			switch err.(type) {
+			case nil:
+				if !test.Valid {
+					t.Fatalf("expected the change from '%s' to '%s' to fail", test.From, test.To)
+				}
+
+				expected := PhaseChange{
+					From:          test.From,
+					To:            test.To,
+					ParticipantId: "facilitator",
+					At:            now,
+				}
+				if s.Phase != test.To || len(s.PhaseHistory) != 1 || *s.PhaseHistory[0] != expected {
+					t.Fatalf("expected phase '%s' and its change, got: '%s', %+v", test.To, s.Phase, s.PhaseHistory)
+				}
+			case ForbiddenError:
+				if test.Valid {
+					t.Fatal(err)
+				}
+
+				if s.Phase != test.From || len(s.PhaseHistory) != 0 {
+					t.Fatalf("expected phase '%s' without changes, got: '%s'", test.From, s.Phase)
+				}
+			default:
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestOperationApplyPhase(t *testing.T) {
+	t.Parallel()
+
+	newCard := func() *RetroCard {
+		return &RetroCard{
+			Id:           "new-pk-0",
+			ColumnId:     "0",
+			Message:      "new",
+			GroupId:      "default",
+			LastModified: 1,
+		}
+	}
+
+	tests := []struct {
+		Name    string
+		Phase   string
+		Op      *Operation
+		Allowed bool
+	}{
+		{
+			Name:    "Add Card Without Phase",
+			Phase:   "",
+			Op:      &Operation{Type: OperationAddCard, Card: newCard()},
+			Allowed: true,
+		},
+		{
+			Name:    "Add Card During Brainstorm",
+			Phase:   PhaseBrainstorm,
+			Op:      &Operation{Type: OperationAddCard, Card: newCard()},
+			Allowed: true,
+		},
+		{
+			Name:    "Add Card During Vote",
+			Phase:   PhaseVote,
+			Op:      &Operation{Type: OperationAddCard, Card: newCard()},
+			Allowed: false,
+		},
+		{
+			Name:    "Vote During Brainstorm",
+			Phase:   PhaseBrainstorm,
+			Op:      &Operation{Type: OperationVote, CardId: "card-pk-0"},
+			Allowed: false,
+		},
+		{
+			Name:    "Vote During Vote",
+			Phase:   PhaseVote,
+			Op:      &Operation{Type: OperationVote, CardId: "card-pk-0"},
+			Allowed: true,
+		},
+		{
+			Name:  "Move Card During Group",
+			Phase: PhaseGroup,
+			Op: &Operation{
+				Type:   OperationMoveCard,
+				CardId: "card-pk-0",
+				Card: &RetroCard{
+					Id:           "card-pk-1",
+					ColumnId:     "0",
+					Message:      "hello",
+					GroupId:      "other",
+					LastModified: 2,
+				},
+			},
+			Allowed: true,
+		},
+		{
+			Name:  "Rename Group During Discuss",
+			Phase: PhaseDiscuss,
+			Op: &Operation{
+				Type:     OperationRenameGroup,
+				ColumnId: "0",
+				GroupId:  "other",
+				Title:    "new",
+			},
+			Allowed: false,
+		},
+		{
+			Name:    "Reveal Votes During Discuss",
+			Phase:   PhaseDiscuss,
+			Op:      &Operation{Type: OperationRevealVotes},
+			Allowed: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			s := newTestState(t)
+			s.Phase = test.Phase
+
+			test.Op.RoomId = s.RoomId
+			test.Op.AuthorId = "author"
+
+			err := test.Op.Apply(s, &Voting{})
+			switch err.(type) {
+			case nil:
+				if !test.Allowed {
+					t.Fatalf("expected '%s' to be forbidden", test.Op.Type)
+				}
+			case ForbiddenError:
+				if test.Allowed {
+					t.Fatal(err)
+				}
+			default:
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestOperationChangePhase(t *testing.T) {
+	t.Parallel()
+
+	s := newTestState(t)
+	s.FacilitatorId = "facilitator"
+
+	op := &Operation{Type: OperationChangePhase, RoomId: s.RoomId, Phase: PhaseBrainstorm}
+
+	op.AuthorId = "author"
+	if err := op.Apply(s, &Voting{}); err == nil {
+		t.Fatal("expected only the facilitator to change the phase")
+	}
+
+	op.AuthorId = "facilitator"
+	if err := op.Apply(s, &Voting{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Phase != PhaseBrainstorm || len(s.PhaseHistory) != 1 {
+		t.Fatalf("expected the brainstorm to start, got: '%s'", s.Phase)
+	}
+}
+
+func TestStateCheckGroups(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name    string
+		Phase   string
+		Change  func(s *State)
+		Allowed bool
+	}{
+		{
+			Name:    "Unchanged During Actions",
+			Phase:   PhaseActions,
+			Change:  func(s *State) {},
+			Allowed: true,
+		},
+		{
+			Name:  "Create Group During Group",
+			Phase: PhaseGroup,
+			Change: func(s *State) {
+				s.Columns[1].Groups = append(s.Columns[1].Groups, &Group{Id: "new", ColumnId: "1", Title: "new"})
+			},
+			Allowed: true,
+		},
+		{
+			Name:  "Create Group During Actions",
+			Phase: PhaseActions,
+			Change: func(s *State) {
+				s.Columns[1].Groups = append(s.Columns[1].Groups, &Group{Id: "new", ColumnId: "1", Title: "new"})
+			},
+			Allowed: false,
+		},
+		{
+			Name:    "Rename Group During Actions",
+			Phase:   PhaseActions,
+			Change:  func(s *State) { s.Columns[0].Groups[1].Title = "renamed" },
+			Allowed: false,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			prev := newTestState(t)
+			prev.Phase = test.Phase
+
+			s := newTestState(t)
+			test.Change(s)
+
+			err := prev.CheckGroups(s)
+			if _, ok := err.(ForbiddenError); ok == test.Allowed {
+				t.Fatalf("expected the change to be allowed: %t, got: %v", test.Allowed, err)
+			}
+		})
+	}
+}
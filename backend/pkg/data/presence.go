@@ -0,0 +1,16 @@
+package data
+
+const (
+	PresenceJoin  = "join"
+	PresenceLeave = "leave"
+)
+
+// Presence is who is connected to a room. Events carry the participant who
+// joined or left, while the list sent when a client connects carries every
+// participant.
+type Presence struct {
+	RoomId       string         `json:"roomId"`
+	Event        string         `json:"event,omitempty"`
+	Participant  *Participant   `json:"participant,omitempty"`
+	Participants []*Participant `json:"participants,omitempty"`
+}
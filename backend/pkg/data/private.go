@@ -0,0 +1,103 @@
+package data
+
+import "fmt"
+
+// revealCards ends the privacy of every card, once the private brainstorm
+// ends.
+func (s *State) revealCards() {
+	s.Private = false
+
+	for _, c := range s.Columns {
+		for _, g := range c.Groups {
+			for _, r := range g.RetroCards {
+				r.Private = false
+			}
+		}
+	}
+}
+
+// HideCards removes the messages of the private cards the participant did
+// not write. Messages are the participant's own copy, so they can be
+// changed.
+func (m *Message) HideCards(pId string) {
+	if s := m.State; s != nil {
+		for _, c := range s.Columns {
+			for _, g := range c.Groups {
+				for _, r := range g.RetroCards {
+					r.hide(pId)
+				}
+			}
+		}
+
+		if a := s.Action; a != nil {
+			a.OldCard.hide(pId)
+			a.NewCard.hide(pId)
+		}
+	}
+
+	if o := m.Operation; o != nil && o.Private && (pId == "" || o.AuthorId != pId) {
+		o.Message = ""
+
+		if o.Card != nil {
+			o.Card.Message = ""
+		}
+	}
+}
+
+func (r *RetroCard) hide(pId string) {
+	if r != nil && r.Private && !owns(pId, r) {
+		r.Message = ""
+	}
+}
+
+// KeepPrivateCards keeps the private cards of others as they are in the
+// previous state, since the participant who sent the merged state was
+// never sent their messages. Cards keep their privacy, and new cards are
+// private during a private brainstorm, unless they continue a chain.
+func (s *State) KeepPrivateCards(prev *State, pId string) {
+	for _, c := range s.Columns {
+		for _, g := range c.Groups {
+			for _, r := range g.RetroCards {
+				p, _ := prev.findCard(r.Id)
+
+				switch {
+				case p == nil:
+					r.Private = prev.Private
+					if cs := prev.cardChain(r.Id); len(cs) > 0 {
+						r.Private = cs[0].Private
+					}
+				case p.Private && !owns(pId, p):
+					*r = *p
+				default:
+					// The participant may still hold the blank copy of a
+					// card revealed since they were sent it.
+					if r.Message == "" {
+						r.Message = p.Message
+					}
+
+					r.Private = p.Private
+				}
+			}
+		}
+	}
+}
+
+// CheckMessages returns an OperationInvalidError if a card of the state has
+// no message. Cards sent by clients can only be told apart from the
+// private cards of others once KeepPrivateCards restored those, so it must
+// be called after it.
+func (s *State) CheckMessages() error {
+	for _, c := range s.Columns {
+		for _, g := range c.Groups {
+			for _, r := range g.RetroCards {
+				if r.Message == "" {
+					return OperationInvalidError{
+						fmt.Errorf("message of retro card '%s' is empty", r.Id),
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStateChangePhasePrivate(t *testing.T) {
+	t.Parallel()
+
+	s := newTestState(t)
+
+	if err := s.ChangePhase(PhaseBrainstorm, "facilitator", true, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	op := &Operation{
+		Type:   OperationAddCard,
+		RoomId: s.RoomId,
+		Card: &RetroCard{
+			Id:           "new-pk-0",
+			ColumnId:     "0",
+			Message:      "secret",
+			GroupId:      "default",
+			LastModified: 1,
+		},
+		AuthorId: "author",
+	}
+	if err := op.Apply(s, &Voting{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !op.Private || !op.Card.Private {
+		t.Fatal("expected the card to be private")
+	}
+
+	if err := s.ChangePhase(PhaseGroup, "facilitator", false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if c, _ := s.findCard("new-pk-0"); s.Private || c.Private {
+		t.Fatal("expected the cards to be revealed")
+	}
+}
+
+func TestOperationChangePrivateCard(t *testing.T) {
+	t.Parallel()
+
+	s := newTestState(t)
+	s.Private = true
+	s.Columns[0].Groups[0].RetroCards[0].Private = true
+
+	for _, test := range []struct {
+		Author  string
+		Allowed bool
+	}{
+		{Author: "other", Allowed: false},
+		{Author: "author", Allowed: true},
+	} {
+		op := &Operation{
+			Type:         OperationEditCard,
+			RoomId:       s.RoomId,
+			CardId:       "card-pk-0",
+			Message:      "edited",
+			LastModified: 2,
+			AuthorId:     test.Author,
+		}
+
+		err := op.Apply(s, &Voting{})
+		if _, ok := err.(ForbiddenError); ok == test.Allowed {
+			t.Fatalf(
+				"expected the edit of '%s' to be allowed: %t, got: %v",
+				test.Author,
+				test.Allowed,
+				err,
+			)
+		}
+
+		if test.Allowed && !op.Private {
+			t.Fatal("expected the operation to be private")
+		}
+	}
+}
+
+func TestMessageHideCards(t *testing.T) {
+	t.Parallel()
+
+	s := newTestState(t)
+	s.Columns[0].Groups[0].RetroCards = append(
+		s.Columns[0].Groups[0].RetroCards,
+		&RetroCard{Id: "private-pk-0", Message: "mine", AuthorId: "other", Private: true},
+	)
+	s.Columns[0].Groups[0].RetroCards[0].Private = true
+
+	m := &Message{Type: MessageSnapshot, State: s}
+	m.HideCards("other")
+
+	rs := s.Columns[0].Groups[0].RetroCards
+	if rs[0].Message != "" || rs[1].Message != "mine" {
+		t.Fatalf(
+			"expected only the card of 'other' to be shown, got: '%s', '%s'",
+			rs[0].Message,
+			rs[1].Message,
+		)
+	}
+
+	op := &Operation{
+		Type:     OperationEditCard,
+		Message:  "edited",
+		AuthorId: "author",
+		Private:  true,
+	}
+
+	m = &Message{Type: MessageOperation, Operation: op}
+	m.HideCards("other")
+
+	if op.Message != "" {
+		t.Fatalf("expected the edit to be hidden, got: '%s'", op.Message)
+	}
+
+	op.Message = "edited"
+	m.HideCards("author")
+
+	if op.Message != "edited" {
+		t.Fatal("expected the author to see their edit")
+	}
+}
+
+func TestStateKeepPrivateCards(t *testing.T) {
+	t.Parallel()
+
+	prev := newTestState(t)
+	prev.Private = true
+	prev.Columns[0].Groups[0].RetroCards[0].Private = true
+
+	// the state of 'other' was sent the card without its message
+	s := newTestState(t)
+	s.Columns[0].Groups[0].RetroCards[0].Message = ""
+	s.Columns[0].Groups[1].RetroCards = []*RetroCard{
+		{Id: "new-pk-0", ColumnId: "0", Message: "new", GroupId: "other", AuthorId: "other"},
+	}
+
+	s.KeepPrivateCards(prev, "other")
+
+	if c, _ := s.findCard("card-pk-0"); c.Message != "hello" || !c.Private {
+		t.Fatalf("expected the private card to be kept, got: %+v", c)
+	}
+
+	if c, _ := s.findCard("new-pk-0"); !c.Private {
+		t.Fatal("expected the new card to be private")
+	}
+}
+
+func TestRetroCardUnmarshalPrivate(t *testing.T) {
+	t.Parallel()
+
+	const card = `{
+		"id": "card-pk-0",
+		"columnId": "0",
+		"groupId": "default",
+		"lastModified": 1,
+		"private": %t
+	}`
+
+	var r RetroCard
+
+	if err := json.Unmarshal([]byte(fmt.Sprintf(card, true)), &r); err != nil {
+		t.Fatalf("expected private cards without a message, got: %v", err)
+	}
+
+	if err := json.Unmarshal([]byte(fmt.Sprintf(card, false)), &r); err == nil {
+		t.Fatal("expected cards to need a message")
+	}
+}
+
+func TestOperationMovePrivateCard(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		Name    string
+		Private bool
+		Author  string
+		Valid   bool
+	}{
+		{Name: "Own Private Card", Private: true, Author: "author", Valid: true},
+		{Name: "Public Card", Private: false, Author: "author", Valid: false},
+		{Name: "Private Card Of Others", Private: true, Author: "other", Valid: false},
+	} {
+		test := test
+
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			s := newTestState(t)
+			s.Columns[0].Groups[0].RetroCards[0].Private = test.Private
+
+			op := &Operation{
+				Type:   OperationMoveCard,
+				RoomId: s.RoomId,
+				CardId: "card-pk-0",
+				Card: &RetroCard{
+					Id:           "card-pk-1",
+					ColumnId:     "0",
+					GroupId:      "other",
+					LastModified: 2,
+					Private:      true,
+				},
+				AuthorId: test.Author,
+			}
+
+			if err := op.Apply(s, &Voting{}); (err == nil) != test.Valid {
+				t.Fatalf("expected the move to be valid: %t, got: %v", test.Valid, err)
+			}
+
+			if !test.Valid {
+				return
+			}
+
+			if c, _ := s.findCard("card-pk-1"); c.Message != "hello" {
+				t.Fatalf("expected the moved card to keep its message, got: '%s'", c.Message)
+			}
+
+			if err := s.CheckMessages(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestStateKeepRevealedCards(t *testing.T) {
+	t.Parallel()
+
+	// the card was revealed, while 'other' still holds its blank copy
+	prev := newTestState(t)
+
+	s := newTestState(t)
+	s.Columns[0].Groups[0].RetroCards[0].Message = ""
+	s.Columns[0].Groups[1].Title = "renamed"
+
+	s.KeepPrivateCards(prev, "other")
+
+	if err := s.CheckMessages(); err != nil {
+		t.Fatalf("expected the revealed card to be restored, got: %v", err)
+	}
+
+	if c, _ := s.findCard("card-pk-0"); c.Message != "hello" || c.Private {
+		t.Fatalf("expected the revealed card, got: %+v", c)
+	}
+}
@@ -16,6 +16,13 @@ type RetroCard struct {
 	GroupId      string `json:"groupId"`
 	IsDeleted    bool   `json:"isDeleted"`
 	LastModified int    `json:"lastModified"`
+	// AuthorId is the id of the participant who wrote the card. It is
+	// assigned by the server, so the value sent by clients is ignored.
+	AuthorId string `json:"authorId,omitempty"`
+	// Private cards were written during a private brainstorm, and only
+	// their author is sent their message until the brainstorm ends. It is
+	// assigned by the server.
+	Private bool `json:"private,omitempty"`
 }
 
 func (r *RetroCard) UnmarshalJSON(data []byte) error {
@@ -39,7 +46,10 @@ func (r *RetroCard) UnmarshalJSON(data []byte) error {
 		return errors.New("column id is empty")
 	}
 
-	if r.Message == "" {
+	// Clients are sent the private cards of others without a message, so
+	// the message is checked by the store, once it knows which cards are
+	// private. See CheckMessages.
+	if r.Message == "" && !r.Private {
 		return errors.New("message is empty")
 	}
 
@@ -55,3 +65,11 @@ func (r *RetroCard) UnmarshalJSON(data []byte) error {
 
 	return nil
 }
+
+// redact removes the author of the card, unless it was written by the
+// participant.
+func (r *RetroCard) redact(pId string) {
+	if r != nil && r.AuthorId != pId {
+		r.AuthorId = ""
+	}
+}
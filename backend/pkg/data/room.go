@@ -5,13 +5,39 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 var RoomIDRegex = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
 
+// MaxDisplayNameLength is the maximum number of characters in a display
+// name.
+const MaxDisplayNameLength = 50
+
 type Room struct {
 	Id       string `json:"id"`
 	Password string `json:"password"`
+	Template string `json:"template"`
+	// RetentionDays overrides how long the room is kept after its last
+	// activity. Zero uses the server's retention policy.
+	RetentionDays int `json:"retentionDays"`
+	// DisplayName is the name of the participant joining the room.
+	DisplayName string `json:"displayName"`
+	// Anonymous hides who wrote the cards of the room from the other
+	// participants.
+	Anonymous bool `json:"anonymous"`
+	// Voting limits how participants vote in the room.
+	Voting Voting `json:"voting"`
+	// PreviousRoomId is the id of the team's previous room, which the new
+	// room continues. Creating it requires a token for the previous room.
+	PreviousRoomId string `json:"previousRoomId"`
+}
+
+// Settings returns the settings of the room, for when it is created.
+func (r *Room) Settings() *Settings {
+	return &Settings{Anonymous: r.Anonymous, Voting: r.Voting}
 }
 
 func (r *Room) UnmarshalJSON(data []byte) error {
@@ -34,5 +60,80 @@ func (r *Room) UnmarshalJSON(data []byte) error {
 		}
 	}
 
+	if r.PreviousRoomId != "" && !RoomIDRegex.MatchString(r.PreviousRoomId) {
+		return RoomIdInvalidError{
+			fmt.Errorf("invalid previous room '%s'", r.PreviousRoomId),
+		}
+	}
+
+	if r.Template == "" {
+		r.Template = DefaultTemplateName
+	}
+
+	if r.RetentionDays < 0 || r.RetentionDays > MaxRetentionDays {
+		return RetentionInvalidError{
+			fmt.Errorf(
+				"invalid retention of %d days - it must be between 0 and %d",
+				r.RetentionDays,
+				MaxRetentionDays,
+			),
+		}
+	}
+
+	if _, err := TemplateByName(r.Template); err != nil {
+		return err
+	}
+
+	if err := r.Voting.validate(); err != nil {
+		return err
+	}
+
+	r.DisplayName = strings.TrimSpace(r.DisplayName)
+
+	if utf8.RuneCountInString(r.DisplayName) > MaxDisplayNameLength {
+		return NameInvalidError{
+			fmt.Errorf(
+				"invalid display name - it may contain at most %d characters",
+				MaxDisplayNameLength,
+			),
+		}
+	}
+
+	if strings.IndexFunc(r.DisplayName, unicode.IsControl) >= 0 {
+		return NameInvalidError{
+			errors.New("invalid display name - it may not contain control characters"),
+		}
+	}
+
+	return nil
+}
+
+// PasswordChange replaces the password of a room.
+type PasswordChange struct {
+	Id          string `json:"id"`
+	Password    string `json:"password"`
+	NewPassword string `json:"newPassword"`
+}
+
+func (p *PasswordChange) UnmarshalJSON(data []byte) error {
+	type target PasswordChange
+
+	if err := json.Unmarshal(data, (*target)(p)); err != nil {
+		return err
+	}
+
+	if p.Password == "" || p.NewPassword == "" {
+		return PasswordInvalidError{errors.New("password cannot be empty")}
+	}
+
+	if !RoomIDRegex.MatchString(p.Id) {
+		return RoomIdInvalidError{
+			fmt.Errorf(
+				"invalid room '%s' - it may contain letters, numbers, underscores and dashes",
+				p.Id,
+			),
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,10 @@
+package data
+
+// Settings are chosen when a room is created.
+type Settings struct {
+	// Anonymous rooms only tell participants which cards they wrote
+	// themselves.
+	Anonymous bool `json:"anonymous"`
+	// Voting limits how participants vote.
+	Voting Voting `json:"voting"`
+}
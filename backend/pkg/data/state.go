@@ -3,13 +3,49 @@ package data
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 )
 
 type State struct {
 	RoomId  string    `json:"roomId"`
 	Columns []*Column `json:"columns"`
 	Action  *Action   `json:"action"`
+	// Revision increases every time the stored state of the room changes.
+	// It is assigned by the store, so the value sent by clients is ignored.
+	Revision uint64 `json:"revision"`
+	// Ballots are the votes of every participant, keyed by participant id.
+	// They are assigned by the store, and each participant is only sent
+	// their own ballot.
+	Ballots map[string]map[string]uint `json:"ballots,omitempty"`
+	// VotesRevealed ends the secret voting of rooms that vote in secret.
+	// It is assigned by the store.
+	VotesRevealed bool `json:"votesRevealed"`
+	// FacilitatorId is the id of the participant who runs the room. It is
+	// assigned by the store, and is empty for rooms created before rooms
+	// had facilitators, where every participant runs the room.
+	FacilitatorId string `json:"facilitatorId,omitempty"`
+	// Locked stops every participant but the facilitator from changing
+	// the board, except to vote.
+	Locked bool `json:"locked"`
+	// Phase is where the retrospective is, which decides how the board
+	// can be changed. Rooms without a phase can always be changed.
+	Phase string `json:"phase,omitempty"`
+	// PhaseHistory records every change of the phase, oldest first.
+	PhaseHistory []*PhaseChange `json:"phaseHistory,omitempty"`
+	// Private is whether the brainstorm is private, which keeps the cards
+	// written during it private until it ends.
+	Private bool `json:"private"`
+	// Timer is the countdown timer of the room, or nil if it is not
+	// started. It is assigned by the store.
+	Timer *Timer `json:"timer,omitempty"`
+	// AuthorId is the id of the participant who sent the state. It is
+	// assigned by the server, and is not stored.
+	AuthorId string `json:"authorId,omitempty"`
+	// Deliveries are the ids of the last queue entries stored into the
+	// state. They are assigned by the store, and are not sent to clients.
+	Deliveries []string `json:"deliveries,omitempty"`
+	// DeliveryId is the id of the queue entry the state was delivered in.
+	// It is assigned by the worker, and is neither stored nor sent.
+	DeliveryId string `json:"-"`
 }
 
 func (s *State) UnmarshalJSON(data []byte) error {
@@ -23,14 +59,10 @@ func (s *State) UnmarshalJSON(data []byte) error {
 		return errors.New("room id is empty")
 	}
 
-	const numCols = 3
-
-	if len(s.Columns) != numCols {
-		return fmt.Errorf(
-			"got '%d' columns, expected '%d'",
-			len(s.Columns),
-			numCols,
-		)
+	// The number of columns depends on the room's template, which is
+	// checked by the store.
+	if len(s.Columns) < 1 {
+		return errors.New("columns are empty")
 	}
 
 	for _, c := range s.Columns {
@@ -41,3 +73,28 @@ func (s *State) UnmarshalJSON(data []byte) error {
 
 	return nil
 }
+
+// SetAuthor sets the author of every card of the state. The store keeps
+// the author of the cards that already exist, so only new cards end up
+// with the author.
+func (s *State) SetAuthor(pId string) {
+	for _, c := range s.Columns {
+		for _, g := range c.Groups {
+			for _, r := range g.RetroCards {
+				r.AuthorId = pId
+			}
+		}
+	}
+}
+
+func (s *State) redact(pId string) {
+	for _, c := range s.Columns {
+		for _, g := range c.Groups {
+			for _, r := range g.RetroCards {
+				r.redact(pId)
+			}
+		}
+	}
+
+	s.Action.redact(pId)
+}
@@ -0,0 +1,202 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const DefaultTemplateName = "good-bad-actions"
+
+type TemplateColumn struct {
+	Id        string     `json:"id"`
+	Title     string     `json:"title"`
+	CardStyle *CardStyle `json:"cardStyle"`
+}
+
+type Template struct {
+	Name       string            `json:"name"`
+	Columns    []*TemplateColumn `json:"columns"`
+	MinColumns int               `json:"minColumns"`
+	MaxColumns int               `json:"maxColumns"`
+}
+
+func (t *Template) UnmarshalJSON(data []byte) error {
+	type target Template
+
+	if err := json.Unmarshal(data, (*target)(t)); err != nil {
+		return err
+	}
+
+	if t.Name == "" {
+		return errors.New("name is empty")
+	}
+
+	if t.MinColumns < 1 {
+		return fmt.Errorf("got min columns '%d', expected at least 1", t.MinColumns)
+	}
+
+	if t.MaxColumns < t.MinColumns {
+		return fmt.Errorf(
+			"got max columns '%d', expected at least '%d'",
+			t.MaxColumns,
+			t.MinColumns,
+		)
+	}
+
+	if len(t.Columns) < t.MinColumns || len(t.Columns) > t.MaxColumns {
+		return fmt.Errorf(
+			"got '%d' columns, expected between '%d' and '%d'",
+			len(t.Columns),
+			t.MinColumns,
+			t.MaxColumns,
+		)
+	}
+
+	for _, c := range t.Columns {
+		if c == nil {
+			return errors.New("column is nil")
+		}
+
+		if c.Id == "" {
+			return errors.New("column id is empty")
+		}
+
+		if c.Title == "" {
+			return errors.New("column title is empty")
+		}
+
+		if c.CardStyle == nil {
+			return errors.New("column card style is nil")
+		}
+	}
+
+	return nil
+}
+
+// NewState builds the empty board for a room, with one column per
+// template column and the default group in each.
+func (t *Template) NewState(rId string) *State {
+	s := &State{RoomId: rId, Columns: make([]*Column, 0, len(t.Columns))}
+
+	for _, tc := range t.Columns {
+		s.Columns = append(s.Columns, &Column{
+			Id:        tc.Id,
+			Title:     tc.Title,
+			CardStyle: &CardStyle{BackgroundColor: tc.CardStyle.BackgroundColor},
+			Groups: []*Group{
+				{
+					Id:         "default",
+					ColumnId:   tc.Id,
+					Title:      "ungrouped cards",
+					RetroCards: []*RetroCard{},
+				},
+			},
+		})
+	}
+
+	return s
+}
+
+// ValidateState returns a TemplateInvalidError if the columns of the state
+// are not the template's columns, in the same order.
+func (t *Template) ValidateState(s *State) error {
+	if len(s.Columns) != len(t.Columns) {
+		return TemplateInvalidError{
+			fmt.Errorf(
+				"got '%d' columns, expected '%d' for template '%s'",
+				len(s.Columns),
+				len(t.Columns),
+				t.Name,
+			),
+		}
+	}
+
+	for i, c := range s.Columns {
+		if tc := t.Columns[i]; c.Id != tc.Id || c.Title != tc.Title {
+			return TemplateInvalidError{
+				fmt.Errorf(
+					"got column '%s' ('%s'), expected '%s' ('%s') for template '%s'",
+					c.Id,
+					c.Title,
+					tc.Id,
+					tc.Title,
+					t.Name,
+				),
+			}
+		}
+	}
+
+	return nil
+}
+
+func newTemplate(name string, cols ...*TemplateColumn) *Template {
+	return &Template{
+		Name:       name,
+		Columns:    cols,
+		MinColumns: len(cols),
+		MaxColumns: len(cols),
+	}
+}
+
+func newTemplateColumn(id, title, bg string) *TemplateColumn {
+	return &TemplateColumn{
+		Id:        id,
+		Title:     title,
+		CardStyle: &CardStyle{BackgroundColor: bg},
+	}
+}
+
+// The column ids of the default template match the boards created before
+// templates existed, so those rooms keep merging against the same ids.
+var templates = map[string]*Template{
+	DefaultTemplateName: newTemplate(
+		DefaultTemplateName,
+		newTemplateColumn("0", "Good", "bg-success"),
+		newTemplateColumn("1", "Bad", "bg-danger"),
+		newTemplateColumn("3", "Actions", "bg-primary"),
+	),
+	"start-stop-continue": newTemplate(
+		"start-stop-continue",
+		newTemplateColumn("0", "Start", "bg-success"),
+		newTemplateColumn("1", "Stop", "bg-danger"),
+		newTemplateColumn("2", "Continue", "bg-info"),
+		newTemplateColumn("3", "Actions", "bg-primary"),
+	),
+	"4ls": newTemplate(
+		"4ls",
+		newTemplateColumn("0", "Liked", "bg-success"),
+		newTemplateColumn("1", "Learned", "bg-info"),
+		newTemplateColumn("2", "Lacked", "bg-danger"),
+		newTemplateColumn("3", "Longed For", "bg-warning"),
+		newTemplateColumn("4", "Actions", "bg-primary"),
+	),
+	"mad-sad-glad": newTemplate(
+		"mad-sad-glad",
+		newTemplateColumn("0", "Mad", "bg-danger"),
+		newTemplateColumn("1", "Sad", "bg-warning"),
+		newTemplateColumn("2", "Glad", "bg-success"),
+		newTemplateColumn("3", "Actions", "bg-primary"),
+	),
+	"sailboat": newTemplate(
+		"sailboat",
+		newTemplateColumn("0", "Wind", "bg-success"),
+		newTemplateColumn("1", "Anchors", "bg-danger"),
+		newTemplateColumn("2", "Rocks", "bg-warning"),
+		newTemplateColumn("3", "Island", "bg-info"),
+		newTemplateColumn("4", "Actions", "bg-primary"),
+	),
+}
+
+func TemplateByName(name string) (*Template, error) {
+	t, ok := templates[name]
+	if !ok {
+		return nil, TemplateInvalidError{
+			fmt.Errorf("invalid template '%s'", name),
+		}
+	}
+
+	return t, nil
+}
+
+func DefaultTemplate() *Template { return templates[DefaultTemplateName] }
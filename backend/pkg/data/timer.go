@@ -0,0 +1,149 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxTimerDuration bounds how long the timer of a room runs.
+const MaxTimerDuration = 24 * time.Hour
+
+// Timer counts down a timeboxed section of the retrospective for
+// everyone in the room. Its times are assigned by the server, so every
+// client counts down to the same end. Its durations are sent in seconds.
+type Timer struct {
+	// Duration is how long the timer runs, counting the time added to it.
+	Duration time.Duration `json:"duration"`
+	// EndsAt is when the timer ends while it runs, and nil while it is
+	// paused.
+	EndsAt *time.Time `json:"endsAt"`
+	// Remaining is how long the timer has left while it is paused.
+	Remaining time.Duration `json:"remaining"`
+	// UpdatedAt is when the timer last changed, so clients can tell how
+	// far their clock is from the server's.
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (t *Timer) MarshalJSON() ([]byte, error) {
+	type target Timer
+
+	return json.Marshal(&struct {
+		*target
+		Duration  int64 `json:"duration"`
+		Remaining int64 `json:"remaining"`
+	}{
+		target:    (*target)(t),
+		Duration:  toSeconds(t.Duration),
+		Remaining: toSeconds(t.Remaining),
+	})
+}
+
+func (t *Timer) UnmarshalJSON(data []byte) error {
+	type target Timer
+
+	v := &struct {
+		*target
+		Duration  int64 `json:"duration"`
+		Remaining int64 `json:"remaining"`
+	}{target: (*target)(t)}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+
+	t.Duration = fromSeconds(v.Duration)
+	t.Remaining = fromSeconds(v.Remaining)
+
+	return nil
+}
+
+// toSeconds returns the duration in whole seconds, which is how durations
+// are sent, rounding to the closest second.
+func toSeconds(d time.Duration) int64 { return int64(d.Round(time.Second) / time.Second) }
+
+func fromSeconds(s int64) time.Duration { return time.Duration(s) * time.Second }
+
+func validTimerDuration(d time.Duration) error {
+	if d <= 0 || d > MaxTimerDuration {
+		return fmt.Errorf("duration must be between 0 and %s", MaxTimerDuration)
+	}
+
+	return nil
+}
+
+// StartTimer starts the timer over, to run for the duration.
+func (s *State) StartTimer(d time.Duration, now time.Time) {
+	end := now.Add(d)
+	s.Timer = &Timer{Duration: d, EndsAt: &end, UpdatedAt: now}
+}
+
+// PauseTimer pauses the running timer.
+func (s *State) PauseTimer(now time.Time) error {
+	t := s.Timer
+	if t == nil || t.EndsAt == nil {
+		return OperationInvalidError{errors.New("timer is not running")}
+	}
+
+	t.Remaining = t.EndsAt.Sub(now)
+	if t.Remaining < 0 {
+		t.Remaining = 0
+	}
+
+	t.EndsAt = nil
+	t.UpdatedAt = now
+
+	return nil
+}
+
+// ResumeTimer runs the paused timer again, for the time it had left.
+func (s *State) ResumeTimer(now time.Time) error {
+	t := s.Timer
+	if t == nil || t.EndsAt != nil {
+		return OperationInvalidError{errors.New("timer is not paused")}
+	}
+
+	end := now.Add(t.Remaining)
+	t.EndsAt = &end
+	t.Remaining = 0
+	t.UpdatedAt = now
+
+	return nil
+}
+
+// AddTime gives the timer more time. A timer that already ended runs
+// again for the added time.
+func (s *State) AddTime(d time.Duration, now time.Time) error {
+	t := s.Timer
+	if t == nil {
+		return OperationInvalidError{errors.New("timer is not started")}
+	}
+
+	if t.Duration+d > MaxTimerDuration {
+		return OperationInvalidError{
+			fmt.Errorf("timer cannot run for more than %s", MaxTimerDuration),
+		}
+	}
+
+	t.Duration += d
+	t.UpdatedAt = now
+
+	if t.EndsAt == nil {
+		t.Remaining += d
+		return nil
+	}
+
+	end := *t.EndsAt
+	if end.Before(now) {
+		end = now
+	}
+
+	end = end.Add(d)
+	t.EndsAt = &end
+
+	return nil
+}
+
+// ResetTimer removes the timer.
+func (s *State) ResetTimer() { s.Timer = nil }
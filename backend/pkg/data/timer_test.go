@@ -0,0 +1,179 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStateTimer(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2021, 4, 6, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	s := newTestState(t)
+
+	if err := s.PauseTimer(start); err == nil {
+		t.Fatal("expected a timer that is not started not to pause")
+	}
+
+	s.StartTimer(5*time.Minute, start)
+
+	if !s.Timer.EndsAt.Equal(at(5 * time.Minute)) {
+		t.Fatalf("expected the timer to end at %s, got: %s", at(5*time.Minute), s.Timer.EndsAt)
+	}
+
+	if err := s.PauseTimer(at(2 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Timer.EndsAt != nil || s.Timer.Remaining != 3*time.Minute {
+		t.Fatalf("expected 3 minutes left while paused, got: %+v", s.Timer)
+	}
+
+	if err := s.AddTime(time.Minute, at(3*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ResumeTimer(at(10 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Timer.EndsAt.Equal(at(14*time.Minute)) || s.Timer.Duration != 6*time.Minute {
+		t.Fatalf("expected the timer to end at %s, got: %+v", at(14*time.Minute), s.Timer)
+	}
+
+	if err := s.ResumeTimer(at(11 * time.Minute)); err == nil {
+		t.Fatal("expected a running timer not to resume")
+	}
+
+	// a timer that ended runs again for the added time
+	if err := s.AddTime(time.Minute, at(20*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Timer.EndsAt.Equal(at(21 * time.Minute)) {
+		t.Fatalf("expected the timer to end at %s, got: %s", at(21*time.Minute), s.Timer.EndsAt)
+	}
+
+	if err := s.AddTime(MaxTimerDuration, at(20*time.Minute)); err == nil {
+		t.Fatal("expected the timer to be bounded")
+	}
+
+	s.ResetTimer()
+
+	if s.Timer != nil {
+		t.Fatalf("expected no timer, got: %+v", s.Timer)
+	}
+}
+
+func TestOperationApplyTimer(t *testing.T) {
+	t.Parallel()
+
+	s := newTestState(t)
+	s.FacilitatorId = "facilitator"
+
+	op := &Operation{
+		Type:     OperationStartTimer,
+		RoomId:   s.RoomId,
+		Duration: time.Minute,
+		AuthorId: "author",
+	}
+	if _, ok := op.Apply(s, &Voting{}).(ForbiddenError); !ok {
+		t.Fatal("expected only the facilitator to start the timer")
+	}
+
+	op.AuthorId = "facilitator"
+	if err := op.Apply(s, &Voting{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if op.Timer == nil || op.Timer.EndsAt == nil || !op.Timer.EndsAt.Equal(*s.Timer.EndsAt) {
+		t.Fatalf("expected the operation to carry the timer, got: %+v", op.Timer)
+	}
+
+	op = &Operation{Type: OperationResetTimer, RoomId: s.RoomId, AuthorId: "facilitator"}
+	if err := op.Apply(s, &Voting{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if op.Timer != nil || s.Timer != nil {
+		t.Fatal("expected the timer to be reset")
+	}
+}
+
+func TestTimerJSON(t *testing.T) {
+	t.Parallel()
+
+	s := newTestState(t)
+	s.FacilitatorId = "facilitator"
+
+	var op Operation
+	if err := json.Unmarshal(
+		[]byte(`{"type": "startTimer", "roomId": "`+s.RoomId+`", "duration": 300}`),
+		&op,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if op.Duration != 5*time.Minute {
+		t.Fatalf("expected a duration of 5 minutes, got: %s", op.Duration)
+	}
+
+	op.AuthorId = "facilitator"
+	if err := op.Apply(s, &Voting{}); err != nil {
+		t.Fatal(err)
+	}
+
+	byt, err := json.Marshal(&op)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sentOp struct {
+		Duration int64 `json:"duration"`
+		Timer    struct {
+			Duration int64 `json:"duration"`
+		} `json:"timer"`
+	}
+	if err := json.Unmarshal(byt, &sentOp); err != nil {
+		t.Fatal(err)
+	}
+
+	if sentOp.Duration != 300 || sentOp.Timer.Duration != 300 {
+		t.Fatalf("expected durations in seconds, got: %s", byt)
+	}
+
+	if err := s.PauseTimer(
+		s.Timer.UpdatedAt.Add(90*time.Second + 400*time.Millisecond),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	byt, err = json.Marshal(s.Timer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sentTimer struct {
+		Remaining int64 `json:"remaining"`
+	}
+	if err := json.Unmarshal(byt, &sentTimer); err != nil {
+		t.Fatal(err)
+	}
+
+	// durations are rounded to the closest second
+	if sentTimer.Remaining != 210 {
+		t.Fatalf("expected 210 seconds left, got: %s", byt)
+	}
+
+	var tm Timer
+	if err := json.Unmarshal(byt, &tm); err != nil {
+		t.Fatal(err)
+	}
+
+	if tm.Duration != 5*time.Minute || tm.Remaining != 210*time.Second {
+		t.Fatalf("expected the timer to be decoded from seconds, got: %+v", tm)
+	}
+}
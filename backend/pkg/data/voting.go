@@ -0,0 +1,310 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxVotes bounds the votes of a participant, and the votes a participant
+// may give a single card.
+const MaxVotes = 100
+
+// Voting limits how participants vote. Zero values do not limit anything,
+// which is how rooms created before voting settings existed vote.
+type Voting struct {
+	// VotesPerParticipant is how many votes each participant may give.
+	VotesPerParticipant uint `json:"votesPerParticipant"`
+	// MaxVotesPerCard is how many votes each participant may give a
+	// single card.
+	MaxVotesPerCard uint `json:"maxVotesPerCard"`
+	// DenySelfVote stops participants from voting for their own cards.
+	DenySelfVote bool `json:"denySelfVote"`
+	// Secret hides the votes of the other participants until the votes
+	// are revealed.
+	Secret bool `json:"secret"`
+}
+
+// Limited reports whether the room limits voting at all.
+func (v *Voting) Limited() bool {
+	return v.VotesPerParticipant > 0 || v.MaxVotesPerCard > 0 || v.DenySelfVote
+}
+
+func (v *Voting) validate() error {
+	if v.VotesPerParticipant > MaxVotes || v.MaxVotesPerCard > MaxVotes {
+		return VotingInvalidError{
+			fmt.Errorf("invalid voting - votes must be between 0 and %d", MaxVotes),
+		}
+	}
+
+	if v.VotesPerParticipant > 0 && v.MaxVotesPerCard > v.VotesPerParticipant {
+		return VotingInvalidError{
+			errors.New("invalid voting - votes per card exceed votes per participant"),
+		}
+	}
+
+	return nil
+}
+
+// Ballot is how a participant voted. It is only sent to the participant.
+type Ballot struct {
+	RoomId string `json:"roomId"`
+	// Votes is how many votes the participant gave each card chain, keyed
+	// by the id of the chain's first card without its "-pk-" suffix.
+	Votes map[string]uint `json:"votes"`
+	// Remaining is how many votes the participant has left, or nil if
+	// their votes are unlimited.
+	Remaining *uint  `json:"remaining"`
+	Voting    Voting `json:"voting"`
+}
+
+// Vote counts the vote in the ballot, as the state did when it applied it.
+func (b *Ballot) Vote(rId string) {
+	b.Votes[cardChainId(rId)]++
+
+	if b.Remaining != nil && *b.Remaining > 0 {
+		*b.Remaining--
+	}
+}
+
+// Unvote takes back a vote of the ballot, as the state did when it applied
+// the retraction.
+func (b *Ballot) Unvote(rId string) {
+	chain := cardChainId(rId)
+	if b.Votes[chain] == 0 {
+		return
+	}
+
+	b.Votes[chain]--
+	if b.Votes[chain] == 0 {
+		delete(b.Votes, chain)
+	}
+
+	if b.Remaining != nil {
+		*b.Remaining++
+	}
+}
+
+// HideVotes replaces the votes of the message's cards with the votes of the
+// participant's ballot, for rooms that vote in secret. It returns false if
+// the message is a vote of another participant, which is not sent at all.
+func (m *Message) HideVotes(pId string, b *Ballot) bool {
+	own := func(aId string) bool { return pId != "" && aId == pId }
+
+	hide := func(r *RetroCard) {
+		if r == nil {
+			return
+		}
+
+		r.NumVotes = 0
+		if b != nil {
+			r.NumVotes = b.Votes[cardChainId(r.Id)]
+		}
+	}
+
+	if s := m.State; s != nil {
+		for _, c := range s.Columns {
+			for _, g := range c.Groups {
+				for _, r := range g.RetroCards {
+					hide(r)
+				}
+			}
+		}
+
+		// Every action is a vote.
+		if a := s.Action; a != nil {
+			if !own(a.AuthorId) {
+				s.Action = nil
+			} else {
+				hide(a.OldCard)
+				hide(a.NewCard)
+			}
+		}
+	}
+
+	if o := m.Operation; o != nil {
+		switch o.Type {
+		case OperationVote, OperationUnvote:
+			return own(o.AuthorId)
+		}
+
+		hide(o.Card)
+	}
+
+	return true
+}
+
+// Rejection tells a participant that a vote or retraction of theirs was
+// not counted, or that an operation of theirs was not allowed.
+type Rejection struct {
+	RoomId        string `json:"roomId"`
+	ParticipantId string `json:"participantId"`
+	// Operation is the type of the rejected operation. It is empty for
+	// votes sent in states.
+	Operation string `json:"operation,omitempty"`
+	CardId    string `json:"cardId"`
+	Reason    string `json:"reason"`
+}
+
+// Ballot returns how the participant voted, according to the room's
+// voting.
+func (s *State) Ballot(pId string, v *Voting) *Ballot {
+	b := &Ballot{RoomId: s.RoomId, Votes: map[string]uint{}, Voting: *v}
+
+	var n uint
+
+	for chain, votes := range s.Ballots[pId] {
+		b.Votes[chain] = votes
+		n += votes
+	}
+
+	if v.VotesPerParticipant > 0 {
+		var r uint
+		if n < v.VotesPerParticipant {
+			r = v.VotesPerParticipant - n
+		}
+
+		b.Remaining = &r
+	}
+
+	return b
+}
+
+// Vote gives the chain of the card one more vote from the participant. It
+// returns a VoteRejectedError if the room's voting does not allow the
+// vote.
+func (s *State) Vote(pId, rId string, v *Voting) error {
+	c, err := s.findLiveChainCard(rId)
+	if err != nil {
+		return err
+	}
+
+	if v.Limited() && pId == "" {
+		return VoteRejectedError{
+			errors.New("votes of this room can only be given by participants"),
+		}
+	}
+
+	if v.DenySelfVote && c.AuthorId == pId {
+		return VoteRejectedError{
+			errors.New("participants cannot vote for their own cards"),
+		}
+	}
+
+	b := s.Ballot(pId, v)
+
+	if v.MaxVotesPerCard > 0 && b.Votes[cardChainId(rId)] >= v.MaxVotesPerCard {
+		return VoteRejectedError{
+			fmt.Errorf("a card may get at most %d votes from a participant", v.MaxVotesPerCard),
+		}
+	}
+
+	if b.Remaining != nil && *b.Remaining == 0 {
+		return VoteRejectedError{
+			fmt.Errorf("a participant may give at most %d votes", v.VotesPerParticipant),
+		}
+	}
+
+	for _, c := range s.cardChain(rId) {
+		c.NumVotes++
+	}
+
+	s.RecordVote(pId, rId)
+
+	return nil
+}
+
+// Unvote takes back one of the participant's votes for the chain of the
+// card. It returns a VoteRejectedError if the participant has no vote for
+// it to take back.
+func (s *State) Unvote(pId, rId string) error {
+	if _, err := s.findLiveChainCard(rId); err != nil {
+		return err
+	}
+
+	chain := cardChainId(rId)
+
+	if pId == "" || s.Ballots[pId][chain] == 0 {
+		return VoteRejectedError{
+			errors.New("participants can only retract their own votes"),
+		}
+	}
+
+	for _, c := range s.cardChain(rId) {
+		if c.NumVotes > 0 {
+			c.NumVotes--
+		}
+	}
+
+	s.Ballots[pId][chain]--
+	if s.Ballots[pId][chain] == 0 {
+		delete(s.Ballots[pId], chain)
+	}
+
+	if len(s.Ballots[pId]) == 0 {
+		delete(s.Ballots, pId)
+	}
+
+	return nil
+}
+
+// RecordVote records the participant's vote for the chain of the card in
+// their ballot, without counting it in the cards.
+func (s *State) RecordVote(pId, rId string) {
+	if pId == "" {
+		return
+	}
+
+	if s.Ballots == nil {
+		s.Ballots = map[string]map[string]uint{}
+	}
+
+	if s.Ballots[pId] == nil {
+		s.Ballots[pId] = map[string]uint{}
+	}
+
+	s.Ballots[pId][cardChainId(rId)]++
+}
+
+// findLiveChainCard returns the card of the chain that is not deleted, so
+// votes for a card that was moved in the meantime count for where it
+// moved.
+func (s *State) findLiveChainCard(rId string) (*RetroCard, error) {
+	if _, err := s.findCard(rId); err != nil {
+		return nil, err
+	}
+
+	for _, c := range s.cardChain(rId) {
+		if !c.IsDeleted {
+			return c, nil
+		}
+	}
+
+	return nil, OperationInvalidError{fmt.Errorf("retro card '%s' is deleted", rId)}
+}
+
+// KeepVotes sets the votes of every card to the votes of its chain in the
+// previous state, so votes only change when they are counted by the
+// server. Cards that are new have no votes.
+func (s *State) KeepVotes(prev *State) {
+	votes := map[string]uint{}
+
+	if prev != nil {
+		for _, c := range prev.Columns {
+			for _, g := range c.Groups {
+				for _, r := range g.RetroCards {
+					if r.NumVotes > votes[cardChainId(r.Id)] {
+						votes[cardChainId(r.Id)] = r.NumVotes
+					}
+				}
+			}
+		}
+	}
+
+	for _, c := range s.Columns {
+		for _, g := range c.Groups {
+			for _, r := range g.RetroCards {
+				r.NumVotes = votes[cardChainId(r.Id)]
+			}
+		}
+	}
+}
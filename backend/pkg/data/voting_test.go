@@ -0,0 +1,300 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStateVote(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name     string
+		Voting   Voting
+		Voter    string
+		Votes    int
+		Accepted int
+	}{
+		{Name: "Unlimited", Voting: Voting{}, Voter: "voter", Votes: 3, Accepted: 3},
+		{
+			Name:     "Votes Per Participant",
+			Voting:   Voting{VotesPerParticipant: 2},
+			Voter:    "voter",
+			Votes:    3,
+			Accepted: 2,
+		},
+		{
+			Name:     "Max Votes Per Card",
+			Voting:   Voting{VotesPerParticipant: 5, MaxVotesPerCard: 1},
+			Voter:    "voter",
+			Votes:    3,
+			Accepted: 1,
+		},
+		{
+			Name:     "Self Vote",
+			Voting:   Voting{DenySelfVote: true},
+			Voter:    "author",
+			Votes:    1,
+			Accepted: 0,
+		},
+		{
+			Name:     "Without Participant",
+			Voting:   Voting{VotesPerParticipant: 2},
+			Voter:    "",
+			Votes:    1,
+			Accepted: 0,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			s := newTestState(t)
+
+			var accepted int
+
+			for i := 0; i < test.Votes; i++ {
+				err := s.Vote(test.Voter, "card-pk-0", &test.Voting)
+				switch err.(type) {
+				case nil:
+					accepted++
+				case VoteRejectedError:
+				default:
+					t.Fatal(err)
+				}
+			}
+
+			if accepted != test.Accepted {
+				t.Fatalf("expected %d accepted votes, got: %d", test.Accepted, accepted)
+			}
+
+			if n := s.Columns[0].Groups[0].RetroCards[0].NumVotes; n != uint(accepted) {
+				t.Fatalf("expected %d votes on the card, got: %d", accepted, n)
+			}
+
+			b := s.Ballot(test.Voter, &test.Voting)
+			if test.Voter != "" && b.Votes["card"] != uint(accepted) {
+				t.Fatalf("expected %d votes in the ballot, got: %d", accepted, b.Votes["card"])
+			}
+		})
+	}
+}
+
+func TestStateUnvote(t *testing.T) {
+	t.Parallel()
+
+	v := &Voting{VotesPerParticipant: 2}
+
+	s := newTestState(t)
+	if err := s.Vote("voter", "card-pk-0", v); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Unvote("other", "card-pk-0"); err == nil {
+		t.Fatal("expected retracting the vote of another participant to fail")
+	}
+
+	if err := s.Unvote("voter", "card-pk-0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := s.Columns[0].Groups[0].RetroCards[0].NumVotes; n != 0 {
+		t.Fatalf("expected the vote to be retracted, got: %d", n)
+	}
+
+	if b := s.Ballot("voter", v); len(b.Votes) != 0 || *b.Remaining != 2 {
+		t.Fatalf("expected the vote to be given back, got: %+v", b)
+	}
+
+	if _, ok := s.Unvote("voter", "card-pk-0").(VoteRejectedError); !ok {
+		t.Fatal("expected retracting a vote twice to be rejected")
+	}
+}
+
+func TestActionUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	const c = `{"id": "card-pk-0", "columnId": "0", "message": "hello", "groupId": "default", "lastModified": 1}`
+
+	for title, valid := range map[string]bool{
+		ActionUpVote: true,
+		ActionUnVote: true,
+		"downVote":   false,
+	} {
+		var a Action
+		err := json.Unmarshal(
+			[]byte(`{"title": "`+title+`", "oldCard": `+c+`, "newCard": `+c+`}`),
+			&a,
+		)
+
+		if valid && err != nil {
+			t.Fatal(err)
+		}
+
+		if !valid && err == nil {
+			t.Fatalf("expected error for action '%s'", title)
+		}
+	}
+}
+
+func TestMessageHideVotes(t *testing.T) {
+	t.Parallel()
+
+	v := &Voting{Secret: true}
+
+	s := newTestState(t)
+	for _, pId := range []string{"voter", "other", "other"} {
+		if err := s.Vote(pId, "card-pk-0", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := s.Ballot("voter", v)
+
+	c := s.Columns[0].Groups[0].RetroCards[0]
+	s.Action = &Action{Title: ActionUpVote, OldCard: c, NewCard: c, AuthorId: "other"}
+
+	m := &Message{Type: MessageState, State: s}
+	if !m.HideVotes("voter", b) {
+		t.Fatal("expected the state to be sent")
+	}
+
+	if c.NumVotes != 1 {
+		t.Fatalf("expected only the participant's vote, got: %d", c.NumVotes)
+	}
+
+	if s.Action != nil {
+		t.Fatalf("expected the vote of another participant to be removed, got: %+v", s.Action)
+	}
+
+	for pId, expected := range map[string]bool{"voter": true, "other": false, "": false} {
+		op := &Message{
+			Type: MessageOperation,
+			Operation: &Operation{
+				Type:     OperationVote,
+				RoomId:   "test",
+				CardId:   "card-pk-0",
+				AuthorId: pId,
+			},
+		}
+
+		if sent := op.HideVotes("voter", b); sent != expected {
+			t.Fatalf("expected the vote of '%s' to be sent: %t, got: %t", pId, expected, sent)
+		}
+	}
+}
+
+func TestStateVoteDeletedCard(t *testing.T) {
+	t.Parallel()
+
+	s := newTestState(t)
+	s.Columns[0].Groups[0].RetroCards[0].IsDeleted = true
+
+	if err := s.Vote("voter", "card-pk-0", &Voting{}); err == nil {
+		t.Fatal("expected vote for a deleted card to fail")
+	}
+}
+
+func TestBallot(t *testing.T) {
+	t.Parallel()
+
+	v := &Voting{VotesPerParticipant: 3}
+
+	s := newTestState(t)
+	if err := s.Vote("voter", "card-pk-0", v); err != nil {
+		t.Fatal(err)
+	}
+
+	b := s.Ballot("voter", v)
+	if b.Remaining == nil || *b.Remaining != 2 {
+		t.Fatalf("expected 2 remaining votes, got: %v", b.Remaining)
+	}
+
+	b.Vote("card-pk-1")
+	if b.Votes["card"] != 2 || *b.Remaining != 1 {
+		t.Fatalf("expected the vote to be counted, got: %+v", b)
+	}
+
+	if b := s.Ballot("voter", &Voting{}); b.Remaining != nil {
+		t.Fatalf("expected unlimited votes, got: %d", *b.Remaining)
+	}
+}
+
+func TestStateKeepVotes(t *testing.T) {
+	t.Parallel()
+
+	prev := newTestState(t)
+	prev.Columns[0].Groups[0].RetroCards[0].NumVotes = 2
+
+	s := newTestState(t)
+	s.Columns[0].Groups[0].RetroCards[0].NumVotes = 10
+	s.Columns[0].Groups[1].RetroCards = []*RetroCard{
+		{Id: "card-pk-1", NumVotes: 10},
+		{Id: "new-pk-0", NumVotes: 10},
+	}
+
+	s.KeepVotes(prev)
+
+	for _, r := range append(s.Columns[0].Groups[0].RetroCards, s.Columns[0].Groups[1].RetroCards...) {
+		expected := uint(2)
+		if r.Id == "new-pk-0" {
+			expected = 0
+		}
+
+		if r.NumVotes != expected {
+			t.Fatalf("expected %d votes for '%s', got: %d", expected, r.Id, r.NumVotes)
+		}
+	}
+}
+
+func TestRoomVoting(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name   string
+		Voting Voting
+		Valid  bool
+	}{
+		{Name: "Unlimited", Voting: Voting{}, Valid: true},
+		{Name: "Limited", Voting: Voting{VotesPerParticipant: 5, MaxVotesPerCard: 2}, Valid: true},
+		{Name: "Too Many Votes", Voting: Voting{VotesPerParticipant: MaxVotes + 1}, Valid: false},
+		{Name: "Card Exceeds Participant", Voting: Voting{VotesPerParticipant: 1, MaxVotesPerCard: 2}, Valid: false},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			byt, err := json.Marshal(map[string]interface{}{
+				"id":       "test",
+				"password": "test",
+				"voting":   test.Voting,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var r Room
+			err = json.Unmarshal(byt, &r)
+
+			if !test.Valid {
+				if _, ok := err.(VotingInvalidError); !ok {
+					t.Fatalf("expected VotingInvalidError, got: %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.Settings().Voting != test.Voting {
+				t.Fatalf("expected voting %+v, got: %+v", test.Voting, r.Settings().Voting)
+			}
+		})
+	}
+}
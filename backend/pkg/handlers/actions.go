@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/safe-waters/retro-simply/backend/pkg/data"
+	"github.com/safe-waters/retro-simply/backend/pkg/store"
+	"github.com/safe-waters/retro-simply/backend/pkg/user"
+	"go.opentelemetry.io/otel"
+)
+
+var actTr = otel.Tracer("pkg/handlers/actions")
+
+var _ http.Handler = (*Actions)(nil)
+
+type ActionItemStorer interface {
+	Team(ctx context.Context, rId string) (string, error)
+	ActionItems(ctx context.Context, tId string) ([]*data.ActionItem, error)
+	StoreActionItem(ctx context.Context, tId string, a *data.ActionItem) error
+	UpdateActionItem(
+		ctx context.Context,
+		tId string,
+		a *data.ActionItem,
+		e data.Editor,
+	) (*data.ActionItem, error)
+	DeleteActionItem(ctx context.Context, tId, aId string, e data.Editor) error
+	Facilitator(ctx context.Context, rId string) (string, error)
+}
+
+// Actions lists, creates, updates and deletes the action items of the team
+// of the user's room.
+type Actions struct {
+	as ActionItemStorer
+}
+
+func NewActions(as ActionItemStorer) *Actions { return &Actions{as: as} }
+
+func (a *Actions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := actTr.Start(r.Context(), "handlers serve http")
+	defer span.End()
+
+	u, ok := user.FromContext(ctx)
+	if !ok || u.RoomId == "" {
+		err := fmt.Errorf("user '%v' incorrectly set", u)
+		span.RecordError(err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError,
+		)
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete:
+	default:
+		err := fmt.Errorf("'%s' not allowed", r.Method)
+		span.RecordError(err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusMethodNotAllowed),
+			http.StatusMethodNotAllowed,
+		)
+
+		return
+	}
+
+	tId, err := a.as.Team(ctx, u.RoomId)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError,
+		)
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		a.list(ctx, w, u.RoomId, tId)
+	case http.MethodPost:
+		a.create(ctx, w, r, u.RoomId, tId)
+	case http.MethodPut:
+		a.update(ctx, w, r, u, tId)
+	case http.MethodDelete:
+		a.delete(ctx, w, r, u, tId)
+	}
+}
+
+func (a *Actions) list(
+	ctx context.Context,
+	w http.ResponseWriter,
+	rId string,
+	tId string,
+) {
+	ctx, span := actTr.Start(ctx, "handlers list")
+	defer span.End()
+
+	as, err := a.as.ActionItems(ctx, tId)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError,
+		)
+
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(
+		&data.ActionItems{RoomId: rId, ActionItems: as},
+	); err != nil {
+		span.RecordError(err)
+	}
+}
+
+// create adds an action item to the team's. Its id, room and times are
+// assigned by the server.
+func (a *Actions) create(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	rId string,
+	tId string,
+) {
+	ctx, span := actTr.Start(ctx, "handlers create")
+	defer span.End()
+
+	var ai data.ActionItem
+	if err := a.decode(ctx, w, r, &ai); err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	now := time.Now().UTC()
+	ai.Id = uuid.New().String()
+	ai.RoomId = rId
+	ai.CreatedAt = now
+	ai.UpdatedAt = now
+
+	if err := a.as.StoreActionItem(ctx, tId, &ai); err != nil {
+		span.RecordError(err)
+
+		switch err.(type) {
+		case data.ActionItemInvalidError:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(
+				w,
+				http.StatusText(http.StatusInternalServerError),
+				http.StatusInternalServerError,
+			)
+		}
+
+		return
+	}
+
+	a.write(ctx, w, http.StatusCreated, &ai)
+}
+
+// update changes the title, assignee, due date and status of one of the
+// team's action items.
+func (a *Actions) update(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	u user.U,
+	tId string,
+) {
+	ctx, span := actTr.Start(ctx, "handlers update")
+	defer span.End()
+
+	var ai data.ActionItem
+	if err := a.decode(ctx, w, r, &ai); err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	e, err := a.editor(ctx, u)
+	if err != nil {
+		span.RecordError(err)
+		a.writeError(ctx, w, r, err)
+
+		return
+	}
+
+	ua, err := a.as.UpdateActionItem(ctx, tId, &ai, e)
+	if err != nil {
+		span.RecordError(err)
+		a.writeError(ctx, w, r, err)
+
+		return
+	}
+
+	a.write(ctx, w, http.StatusOK, ua)
+}
+
+// delete removes the team's action item with the id of the query.
+func (a *Actions) delete(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	u user.U,
+	tId string,
+) {
+	ctx, span := actTr.Start(ctx, "handlers delete")
+	defer span.End()
+
+	aId := r.URL.Query().Get("id")
+	if aId == "" {
+		err := errors.New("id of the action item is missing")
+		span.RecordError(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	e, err := a.editor(ctx, u)
+	if err != nil {
+		span.RecordError(err)
+		a.writeError(ctx, w, r, err)
+
+		return
+	}
+
+	if err := a.as.DeleteActionItem(ctx, tId, aId, e); err != nil {
+		span.RecordError(err)
+		a.writeError(ctx, w, r, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// editor returns the user as the editor of the team's action items, who
+// may change any of them if they facilitate their room.
+func (a *Actions) editor(ctx context.Context, u user.U) (data.Editor, error) {
+	ctx, span := actTr.Start(ctx, "handlers editor")
+	defer span.End()
+
+	fId, err := a.as.Facilitator(ctx, u.RoomId)
+	if err != nil {
+		span.RecordError(err)
+		return data.Editor{}, err
+	}
+
+	return data.Editor{
+		Name:        u.DisplayName,
+		Facilitator: u.ParticipantId != "" && u.ParticipantId == fId,
+	}, nil
+}
+
+func (a *Actions) writeError(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	err error,
+) {
+	_, span := actTr.Start(ctx, "handlers write error")
+	defer span.End()
+
+	switch err.(type) {
+	case store.DataDoesNotExistError:
+		http.NotFound(w, r)
+	case data.ForbiddenError:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(
+			w,
+			http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError,
+		)
+	}
+}
+
+func (a *Actions) decode(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	ai *data.ActionItem,
+) error {
+	_, span := actTr.Start(ctx, "handlers decode")
+	defer span.End()
+
+	if err := json.NewDecoder(r.Body).Decode(ai); err != nil {
+		span.RecordError(err)
+
+		msg := http.StatusText(http.StatusBadRequest)
+		if _, ok := err.(data.ActionItemInvalidError); ok {
+			msg = err.Error()
+		}
+
+		http.Error(w, msg, http.StatusBadRequest)
+
+		return err
+	}
+
+	return nil
+}
+
+func (a *Actions) write(
+	ctx context.Context,
+	w http.ResponseWriter,
+	status int,
+	ai *data.ActionItem,
+) {
+	_, span := actTr.Start(ctx, "handlers write")
+	defer span.End()
+
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(ai); err != nil {
+		span.RecordError(err)
+	}
+}
@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/safe-waters/retro-simply/backend/pkg/data"
+	"github.com/safe-waters/retro-simply/backend/pkg/store"
+)
+
+type mockActionItemStorer struct {
+	teams        map[string]string
+	facilitators map[string]string
+	as           map[string][]*data.ActionItem
+	mu           *sync.Mutex
+}
+
+func newMockActionItemStorer(
+	teams map[string]string,
+	facilitators map[string]string,
+) *mockActionItemStorer {
+	return &mockActionItemStorer{
+		teams:        teams,
+		facilitators: facilitators,
+		as:           map[string][]*data.ActionItem{},
+		mu:           &sync.Mutex{},
+	}
+}
+
+func (m *mockActionItemStorer) Team(ctx context.Context, rId string) (string, error) {
+	tId, ok := m.teams[rId]
+	if !ok {
+		return "", errors.New("team unavailable")
+	}
+
+	return tId, nil
+}
+
+func (m *mockActionItemStorer) ActionItems(
+	ctx context.Context,
+	tId string,
+) ([]*data.ActionItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]*data.ActionItem{}, m.as[tId]...), nil
+}
+
+func (m *mockActionItemStorer) StoreActionItem(
+	ctx context.Context,
+	tId string,
+	a *data.ActionItem,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if a.CardId == "missing-pk-0" {
+		return data.ActionItemInvalidError{
+			Err: fmt.Errorf("retro card '%s' does not exist", a.CardId),
+		}
+	}
+
+	m.as[tId] = append(m.as[tId], a)
+
+	return nil
+}
+
+func (m *mockActionItemStorer) UpdateActionItem(
+	ctx context.Context,
+	tId string,
+	a *data.ActionItem,
+	e data.Editor,
+) (*data.ActionItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ea := range m.as[tId] {
+		if ea.Id == a.Id {
+			if err := ea.CheckEditor(e); err != nil {
+				return nil, err
+			}
+
+			ea.Update(a, time.Now().UTC())
+
+			return ea, nil
+		}
+	}
+
+	return nil, store.DataDoesNotExistError{
+		Err: errors.New("action item does not exist"),
+	}
+}
+
+func (m *mockActionItemStorer) DeleteActionItem(
+	ctx context.Context,
+	tId string,
+	aId string,
+	e data.Editor,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, ea := range m.as[tId] {
+		if ea.Id == aId {
+			if err := ea.CheckEditor(e); err != nil {
+				return err
+			}
+
+			m.as[tId] = append(m.as[tId][:i], m.as[tId][i+1:]...)
+
+			return nil
+		}
+	}
+
+	return store.DataDoesNotExistError{
+		Err: errors.New("action item does not exist"),
+	}
+}
+
+func (m *mockActionItemStorer) Facilitator(ctx context.Context, rId string) (string, error) {
+	return m.facilitators[rId], nil
+}
+
+func actionsRequest(
+	t *testing.T,
+	h http.Handler,
+	method string,
+	rId string,
+	query string,
+	body string,
+) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(
+		method,
+		"/api/v1/actions/"+rId+"?"+query,
+		strings.NewReader(body),
+	)
+	res := httptest.NewRecorder()
+
+	h.ServeHTTP(res, req)
+
+	return res
+}
+
+func TestActions(t *testing.T) {
+	t.Parallel()
+
+	as := newMockActionItemStorer(
+		map[string]string{"first": "team", "second": "team"},
+		map[string]string{"first": "facilitator"},
+	)
+	first := mockUserMiddleware("first")(NewActions(as))
+	second := mockUserMiddleware("second")(NewActions(as))
+
+	res := actionsRequest(
+		t,
+		first,
+		http.MethodPost,
+		"first",
+		"",
+		`{"title": "fix the build", "assignee": "Ada", "cardId": "card-pk-0"}`,
+	)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got: %d", http.StatusCreated, res.Code)
+	}
+
+	var created data.ActionItem
+	if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	if created.Id == "" || created.RoomId != "first" || created.Status != data.ActionItemOpen {
+		t.Fatalf("expected an open action item of room 'first', got: %+v", created)
+	}
+
+	// the rooms of a team share their action items
+	res = actionsRequest(t, second, http.MethodGet, "second", "", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got: %d", http.StatusOK, res.Code)
+	}
+
+	var got data.ActionItems
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.ActionItems) != 1 || got.ActionItems[0].Id != created.Id {
+		t.Fatalf("expected action item '%s', got: %+v", created.Id, got.ActionItems)
+	}
+
+	// the assignee can change the action item from any room of the team
+	res = actionsRequest(
+		t,
+		second,
+		http.MethodPut,
+		"second",
+		"displayName=Ada",
+		`{"id": "`+created.Id+`", "title": "fix the build", "status": "done", "roomId": "forged"}`,
+	)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got: %d", http.StatusOK, res.Code)
+	}
+
+	var updated data.ActionItem
+	if err := json.NewDecoder(res.Body).Decode(&updated); err != nil {
+		t.Fatal(err)
+	}
+
+	if updated.Status != data.ActionItemDone || updated.RoomId != "first" {
+		t.Fatalf("expected a done action item of room 'first', got: %+v", updated)
+	}
+
+	res = actionsRequest(
+		t,
+		first,
+		http.MethodPost,
+		"first",
+		"",
+		`{"title": "retire the old runner", "assignee": "Grace"}`,
+	)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got: %d", http.StatusCreated, res.Code)
+	}
+
+	var retired data.ActionItem
+	if err := json.NewDecoder(res.Body).Decode(&retired); err != nil {
+		t.Fatal(err)
+	}
+
+	// the facilitator can delete any action item
+	res = actionsRequest(
+		t,
+		first,
+		http.MethodDelete,
+		"first",
+		"participantId=facilitator&id="+retired.Id,
+		"",
+	)
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("expected status code %d, got: %d", http.StatusNoContent, res.Code)
+	}
+
+	if as, _ := as.ActionItems(context.Background(), "team"); len(as) != 1 {
+		t.Fatalf("expected 1 action item, got: %d", len(as))
+	}
+
+	tests := []struct {
+		Name   string
+		Method string
+		RId    string
+		Query  string
+		Body   string
+		Code   int
+	}{
+		{
+			Name:   "Missing Title",
+			Method: http.MethodPost,
+			RId:    "first",
+			Body:   `{"title": " "}`,
+			Code:   http.StatusBadRequest,
+		},
+		{
+			Name:   "Missing Card",
+			Method: http.MethodPost,
+			RId:    "first",
+			Body:   `{"title": "t", "cardId": "missing-pk-0"}`,
+			Code:   http.StatusBadRequest,
+		},
+		{
+			Name:   "Unknown Status",
+			Method: http.MethodPut,
+			RId:    "first",
+			Query:  "displayName=Ada",
+			Body:   `{"id": "` + created.Id + `", "title": "t", "status": "wrong"}`,
+			Code:   http.StatusBadRequest,
+		},
+		{
+			Name:   "Missing Action Item",
+			Method: http.MethodPut,
+			RId:    "first",
+			Query:  "displayName=Ada",
+			Body:   `{"id": "missing", "title": "t"}`,
+			Code:   http.StatusNotFound,
+		},
+		{
+			Name:   "Update By Facilitator",
+			Method: http.MethodPut,
+			RId:    "first",
+			Query:  "participantId=facilitator",
+			Body:   `{"id": "` + created.Id + `", "title": "fix the build", "assignee": "Ada"}`,
+			Code:   http.StatusOK,
+		},
+		{
+			Name:   "Update By Other",
+			Method: http.MethodPut,
+			RId:    "first",
+			Query:  "displayName=Grace",
+			Body:   `{"id": "` + created.Id + `", "title": "t", "assignee": "Grace"}`,
+			Code:   http.StatusForbidden,
+		},
+		{
+			Name:   "Update By Facilitator Of Other Room",
+			Method: http.MethodPut,
+			RId:    "second",
+			Query:  "participantId=facilitator",
+			Body:   `{"id": "` + created.Id + `", "title": "t"}`,
+			Code:   http.StatusForbidden,
+		},
+		{
+			Name:   "Delete By Other",
+			Method: http.MethodDelete,
+			RId:    "first",
+			Query:  "displayName=Grace&id=" + created.Id,
+			Code:   http.StatusForbidden,
+		},
+		{
+			Name:   "Delete Missing Id",
+			Method: http.MethodDelete,
+			RId:    "first",
+			Query:  "participantId=facilitator",
+			Code:   http.StatusBadRequest,
+		},
+		{
+			Name:   "Delete Missing Action Item",
+			Method: http.MethodDelete,
+			RId:    "first",
+			Query:  "participantId=facilitator&id=missing",
+			Code:   http.StatusNotFound,
+		},
+		{
+			Name:   "Method Not Allowed",
+			Method: http.MethodPatch,
+			RId:    "first",
+			Code:   http.StatusMethodNotAllowed,
+		},
+		{
+			Name:   "Team Unavailable",
+			Method: http.MethodGet,
+			RId:    "other",
+			Code:   http.StatusInternalServerError,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			h := mockUserMiddleware(test.RId)(NewActions(as))
+
+			res := actionsRequest(t, h, test.Method, test.RId, test.Query, test.Body)
+			if res.Code != test.Code {
+				t.Fatalf("expected status code %d, got: %d", test.Code, res.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/safe-waters/retro-simply/backend/pkg/data"
+	"github.com/safe-waters/retro-simply/backend/pkg/store"
+	"github.com/safe-waters/retro-simply/backend/pkg/user"
+	"go.opentelemetry.io/otel"
+)
+
+var expTr = otel.Tracer("pkg/handlers/expiry")
+
+var _ http.Handler = (*Expiry)(nil)
+
+type ExpiryGetter interface {
+	Expiry(ctx context.Context, rId string) (*data.Expiry, error)
+}
+
+// Expiry responds with when the user's room expires.
+type Expiry struct {
+	eg ExpiryGetter
+}
+
+func NewExpiry(eg ExpiryGetter) *Expiry { return &Expiry{eg: eg} }
+
+func (e *Expiry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := expTr.Start(r.Context(), "handlers serve http")
+	defer span.End()
+
+	u, ok := user.FromContext(ctx)
+	if !ok || u.RoomId == "" {
+		err := fmt.Errorf("user '%v' incorrectly set", u)
+		span.RecordError(err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError,
+		)
+
+		return
+	}
+
+	ex, err := e.eg.Expiry(ctx, u.RoomId)
+	if err != nil {
+		span.RecordError(err)
+
+		switch err.(type) {
+		case store.DataDoesNotExistError:
+			http.NotFound(w, r)
+		default:
+			http.Error(
+				w,
+				http.StatusText(http.StatusInternalServerError),
+				http.StatusInternalServerError,
+			)
+		}
+
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(ex); err != nil {
+		span.RecordError(err)
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/safe-waters/retro-simply/backend/pkg/data"
+	"github.com/safe-waters/retro-simply/backend/pkg/store"
+)
+
+type mockExpiryGetter struct{ es map[string]*data.Expiry }
+
+func (m *mockExpiryGetter) Expiry(ctx context.Context, rId string) (*data.Expiry, error) {
+	e, ok := m.es[rId]
+	if !ok {
+		return nil, store.DataDoesNotExistError{Err: errors.New("expiry does not exist")}
+	}
+
+	return e, nil
+}
+
+func TestExpiry(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	e := data.NewExpiry(now, 24*time.Hour, 0)
+
+	mg := &mockExpiryGetter{es: map[string]*data.Expiry{"test": e}}
+
+	tests := []struct {
+		Name string
+		RId  string
+		Code int
+	}{
+		{Name: "Room With Expiry", RId: "test", Code: http.StatusOK},
+		{Name: "Room Without Expiry", RId: "other", Code: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			route := "/api/v1/expiry/"
+			h := mockUserMiddleware(test.RId)(NewExpiry(mg))
+
+			req := httptest.NewRequest(http.MethodGet, route+test.RId, nil)
+			res := httptest.NewRecorder()
+
+			h.ServeHTTP(res, req)
+
+			if res.Code != test.Code {
+				t.Fatalf("expected status code %d, got: %d", test.Code, res.Code)
+			}
+
+			if test.Code != http.StatusOK {
+				return
+			}
+
+			var got data.Expiry
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+
+			expected := now.Add(24 * time.Hour)
+			if got.ExpiresAt == nil || !got.ExpiresAt.Equal(expected) {
+				t.Fatalf("expected expiry at %v, got: %v", expected, got.ExpiresAt)
+			}
+		})
+	}
+}
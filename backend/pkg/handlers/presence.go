@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/safe-waters/retro-simply/backend/pkg/data"
+	"github.com/safe-waters/retro-simply/backend/pkg/user"
+	"go.opentelemetry.io/otel"
+)
+
+var preTr = otel.Tracer("pkg/handlers/presence")
+
+var _ http.Handler = (*Presence)(nil)
+
+type ParticipantsGetter interface {
+	Participants(ctx context.Context, rId string) ([]*data.Participant, error)
+}
+
+// Presence responds with who is connected to the user's room.
+type Presence struct {
+	pg ParticipantsGetter
+}
+
+func NewPresence(pg ParticipantsGetter) *Presence { return &Presence{pg: pg} }
+
+func (p *Presence) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := preTr.Start(r.Context(), "handlers serve http")
+	defer span.End()
+
+	u, ok := user.FromContext(ctx)
+	if !ok || u.RoomId == "" {
+		err := fmt.Errorf("user '%v' incorrectly set", u)
+		span.RecordError(err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError,
+		)
+
+		return
+	}
+
+	ps, err := p.pg.Participants(ctx, u.RoomId)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError,
+		)
+
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(
+		&data.Presence{RoomId: u.RoomId, Participants: ps},
+	); err != nil {
+		span.RecordError(err)
+	}
+}
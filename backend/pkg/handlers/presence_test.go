@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/safe-waters/retro-simply/backend/pkg/data"
+)
+
+type mockParticipantsGetter struct {
+	ps map[string][]*data.Participant
+}
+
+func (m *mockParticipantsGetter) Participants(
+	ctx context.Context,
+	rId string,
+) ([]*data.Participant, error) {
+	ps, ok := m.ps[rId]
+	if !ok {
+		return nil, errors.New("participants unavailable")
+	}
+
+	return ps, nil
+}
+
+func TestPresence(t *testing.T) {
+	t.Parallel()
+
+	ada := &data.Participant{Id: "a", DisplayName: "Ada"}
+	mg := &mockParticipantsGetter{
+		ps: map[string][]*data.Participant{"test": {ada}},
+	}
+
+	tests := []struct {
+		Name string
+		RId  string
+		Code int
+	}{
+		{Name: "Room With Participants", RId: "test", Code: http.StatusOK},
+		{Name: "Unavailable", RId: "other", Code: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			route := "/api/v1/presence/"
+			h := mockUserMiddleware(test.RId)(NewPresence(mg))
+
+			req := httptest.NewRequest(http.MethodGet, route+test.RId, nil)
+			res := httptest.NewRecorder()
+
+			h.ServeHTTP(res, req)
+
+			if res.Code != test.Code {
+				t.Fatalf("expected status code %d, got: %d", test.Code, res.Code)
+			}
+
+			if test.Code != http.StatusOK {
+				return
+			}
+
+			var got data.Presence
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+
+			expectState(
+				t,
+				&data.Presence{RoomId: test.RId, Participants: []*data.Participant{ada}},
+				&got,
+			)
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/safe-waters/retro-simply/backend/pkg/auth"
 	"github.com/safe-waters/retro-simply/backend/pkg/data"
 	"github.com/safe-waters/retro-simply/backend/pkg/store"
@@ -23,10 +24,44 @@ type PasswordHashStorer interface {
 	StoreHashedPassword(ctx context.Context, rId, h string) error
 }
 
+type RoomStorer interface {
+	PasswordHashStorer
+	StoreTemplate(ctx context.Context, rId string, t *data.Template) error
+	StoreSettings(ctx context.Context, rId string, st *data.Settings) error
+	StoreExpiry(ctx context.Context, rId string, idle time.Duration) error
+	StoreFacilitator(ctx context.Context, rId, pId string) error
+	Facilitator(ctx context.Context, rId string) (string, error)
+	StoreTeam(ctx context.Context, rId, tId string) error
+	Team(ctx context.Context, rId string) (string, error)
+	CarryActionItems(ctx context.Context, rId, tId string) error
+	ChangeHashedPassword(ctx context.Context, rId, h string) (string, error)
+	DeleteRoom(ctx context.Context, rId string) error
+	TokenGeneration(ctx context.Context, rId string) (string, error)
+	RotateTokenGeneration(ctx context.Context, rId string) (string, error)
+}
+
 type TokenSetter interface {
 	SetToken(ctx context.Context, w http.ResponseWriter, c *auth.Claims) error
 }
 
+type TokenSetValidator interface {
+	TokenSetter
+	ValidateToken(
+		ctx context.Context,
+		r *http.Request,
+		cc *auth.ComparisonClaims,
+	) (*auth.Claims, error)
+}
+
+type Disconnecter interface {
+	PublishDisconnect(ctx context.Context, rId, reason string) error
+}
+
+type AttemptRecorder interface {
+	Fail(ctx context.Context, r *http.Request, rId string)
+	Succeed(ctx context.Context, r *http.Request, rId string)
+}
+
 type PasswordHashComparer interface {
 	HashPassword(ctx context.Context, p string) (string, error)
 	CompareHashAndPassword(ctx context.Context, h, p string) error
@@ -34,22 +69,28 @@ type PasswordHashComparer interface {
 
 type Registration struct {
 	route string
-	phs   PasswordHashStorer
-	ts    TokenSetter
+	rs    RoomStorer
+	ts    TokenSetValidator
 	phc   PasswordHashComparer
+	d     Disconnecter
+	ar    AttemptRecorder
 }
 
 func NewRegistration(
 	route string,
-	phs PasswordHashStorer,
-	ts TokenSetter,
+	rs RoomStorer,
+	ts TokenSetValidator,
 	phc PasswordHashComparer,
+	d Disconnecter,
+	ar AttemptRecorder,
 ) *Registration {
 	return &Registration{
 		route: route,
-		phs:   phs,
+		rs:    rs,
 		ts:    ts,
 		phc:   phc,
+		d:     d,
+		ar:    ar,
 	}
 }
 
@@ -63,6 +104,12 @@ func (rg *Registration) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		rg.create(ctx, w, r)
 	case "join":
 		rg.join(ctx, w, r)
+	case "delete":
+		rg.delete(ctx, w, r)
+	case "change-password":
+		rg.changePassword(ctx, w, r)
+	case "kick":
+		rg.kick(ctx, w, r)
 	default:
 		err := fmt.Errorf("'%s' not found", p)
 		span.RecordError(err)
@@ -79,7 +126,21 @@ func (rg *Registration) create(
 	ctx, span := regTr.Start(ctx, "handlers create")
 	defer span.End()
 
-	rm, err := rg.decodeRoom(ctx, w, r)
+	var rm data.Room
+	if err := rg.decode(ctx, w, r, &rm); err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	t, err := data.TemplateByName(rm.Template)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	tId, err := rg.team(ctx, w, r, rm.PreviousRoomId)
 	if err != nil {
 		span.RecordError(err)
 		return
@@ -97,7 +158,7 @@ func (rg *Registration) create(
 		return
 	}
 
-	if err := rg.phs.StoreHashedPassword(ctx, rm.Id, h); err != nil {
+	if err := rg.rs.StoreHashedPassword(ctx, rm.Id, h); err != nil {
 		span.RecordError(err)
 
 		switch err.(type) {
@@ -114,8 +175,28 @@ func (rg *Registration) create(
 		}
 	}
 
-	if err := rg.setToken(ctx, rm.Id, r, w); err != nil {
+	// The creator of the room is its facilitator.
+	p := auth.NewParticipant(uuid.New().String(), rm.DisplayName)
+	p.Role = auth.RoleFacilitator
+
+	gen, err := rg.setUp(ctx, &rm, t, tId, p.ParticipantId)
+	if err != nil {
 		span.RecordError(err)
+		rg.abandon(ctx, rm.Id)
+		http.Error(
+			w,
+			http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError,
+		)
+
+		return
+	}
+
+	// Nobody could facilitate a room its creator has no token for.
+	if err := rg.setToken(ctx, rm.Id, gen, p, r, w); err != nil {
+		span.RecordError(err)
+		rg.abandon(ctx, rm.Id)
+
 		return
 	}
 
@@ -123,7 +204,75 @@ func (rg *Registration) create(
 		"Content-Location",
 		fmt.Sprintf("/retrospective?roomId=%s", rm.Id),
 	)
-	w.WriteHeader(http.StatusCreated)
+	rg.writeParticipant(ctx, w, http.StatusCreated, p)
+}
+
+// setUp stores everything of a room but its password, which claimed it,
+// and returns the generation of its tokens. The expiry is stored first, so
+// a room that is left half set up is still swept.
+func (rg *Registration) setUp(
+	ctx context.Context,
+	rm *data.Room,
+	t *data.Template,
+	tId string,
+	fId string,
+) (string, error) {
+	ctx, span := regTr.Start(ctx, "handlers set up")
+	defer span.End()
+
+	idle := time.Duration(rm.RetentionDays) * 24 * time.Hour
+	if err := rg.rs.StoreExpiry(ctx, rm.Id, idle); err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	if err := rg.rs.StoreTemplate(ctx, rm.Id, t); err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	if err := rg.rs.StoreSettings(ctx, rm.Id, rm.Settings()); err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	if err := rg.rs.StoreFacilitator(ctx, rm.Id, fId); err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	if err := rg.rs.StoreTeam(ctx, rm.Id, tId); err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	// A room that continues another starts from the team's open action
+	// items.
+	if rm.PreviousRoomId != "" {
+		if err := rg.rs.CarryActionItems(ctx, rm.Id, tId); err != nil {
+			span.RecordError(err)
+			return "", err
+		}
+	}
+
+	gen, err := rg.rs.RotateTokenGeneration(ctx, rm.Id)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	return gen, nil
+}
+
+// abandon removes a room whose creation failed, so that creating it again
+// does not find it already exists.
+func (rg *Registration) abandon(ctx context.Context, rId string) {
+	ctx, span := regTr.Start(ctx, "handlers abandon")
+	defer span.End()
+
+	if err := rg.rs.DeleteRoom(ctx, rId); err != nil {
+		span.RecordError(err)
+	}
 }
 
 func (rg *Registration) join(
@@ -134,13 +283,132 @@ func (rg *Registration) join(
 	ctx, span := regTr.Start(ctx, "handlers join")
 	defer span.End()
 
-	room, err := rg.decodeRoom(ctx, w, r)
+	var room data.Room
+	if err := rg.decode(ctx, w, r, &room); err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	if err := rg.comparePassword(ctx, w, r, room.Id, room.Password); err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	gen, err := rg.tokenGeneration(ctx, w, room.Id)
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	// Participants who join again with a token for the room keep their id,
+	// so they are still the authors of their cards.
+	pId := uuid.New().String()
+	if c, err := rg.ts.ValidateToken(
+		ctx,
+		r,
+		auth.NewComparisonClaims(room.Id, gen),
+	); err == nil && c.ParticipantId != "" {
+		pId = c.ParticipantId
+	}
+
+	f, err := rg.facilitator(ctx, w, room.Id)
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	p := auth.NewParticipant(pId, room.DisplayName)
+	p.Role = role(f, pId)
+
+	if err := rg.setToken(ctx, room.Id, gen, p, r, w); err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	w.Header().Set(
+		"Content-Location",
+		fmt.Sprintf("/retrospective?roomId=%s", room.Id),
+	)
+	rg.writeParticipant(ctx, w, http.StatusOK, p)
+}
+
+// delete removes the room. It requires a token for the room along with
+// its password, since it cannot be undone.
+func (rg *Registration) delete(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	ctx, span := regTr.Start(ctx, "handlers delete")
+	defer span.End()
+
+	var room data.Room
+	if err := rg.decode(ctx, w, r, &room); err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	if _, err := rg.authenticate(ctx, w, r, room.Id, room.Password); err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	if err := rg.rs.DeleteRoom(ctx, room.Id); err != nil {
+		span.RecordError(err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError,
+		)
+
+		return
+	}
+
+	// The room is gone whether or not its clients are told, and clients
+	// that are not cannot connect again.
+	if err := rg.d.PublishDisconnect(ctx, room.Id, "room deleted"); err != nil {
+		span.RecordError(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// changePassword replaces the room's password. Every other token for the
+// room is revoked and its clients are disconnected, while the requester
+// gets a new token.
+func (rg *Registration) changePassword(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	ctx, span := regTr.Start(ctx, "handlers change password")
+	defer span.End()
+
+	var pc data.PasswordChange
+	if err := rg.decode(ctx, w, r, &pc); err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	p, err := rg.authenticate(ctx, w, r, pc.Id, pc.Password)
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	h, err := rg.phc.HashPassword(ctx, pc.NewPassword)
 	if err != nil {
 		span.RecordError(err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError,
+		)
+
 		return
 	}
 
-	h, err := rg.phs.HashedPassword(ctx, room.Id)
+	gen, err := rg.rs.ChangeHashedPassword(ctx, pc.Id, h)
 	if err != nil {
 		span.RecordError(err)
 
@@ -158,14 +426,61 @@ func (rg *Registration) join(
 		}
 	}
 
-	if err := rg.phc.CompareHashAndPassword(ctx, h, room.Password); err != nil {
+	if err := rg.d.PublishDisconnect(ctx, pc.Id, "password changed"); err != nil {
 		span.RecordError(err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+
+	if err := rg.setToken(ctx, pc.Id, gen, p, r, w); err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	w.Header().Set(
+		"Content-Location",
+		fmt.Sprintf("/retrospective?roomId=%s", pc.Id),
+	)
+	rg.writeParticipant(ctx, w, http.StatusOK, p)
+}
+
+// kick revokes every token for the room and disconnects its clients, so
+// everyone has to join again. The requester gets a new token.
+func (rg *Registration) kick(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	ctx, span := regTr.Start(ctx, "handlers kick")
+	defer span.End()
+
+	var room data.Room
+	if err := rg.decode(ctx, w, r, &room); err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	p, err := rg.authenticate(ctx, w, r, room.Id, room.Password)
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	gen, err := rg.rs.RotateTokenGeneration(ctx, room.Id)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError,
+		)
 
 		return
 	}
 
-	if err := rg.setToken(ctx, room.Id, r, w); err != nil {
+	if err := rg.d.PublishDisconnect(ctx, room.Id, "everyone was kicked out"); err != nil {
+		span.RecordError(err)
+	}
+
+	if err := rg.setToken(ctx, room.Id, gen, p, r, w); err != nil {
 		span.RecordError(err)
 		return
 	}
@@ -174,26 +489,261 @@ func (rg *Registration) join(
 		"Content-Location",
 		fmt.Sprintf("/retrospective?roomId=%s", room.Id),
 	)
-	w.WriteHeader(http.StatusOK)
+	rg.writeParticipant(ctx, w, http.StatusOK, p)
+}
+
+// authenticate checks that the request has a token for the room that was
+// not revoked, the room's password, and that the token's participant runs
+// the room. It returns the participant of the token, who is given a new id
+// if the token was issued before participants had ids.
+func (rg *Registration) authenticate(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	rId string,
+	p string,
+) (*auth.Participant, error) {
+	ctx, span := regTr.Start(ctx, "handlers authenticate")
+	defer span.End()
+
+	gen, err := rg.tokenGeneration(ctx, w, rId)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	c, err := rg.ts.ValidateToken(ctx, r, auth.NewComparisonClaims(rId, gen))
+	if err != nil {
+		span.RecordError(err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusBadRequest),
+			http.StatusBadRequest,
+		)
+
+		return nil, err
+	}
+
+	if err := rg.comparePassword(ctx, w, r, rId, p); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	pt := c.Participant
+	if pt.ParticipantId == "" {
+		pt = auth.NewParticipant(uuid.New().String(), c.DisplayName)
+	}
+
+	// The facilitator may have changed since the token was issued, so the
+	// role of the token is not trusted.
+	f, err := rg.facilitator(ctx, w, rId)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	// Every participant runs rooms created before rooms had facilitators.
+	if f != "" && f != pt.ParticipantId {
+		err := fmt.Errorf(
+			"participant '%s' does not facilitate room '%s'",
+			pt.ParticipantId,
+			rId,
+		)
+		span.RecordError(err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusForbidden),
+			http.StatusForbidden,
+		)
+
+		return nil, err
+	}
+
+	pt.Role = role(f, pt.ParticipantId)
+
+	return pt, nil
+}
+
+// team returns the id of the team of a room that is created. A room that
+// continues a previous room joins its team, which requires a token for the
+// previous room, while any other room starts a new team.
+func (rg *Registration) team(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	prevRId string,
+) (string, error) {
+	ctx, span := regTr.Start(ctx, "handlers team")
+	defer span.End()
+
+	if prevRId == "" {
+		return uuid.New().String(), nil
+	}
+
+	gen, err := rg.tokenGeneration(ctx, w, prevRId)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	if _, err := rg.ts.ValidateToken(
+		ctx,
+		r,
+		auth.NewComparisonClaims(prevRId, gen),
+	); err != nil {
+		span.RecordError(err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusBadRequest),
+			http.StatusBadRequest,
+		)
+
+		return "", err
+	}
+
+	tId, err := rg.rs.Team(ctx, prevRId)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError,
+		)
+
+		return "", err
+	}
+
+	return tId, nil
 }
 
-func (rg *Registration) decodeRoom(
+// facilitator returns the id of the room's facilitator, which is empty
+// for rooms without one.
+func (rg *Registration) facilitator(
+	ctx context.Context,
+	w http.ResponseWriter,
+	rId string,
+) (string, error) {
+	ctx, span := regTr.Start(ctx, "handlers facilitator")
+	defer span.End()
+
+	f, err := rg.rs.Facilitator(ctx, rId)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError,
+		)
+
+		return "", err
+	}
+
+	return f, nil
+}
+
+// role returns the participant's role in the room with the facilitator.
+func role(fId, pId string) string {
+	if fId != "" && fId == pId {
+		return auth.RoleFacilitator
+	}
+
+	return ""
+}
+
+// comparePassword compares the password with the room's, recording the
+// attempt so clients that keep failing are locked out.
+func (rg *Registration) comparePassword(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	rId string,
+	p string,
+) error {
+	ctx, span := regTr.Start(ctx, "handlers compare password")
+	defer span.End()
+
+	h, err := rg.rs.HashedPassword(ctx, rId)
+	if err != nil {
+		span.RecordError(err)
+
+		switch err.(type) {
+		case store.DataDoesNotExistError, store.RoomExpiredError:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		default:
+			http.Error(
+				w,
+				http.StatusText(http.StatusInternalServerError),
+				http.StatusInternalServerError,
+			)
+			return err
+		}
+	}
+
+	if err := rg.phc.CompareHashAndPassword(ctx, h, p); err != nil {
+		span.RecordError(err)
+		rg.ar.Fail(ctx, r, rId)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return err
+	}
+
+	rg.ar.Succeed(ctx, r, rId)
+
+	return nil
+}
+
+func (rg *Registration) tokenGeneration(
+	ctx context.Context,
+	w http.ResponseWriter,
+	rId string,
+) (string, error) {
+	ctx, span := regTr.Start(ctx, "handlers token generation")
+	defer span.End()
+
+	gen, err := rg.rs.TokenGeneration(ctx, rId)
+	if err != nil {
+		span.RecordError(err)
+
+		switch err.(type) {
+		case store.DataDoesNotExistError:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return "", err
+		default:
+			http.Error(
+				w,
+				http.StatusText(http.StatusInternalServerError),
+				http.StatusInternalServerError,
+			)
+			return "", err
+		}
+	}
+
+	return gen, nil
+}
+
+func (rg *Registration) decode(
 	ctx context.Context,
 	w http.ResponseWriter,
 	r *http.Request,
-) (*data.Room, error) {
-	_, span := regTr.Start(ctx, "handlers decode room")
+	v interface{},
+) error {
+	_, span := regTr.Start(ctx, "handlers decode")
 	defer span.End()
 
 	d := json.NewDecoder(r.Body)
 
-	var room data.Room
-	if err := d.Decode(&room); err != nil {
+	if err := d.Decode(v); err != nil {
 		span.RecordError(err)
 
 		var msg string
 		switch err.(type) {
-		case data.PasswordInvalidError, data.RoomIdInvalidError:
+		case data.PasswordInvalidError,
+			data.RoomIdInvalidError,
+			data.TemplateInvalidError,
+			data.RetentionInvalidError,
+			data.NameInvalidError,
+			data.VotingInvalidError:
 			msg = err.Error()
 		default:
 			msg = http.StatusText(http.StatusBadRequest)
@@ -201,22 +751,24 @@ func (rg *Registration) decodeRoom(
 
 		http.Error(w, msg, http.StatusBadRequest)
 
-		return nil, err
+		return err
 	}
 
-	return &room, nil
+	return nil
 }
 
 func (rg *Registration) setToken(
 	ctx context.Context,
 	roomId string,
+	gen string,
+	p *auth.Participant,
 	r *http.Request,
 	w http.ResponseWriter,
 ) error {
 	ctx, span := regTr.Start(ctx, "handlers set token")
 	defer span.End()
 
-	c := auth.NewClaims(roomId, time.Now().UTC().Add(time.Hour*24*7))
+	c := auth.NewClaims(roomId, gen, p, time.Now().UTC().Add(time.Hour*24*7))
 	if err := rg.ts.SetToken(ctx, w, c); err != nil {
 		span.RecordError(err)
 		http.Error(
@@ -230,3 +782,25 @@ func (rg *Registration) setToken(
 
 	return nil
 }
+
+// writeParticipant tells clients who they joined the room as, since their
+// token cannot be read by scripts.
+func (rg *Registration) writeParticipant(
+	ctx context.Context,
+	w http.ResponseWriter,
+	status int,
+	p *auth.Participant,
+) {
+	_, span := regTr.Start(ctx, "handlers write participant")
+	defer span.End()
+
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(&data.Participant{
+		Id:          p.ParticipantId,
+		DisplayName: p.DisplayName,
+		Role:        p.Role,
+	}); err != nil {
+		span.RecordError(err)
+	}
+}
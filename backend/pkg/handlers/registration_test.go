@@ -9,8 +9,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/safe-waters/retro-simply/backend/pkg/auth"
 	"github.com/safe-waters/retro-simply/backend/pkg/data"
@@ -19,6 +21,7 @@ import (
 
 type mockPasswordStore struct {
 	data map[string]interface{}
+	gens int
 	mu   *sync.Mutex
 }
 
@@ -81,7 +84,226 @@ func (m *mockPasswordStore) StoreHashedPassword(
 	return nil
 }
 
-type erroneousMockStoreHashedPassword struct{ PasswordHashStorer }
+func (m *mockPasswordStore) StoreTemplate(
+	ctx context.Context,
+	rId string,
+	t *data.Template,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.data[fmt.Sprintf("template%s", rId)] = t
+
+	return nil
+}
+
+func (m *mockPasswordStore) StoreSettings(
+	ctx context.Context,
+	rId string,
+	st *data.Settings,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.data[fmt.Sprintf("settings%s", rId)] = st
+
+	return nil
+}
+
+func (m *mockPasswordStore) StoreExpiry(
+	ctx context.Context,
+	rId string,
+	idle time.Duration,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.data[fmt.Sprintf("expiry%s", rId)] = idle
+
+	return nil
+}
+
+func (m *mockPasswordStore) StoreFacilitator(
+	ctx context.Context,
+	rId,
+	pId string,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.data[fmt.Sprintf("facilitator%s", rId)] = pId
+
+	return nil
+}
+
+func (m *mockPasswordStore) Facilitator(
+	ctx context.Context,
+	rId string,
+) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, _ := m.data[fmt.Sprintf("facilitator%s", rId)].(string)
+
+	return f, nil
+}
+
+func (m *mockPasswordStore) StoreTeam(ctx context.Context, rId, tId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.data[fmt.Sprintf("team%s", rId)] = tId
+
+	return nil
+}
+
+func (m *mockPasswordStore) Team(ctx context.Context, rId string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tId, _ := m.data[fmt.Sprintf("team%s", rId)].(string)
+
+	return tId, nil
+}
+
+func (m *mockPasswordStore) CarryActionItems(
+	ctx context.Context,
+	rId,
+	tId string,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.data[fmt.Sprintf("carried%s", rId)] = tId
+
+	return nil
+}
+
+func (m *mockPasswordStore) ChangeHashedPassword(
+	ctx context.Context,
+	rId,
+	h string,
+) (string, error) {
+	m.mu.Lock()
+
+	if _, ok := m.data[rId]; !ok {
+		m.mu.Unlock()
+
+		return "", store.DataDoesNotExistError{
+			Err: errors.New("room does not exist"),
+		}
+	}
+
+	m.data[rId] = h
+	m.mu.Unlock()
+
+	return m.RotateTokenGeneration(ctx, rId)
+}
+
+func (m *mockPasswordStore) DeleteRoom(ctx context.Context, rId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.data, rId)
+	delete(m.data, fmt.Sprintf("generation%s", rId))
+
+	return nil
+}
+
+func (m *mockPasswordStore) TokenGeneration(
+	ctx context.Context,
+	rId string,
+) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.data[rId]; !ok {
+		return "", store.DataDoesNotExistError{
+			Err: errors.New("room does not exist"),
+		}
+	}
+
+	gen, _ := m.data[fmt.Sprintf("generation%s", rId)].(string)
+
+	return gen, nil
+}
+
+func (m *mockPasswordStore) RotateTokenGeneration(
+	ctx context.Context,
+	rId string,
+) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.gens++
+	gen := fmt.Sprintf("%d", m.gens)
+	m.data[fmt.Sprintf("generation%s", rId)] = gen
+
+	return gen, nil
+}
+
+type mockDisconnecter struct {
+	reasons map[string]string
+	mu      *sync.Mutex
+}
+
+func newMockDisconnecter() *mockDisconnecter {
+	return &mockDisconnecter{
+		reasons: map[string]string{},
+		mu:      &sync.Mutex{},
+	}
+}
+
+func (m *mockDisconnecter) PublishDisconnect(
+	ctx context.Context,
+	rId,
+	reason string,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reasons[rId] = reason
+
+	return nil
+}
+
+type mockAttemptRecorder struct {
+	failures  map[string]int
+	successes map[string]int
+	mu        *sync.Mutex
+}
+
+func newMockAttemptRecorder() *mockAttemptRecorder {
+	return &mockAttemptRecorder{
+		failures:  map[string]int{},
+		successes: map[string]int{},
+		mu:        &sync.Mutex{},
+	}
+}
+
+func (m *mockAttemptRecorder) Fail(
+	ctx context.Context,
+	r *http.Request,
+	rId string,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.failures[rId]++
+}
+
+func (m *mockAttemptRecorder) Succeed(
+	ctx context.Context,
+	r *http.Request,
+	rId string,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.successes[rId]++
+}
+
+type erroneousMockStoreHashedPassword struct{ RoomStorer }
 
 func (e *erroneousMockStoreHashedPassword) StoreHashedPassword(
 	ctx context.Context,
@@ -91,7 +313,17 @@ func (e *erroneousMockStoreHashedPassword) StoreHashedPassword(
 	return errors.New("")
 }
 
-type erroneousMockGetHashedPassword struct{ PasswordHashStorer }
+type erroneousMockStoreSettings struct{ RoomStorer }
+
+func (e *erroneousMockStoreSettings) StoreSettings(
+	ctx context.Context,
+	rId string,
+	st *data.Settings,
+) error {
+	return errors.New("")
+}
+
+type erroneousMockGetHashedPassword struct{ RoomStorer }
 
 func (e *erroneousMockGetHashedPassword) HashedPassword(
 	ctx context.Context,
@@ -100,6 +332,15 @@ func (e *erroneousMockGetHashedPassword) HashedPassword(
 	return "", errors.New("")
 }
 
+type expiredMockGetHashedPassword struct{ RoomStorer }
+
+func (e *expiredMockGetHashedPassword) HashedPassword(
+	ctx context.Context,
+	rId string,
+) (string, error) {
+	return "", store.RoomExpiredError{Err: errors.New("room expired")}
+}
+
 type erroneousMockHashPassword struct{ PasswordHashComparer }
 
 func (e *erroneousMockHashPassword) HashPassword(
@@ -141,6 +382,82 @@ func TestCreateRoom(t *testing.T) {
 	expectRegistration(t, res, http.StatusCreated)
 }
 
+func TestCreateRoomWithTemplate(t *testing.T) {
+	t.Parallel()
+
+	b := map[string]string{
+		"id":       "test",
+		"password": "test",
+		"template": "start-stop-continue",
+	}
+	phc := auth.NewPasswordManager()
+	ts := auth.NewJWT([]byte("secret"))
+	phs := newMockPasswordStore()
+
+	res := postRequest(t, "create", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusCreated)
+
+	tmpl, ok := phs.data["templatetest"].(*data.Template)
+	if !ok || tmpl.Name != "start-stop-continue" {
+		t.Fatalf("expected template 'start-stop-continue', got: %v", tmpl)
+	}
+}
+
+func TestCreateWithInvalidTemplate(t *testing.T) {
+	t.Parallel()
+
+	b := map[string]string{"id": "test", "password": "test", "template": "wrong"}
+	phc := auth.NewPasswordManager()
+	ts := auth.NewJWT([]byte("secret"))
+	phs := newMockPasswordStore()
+
+	res := postRequest(t, "create", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusBadRequest)
+}
+
+func TestCreateRoomWithRetention(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name          string
+		RetentionDays int
+		Code          int
+		Idle          time.Duration
+	}{
+		{Name: "Default", RetentionDays: 0, Code: http.StatusCreated, Idle: 0},
+		{Name: "Week", RetentionDays: 7, Code: http.StatusCreated, Idle: 7 * 24 * time.Hour},
+		{Name: "Negative", RetentionDays: -1, Code: http.StatusBadRequest},
+		{Name: "Too Long", RetentionDays: data.MaxRetentionDays + 1, Code: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			b := map[string]interface{}{
+				"id":            "test",
+				"password":      "test",
+				"retentionDays": test.RetentionDays,
+			}
+			phc := auth.NewPasswordManager()
+			ts := auth.NewJWT([]byte("secret"))
+			phs := newMockPasswordStore()
+
+			res := postRequest(t, "create", b, phc, ts, phs)
+			expectRegistration(t, res, test.Code)
+
+			if test.Code != http.StatusCreated {
+				return
+			}
+
+			if idle := phs.data["expirytest"]; idle != test.Idle {
+				t.Fatalf("expected idle %v, got: %v", test.Idle, idle)
+			}
+		})
+	}
+}
+
 func TestCreateDuplicateRoom(t *testing.T) {
 	t.Parallel()
 
@@ -216,6 +533,22 @@ func TestCreateUnableToStorePassword(t *testing.T) {
 	expectRegistration(t, res, http.StatusInternalServerError)
 }
 
+func TestCreateUnableToSetUpRoom(t *testing.T) {
+	t.Parallel()
+
+	b := map[string]string{"id": "test", "password": "test"}
+	phc := auth.NewPasswordManager()
+	ts := auth.NewJWT([]byte("secret"))
+	phs := newMockPasswordStore()
+
+	res := postRequest(t, "create", b, phc, ts, &erroneousMockStoreSettings{phs})
+	expectRegistration(t, res, http.StatusInternalServerError)
+
+	// the room is removed, so creating it again succeeds
+	res = postRequest(t, "create", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusCreated)
+}
+
 func TestCreateUnableToSetToken(t *testing.T) {
 	t.Parallel()
 
@@ -243,6 +576,259 @@ func TestJoinRoom(t *testing.T) {
 	expectRegistration(t, res, http.StatusOK)
 }
 
+func TestCreateAnonymousRoom(t *testing.T) {
+	t.Parallel()
+
+	b := map[string]interface{}{"id": "test", "password": "test", "anonymous": true}
+	phc := auth.NewPasswordManager()
+	ts := auth.NewJWT([]byte("secret"))
+	phs := newMockPasswordStore()
+
+	res := postRequest(t, "create", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusCreated)
+
+	st, ok := phs.data["settingstest"].(*data.Settings)
+	if !ok || !st.Anonymous {
+		t.Fatalf("expected anonymous settings, got: %v", st)
+	}
+}
+
+func TestCreateRoomWithVoting(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name   string
+		Voting map[string]interface{}
+		Code   int
+	}{
+		{
+			Name:   "Valid",
+			Voting: map[string]interface{}{"votesPerParticipant": 3, "denySelfVote": true},
+			Code:   http.StatusCreated,
+		},
+		{
+			Name:   "Too Many Votes",
+			Voting: map[string]interface{}{"votesPerParticipant": data.MaxVotes + 1},
+			Code:   http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			b := map[string]interface{}{"id": "test", "password": "test", "voting": test.Voting}
+			phc := auth.NewPasswordManager()
+			ts := auth.NewJWT([]byte("secret"))
+			phs := newMockPasswordStore()
+
+			res := postRequest(t, "create", b, phc, ts, phs)
+			expectRegistration(t, res, test.Code)
+
+			if test.Code != http.StatusCreated {
+				return
+			}
+
+			st, ok := phs.data["settingstest"].(*data.Settings)
+			expected := data.Voting{VotesPerParticipant: 3, DenySelfVote: true}
+			if !ok || st.Voting != expected {
+				t.Fatalf("expected voting %+v, got: %v", expected, st)
+			}
+		})
+	}
+}
+
+func TestJoinRoomAsParticipant(t *testing.T) {
+	t.Parallel()
+
+	b := map[string]string{"id": "test", "password": "test", "displayName": " Ada "}
+	phc := auth.NewPasswordManager()
+	ts := auth.NewJWT([]byte("secret"))
+	phs := newMockPasswordStore()
+
+	res := postRequest(t, "create", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusCreated)
+
+	creator := decodeParticipant(t, res)
+	if creator.Id == "" || creator.DisplayName != "Ada" {
+		t.Fatalf("expected participant 'Ada' with an id, got: %+v", creator)
+	}
+
+	// participants joining again with their token keep their id
+	b["displayName"] = "Grace"
+	res = postRequestWithCookies(
+		t,
+		"join",
+		b,
+		phc,
+		ts,
+		phs,
+		newMockDisconnecter(),
+		res.Result().Cookies(),
+	)
+	expectRegistration(t, res, http.StatusOK)
+
+	if p := decodeParticipant(t, res); p.Id != creator.Id || p.DisplayName != "Grace" {
+		t.Fatalf("expected participant '%s' named 'Grace', got: %+v", creator.Id, p)
+	}
+
+	res = postRequest(t, "join", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusOK)
+
+	if p := decodeParticipant(t, res); p.Id == "" || p.Id == creator.Id {
+		t.Fatalf("expected a new participant id, got: '%s'", p.Id)
+	}
+}
+
+func TestFacilitatorRole(t *testing.T) {
+	t.Parallel()
+
+	b := map[string]string{"id": "test", "password": "test"}
+	phc := auth.NewPasswordManager()
+	ts := auth.NewJWT([]byte("secret"))
+	phs := newMockPasswordStore()
+
+	res := postRequest(t, "create", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusCreated)
+
+	creator := decodeParticipant(t, res)
+	if creator.Role != auth.RoleFacilitator {
+		t.Fatalf("expected the creator to facilitate, got role: '%s'", creator.Role)
+	}
+
+	if f, _ := phs.Facilitator(context.Background(), "test"); f != creator.Id {
+		t.Fatalf("expected facilitator '%s', got: '%s'", creator.Id, f)
+	}
+
+	cks := res.Result().Cookies()
+
+	res = postRequest(t, "join", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusOK)
+
+	other := decodeParticipant(t, res)
+	if other.Role != "" {
+		t.Fatalf("expected no role, got: '%s'", other.Role)
+	}
+
+	// the role follows the facilitator once it is transferred
+	if err := phs.StoreFacilitator(context.Background(), "test", other.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	res = postRequestWithCookies(
+		t,
+		"join",
+		b,
+		phc,
+		ts,
+		phs,
+		newMockDisconnecter(),
+		cks,
+	)
+	expectRegistration(t, res, http.StatusOK)
+
+	if p := decodeParticipant(t, res); p.Id != creator.Id || p.Role != "" {
+		t.Fatalf("expected '%s' without a role, got: %+v", creator.Id, p)
+	}
+}
+
+func TestCreateRoomContinuingTeam(t *testing.T) {
+	t.Parallel()
+
+	phc := auth.NewPasswordManager()
+	ts := auth.NewJWT([]byte("secret"))
+	phs := newMockPasswordStore()
+
+	res := postRequest(
+		t,
+		"create",
+		map[string]string{"id": "first", "password": "test"},
+		phc,
+		ts,
+		phs,
+	)
+	expectRegistration(t, res, http.StatusCreated)
+
+	cks := res.Result().Cookies()
+
+	tId, _ := phs.Team(context.Background(), "first")
+	if tId == "" {
+		t.Fatal("expected the room to start a team")
+	}
+
+	b := map[string]string{
+		"id":             "second",
+		"password":       "test",
+		"previousRoomId": "first",
+	}
+
+	// the previous room requires a token for it
+	res = postRequest(t, "create", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusBadRequest)
+
+	res = postRequestWithCookies(
+		t,
+		"create",
+		b,
+		phc,
+		ts,
+		phs,
+		newMockDisconnecter(),
+		cks,
+	)
+	expectRegistration(t, res, http.StatusCreated)
+
+	if n, _ := phs.Team(context.Background(), "second"); n != tId {
+		t.Fatalf("expected team '%s', got: '%s'", tId, n)
+	}
+
+	phs.mu.Lock()
+	carried := phs.data["carriedsecond"]
+	phs.mu.Unlock()
+
+	if carried != tId {
+		t.Fatalf(
+			"expected the action items of team '%s' to be carried, got: '%v'",
+			tId,
+			carried,
+		)
+	}
+
+	b = map[string]string{
+		"id":             "third",
+		"password":       "test",
+		"previousRoomId": "missing",
+	}
+	res = postRequestWithCookies(
+		t,
+		"create",
+		b,
+		phc,
+		ts,
+		phs,
+		newMockDisconnecter(),
+		cks,
+	)
+	expectRegistration(t, res, http.StatusBadRequest)
+}
+
+func TestJoinWithInvalidDisplayName(t *testing.T) {
+	t.Parallel()
+
+	b := map[string]string{"id": "test", "password": "test"}
+	phc := auth.NewPasswordManager()
+	ts := auth.NewJWT([]byte("secret"))
+	phs := newMockPasswordStore()
+
+	res := postRequest(t, "create", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusCreated)
+
+	b["displayName"] = strings.Repeat("a", data.MaxDisplayNameLength+1)
+	res = postRequest(t, "join", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusBadRequest)
+}
+
 func TestJoinRoomDoesNotExist(t *testing.T) {
 	t.Parallel()
 
@@ -270,6 +856,47 @@ func TestUnableToGetHashedPassword(t *testing.T) {
 	expectRegistration(t, res, http.StatusInternalServerError)
 }
 
+func TestJoinExpiredRoom(t *testing.T) {
+	t.Parallel()
+
+	b := map[string]string{"id": "test", "password": "test"}
+	phc := auth.NewPasswordManager()
+	ts := auth.NewJWT([]byte("secret"))
+	phs := &expiredMockGetHashedPassword{newMockPasswordStore()}
+
+	res := postRequest(t, "join", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusBadRequest)
+}
+
+func TestJoinRecordsAttempts(t *testing.T) {
+	t.Parallel()
+
+	b := map[string]string{"id": "test", "password": "test"}
+	phc := auth.NewPasswordManager()
+	ts := auth.NewJWT([]byte("secret"))
+	phs := newMockPasswordStore()
+	d := newMockDisconnecter()
+	ar := newMockAttemptRecorder()
+
+	res := postRequest(t, "create", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusCreated)
+
+	wrong := map[string]string{"id": "test", "password": "wrong"}
+	res = postRequestWithRecorder(t, "join", wrong, phc, ts, phs, d, ar, nil)
+	expectRegistration(t, res, http.StatusBadRequest)
+
+	res = postRequestWithRecorder(t, "join", b, phc, ts, phs, d, ar, nil)
+	expectRegistration(t, res, http.StatusOK)
+
+	if ar.failures["test"] != 1 || ar.successes["test"] != 1 {
+		t.Fatalf(
+			"expected 1 failure and 1 success, got: %d and %d",
+			ar.failures["test"],
+			ar.successes["test"],
+		)
+	}
+}
+
 func TestJoinWithInvalidPassword(t *testing.T) {
 	t.Parallel()
 
@@ -350,6 +977,175 @@ func TestInvalidRoute(t *testing.T) {
 	expectRegistration(t, res, http.StatusNotFound)
 }
 
+func TestDeleteRoom(t *testing.T) {
+	t.Parallel()
+
+	b := map[string]string{"id": "test", "password": "test"}
+	phc := auth.NewPasswordManager()
+	ts := auth.NewJWT([]byte("secret"))
+	phs := newMockPasswordStore()
+	d := newMockDisconnecter()
+
+	res := postRequest(t, "create", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusCreated)
+
+	cks := res.Result().Cookies()
+
+	res = postRequestWithCookies(t, "delete", b, phc, ts, phs, d, cks)
+	expectRegistration(t, res, http.StatusNoContent)
+
+	if reason := d.reasons["test"]; reason != "room deleted" {
+		t.Fatalf("expected clients to be disconnected, got: '%s'", reason)
+	}
+
+	res = postRequest(t, "join", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusBadRequest)
+
+	// the token of the deleted room does not work for a new room with
+	// the same id
+	res = postRequest(t, "create", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusCreated)
+
+	res = postRequestWithCookies(t, "delete", b, phc, ts, phs, d, cks)
+	expectRegistration(t, res, http.StatusBadRequest)
+}
+
+func TestDeleteRoomWithoutToken(t *testing.T) {
+	t.Parallel()
+
+	b := map[string]string{"id": "test", "password": "test"}
+	phc := auth.NewPasswordManager()
+	ts := auth.NewJWT([]byte("secret"))
+	phs := newMockPasswordStore()
+
+	res := postRequest(t, "create", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusCreated)
+
+	res = postRequest(t, "delete", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusBadRequest)
+
+	res = postRequest(t, "join", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusOK)
+}
+
+func TestDeleteRoomWithInvalidPassword(t *testing.T) {
+	t.Parallel()
+
+	b := map[string]string{"id": "test", "password": "test"}
+	phc := auth.NewPasswordManager()
+	ts := auth.NewJWT([]byte("secret"))
+	phs := newMockPasswordStore()
+	d := newMockDisconnecter()
+
+	res := postRequest(t, "create", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusCreated)
+
+	wrong := map[string]string{"id": "test", "password": "wrong"}
+	res = postRequestWithCookies(t, "delete", wrong, phc, ts, phs, d, res.Result().Cookies())
+	expectRegistration(t, res, http.StatusBadRequest)
+
+	res = postRequest(t, "join", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusOK)
+}
+
+func TestChangePassword(t *testing.T) {
+	t.Parallel()
+
+	b := map[string]string{"id": "test", "password": "test"}
+	phc := auth.NewPasswordManager()
+	ts := auth.NewJWT([]byte("secret"))
+	phs := newMockPasswordStore()
+	d := newMockDisconnecter()
+
+	res := postRequest(t, "create", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusCreated)
+
+	old := res.Result().Cookies()
+
+	pc := map[string]string{"id": "test", "password": "test", "newPassword": "new"}
+	res = postRequestWithCookies(t, "change-password", pc, phc, ts, phs, d, old)
+	expectRegistration(t, res, http.StatusOK)
+
+	if reason := d.reasons["test"]; reason != "password changed" {
+		t.Fatalf("expected clients to be disconnected, got: '%s'", reason)
+	}
+
+	res = postRequest(t, "join", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusBadRequest)
+
+	nb := map[string]string{"id": "test", "password": "new"}
+	res = postRequest(t, "join", nb, phc, ts, phs)
+	expectRegistration(t, res, http.StatusOK)
+
+	// tokens issued before the change are revoked
+	pc = map[string]string{"id": "test", "password": "new", "newPassword": "newer"}
+	res = postRequestWithCookies(t, "change-password", pc, phc, ts, phs, d, old)
+	expectRegistration(t, res, http.StatusBadRequest)
+}
+
+func TestChangePasswordWithInvalidNewPassword(t *testing.T) {
+	t.Parallel()
+
+	b := map[string]string{"id": "test", "password": "test"}
+	phc := auth.NewPasswordManager()
+	ts := auth.NewJWT([]byte("secret"))
+	phs := newMockPasswordStore()
+	d := newMockDisconnecter()
+
+	res := postRequest(t, "create", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusCreated)
+
+	pc := map[string]string{"id": "test", "password": "test", "newPassword": ""}
+	res = postRequestWithCookies(t, "change-password", pc, phc, ts, phs, d, res.Result().Cookies())
+	expectRegistration(t, res, http.StatusBadRequest)
+
+	res = postRequest(t, "join", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusOK)
+}
+
+func TestKick(t *testing.T) {
+	t.Parallel()
+
+	b := map[string]string{"id": "test", "password": "test"}
+	phc := auth.NewPasswordManager()
+	ts := auth.NewJWT([]byte("secret"))
+	phs := newMockPasswordStore()
+	d := newMockDisconnecter()
+
+	res := postRequest(t, "create", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusCreated)
+
+	creator := res.Result().Cookies()
+
+	res = postRequest(t, "join", b, phc, ts, phs)
+	expectRegistration(t, res, http.StatusOK)
+
+	other := res.Result().Cookies()
+
+	// only the facilitator runs the room
+	for _, path := range []string{"kick", "delete", "change-password"} {
+		pc := map[string]string{"id": "test", "password": "test", "newPassword": "new"}
+		res = postRequestWithCookies(t, path, pc, phc, ts, phs, d, other)
+		expectRegistration(t, res, http.StatusForbidden)
+	}
+
+	res = postRequestWithCookies(t, "kick", b, phc, ts, phs, d, creator)
+	expectRegistration(t, res, http.StatusOK)
+
+	if reason := d.reasons["test"]; reason != "everyone was kicked out" {
+		t.Fatalf("expected clients to be disconnected, got: '%s'", reason)
+	}
+
+	kicker := res.Result().Cookies()
+
+	// the requester keeps access with a new token, the others do not
+	res = postRequestWithCookies(t, "kick", b, phc, ts, phs, d, creator)
+	expectRegistration(t, res, http.StatusBadRequest)
+
+	res = postRequestWithCookies(t, "kick", b, phc, ts, phs, d, kicker)
+	expectRegistration(t, res, http.StatusOK)
+}
+
 func expectRegistration(t *testing.T, res *httptest.ResponseRecorder, code int) {
 	t.Helper()
 
@@ -417,13 +1213,77 @@ func expectRegistration(t *testing.T, res *httptest.ResponseRecorder, code int)
 	}
 }
 
+func decodeParticipant(
+	t *testing.T,
+	res *httptest.ResponseRecorder,
+) *data.Participant {
+	t.Helper()
+
+	var p data.Participant
+	if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+
+	return &p
+}
+
 func postRequest(
 	t *testing.T,
 	route string,
-	body map[string]string,
+	body interface{},
 	phc PasswordHashComparer,
-	ts TokenSetter,
-	phs PasswordHashStorer,
+	ts TokenSetValidator,
+	phs RoomStorer,
+) *httptest.ResponseRecorder {
+	t.Helper()
+
+	return postRequestWithCookies(
+		t,
+		route,
+		body,
+		phc,
+		ts,
+		phs,
+		newMockDisconnecter(),
+		nil,
+	)
+}
+
+func postRequestWithCookies(
+	t *testing.T,
+	route string,
+	body interface{},
+	phc PasswordHashComparer,
+	ts TokenSetValidator,
+	phs RoomStorer,
+	d Disconnecter,
+	cks []*http.Cookie,
+) *httptest.ResponseRecorder {
+	t.Helper()
+
+	return postRequestWithRecorder(
+		t,
+		route,
+		body,
+		phc,
+		ts,
+		phs,
+		d,
+		newMockAttemptRecorder(),
+		cks,
+	)
+}
+
+func postRequestWithRecorder(
+	t *testing.T,
+	route string,
+	body interface{},
+	phc PasswordHashComparer,
+	ts TokenSetValidator,
+	phs RoomStorer,
+	d Disconnecter,
+	ar AttemptRecorder,
+	cks []*http.Cookie,
 ) *httptest.ResponseRecorder {
 	t.Helper()
 
@@ -442,7 +1302,11 @@ func postRequest(
 		t.Fatal(err)
 	}
 
-	r := NewRegistration(regRoute, phs, ts, phc)
+	for _, ck := range cks {
+		req.AddCookie(ck)
+	}
+
+	r := NewRegistration(regRoute, phs, ts, phc, d, ar)
 	res := httptest.NewRecorder()
 
 	r.ServeHTTP(res, req)
@@ -5,8 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"reflect"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/safe-waters/retro-simply/backend/pkg/broker"
 	"github.com/safe-waters/retro-simply/backend/pkg/data"
@@ -22,35 +25,59 @@ var _ http.Handler = (*Retrospective)(nil)
 
 type Stater interface {
 	State(ctx context.Context, rId string) (*data.State, error)
+	Settings(ctx context.Context, rId string) (*data.Settings, error)
+}
+
+type PresenceTracker interface {
+	Heartbeat(
+		ctx context.Context,
+		rId string,
+		cId string,
+		p *data.Participant,
+	) (*store.PresenceChange, error)
+	Leave(ctx context.Context, rId, cId string) (*store.PresenceChange, error)
+	Participants(ctx context.Context, rId string) ([]*data.Participant, error)
 }
 
 type Puber interface {
 	Publish(ctx context.Context, rId string, s *data.State) error
+	PublishOperation(ctx context.Context, rId string, op *data.Operation) error
 }
 
 type PubSuber interface {
 	Subscribe(ctx context.Context, rId string) (<-chan *broker.Message, error)
+	PublishPresence(ctx context.Context, rId string, p *data.Presence) error
 	Puber
 }
 
 type Retrospective struct {
 	st   Stater
+	pt   PresenceTracker
 	ps   PubSuber
 	p    Puber
 	pKey string
+
+	mu      *sync.Mutex
+	clients map[*client]struct{}
+	closing bool
 }
 
 func NewRetrospective(
 	st Stater,
+	pt PresenceTracker,
 	ps PubSuber,
 	p Puber,
 	pKey string,
 ) *Retrospective {
 	return &Retrospective{
 		st:   st,
+		pt:   pt,
 		ps:   ps,
 		p:    p,
 		pKey: pKey,
+
+		mu:      &sync.Mutex{},
+		clients: map[*client]struct{}{},
 	}
 }
 
@@ -71,6 +98,21 @@ func (rt *Retrospective) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.closing {
+		err := errors.New("shutting down")
+		span.RecordError(err)
+		http.Error(
+			w,
+			http.StatusText(http.StatusServiceUnavailable),
+			http.StatusServiceUnavailable,
+		)
+
+		return
+	}
+
 	wsc, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
 	if err != nil {
 		span.RecordError(err)
@@ -83,15 +125,65 @@ func (rt *Retrospective) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c := newClient(wsc, rt.ps, rt.p, rt.st, rt.pKey)
+	c := newClient(wsc, rt.ps, rt.p, rt.st, rt.pt, rt.pKey)
+	rt.clients[c] = struct{}{}
+
+	go c.run(ctx, u)
+
+	go func() {
+		<-c.done
+
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+
+		delete(rt.clients, c)
+	}()
+}
+
+// Shutdown stops accepting clients and sends a close frame to every
+// connected client. It waits for the clients to disconnect until the
+// context is done, and then closes the remaining connections.
+func (rt *Retrospective) Shutdown(ctx context.Context) error {
+	ctx, span := retTr.Start(ctx, "handlers shutdown")
+	defer span.End()
+
+	rt.mu.Lock()
+	rt.closing = true
+
+	cs := make([]*client, 0, len(rt.clients))
+	for c := range rt.clients {
+		cs = append(cs, c)
+	}
+	rt.mu.Unlock()
+
+	for _, c := range cs {
+		c.shutdown()
+	}
+
+	for _, c := range cs {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			for _, c := range cs {
+				_ = c.wsc.Close()
+			}
+
+			span.RecordError(ctx.Err())
+
+			return ctx.Err()
+		}
+	}
 
-	go c.run(ctx, u.RoomId)
+	return nil
 }
 
 const (
 	wWait   = 10 * time.Second
 	pWait   = 60 * time.Second
 	pPeriod = (pWait * 9) / 10
+	// hPeriod leaves time for a couple of missed heartbeats before the
+	// connection's presence expires.
+	hPeriod = store.PresenceTTL / 3
 )
 
 type wsConn interface {
@@ -102,16 +194,39 @@ type wsConn interface {
 	SetReadDeadline(t time.Time) error
 	SetWriteDeadline(t time.Time) error
 	SetPongHandler(h func(string) error)
+	WriteControl(messageType int, data []byte, deadline time.Time) error
 }
 
 type client struct {
-	wsc   wsConn
-	ps    PubSuber
-	p     Puber
-	st    Stater
-	pKey  string
-	wDone chan struct{}
-	rDone chan struct{}
+	// id tells the connections of a participant apart.
+	id   string
+	wsc  wsConn
+	ps   PubSuber
+	p    Puber
+	st   Stater
+	pt   PresenceTracker
+	pKey string
+	rev  uint64
+	// u is the participant the client connected as, and anonymous hides
+	// the authors of the other participants from it.
+	u         user.U
+	anonymous bool
+	// voting is how the room votes, and ballot is how the participant
+	// voted and revealed whether the votes were revealed, as of the last
+	// message written.
+	voting   data.Voting
+	ballot   *data.Ballot
+	revealed bool
+	// private is whether the brainstorm was private as of the last message
+	// written.
+	private bool
+	wDone   chan struct{}
+	rDone   chan struct{}
+	// quit is closed when the server shuts down, and done is closed once
+	// the client has ended.
+	quit     chan struct{}
+	quitOnce *sync.Once
+	done     chan struct{}
 }
 
 func newClient(
@@ -119,20 +234,53 @@ func newClient(
 	ps PubSuber,
 	p Puber,
 	st Stater,
+	pt PresenceTracker,
 	pKey string,
 ) *client {
 	return &client{
+		id:    uuid.New().String(),
 		wsc:   wsc,
 		ps:    ps,
 		p:     p,
 		st:    st,
+		pt:    pt,
 		pKey:  pKey,
 		wDone: make(chan struct{}),
 		rDone: make(chan struct{}),
+
+		quit:     make(chan struct{}),
+		quitOnce: &sync.Once{},
+		done:     make(chan struct{}),
 	}
 }
 
-func (c *client) run(ctx context.Context, rId string) {
+func (c *client) shutdown() {
+	c.quitOnce.Do(func() {
+		close(c.quit)
+
+		// WriteControl may be called concurrently with the write loop.
+		_ = c.wsc.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(
+				websocket.CloseGoingAway,
+				"server shutting down",
+			),
+			time.Now().Add(wWait),
+		)
+	})
+}
+
+// disconnect sends a close frame to the client, whose reply ends the read
+// loop.
+func (c *client) disconnect(reason string) {
+	_ = c.wsc.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason),
+		time.Now().Add(wWait),
+	)
+}
+
+func (c *client) run(ctx context.Context, u user.U) {
 	span := trace.SpanFromContext(ctx)
 	ctx = trace.ContextWithSpan(context.Background(), span)
 
@@ -147,12 +295,33 @@ func (c *client) run(ctx context.Context, rId string) {
 		<-c.wDone
 		<-c.rDone
 
+		c.leave(ctx)
+
 		cancel()
 		c.wsc.Close()
+		close(c.done)
 
 		span.AddEvent("client ended")
 	}(ctx)
 
+	rId := u.RoomId
+	c.u = u
+
+	// Settings never change once the room is created, so they are read
+	// once.
+	st, err := c.st.Settings(ctx, rId)
+	if err != nil {
+		span.RecordError(err)
+
+		close(c.wDone)
+		close(c.rDone)
+
+		return
+	}
+
+	c.anonymous = st.Anonymous
+	c.voting = st.Voting
+
 	br, err := c.ps.Subscribe(ctx, rId)
 	if err != nil {
 		span.RecordError(err)
@@ -163,6 +332,8 @@ func (c *client) run(ctx context.Context, rId string) {
 		return
 	}
 
+	c.heartbeat(ctx)
+
 	s, err := c.st.State(ctx, rId)
 	if err != nil {
 		switch err.(type) {
@@ -183,7 +354,33 @@ func (c *client) run(ctx context.Context, rId string) {
 		}
 	}
 
-	if err := c.wsc.WriteJSON(s); err != nil {
+	if err := c.write(
+		&data.Message{Type: data.MessageSnapshot, State: s},
+	); err != nil {
+		span.RecordError(err)
+
+		close(c.wDone)
+		close(c.rDone)
+
+		return
+	}
+
+	c.rev = s.Revision
+
+	ps, err := c.pt.Participants(ctx, rId)
+	if err != nil {
+		span.RecordError(err)
+
+		close(c.wDone)
+		close(c.rDone)
+
+		return
+	}
+
+	if err := c.write(&data.Message{
+		Type:     data.MessagePresence,
+		Presence: &data.Presence{RoomId: rId, Participants: ps},
+	}); err != nil {
 		span.RecordError(err)
 
 		close(c.wDone)
@@ -196,6 +393,67 @@ func (c *client) run(ctx context.Context, rId string) {
 	go c.writeMessages(ctx, br)
 }
 
+// participant returns who the client connected as. Tokens issued before
+// participants had ids are told apart by their connection.
+func (c *client) participant() *data.Participant {
+	p := &data.Participant{Id: c.u.ParticipantId, DisplayName: c.u.DisplayName}
+	if p.Id == "" {
+		p.Id = c.id
+	}
+
+	return p
+}
+
+// heartbeat keeps the client present in the room. Presence is not worth
+// ending the client for, so errors are only recorded.
+func (c *client) heartbeat(ctx context.Context) {
+	ctx, span := retTr.Start(ctx, "handlers heartbeat")
+	defer span.End()
+
+	pc, err := c.pt.Heartbeat(ctx, c.u.RoomId, c.id, c.participant())
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	c.publishPresence(ctx, pc)
+}
+
+func (c *client) leave(ctx context.Context) {
+	ctx, span := retTr.Start(ctx, "handlers leave")
+	defer span.End()
+
+	pc, err := c.pt.Leave(ctx, c.u.RoomId, c.id)
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+
+	c.publishPresence(ctx, pc)
+}
+
+func (c *client) publishPresence(ctx context.Context, pc *store.PresenceChange) {
+	span := trace.SpanFromContext(ctx)
+
+	for _, e := range []struct {
+		event string
+		ps    []*data.Participant
+	}{
+		{event: data.PresenceJoin, ps: pc.Joined},
+		{event: data.PresenceLeave, ps: pc.Left},
+	} {
+		for _, p := range e.ps {
+			if err := c.ps.PublishPresence(ctx, c.u.RoomId, &data.Presence{
+				RoomId:      c.u.RoomId,
+				Event:       e.event,
+				Participant: p,
+			}); err != nil {
+				span.RecordError(err)
+			}
+		}
+	}
+}
+
 func (c *client) readMessages(ctx context.Context, rId string) {
 	ctx, span := retTr.Start(ctx, "handlers read messages")
 
@@ -218,33 +476,56 @@ func (c *client) readMessages(ctx context.Context, rId string) {
 		select {
 		case <-c.wDone:
 			return
+		case <-c.quit:
+			return
 		case <-ctx.Done():
 			return
 		default:
-			var s data.State
+			var m data.Message
 
-			if err := c.wsc.ReadJSON(&s); err != nil {
+			if err := c.wsc.ReadJSON(&m); err != nil {
 				span.RecordError(err)
 				return
 			}
 
-			if s.RoomId != rId {
-				err := errors.New("read state contains the wrong roomId")
+			if m.RoomId() != rId {
+				err := errors.New("read message contains the wrong roomId")
 				span.RecordError(err)
 
 				return
 			}
 
-			if err := c.ps.Publish(ctx, rId, &s); err != nil {
+			if err := c.publish(ctx, &m); err != nil {
 				span.RecordError(err)
 				return
 			}
+		}
+	}
+}
 
-			if err := c.p.Publish(ctx, c.pKey, &s); err != nil {
-				span.RecordError(err)
-				return
-			}
+// publish queues the message for the worker. Messages are not broadcast
+// to the room here - the worker broadcasts the result of storing them
+// once the transaction commits, so clients only ever see stored states.
+//
+// The client's participant is set as the author of the message, whatever
+// the client sent.
+func (c *client) publish(ctx context.Context, m *data.Message) error {
+	switch m.Type {
+	case data.MessageState:
+		m.State.Ballots = nil
+		m.State.AuthorId = c.u.ParticipantId
+		m.State.SetAuthor(c.u.ParticipantId)
+		if m.State.Action != nil {
+			m.State.Action.AuthorId = c.u.ParticipantId
 		}
+
+		return c.p.Publish(ctx, c.pKey, m.State)
+	case data.MessageOperation:
+		m.Operation.AuthorId = c.u.ParticipantId
+
+		return c.p.PublishOperation(ctx, c.pKey, m.Operation)
+	default:
+		return fmt.Errorf("clients cannot send '%s' messages", m.Type)
 	}
 }
 
@@ -263,6 +544,9 @@ func (c *client) writeMessages(ctx context.Context, br <-chan *broker.Message) {
 	t := time.NewTicker(pPeriod)
 	defer t.Stop()
 
+	h := time.NewTicker(hPeriod)
+	defer h.Stop()
+
 	for {
 		select {
 		case m, ok := <-br:
@@ -273,8 +557,47 @@ func (c *client) writeMessages(ctx context.Context, br <-chan *broker.Message) {
 				return
 			}
 
-			_ = c.wsc.SetWriteDeadline(time.Now().Add(wWait))
-			if err := c.wsc.WriteJSON(m.State); err != nil {
+			if m.Disconnect != nil {
+				span.AddEvent("disconnected")
+				c.disconnect(m.Disconnect.Reason)
+
+				return
+			}
+
+			// Presence is not stored in the state, so it has no revision.
+			if m.Presence != nil {
+				_ = c.wsc.SetWriteDeadline(time.Now().Add(wWait))
+				if err := c.write(&data.Message{
+					Type:     data.MessagePresence,
+					Presence: m.Presence,
+				}); err != nil {
+					span.RecordError(err)
+					return
+				}
+
+				continue
+			}
+
+			// Rejections are only for the participant whose vote or
+			// operation was rejected.
+			if m.Rejection != nil {
+				if m.Rejection.ParticipantId != c.u.ParticipantId {
+					continue
+				}
+
+				_ = c.wsc.SetWriteDeadline(time.Now().Add(wWait))
+				if err := c.write(&data.Message{
+					Type:      data.MessageRejection,
+					Rejection: m.Rejection,
+				}); err != nil {
+					span.RecordError(err)
+					return
+				}
+
+				continue
+			}
+
+			if err := c.writeMessage(ctx, newMessage(m)); err != nil {
 				span.RecordError(err)
 				return
 			}
@@ -286,10 +609,183 @@ func (c *client) writeMessages(ctx context.Context, br <-chan *broker.Message) {
 				span.RecordError(err)
 				return
 			}
+		case <-h.C:
+			c.heartbeat(ctx)
 		case <-ctx.Done():
 			return
+		case <-c.quit:
+			return
 		case <-c.rDone:
 			return
 		}
 	}
 }
+
+// writeMessage writes the message if it is the next revision the client
+// expects. Every broadcast message has been stored, so it has a revision.
+// Messages the client has already seen are dropped, and if the client has
+// missed any messages, a snapshot of the stored state is written first, so
+// the client never drifts from the stored state.
+func (c *client) writeMessage(ctx context.Context, m *data.Message) error {
+	ctx, span := retTr.Start(ctx, "handlers write message")
+	defer span.End()
+
+	rev := m.Revision()
+
+	switch {
+	case rev <= c.rev:
+		span.AddEvent("stale message dropped")
+		return nil
+	case rev > c.rev+1 || c.reveals(m):
+		// Revealed votes and cards are written as a snapshot, since the
+		// client has not seen those of the other participants, as are
+		// votes hidden again, since the client holds their totals.
+		span.AddEvent("revision gap detected")
+
+		s, err := c.st.State(ctx, m.RoomId())
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+
+		_ = c.wsc.SetWriteDeadline(time.Now().Add(wWait))
+		if err := c.write(
+			&data.Message{Type: data.MessageSnapshot, State: s},
+		); err != nil {
+			span.RecordError(err)
+			return err
+		}
+
+		c.rev = s.Revision
+
+		if rev <= c.rev {
+			return nil
+		}
+	}
+
+	_ = c.wsc.SetWriteDeadline(time.Now().Add(wWait))
+	if err := c.write(m); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	c.rev = rev
+
+	return nil
+}
+
+// reveals reports whether the message reveals votes or cards the client
+// has not seen, or hides again the votes it has.
+func (c *client) reveals(m *data.Message) bool {
+	o := m.Operation
+	if o == nil {
+		return false
+	}
+
+	switch o.Type {
+	case data.OperationRevealVotes:
+		return c.hidesVotes()
+	case data.OperationHideVotes:
+		return c.voting.Secret && c.revealed
+	case data.OperationChangePhase:
+		return c.private && o.Phase != data.PhaseBrainstorm
+	default:
+		return false
+	}
+}
+
+func (c *client) hidesVotes() bool { return c.voting.Secret && !c.revealed }
+
+// write writes the message, without the authors the client may not know,
+// the ballots of the other participants, their votes until the votes are
+// revealed, or the messages of their private cards. Messages are the
+// client's own copy, so they can be changed. If the message changes the
+// participant's ballot, the ballot is written after it.
+func (c *client) write(m *data.Message) error {
+	b := c.nextBallot(m)
+
+	if m.State != nil {
+		c.revealed = m.State.VotesRevealed
+		c.private = m.State.Private
+	}
+
+	if o := m.Operation; o != nil && o.Type == data.OperationChangePhase {
+		c.private = o.Phase == data.PhaseBrainstorm && o.Private
+	}
+
+	if c.hidesVotes() {
+		own := b
+		if own == nil {
+			own = c.ballot
+		}
+
+		if !m.HideVotes(c.u.ParticipantId, own) {
+			return nil
+		}
+	}
+
+	m.HideBallots()
+	m.HideCards(c.u.ParticipantId)
+
+	if c.anonymous {
+		m.Redact(c.u.ParticipantId)
+	}
+
+	if err := c.wsc.WriteJSON(m); err != nil {
+		return err
+	}
+
+	if b == nil {
+		return nil
+	}
+
+	c.ballot = b
+
+	return c.wsc.WriteJSON(&data.Message{Type: data.MessageBallot, Ballot: b})
+}
+
+// nextBallot returns the participant's ballot once the message is
+// applied, or nil if the ballot does not change. Participants without an
+// id have no ballot.
+func (c *client) nextBallot(m *data.Message) *data.Ballot {
+	pId := c.u.ParticipantId
+	if pId == "" {
+		return nil
+	}
+
+	switch {
+	case m.State != nil:
+		b := m.State.Ballot(pId, &c.voting)
+		if m.Type != data.MessageSnapshot && reflect.DeepEqual(b, c.ballot) {
+			return nil
+		}
+
+		return b
+	case m.Operation != nil:
+		if m.Operation.AuthorId != pId || c.ballot == nil {
+			return nil
+		}
+
+		// Only applied votes and retractions are broadcast, so they count.
+		switch m.Operation.Type {
+		case data.OperationVote:
+			c.ballot.Vote(m.Operation.CardId)
+		case data.OperationUnvote:
+			c.ballot.Unvote(m.Operation.CardId)
+		default:
+			return nil
+		}
+
+		return c.ballot
+	default:
+		return nil
+	}
+}
+
+func newMessage(m *broker.Message) *data.Message {
+	if m.Operation != nil {
+		return &data.Message{Type: data.MessageOperation, Operation: m.Operation}
+	}
+
+	return &data.Message{Type: data.MessageState, State: m.State}
+}
@@ -8,12 +8,15 @@ import (
 	"net/http/httptest"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
 
 	"github.com/safe-waters/retro-simply/backend/pkg/broker"
 	"github.com/safe-waters/retro-simply/backend/pkg/data"
+	"github.com/safe-waters/retro-simply/backend/pkg/store"
 	"github.com/safe-waters/retro-simply/backend/pkg/user"
 )
 
@@ -93,6 +96,13 @@ func (m *mockStateStore) State(
 	return &s, nil
 }
 
+func (m *mockStateStore) Settings(
+	ctx context.Context,
+	rId string,
+) (*data.Settings, error) {
+	return &data.Settings{}, nil
+}
+
 type mockBroker struct {
 	ch chan *broker.Message
 }
@@ -123,11 +133,37 @@ func (m *mockBroker) Publish(
 	return nil
 }
 
+func (m *mockBroker) PublishOperation(
+	ctx context.Context,
+	rId string,
+	op *data.Operation,
+) error {
+	go func() {
+		m.ch <- &broker.Message{Operation: op}
+	}()
+
+	return nil
+}
+
+func (m *mockBroker) PublishPresence(
+	ctx context.Context,
+	rId string,
+	p *data.Presence,
+) error {
+	return nil
+}
+
+func newPresenceTracker() PresenceTracker {
+	return store.New(store.NewMemory(), store.Retention{})
+}
+
 func mockUserMiddleware(rId string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			u, _ := user.FromContext(r.Context())
 			u.RoomId = rId
+			u.ParticipantId = r.URL.Query().Get("participantId")
+			u.DisplayName = r.URL.Query().Get("displayName")
 			ctx := user.WithContext(r.Context(), u)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
@@ -141,7 +177,7 @@ func TestRetrospective(t *testing.T) {
 
 	retRoute := "/api/v1/retrospectives/"
 	rId := "test"
-	ret := mockUserMiddleware(rId)(NewRetrospective(ms, mb, mq, rId))
+	ret := mockUserMiddleware(rId)(NewRetrospective(ms, newPresenceTracker(), mb, mq, rId))
 
 	r := http.NewServeMux()
 	r.Handle(retRoute, ret)
@@ -161,7 +197,7 @@ func TestRetrospective(t *testing.T) {
 	}
 	defer ws.Close()
 
-	const numMessages = 10
+	snapshot := readSnapshot(t, ws)
 
 	var stateToSend data.State
 
@@ -172,19 +208,1186 @@ func TestRetrospective(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	expectState(
+		t,
+		&data.Message{Type: data.MessageSnapshot, State: &stateToSend},
+		snapshot,
+	)
+
+	const numMessages = 10
+
 	for i := 0; i < numMessages; i++ {
-		if err := ws.WriteJSON(&stateToSend); err != nil {
+		if err := ws.WriteJSON(
+			&data.Message{Type: data.MessageState, State: &stateToSend},
+		); err != nil {
 			t.Fatal(err)
 		}
 	}
 
+	op := &data.Operation{
+		Type:   data.OperationVote,
+		RoomId: rId,
+		CardId: "some-uuid-pk-0",
+	}
+
+	if err := ws.WriteJSON(
+		&data.Message{Type: data.MessageOperation, Operation: op},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// messages are only queued for the worker, which broadcasts them
+	// once they are stored
+	var numStates, numOps int
+
 	for i := 0; i < numMessages+1; i++ {
-		var stateToReceive data.State
-		if err := ws.ReadJSON(&stateToReceive); err != nil {
+		queued := <-mq.ch
+
+		switch {
+		case queued.State != nil:
+			expectState(t, &stateToSend, queued.State)
+			numStates++
+		case queued.Operation != nil:
+			expectState(t, op, queued.Operation)
+			numOps++
+		}
+	}
+
+	if numStates != numMessages || numOps != 1 {
+		t.Fatalf(
+			"expected %d states and 1 operation queued, got %d and %d",
+			numMessages,
+			numStates,
+			numOps,
+		)
+	}
+
+	// simulate the worker broadcasting stored operations
+	for _, rev := range []uint64{1, 3, 2, 4} {
+		storedOp := *op
+		storedOp.Revision = rev
+
+		if err := mb.PublishOperation(context.Background(), rId, &storedOp); err != nil {
+			t.Fatal(err)
+		}
+
+		// wait for the message to be handled, so the revisions arrive in order
+		switch rev {
+		case 1, 4:
+			expectMessage(t, ws, &data.Message{Type: data.MessageOperation, Operation: &storedOp})
+		case 3:
+			// revision 2 was missed, so a snapshot is sent first
+			expectMessage(t, ws, &data.Message{Type: data.MessageSnapshot, State: &stateToSend})
+			expectMessage(t, ws, &data.Message{Type: data.MessageOperation, Operation: &storedOp})
+		}
+	}
+}
+
+// mockWorker applies queued operations one at a time and broadcasts the
+// result, like the worker does. Tests that send states use storeWorker,
+// which merges them.
+type mockWorker struct {
+	mu        sync.Mutex
+	s         *data.State
+	anonymous bool
+	voting    data.Voting
+}
+
+func (m *mockWorker) State(ctx context.Context, rId string) (*data.State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.copyState()
+}
+
+func (m *mockWorker) Settings(ctx context.Context, rId string) (*data.Settings, error) {
+	return &data.Settings{Anonymous: m.anonymous, Voting: m.voting}, nil
+}
+
+func (m *mockWorker) copyState() (*data.State, error) {
+	byt, err := json.Marshal(m.s)
+	if err != nil {
+		return nil, err
+	}
+
+	var s data.State
+	if err := json.Unmarshal(byt, &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (m *mockWorker) run(t *testing.T, q <-chan *broker.Message, b *broker.Memory) {
+	for msg := range q {
+		m.mu.Lock()
+
+		switch {
+		case msg.Operation != nil:
+			op := *msg.Operation
+			if err := op.Apply(m.s, &m.voting); err != nil {
+				switch err.(type) {
+				case data.VoteRejectedError, data.ForbiddenError:
+					_ = b.PublishRejection(context.Background(), op.RoomId, op.Reject(err))
+					m.mu.Unlock()
+
+					continue
+				}
+
+				m.mu.Unlock()
+				t.Error(err)
+
+				return
+			}
+
+			m.s.Revision++
+			op.Revision = m.s.Revision
+
+			_ = b.PublishOperation(context.Background(), op.RoomId, &op)
+		case msg.State != nil:
+			m.mu.Unlock()
+			t.Error("mockWorker does not merge states, use storeWorker")
+
+			return
+		}
+
+		m.mu.Unlock()
+	}
+}
+
+// storeWorker stores queued messages in a store and broadcasts the stored
+// result, like the worker does, so conflicts are resolved by the store's
+// merge.
+type storeWorker struct {
+	s *store.S
+}
+
+func (w *storeWorker) run(t *testing.T, q <-chan *broker.Message, b *broker.Memory) {
+	ctx := context.Background()
+
+	for msg := range q {
+		switch {
+		case msg.Operation != nil:
+			op := msg.Operation
+			if _, err := w.s.ApplyOperation(ctx, op); err != nil {
+				switch err.(type) {
+				case data.VoteRejectedError, data.ForbiddenError:
+					_ = b.PublishRejection(ctx, op.RoomId, op.Reject(err))
+					continue
+				}
+
+				t.Error(err)
+
+				return
+			}
+
+			_ = b.PublishOperation(ctx, op.RoomId, op)
+		case msg.State != nil:
+			ms, rs, err := w.s.StoreState(ctx, msg.State)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			_ = b.Publish(ctx, ms.RoomId, ms)
+
+			for _, r := range rs {
+				_ = b.PublishRejection(ctx, r.RoomId, r)
+			}
+		}
+	}
+}
+
+func TestRetrospectiveConcurrentWriters(t *testing.T) {
+	const (
+		rId        = "test"
+		cardId     = "some-uuid-pk-0"
+		numClients = 5
+		numVotes   = 10
+		numWrites  = numClients * (numVotes + 1)
+	)
+
+	ctx := context.Background()
+	s := store.New(store.NewMemory(), store.Retention{})
+
+	if err := s.StoreHashedPassword(ctx, rId, "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.StoreTemplate(ctx, rId, data.DefaultTemplate()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ApplyOperation(ctx, &data.Operation{
+		Type:   data.OperationAddCard,
+		RoomId: rId,
+		Card: &data.RetroCard{
+			Id:           cardId,
+			ColumnId:     "0",
+			Message:      "hello",
+			GroupId:      "default",
+			LastModified: 1,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	sw := &storeWorker{s: s}
+	mb := broker.NewMemory(1024, broker.SlowConsumerBlock)
+	mq := newMockBroker()
+
+	go sw.run(t, mq.ch, mb)
+
+	retRoute := "/api/v1/retrospectives/"
+	ret := mockUserMiddleware(rId)(NewRetrospective(s, newPresenceTracker(), mb, mq, rId))
+
+	r := http.NewServeMux()
+	r.Handle(retRoute, ret)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	u := fmt.Sprintf(
+		"ws%s%s%s",
+		strings.TrimPrefix(srv.URL, "http"),
+		retRoute,
+		rId,
+	)
+
+	wss := make([]*websocket.Conn, 0, numClients)
+
+	for i := 0; i < numClients; i++ {
+		ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+
+		readSnapshot(t, ws)
+
+		wss = append(wss, ws)
+	}
+
+	var wg sync.WaitGroup
+
+	for _, ws := range wss {
+		ws := ws
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < numVotes; i++ {
+				if err := ws.WriteJSON(&data.Message{
+					Type: data.MessageOperation,
+					Operation: &data.Operation{
+						Type:   data.OperationVote,
+						RoomId: rId,
+						CardId: cardId,
+					},
+				}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+
+			// a client sending a stale state with too many votes must not
+			// be able to overwrite what other clients see
+			stale, err := s.State(ctx, rId)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			stale.Columns[0].Groups[0].RetroCards[0].NumVotes = 1000
+
+			if err := ws.WriteJSON(
+				&data.Message{Type: data.MessageState, State: stale},
+			); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	for _, ws := range wss {
+		ws := ws
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var rev uint64
+
+			for rev < numWrites {
+				var m data.Message
+				if err := ws.ReadJSON(&m); err != nil {
+					t.Error(err)
+					return
+				}
+
+				if m.Presence != nil {
+					continue
+				}
+
+				if m.Revision() <= rev {
+					t.Errorf(
+						"expected revision after %d, got %d",
+						rev,
+						m.Revision(),
+					)
+
+					return
+				}
+
+				if m.State != nil {
+					if n := m.State.Columns[0].Groups[0].RetroCards[0].NumVotes; n > numClients*numVotes {
+						t.Errorf("got unmerged state with %d votes", n)
+						return
+					}
+				}
+
+				rev = m.Revision()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	fs, err := s.State(ctx, rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := fs.Columns[0].Groups[0].RetroCards[0].NumVotes; n != numClients*numVotes {
+		t.Fatalf("expected %d votes, got %d", numClients*numVotes, n)
+	}
+}
+
+func TestRetrospectiveShutdown(t *testing.T) {
+	const rId = "test"
+
+	retRoute := "/api/v1/retrospectives/"
+	rt := NewRetrospective(
+		newMockStateStore(),
+		newPresenceTracker(),
+		newMockBroker(),
+		newMockBroker(),
+		rId,
+	)
+
+	r := http.NewServeMux()
+	r.Handle(retRoute, mockUserMiddleware(rId)(rt))
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	u := fmt.Sprintf(
+		"ws%s%s%s",
+		strings.TrimPrefix(srv.URL, "http"),
+		retRoute,
+		rId,
+	)
+
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	readSnapshot(t, ws)
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		errCh <- rt.Shutdown(ctx)
+	}()
+
+	// the client answers the close frame, which ends the connection
+	_, _, err = ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("expected close frame going away, got: %v", err)
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+
+	// new clients are rejected while shutting down
+	_, res, err := websocket.DefaultDialer.Dial(u, nil)
+	if err == nil {
+		t.Fatal("expected dial to fail")
+	}
+
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf(
+			"expected status code %d, got %d",
+			http.StatusServiceUnavailable,
+			res.StatusCode,
+		)
+	}
+}
+
+func TestRetrospectiveDisconnect(t *testing.T) {
+	const rId = "test"
+
+	b := broker.NewMemory(1024, broker.SlowConsumerBlock)
+
+	retRoute := "/api/v1/retrospectives/"
+	rt := NewRetrospective(
+		newMockStateStore(),
+		newPresenceTracker(),
+		b,
+		newMockBroker(),
+		rId,
+	)
+
+	r := http.NewServeMux()
+	r.Handle(retRoute, mockUserMiddleware(rId)(rt))
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	u := fmt.Sprintf(
+		"ws%s%s%s",
+		strings.TrimPrefix(srv.URL, "http"),
+		retRoute,
+		rId,
+	)
+
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	readSnapshot(t, ws)
+
+	if err := b.PublishDisconnect(context.Background(), rId, "room deleted"); err != nil {
+		t.Fatal(err)
+	}
+
+	// presence messages may arrive before the close frame
+	for err == nil {
+		_, _, err = ws.ReadMessage()
+	}
+
+	ce, ok := err.(*websocket.CloseError)
+	if !ok || ce.Code != websocket.ClosePolicyViolation || ce.Text != "room deleted" {
+		t.Fatalf("expected close frame for the deleted room, got: %v", err)
+	}
+}
+
+func TestRetrospectiveAnonymous(t *testing.T) {
+	const (
+		rId    = "test"
+		cardId = "some-uuid-pk-0"
+	)
+
+	var s data.State
+	if err := json.Unmarshal([]byte(fmt.Sprintf(baseState, rId)), &s); err != nil {
+		t.Fatal(err)
+	}
+
+	mw := &mockWorker{s: &s, anonymous: true}
+	mb := broker.NewMemory(1024, broker.SlowConsumerBlock)
+	mq := newMockBroker()
+
+	go mw.run(t, mq.ch, mb)
+
+	retRoute := "/api/v1/retrospectives/"
+	ret := mockUserMiddleware(rId)(NewRetrospective(mw, newPresenceTracker(), mb, mq, rId))
+
+	r := http.NewServeMux()
+	r.Handle(retRoute, ret)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	dial := func(pId string) *websocket.Conn {
+		t.Helper()
+
+		u := fmt.Sprintf(
+			"ws%s%s%s?participantId=%s",
+			strings.TrimPrefix(srv.URL, "http"),
+			retRoute,
+			rId,
+			pId,
+		)
+
+		ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		readSnapshot(t, ws)
+
+		return ws
+	}
+
+	author := dial("author")
+	defer author.Close()
+
+	other := dial("other")
+	defer other.Close()
+
+	// the author sent by the client is replaced with its participant
+	if err := author.WriteJSON(&data.Message{
+		Type: data.MessageOperation,
+		Operation: &data.Operation{
+			Type:   data.OperationAddCard,
+			RoomId: rId,
+			Card: &data.RetroCard{
+				Id:           cardId,
+				ColumnId:     "0",
+				Message:      "hello",
+				GroupId:      "default",
+				LastModified: 1,
+				AuthorId:     "forged",
+			},
+			AuthorId: "forged",
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		ws       *websocket.Conn
+		expected string
+	}{
+		{ws: author, expected: "author"},
+		{ws: other, expected: ""},
+	} {
+		m := readBoardMessage(t, c.ws)
+
+		if m.Operation.AuthorId != c.expected || m.Operation.Card.AuthorId != c.expected {
+			t.Fatalf(
+				"expected author '%s', got: '%s' and '%s'",
+				c.expected,
+				m.Operation.AuthorId,
+				m.Operation.Card.AuthorId,
+			)
+		}
+	}
+
+	// the stored card keeps its author
+	st, err := mw.State(context.Background(), rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a := st.Columns[0].Groups[0].RetroCards[0].AuthorId; a != "author" {
+		t.Fatalf("expected stored author 'author', got: '%s'", a)
+	}
+}
+
+func TestRetrospectiveVoting(t *testing.T) {
+	const (
+		rId    = "test"
+		cardId = "some-uuid-pk-0"
+	)
+
+	var s data.State
+	if err := json.Unmarshal([]byte(fmt.Sprintf(baseState, rId)), &s); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Columns[0].Groups[0].RetroCards = []*data.RetroCard{
+		{
+			Id:           cardId,
+			ColumnId:     "0",
+			Message:      "hello",
+			GroupId:      "default",
+			LastModified: 1,
+			AuthorId:     "author",
+		},
+	}
+	s.Ballots = map[string]map[string]uint{"other": {"some-uuid": 1}}
+
+	mw := &mockWorker{s: &s, voting: data.Voting{VotesPerParticipant: 1}}
+	mb := broker.NewMemory(1024, broker.SlowConsumerBlock)
+	mq := newMockBroker()
+
+	go mw.run(t, mq.ch, mb)
+
+	retRoute := "/api/v1/retrospectives/"
+	ret := mockUserMiddleware(rId)(NewRetrospective(mw, newPresenceTracker(), mb, mq, rId))
+
+	r := http.NewServeMux()
+	r.Handle(retRoute, ret)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	u := fmt.Sprintf(
+		"ws%s%s%s?participantId=voter",
+		strings.TrimPrefix(srv.URL, "http"),
+		retRoute,
+		rId,
+	)
+
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	snapshot, ballot, _ := readConnected(t, ws)
+
+	// participants are only sent their own ballot
+	if snapshot.State.Ballots != nil {
+		t.Fatalf("expected the ballots to be hidden, got: %v", snapshot.State.Ballots)
+	}
+
+	if ballot == nil || ballot.Ballot.Remaining == nil || *ballot.Ballot.Remaining != 1 {
+		t.Fatalf("expected 1 remaining vote, got: %+v", ballot)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := ws.WriteJSON(&data.Message{
+			Type: data.MessageOperation,
+			Operation: &data.Operation{
+				Type:   data.OperationVote,
+				RoomId: rId,
+				CardId: cardId,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first vote is counted, and the second one is rejected
+	var ms []*data.Message
+
+	for len(ms) < 3 {
+		var m data.Message
+		if err := ws.ReadJSON(&m); err != nil {
 			t.Fatal(err)
 		}
 
-		expectState(t, &stateToSend, &stateToReceive)
+		if m.Presence == nil {
+			ms = append(ms, &m)
+		}
+	}
+
+	if ms[0].Type != data.MessageOperation || ms[0].Operation.Type != data.OperationVote {
+		t.Fatalf("expected the vote, got: %+v", ms[0])
+	}
+
+	if ms[1].Type != data.MessageBallot || *ms[1].Ballot.Remaining != 0 || ms[1].Ballot.Votes["some-uuid"] != 1 {
+		t.Fatalf("expected the ballot to count the vote, got: %+v", ms[1].Ballot)
+	}
+
+	if ms[2].Type != data.MessageRejection || ms[2].Rejection.CardId != cardId {
+		t.Fatalf("expected the second vote to be rejected, got: %+v", ms[2])
+	}
+	// retracting the vote gives it back
+	if err := ws.WriteJSON(&data.Message{
+		Type: data.MessageOperation,
+		Operation: &data.Operation{
+			Type:   data.OperationUnvote,
+			RoomId: rId,
+			CardId: cardId,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if m := readBoardMessage(t, ws); m.Operation == nil || m.Operation.Type != data.OperationUnvote {
+		t.Fatalf("expected the retraction, got: %+v", m)
+	}
+}
+
+func TestRetrospectiveSecretVoting(t *testing.T) {
+	const (
+		rId    = "test"
+		cardId = "some-uuid-pk-0"
+	)
+
+	var s data.State
+	if err := json.Unmarshal([]byte(fmt.Sprintf(baseState, rId)), &s); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Columns[0].Groups[0].RetroCards = []*data.RetroCard{
+		{
+			Id:           cardId,
+			ColumnId:     "0",
+			Message:      "hello",
+			GroupId:      "default",
+			LastModified: 1,
+			NumVotes:     2,
+		},
+	}
+	s.Ballots = map[string]map[string]uint{"someone": {"some-uuid": 2}}
+
+	mw := &mockWorker{s: &s, voting: data.Voting{Secret: true}}
+	mb := broker.NewMemory(1024, broker.SlowConsumerBlock)
+	mq := newMockBroker()
+
+	go mw.run(t, mq.ch, mb)
+
+	retRoute := "/api/v1/retrospectives/"
+	ret := mockUserMiddleware(rId)(NewRetrospective(mw, newPresenceTracker(), mb, mq, rId))
+
+	r := http.NewServeMux()
+	r.Handle(retRoute, ret)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	dial := func(pId string) *websocket.Conn {
+		t.Helper()
+
+		u := fmt.Sprintf(
+			"ws%s%s%s?participantId=%s",
+			strings.TrimPrefix(srv.URL, "http"),
+			retRoute,
+			rId,
+			pId,
+		)
+
+		ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		snapshot := readSnapshot(t, ws)
+		if n := snapshot.State.Columns[0].Groups[0].RetroCards[0].NumVotes; n != 0 {
+			t.Fatalf("expected the votes of others to be hidden, got: %d", n)
+		}
+
+		return ws
+	}
+
+	voter := dial("voter")
+	defer voter.Close()
+
+	other := dial("other")
+	defer other.Close()
+
+	send := func(ws *websocket.Conn, typ string) {
+		t.Helper()
+
+		if err := ws.WriteJSON(&data.Message{
+			Type: data.MessageOperation,
+			Operation: &data.Operation{
+				Type:   typ,
+				RoomId: rId,
+				CardId: cardId,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send(voter, data.OperationVote)
+
+	if m := readBoardMessage(t, voter); m.Operation == nil || m.Operation.Type != data.OperationVote {
+		t.Fatalf("expected the participant's own vote, got: %+v", m)
+	}
+
+	send(other, data.OperationRevealVotes)
+
+	// the vote is never sent to the other participant, who gets the
+	// totals once they are revealed
+	for _, ws := range []*websocket.Conn{other, voter} {
+		m := readBoardMessage(t, ws)
+		if m.Type != data.MessageSnapshot {
+			t.Fatalf("expected the revealed votes, got: %+v", m)
+		}
+
+		if n := m.State.Columns[0].Groups[0].RetroCards[0].NumVotes; n != 3 || !m.State.VotesRevealed {
+			t.Fatalf("expected 3 revealed votes, got: %d", n)
+		}
+	}
+
+	send(other, data.OperationHideVotes)
+
+	// a new round hides the totals again, leaving each participant their
+	// own votes
+	for ws, want := range map[*websocket.Conn]uint{other: 0, voter: 1} {
+		m := readBoardMessage(t, ws)
+		if m.Type != data.MessageSnapshot {
+			t.Fatalf("expected the hidden votes, got: %+v", m)
+		}
+
+		if n := m.State.Columns[0].Groups[0].RetroCards[0].NumVotes; n != want || m.State.VotesRevealed {
+			t.Fatalf("expected %d hidden votes, got: %d", want, n)
+		}
+	}
+}
+
+func TestRetrospectiveFacilitator(t *testing.T) {
+	const rId = "test"
+
+	var s data.State
+	if err := json.Unmarshal([]byte(fmt.Sprintf(baseState, rId)), &s); err != nil {
+		t.Fatal(err)
+	}
+
+	s.FacilitatorId = "facilitator"
+
+	mw := &mockWorker{s: &s}
+	mb := broker.NewMemory(1024, broker.SlowConsumerBlock)
+	mq := newMockBroker()
+
+	go mw.run(t, mq.ch, mb)
+
+	retRoute := "/api/v1/retrospectives/"
+	ret := mockUserMiddleware(rId)(NewRetrospective(mw, newPresenceTracker(), mb, mq, rId))
+
+	r := http.NewServeMux()
+	r.Handle(retRoute, ret)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	dial := func(pId string) *websocket.Conn {
+		t.Helper()
+
+		u := fmt.Sprintf(
+			"ws%s%s%s?participantId=%s",
+			strings.TrimPrefix(srv.URL, "http"),
+			retRoute,
+			rId,
+			pId,
+		)
+
+		ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m := readSnapshot(t, ws); m.State.FacilitatorId != "facilitator" {
+			t.Fatalf("expected facilitator 'facilitator', got: '%s'", m.State.FacilitatorId)
+		}
+
+		return ws
+	}
+
+	facilitator := dial("facilitator")
+	defer facilitator.Close()
+
+	other := dial("other")
+	defer other.Close()
+
+	send := func(ws *websocket.Conn, typ string) {
+		t.Helper()
+
+		if err := ws.WriteJSON(&data.Message{
+			Type:      data.MessageOperation,
+			Operation: &data.Operation{Type: typ, RoomId: rId},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// only the participant who was not allowed hears about it
+	send(other, data.OperationLockBoard)
+
+	m := readBoardMessage(t, other)
+	if m.Type != data.MessageRejection || m.Rejection.Operation != data.OperationLockBoard {
+		t.Fatalf("expected the lock to be rejected, got: %+v", m)
+	}
+
+	send(facilitator, data.OperationLockBoard)
+
+	for _, ws := range []*websocket.Conn{facilitator, other} {
+		m := readBoardMessage(t, ws)
+		if m.Operation == nil || m.Operation.Type != data.OperationLockBoard {
+			t.Fatalf("expected the board to be locked, got: %+v", m)
+		}
+	}
+}
+
+func TestRetrospectivePrivateBrainstorm(t *testing.T) {
+	const (
+		rId    = "test"
+		cardId = "some-uuid-pk-0"
+	)
+
+	var s data.State
+	if err := json.Unmarshal([]byte(fmt.Sprintf(baseState, rId)), &s); err != nil {
+		t.Fatal(err)
+	}
+
+	s.FacilitatorId = "facilitator"
+
+	mw := &mockWorker{s: &s}
+	mb := broker.NewMemory(1024, broker.SlowConsumerBlock)
+	mq := newMockBroker()
+
+	go mw.run(t, mq.ch, mb)
+
+	retRoute := "/api/v1/retrospectives/"
+	ret := mockUserMiddleware(rId)(NewRetrospective(mw, newPresenceTracker(), mb, mq, rId))
+
+	r := http.NewServeMux()
+	r.Handle(retRoute, ret)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	dial := func(pId string) *websocket.Conn {
+		t.Helper()
+
+		u := fmt.Sprintf(
+			"ws%s%s%s?participantId=%s",
+			strings.TrimPrefix(srv.URL, "http"),
+			retRoute,
+			rId,
+			pId,
+		)
+
+		ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		readSnapshot(t, ws)
+
+		return ws
+	}
+
+	facilitator := dial("facilitator")
+	defer facilitator.Close()
+
+	author := dial("author")
+	defer author.Close()
+
+	send := func(ws *websocket.Conn, op *data.Operation) {
+		t.Helper()
+
+		op.RoomId = rId
+		if err := ws.WriteJSON(&data.Message{
+			Type:      data.MessageOperation,
+			Operation: op,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectOperation := func(typ string) {
+		t.Helper()
+
+		for _, ws := range []*websocket.Conn{facilitator, author} {
+			if m := readBoardMessage(t, ws); m.Operation == nil || m.Operation.Type != typ {
+				t.Fatalf("expected '%s', got: %+v", typ, m)
+			}
+		}
+	}
+
+	send(facilitator, &data.Operation{
+		Type:    data.OperationChangePhase,
+		Phase:   data.PhaseBrainstorm,
+		Private: true,
+	})
+	expectOperation(data.OperationChangePhase)
+
+	send(author, &data.Operation{
+		Type: data.OperationAddCard,
+		Card: &data.RetroCard{
+			Id:           cardId,
+			ColumnId:     "0",
+			Message:      "secret",
+			GroupId:      "default",
+			LastModified: 1,
+		},
+	})
+
+	// only the author sees what they wrote
+	for _, e := range []struct {
+		ws      *websocket.Conn
+		message string
+	}{
+		{ws: facilitator, message: ""},
+		{ws: author, message: "secret"},
+	} {
+		m := readBoardMessage(t, e.ws)
+		if m.Operation == nil || m.Operation.Card.Message != e.message {
+			t.Fatalf("expected the card with message '%s', got: %+v", e.message, m)
+		}
+	}
+
+	send(facilitator, &data.Operation{
+		Type:  data.OperationChangePhase,
+		Phase: data.PhaseGroup,
+	})
+
+	// the end of the brainstorm reveals every card
+	for _, ws := range []*websocket.Conn{facilitator, author} {
+		m := readBoardMessage(t, ws)
+		if m.Type != data.MessageSnapshot {
+			t.Fatalf("expected the revealed cards, got: %+v", m)
+		}
+
+		if c := m.State.Columns[0].Groups[0].RetroCards[0]; c.Message != "secret" || c.Private {
+			t.Fatalf("expected the card to be revealed, got: %+v", c)
+		}
+	}
+}
+
+func TestRetrospectivePresence(t *testing.T) {
+	const rId = "test"
+
+	b := broker.NewMemory(1024, broker.SlowConsumerBlock)
+	pt := newPresenceTracker()
+
+	retRoute := "/api/v1/retrospectives/"
+	rt := NewRetrospective(newMockStateStore(), pt, b, newMockBroker(), rId)
+
+	r := http.NewServeMux()
+	r.Handle(retRoute, mockUserMiddleware(rId)(rt))
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	dial := func(pId string) (*websocket.Conn, *data.Message) {
+		t.Helper()
+
+		u := fmt.Sprintf(
+			"ws%s%s%s?participantId=%s",
+			strings.TrimPrefix(srv.URL, "http"),
+			retRoute,
+			rId,
+			pId,
+		)
+
+		ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, presence := readConnected(t, ws)
+
+		return ws, presence
+	}
+
+	// expectEvent skips the events that came before the expected one, like
+	// the client's own join
+	expectEvent := func(ws *websocket.Conn, event, pId string) {
+		t.Helper()
+
+		for {
+			var m data.Message
+			if err := ws.ReadJSON(&m); err != nil {
+				t.Fatal(err)
+			}
+
+			if m.Presence != nil && m.Presence.Event == event && m.Presence.Participant.Id == pId {
+				return
+			}
+		}
+	}
+
+	first, p := dial("first")
+	defer first.Close()
+
+	expectState(
+		t,
+		&data.Presence{
+			RoomId:       rId,
+			Participants: []*data.Participant{{Id: "first"}},
+		},
+		p.Presence,
+	)
+
+	second, p := dial("second")
+
+	if n := len(p.Presence.Participants); n != 2 {
+		t.Fatalf("expected 2 participants, got: %d", n)
+	}
+
+	expectEvent(first, data.PresenceJoin, "second")
+
+	second.Close()
+
+	expectEvent(first, data.PresenceLeave, "second")
+
+	ps, err := pt.Participants(context.Background(), rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectState(t, []*data.Participant{{Id: "first"}}, ps)
+}
+
+func expectMessage(t *testing.T, ws *websocket.Conn, expected *data.Message) {
+	t.Helper()
+
+	expectState(t, expected, readBoardMessage(t, ws))
+}
+
+// readSnapshot reads the messages written when a client connects, and
+// returns the snapshot.
+func readSnapshot(t *testing.T, ws *websocket.Conn) *data.Message {
+	t.Helper()
+
+	snapshot, _, _ := readConnected(t, ws)
+
+	return snapshot
+}
+
+// readConnected reads the messages written when a client connects - the
+// snapshot of the board, the ballot of participants, and then who is
+// connected. The ballot is nil for clients without a participant.
+func readConnected(
+	t *testing.T,
+	ws *websocket.Conn,
+) (*data.Message, *data.Message, *data.Message) {
+	t.Helper()
+
+	var snapshot, ballot, presence data.Message
+
+	if err := ws.ReadJSON(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ws.ReadJSON(&presence); err != nil {
+		t.Fatal(err)
+	}
+
+	if presence.Type == data.MessageBallot {
+		ballot = presence
+		presence = data.Message{}
+
+		if err := ws.ReadJSON(&presence); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if snapshot.Type != data.MessageSnapshot || presence.Type != data.MessagePresence {
+		t.Fatalf(
+			"expected snapshot and presence, got: '%s' and '%s'",
+			snapshot.Type,
+			presence.Type,
+		)
+	}
+
+	if ballot.Ballot == nil {
+		return &snapshot, nil, &presence
+	}
+
+	return &snapshot, &ballot, &presence
+}
+
+// readBoardMessage reads the next message about the board, skipping
+// presence messages, which arrive whenever clients connect, and ballots.
+func readBoardMessage(t *testing.T, ws *websocket.Conn) *data.Message {
+	t.Helper()
+
+	for {
+		var m data.Message
+		if err := ws.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+
+		if m.Presence == nil && m.Ballot == nil {
+			return &m
+		}
 	}
 }
 
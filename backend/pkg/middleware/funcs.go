@@ -19,10 +19,25 @@ type TokenValidator interface {
 		ctx context.Context,
 		r *http.Request,
 		cc *auth.ComparisonClaims,
-	) error
+	) (*auth.Claims, error)
 }
 
-func AuthFunc(t TokenValidator, route string) func(next http.Handler) http.Handler {
+type TokenGenerationGetter interface {
+	TokenGeneration(ctx context.Context, rId string) (string, error)
+}
+
+// notExistError is the error of a TokenGenerationGetter for a room that
+// does not exist.
+type notExistError interface {
+	error
+	NotExist() bool
+}
+
+func AuthFunc(
+	t TokenValidator,
+	g TokenGenerationGetter,
+	route string,
+) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			_, span := tr.Start(r.Context(), "auth middleware")
@@ -42,8 +57,37 @@ func AuthFunc(t TokenValidator, route string) func(next http.Handler) http.Handl
 				return
 			}
 
-			c := auth.NewComparisonClaims(rId)
-			if err := t.ValidateToken(r.Context(), r, c); err != nil {
+			// Rooms that were deleted do not have a generation, so their
+			// tokens are rejected.
+			gen, err := g.TokenGeneration(r.Context(), rId)
+			if err != nil {
+				span.RecordError(err)
+
+				if ne, ok := err.(notExistError); ok && ne.NotExist() {
+					http.Error(
+						w,
+						http.StatusText(http.StatusBadRequest),
+						http.StatusBadRequest,
+					)
+
+					return
+				}
+
+				http.Error(
+					w,
+					http.StatusText(http.StatusInternalServerError),
+					http.StatusInternalServerError,
+				)
+
+				return
+			}
+
+			c, err := t.ValidateToken(
+				r.Context(),
+				r,
+				auth.NewComparisonClaims(rId, gen),
+			)
+			if err != nil {
 				span.RecordError(err)
 
 				http.Error(
@@ -57,6 +101,8 @@ func AuthFunc(t TokenValidator, route string) func(next http.Handler) http.Handl
 
 			u, _ := user.FromContext(r.Context())
 			u.RoomId = rId
+			u.ParticipantId = c.ParticipantId
+			u.DisplayName = c.DisplayName
 
 			ctx := user.WithContext(r.Context(), u)
 			next.ServeHTTP(w, r.WithContext(ctx))
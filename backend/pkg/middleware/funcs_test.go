@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/safe-waters/retro-simply/backend/pkg/store"
+)
+
+type mockTokenGenerationGetter struct{ err error }
+
+func (m *mockTokenGenerationGetter) TokenGeneration(
+	ctx context.Context,
+	rId string,
+) (string, error) {
+	return "", m.err
+}
+
+func TestAuthFuncTokenGeneration(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name string
+		Err  error
+		Code int
+	}{
+		{
+			Name: "Deleted Room",
+			Err:  store.DataDoesNotExistError{Err: errors.New("room does not exist")},
+			Code: http.StatusBadRequest,
+		},
+		{
+			Name: "Store Unavailable",
+			Err:  errors.New("store unavailable"),
+			Code: http.StatusInternalServerError,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			const route = "/api/v1/retrospectives/"
+
+			h := AuthFunc(nil, &mockTokenGenerationGetter{err: test.Err}, route)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					t.Fatal("expected the request to be rejected")
+				}),
+			)
+
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, route+"test", nil))
+
+			if res.Code != test.Code {
+				t.Fatalf("expected status code %d, got: %d", test.Code, res.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/safe-waters/retro-simply/backend/pkg/data"
+)
+
+// maxBodySize bounds how much of a request's body is read to find its
+// room id.
+const maxBodySize = 1 << 20
+
+type AttemptLimiter interface {
+	Allow(ctx context.Context, k string) (time.Duration, error)
+	Fail(ctx context.Context, k string) error
+	Succeed(ctx context.Context, k string) error
+}
+
+// Limiter rate limits requests by client address and by the room id in
+// their body, and locks them out after failed password comparisons, which
+// handlers report with Fail. Failures lock out the client's address, and
+// the client in the room, but never the room for every client.
+type Limiter struct {
+	addr AttemptLimiter
+	room AttemptLimiter
+	// trustProxy uses the address the reverse proxy appended to the
+	// X-Forwarded-For header, instead of the address of the proxy.
+	trustProxy bool
+}
+
+func NewLimiter(addr, room AttemptLimiter, trustProxy bool) *Limiter {
+	return &Limiter{addr: addr, room: room, trustProxy: trustProxy}
+}
+
+func (l *Limiter) Func(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tr.Start(r.Context(), "limit middleware")
+		defer span.End()
+
+		// The body is read to find the room id, and then replaced, so the
+		// handler can read it again.
+		byt, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			span.RecordError(err)
+			http.Error(
+				w,
+				http.StatusText(http.StatusBadRequest),
+				http.StatusBadRequest,
+			)
+
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(byt))
+
+		var room struct {
+			Id string `json:"id"`
+		}
+
+		// Bodies without a room id are only limited by address, and left
+		// for the handler to reject.
+		_ = json.Unmarshal(byt, &room)
+
+		var wait time.Duration
+
+		for _, c := range []struct {
+			al AttemptLimiter
+			k  string
+		}{
+			{al: l.addr, k: l.addrKey(r)},
+			{al: l.room, k: roomKey(room.Id)},
+			{al: l.room, k: l.clientRoomKey(r, room.Id)},
+		} {
+			if c.k == "" {
+				continue
+			}
+
+			cWait, err := c.al.Allow(ctx, c.k)
+			if err != nil {
+				span.RecordError(err)
+				http.Error(
+					w,
+					http.StatusText(http.StatusInternalServerError),
+					http.StatusInternalServerError,
+				)
+
+				return
+			}
+
+			if cWait > wait {
+				wait = cWait
+			}
+		}
+
+		if wait > 0 {
+			secs := int(math.Ceil(wait.Seconds()))
+
+			err := fmt.Errorf("too many attempts, retry in %d seconds", secs)
+			span.RecordError(err)
+
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Fail records a failed password comparison for the request's client, and
+// for the client in the room.
+func (l *Limiter) Fail(ctx context.Context, r *http.Request, rId string) {
+	ctx, span := tr.Start(ctx, "limiter fail")
+	defer span.End()
+
+	if k := l.addrKey(r); k != "" {
+		if err := l.addr.Fail(ctx, k); err != nil {
+			span.RecordError(err)
+		}
+	}
+
+	if k := l.clientRoomKey(r, rId); k != "" {
+		if err := l.room.Fail(ctx, k); err != nil {
+			span.RecordError(err)
+		}
+	}
+}
+
+// Succeed forgets the failed password comparisons of the request's client,
+// and of the client in the room.
+func (l *Limiter) Succeed(ctx context.Context, r *http.Request, rId string) {
+	ctx, span := tr.Start(ctx, "limiter succeed")
+	defer span.End()
+
+	if k := l.addrKey(r); k != "" {
+		if err := l.addr.Succeed(ctx, k); err != nil {
+			span.RecordError(err)
+		}
+	}
+
+	if k := l.clientRoomKey(r, rId); k != "" {
+		if err := l.room.Succeed(ctx, k); err != nil {
+			span.RecordError(err)
+		}
+	}
+}
+
+func (l *Limiter) addrKey(r *http.Request) string {
+	if l.trustProxy {
+		// Only the last address was added by the proxy - the others were
+		// sent by the client, which could make them up.
+		fs := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if a := strings.TrimSpace(fs[len(fs)-1]); a != "" {
+			return fmt.Sprintf("addr:%s", a)
+		}
+	}
+
+	h, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		h = r.RemoteAddr
+	}
+
+	if h == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("addr:%s", h)
+}
+
+func roomKey(rId string) string {
+	if !data.RoomIDRegex.MatchString(rId) {
+		return ""
+	}
+
+	return fmt.Sprintf("room:%s", rId)
+}
+
+// clientRoomKey returns the key of the request's client in the room, which
+// failures lock out.
+func (l *Limiter) clientRoomKey(r *http.Request, rId string) string {
+	rk, ak := roomKey(rId), l.addrKey(r)
+	if rk == "" || ak == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%s", rk, ak)
+}
@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/safe-waters/retro-simply/backend/pkg/store"
+)
+
+func newTestLimiter(trustProxy bool) *Limiter {
+	l := store.Limit{
+		Attempts:   2,
+		Window:     time.Hour,
+		Failures:   0,
+		Lockout:    time.Minute,
+		MaxLockout: time.Hour,
+	}
+
+	b := store.NewMemory()
+
+	return NewLimiter(store.NewLimiter(b, l), store.NewLimiter(b, l), trustProxy)
+}
+
+func limitRequest(
+	t *testing.T,
+	l *Limiter,
+	addr string,
+	xff string,
+	body string,
+) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.RemoteAddr = addr
+
+	if xff != "" {
+		req.Header.Set("X-Forwarded-For", xff)
+	}
+
+	res := httptest.NewRecorder()
+
+	l.Func(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the handler reads the body the limiter read
+		byt, err := ioutil.ReadAll(r.Body)
+		if err != nil || string(byt) != body {
+			t.Errorf("expected body '%s', got: '%s', %v", body, byt, err)
+		}
+	})).ServeHTTP(res, req)
+
+	return res
+}
+
+func expectLimited(t *testing.T, res *httptest.ResponseRecorder, limited bool) {
+	t.Helper()
+
+	code := res.Result().StatusCode
+	ra := res.Result().Header.Get("Retry-After")
+
+	switch {
+	case limited && (code != http.StatusTooManyRequests || ra == ""):
+		t.Fatalf(
+			"expected %d with Retry-After, got: %d, '%s'",
+			http.StatusTooManyRequests,
+			code,
+			ra,
+		)
+	case !limited && code != http.StatusOK:
+		t.Fatalf("expected %d, got: %d", http.StatusOK, code)
+	}
+}
+
+func TestLimiterByAddress(t *testing.T) {
+	t.Parallel()
+
+	l := newTestLimiter(false)
+
+	expectLimited(t, limitRequest(t, l, "1.1.1.1:1", "", ""), false)
+	expectLimited(t, limitRequest(t, l, "1.1.1.1:2", "", "{}"), false)
+	expectLimited(t, limitRequest(t, l, "1.1.1.1:3", "", ""), true)
+
+	// X-Forwarded-For is not trusted without a proxy
+	expectLimited(t, limitRequest(t, l, "1.1.1.1:4", "2.2.2.2", ""), true)
+	expectLimited(t, limitRequest(t, l, "2.2.2.2:1", "", ""), false)
+}
+
+func TestLimiterByRoom(t *testing.T) {
+	t.Parallel()
+
+	l := newTestLimiter(true)
+	body := `{"id": "test", "password": "test"}`
+
+	expectLimited(t, limitRequest(t, l, "proxy:1", "1.1.1.1", body), false)
+	expectLimited(t, limitRequest(t, l, "proxy:1", "made-up, 2.2.2.2", body), false)
+	expectLimited(t, limitRequest(t, l, "proxy:1", "3.3.3.3", body), true)
+	expectLimited(t, limitRequest(t, l, "proxy:1", "3.3.3.3", `{"id": "other"}`), false)
+}
+
+func TestLimiterLockoutByClientInRoom(t *testing.T) {
+	t.Parallel()
+
+	b := store.NewMemory()
+	l := NewLimiter(
+		store.NewLimiter(b, store.Limit{Attempts: 10, Window: time.Hour, Failures: 10}),
+		store.NewLimiter(b, store.Limit{
+			Attempts:   10,
+			Window:     time.Hour,
+			Failures:   0,
+			Lockout:    time.Minute,
+			MaxLockout: time.Hour,
+		}),
+		false,
+	)
+	body := `{"id": "test", "password": "wrong"}`
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = "1.1.1.1:1"
+
+	l.Fail(context.Background(), req, "test")
+
+	// the client is only locked out of the room it failed to join
+	expectLimited(t, limitRequest(t, l, "1.1.1.1:1", "", body), true)
+	expectLimited(t, limitRequest(t, l, "1.1.1.1:1", "", `{"id": "other"}`), false)
+	expectLimited(t, limitRequest(t, l, "2.2.2.2:1", "", body), false)
+}
+
+func TestLimiterLockout(t *testing.T) {
+	t.Parallel()
+
+	l := newTestLimiter(false)
+	body := `{"id": "test", "password": "wrong"}`
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = "1.1.1.1:1"
+
+	l.Fail(context.Background(), req, "test")
+
+	res := limitRequest(t, l, "1.1.1.1:1", "", "")
+	expectLimited(t, res, true)
+
+	if ra := res.Result().Header.Get("Retry-After"); ra != "60" {
+		t.Fatalf("expected to retry after 60 seconds, got: '%s'", ra)
+	}
+
+	// the client is locked out of the room, but not the other clients
+	expectLimited(t, limitRequest(t, l, "2.2.2.2:1", "", body), false)
+
+	l.Succeed(context.Background(), req, "test")
+
+	expectLimited(t, limitRequest(t, l, "1.1.1.1:1", "", body), false)
+}
@@ -0,0 +1,171 @@
+package pool
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/safe-waters/retro-simply/backend/pkg/queue"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var tr = otel.Tracer("pkg/pool")
+
+const (
+	shardBuffer = 1024
+	// maxBatch bounds how many queued states of a room are stored in a
+	// single transaction.
+	maxBatch = 100
+)
+
+// HandleFunc handles a batch of deliveries for the same room. A batch has
+// more than one delivery only when consecutive states of a room were
+// queued, so they can be stored together.
+type HandleFunc func(ctx context.Context, ds []*queue.Delivery)
+
+type Stats struct {
+	QueueDepth int64  `json:"queueDepth"`
+	InFlight   int64  `json:"inFlight"`
+	Handled    uint64 `json:"handled"`
+	Coalesced  uint64 `json:"coalesced"`
+}
+
+// P handles deliveries on a fixed number of goroutines. Deliveries are
+// sharded by room id, so the deliveries of a room are handled one at a
+// time, in the order they were submitted, and never compete with each
+// other for the same key in the store.
+type P struct {
+	shards []chan *queue.Delivery
+	h      HandleFunc
+	wg     *sync.WaitGroup
+
+	depth     int64
+	inFlight  int64
+	handled   uint64
+	coalesced uint64
+}
+
+func New(size int, h HandleFunc) *P {
+	if size < 1 {
+		size = 1
+	}
+
+	shards := make([]chan *queue.Delivery, size)
+	for i := range shards {
+		shards[i] = make(chan *queue.Delivery, shardBuffer)
+	}
+
+	return &P{shards: shards, h: h, wg: &sync.WaitGroup{}}
+}
+
+// Run starts the goroutines of the pool. They stop once Close is called
+// and every submitted delivery has been handled.
+func (p *P) Run(ctx context.Context) {
+	for _, ch := range p.shards {
+		p.wg.Add(1)
+
+		go func(ch chan *queue.Delivery) {
+			defer p.wg.Done()
+
+			p.runShard(ctx, ch)
+		}(ch)
+	}
+}
+
+// Submit queues the delivery on the shard of its room. It blocks while
+// the shard is full, so a burst slows down consuming instead of piling up
+// goroutines.
+func (p *P) Submit(ctx context.Context, d *queue.Delivery) error {
+	ch := p.shards[p.shard(d.RoomId())]
+
+	atomic.AddInt64(&p.depth, 1)
+
+	select {
+	case ch <- d:
+		return nil
+	case <-ctx.Done():
+		atomic.AddInt64(&p.depth, -1)
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting deliveries. Wait returns once the deliveries
+// submitted before Close have been handled.
+func (p *P) Close() {
+	for _, ch := range p.shards {
+		close(ch)
+	}
+}
+
+func (p *P) Wait() { p.wg.Wait() }
+
+func (p *P) Stats() Stats {
+	return Stats{
+		QueueDepth: atomic.LoadInt64(&p.depth),
+		InFlight:   atomic.LoadInt64(&p.inFlight),
+		Handled:    atomic.LoadUint64(&p.handled),
+		Coalesced:  atomic.LoadUint64(&p.coalesced),
+	}
+}
+
+func (p *P) shard(rId string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(rId))
+
+	return int(h.Sum32() % uint32(len(p.shards)))
+}
+
+func (p *P) runShard(ctx context.Context, ch <-chan *queue.Delivery) {
+	for d := range ch {
+		batch := []*queue.Delivery{d}
+
+		// Coalesce the states of the same room that are already queued
+		// behind the first one. Anything else ends the batch, to keep the
+		// order of the room's deliveries.
+	coalesce:
+		for len(batch) < maxBatch && batch[0].State != nil {
+			select {
+			case n, ok := <-ch:
+				if !ok {
+					break coalesce
+				}
+
+				if n.State == nil || n.RoomId() != batch[0].RoomId() {
+					p.handle(ctx, batch)
+					batch = []*queue.Delivery{n}
+
+					continue
+				}
+
+				batch = append(batch, n)
+			default:
+				break coalesce
+			}
+		}
+
+		p.handle(ctx, batch)
+	}
+}
+
+func (p *P) handle(ctx context.Context, batch []*queue.Delivery) {
+	ctx, span := tr.Start(ctx, "pool handle")
+	defer span.End()
+
+	n := int64(len(batch))
+
+	span.SetAttributes(
+		attribute.String("room.id", batch[0].RoomId()),
+		attribute.Int64("batch.size", n),
+	)
+
+	atomic.AddInt64(&p.depth, -n)
+	atomic.AddInt64(&p.inFlight, n)
+
+	p.h(ctx, batch)
+
+	atomic.AddInt64(&p.inFlight, -n)
+	atomic.AddUint64(&p.handled, uint64(n))
+	atomic.AddUint64(&p.coalesced, uint64(n-1))
+}
@@ -0,0 +1,169 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/safe-waters/retro-simply/backend/pkg/broker"
+	"github.com/safe-waters/retro-simply/backend/pkg/data"
+	"github.com/safe-waters/retro-simply/backend/pkg/queue"
+)
+
+func newStateDelivery(rId string, id int) *queue.Delivery {
+	return &queue.Delivery{
+		Message: &broker.Message{State: &data.State{RoomId: rId}},
+		Id:      fmt.Sprint(id),
+	}
+}
+
+func newOperationDelivery(rId string, id int) *queue.Delivery {
+	return &queue.Delivery{
+		Message: &broker.Message{Operation: &data.Operation{RoomId: rId}},
+		Id:      fmt.Sprint(id),
+	}
+}
+
+func TestPoolOrdersPerRoom(t *testing.T) {
+	t.Parallel()
+
+	const (
+		numRooms      = 10
+		numDeliveries = 100
+	)
+
+	var (
+		mu      sync.Mutex
+		handled = map[string][]string{}
+		running = map[string]bool{}
+	)
+
+	p := New(4, func(ctx context.Context, ds []*queue.Delivery) {
+		rId := ds[0].RoomId()
+
+		mu.Lock()
+		if running[rId] {
+			t.Errorf("room '%s' handled concurrently", rId)
+		}
+		running[rId] = true
+		mu.Unlock()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, d := range ds {
+			if d.RoomId() != rId {
+				t.Errorf("got room '%s' in batch of room '%s'", d.RoomId(), rId)
+			}
+
+			handled[rId] = append(handled[rId], d.Id)
+		}
+
+		running[rId] = false
+	})
+	p.Run(context.Background())
+
+	for i := 0; i < numDeliveries; i++ {
+		for r := 0; r < numRooms; r++ {
+			rId := fmt.Sprint(r)
+
+			d := newStateDelivery(rId, i)
+			if i%3 == 0 {
+				d = newOperationDelivery(rId, i)
+			}
+
+			if err := p.Submit(context.Background(), d); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	p.Close()
+	p.Wait()
+
+	for r := 0; r < numRooms; r++ {
+		rId := fmt.Sprint(r)
+
+		if len(handled[rId]) != numDeliveries {
+			t.Fatalf(
+				"expected %d deliveries for room '%s', got %d",
+				numDeliveries,
+				rId,
+				len(handled[rId]),
+			)
+		}
+
+		for i, id := range handled[rId] {
+			if id != fmt.Sprint(i) {
+				t.Fatalf("expected delivery %d of room '%s', got %s", i, rId, id)
+			}
+		}
+	}
+
+	if s := p.Stats(); s.QueueDepth != 0 || s.InFlight != 0 {
+		t.Fatalf("expected empty pool, got: %+v", s)
+	}
+}
+
+func TestPoolCoalescesStates(t *testing.T) {
+	t.Parallel()
+
+	var (
+		started = make(chan struct{})
+		release = make(chan struct{})
+		batches [][]*queue.Delivery
+	)
+
+	p := New(1, func(ctx context.Context, ds []*queue.Delivery) {
+		if len(batches) == 0 {
+			close(started)
+			<-release
+		}
+
+		batches = append(batches, ds)
+	})
+	p.Run(context.Background())
+
+	submit := func(d *queue.Delivery) {
+		if err := p.Submit(context.Background(), d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	submit(newStateDelivery("a", 0))
+	<-started
+
+	// queued while the first delivery is handled
+	submit(newStateDelivery("a", 1))
+	submit(newStateDelivery("a", 2))
+	submit(newStateDelivery("a", 3))
+	submit(newOperationDelivery("a", 4))
+	submit(newStateDelivery("a", 5))
+	submit(newStateDelivery("b", 6))
+
+	if s := p.Stats(); s.QueueDepth != 6 {
+		t.Fatalf("expected queue depth 6, got: %d", s.QueueDepth)
+	}
+
+	close(release)
+
+	p.Close()
+	p.Wait()
+
+	var got []int
+	for _, b := range batches {
+		got = append(got, len(b))
+	}
+
+	// states 1 to 3 are coalesced, the operation and the other room's
+	// state are not
+	expected := []int{1, 3, 1, 1, 1}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("expected batch sizes %v, got %v", expected, got)
+	}
+
+	if s := p.Stats(); s.Coalesced != 2 || s.Handled != 7 {
+		t.Fatalf("expected 2 coalesced and 7 handled, got: %+v", s)
+	}
+}
@@ -0,0 +1,453 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/safe-waters/retro-simply/backend/pkg/broker"
+	"github.com/safe-waters/retro-simply/backend/pkg/client"
+	"github.com/safe-waters/retro-simply/backend/pkg/data"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+var tr = otel.Tracer("pkg/queue")
+
+const (
+	payloadKey = "message"
+	// Entries delivered this many times without being acknowledged are
+	// moved to the dead letter stream.
+	maxDeliveries = 5
+	// Entries pending for longer than minIdle belong to a consumer that
+	// crashed or is stuck, so they are reclaimed by another consumer.
+	minIdle   = 30 * time.Second
+	block     = 5 * time.Second
+	batchSize = 100
+	maxLen    = 1000000
+)
+
+type Streamer interface {
+	XAdd(ctx context.Context, a *redis.XAddArgs) client.StrResult
+	XGroupCreateMkStream(ctx context.Context, stream, group, start string) client.Err
+	XReadGroup(ctx context.Context, a *redis.XReadGroupArgs) client.XStreamSliceResult
+	XAck(ctx context.Context, stream, group string, ids ...string) client.IntResult
+	XPendingExt(ctx context.Context, a *redis.XPendingExtArgs) client.XPendingExtResult
+	XClaim(ctx context.Context, a *redis.XClaimArgs) client.XMessageSliceResult
+}
+
+// Q is a durable work queue on top of Redis streams. Unlike pub sub,
+// entries added while no consumer is running are kept until a consumer
+// acknowledges them, so every entry is handled at least once.
+type Q struct {
+	s        Streamer
+	group    string
+	consumer string
+	// inFlight are the ids of the entries delivered by this consumer that
+	// are still being handled, which are not reclaimed however long they
+	// wait to be handled.
+	inFlight map[string]struct{}
+	mu       sync.Mutex
+}
+
+func New(s Streamer, group, consumer string) *Q {
+	return &Q{
+		s:        s,
+		group:    group,
+		consumer: consumer,
+		inFlight: map[string]struct{}{},
+	}
+}
+
+func DeadLetterStream(stream string) string {
+	return fmt.Sprintf("%s-dead-letter", stream)
+}
+
+// Delivery is a queued message. It must be acknowledged once it has been
+// handled, otherwise it is delivered again.
+type Delivery struct {
+	*broker.Message
+	Id     string
+	stream string
+	q      *Q
+}
+
+func (d *Delivery) Ack(ctx context.Context) error {
+	ctx, span := tr.Start(ctx, "queue ack")
+	defer span.End()
+
+	defer d.Release()
+
+	if err := d.q.s.XAck(ctx, d.stream, d.q.group, d.Id).Err(); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// DeadLetter moves the delivery to the dead letter stream, so it is not
+// delivered again.
+func (d *Delivery) DeadLetter(ctx context.Context, reason error) error {
+	ctx, span := tr.Start(ctx, "queue dead letter")
+	defer span.End()
+
+	defer d.Release()
+
+	byt, err := json.Marshal(d.Message)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if err := d.q.deadLetter(ctx, d.stream, d.Id, string(byt), reason); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// Release tells the queue the delivery is not being handled anymore, so
+// it is reclaimed once it has been idle for long enough. Acknowledging or
+// dead lettering the delivery releases it.
+func (d *Delivery) Release() {
+	d.q.mu.Lock()
+	defer d.q.mu.Unlock()
+
+	delete(d.q.inFlight, d.Id)
+}
+
+func (q *Q) Publish(ctx context.Context, stream string, s *data.State) error {
+	ctx, span := tr.Start(ctx, "queue publish")
+	defer span.End()
+
+	if err := q.publish(ctx, stream, &broker.Message{State: s}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+func (q *Q) PublishOperation(
+	ctx context.Context,
+	stream string,
+	op *data.Operation,
+) error {
+	ctx, span := tr.Start(ctx, "queue publish operation")
+	defer span.End()
+
+	if err := q.publish(ctx, stream, &broker.Message{Operation: op}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+func (q *Q) publish(ctx context.Context, stream string, m *broker.Message) error {
+	m.Header = http.Header{}
+
+	var pr propagation.TraceContext
+	pr.Inject(ctx, propagation.HeaderCarrier(m.Header))
+
+	byt, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return q.s.XAdd(ctx, &redis.XAddArgs{
+		Stream:       stream,
+		MaxLenApprox: maxLen,
+		Values:       map[string]interface{}{payloadKey: string(byt)},
+	}).Err()
+}
+
+// Consume delivers the entries of the stream to the returned channel until
+// the context is done. Entries that another consumer of the group failed
+// to acknowledge in time are reclaimed and delivered again.
+func (q *Q) Consume(ctx context.Context, stream string) (<-chan *Delivery, error) {
+	ctx, span := tr.Start(ctx, "queue consume")
+	defer span.End()
+
+	err := q.s.XGroupCreateMkStream(ctx, stream, q.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	dCh := make(chan *Delivery)
+
+	go func() {
+		ctx, span := tr.Start(ctx, "queue consuming")
+		defer span.End()
+
+		defer close(dCh)
+
+		t := time.NewTicker(minIdle)
+		defer t.Stop()
+
+		if !q.deliverPending(ctx, stream, true, dCh) {
+			return
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if !q.deliverPending(ctx, stream, false, dCh) {
+					return
+				}
+			default:
+			}
+
+			xs, err := q.s.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    q.group,
+				Consumer: q.consumer,
+				Streams:  []string{stream, ">"},
+				Count:    batchSize,
+				Block:    block,
+			}).Result()
+			if err != nil {
+				if err != redis.Nil && ctx.Err() == nil {
+					span.RecordError(err)
+
+					select {
+					case <-time.After(time.Second):
+					case <-ctx.Done():
+						return
+					}
+				}
+
+				continue
+			}
+
+			for _, x := range xs {
+				if !q.deliver(ctx, stream, x.Messages, dCh) {
+					return
+				}
+			}
+		}
+	}()
+
+	return dCh, nil
+}
+
+// deliverPending delivers entries that were delivered before but never
+// acknowledged, a page of batchSize entries at a time. When the consumer
+// starts, its own pending entries are delivered again right away, since it
+// is not handling them anymore. Otherwise, only entries idle for longer
+// than minIdle that this consumer is not still handling are reclaimed.
+func (q *Q) deliverPending(
+	ctx context.Context,
+	stream string,
+	starting bool,
+	dCh chan<- *Delivery,
+) bool {
+	ctx, span := tr.Start(ctx, "queue deliver pending")
+	defer span.End()
+
+	start := "-"
+
+	for {
+		ps, err := q.s.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: stream,
+			Group:  q.group,
+			Start:  start,
+			End:    "+",
+			Count:  batchSize,
+		}).Result()
+		if err != nil {
+			span.RecordError(err)
+			return ctx.Err() == nil
+		}
+
+		if !q.reclaim(ctx, stream, starting, ps, dCh) {
+			return false
+		}
+
+		if len(ps) < batchSize {
+			return true
+		}
+
+		start, err = nextId(ps[len(ps)-1].ID)
+		if err != nil {
+			span.RecordError(err)
+			return true
+		}
+	}
+}
+
+// reclaim claims the pending entries that are to be delivered again, and
+// delivers them, or dead letters them if they were delivered too often.
+func (q *Q) reclaim(
+	ctx context.Context,
+	stream string,
+	starting bool,
+	ps []redis.XPendingExt,
+	dCh chan<- *Delivery,
+) bool {
+	ctx, span := tr.Start(ctx, "queue reclaim")
+	defer span.End()
+
+	var (
+		own       []string
+		abandoned []string
+		exhausted = map[string]int64{}
+	)
+
+	for _, p := range ps {
+		switch {
+		case starting && p.Consumer == q.consumer:
+			own = append(own, p.ID)
+		case p.Idle >= minIdle && !q.handling(p.ID):
+			abandoned = append(abandoned, p.ID)
+		default:
+			continue
+		}
+
+		if p.RetryCount >= maxDeliveries {
+			exhausted[p.ID] = p.RetryCount
+		}
+	}
+
+	for _, c := range []struct {
+		ids  []string
+		idle time.Duration
+	}{
+		{ids: own, idle: 0},
+		{ids: abandoned, idle: minIdle},
+	} {
+		if len(c.ids) == 0 {
+			continue
+		}
+
+		ms, err := q.s.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   stream,
+			Group:    q.group,
+			Consumer: q.consumer,
+			MinIdle:  c.idle,
+			Messages: c.ids,
+		}).Result()
+		if err != nil {
+			span.RecordError(err)
+			return ctx.Err() == nil
+		}
+
+		var toDeliver []redis.XMessage
+
+		for _, xm := range ms {
+			n, ok := exhausted[xm.ID]
+			if !ok {
+				toDeliver = append(toDeliver, xm)
+				continue
+			}
+
+			payload, _ := xm.Values[payloadKey].(string)
+			if err := q.deadLetter(
+				ctx,
+				stream,
+				xm.ID,
+				payload,
+				fmt.Errorf("delivered %d times without being acknowledged", n),
+			); err != nil {
+				span.RecordError(err)
+			}
+		}
+
+		if !q.deliver(ctx, stream, toDeliver, dCh) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (q *Q) handling(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, ok := q.inFlight[id]
+
+	return ok
+}
+
+// nextId returns the smallest stream id after the id, since ranges of
+// XPENDING include their start.
+func nextId(id string) (string, error) {
+	i := strings.IndexByte(id, '-')
+	if i < 0 {
+		return "", fmt.Errorf("malformed stream id '%s'", id)
+	}
+
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("malformed stream id '%s': %w", id, err)
+	}
+
+	return fmt.Sprintf("%s-%d", id[:i], seq+1), nil
+}
+
+func (q *Q) deliver(
+	ctx context.Context,
+	stream string,
+	ms []redis.XMessage,
+	dCh chan<- *Delivery,
+) bool {
+	for _, xm := range ms {
+		payload, _ := xm.Values[payloadKey].(string)
+
+		m := &broker.Message{}
+		if err := json.Unmarshal([]byte(payload), m); err != nil {
+			// A malformed entry would fail every time, so it is not retried.
+			_ = q.deadLetter(ctx, stream, xm.ID, payload, err)
+			continue
+		}
+
+		q.mu.Lock()
+		q.inFlight[xm.ID] = struct{}{}
+		q.mu.Unlock()
+
+		select {
+		case dCh <- &Delivery{Message: m, Id: xm.ID, stream: stream, q: q}:
+		case <-ctx.Done():
+			q.mu.Lock()
+			delete(q.inFlight, xm.ID)
+			q.mu.Unlock()
+
+			return false
+		}
+	}
+
+	return true
+}
+
+func (q *Q) deadLetter(
+	ctx context.Context,
+	stream string,
+	id string,
+	payload string,
+	reason error,
+) error {
+	if err := q.s.XAdd(ctx, &redis.XAddArgs{
+		Stream:       DeadLetterStream(stream),
+		MaxLenApprox: maxLen,
+		Values: map[string]interface{}{
+			"id":       id,
+			payloadKey: payload,
+			"reason":   reason.Error(),
+		},
+	}).Err(); err != nil {
+		return err
+	}
+
+	return q.s.XAck(ctx, stream, q.group, id).Err()
+}
@@ -0,0 +1,568 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/safe-waters/retro-simply/backend/pkg/client"
+	"github.com/safe-waters/retro-simply/backend/pkg/data"
+)
+
+type mockPending struct {
+	consumer   string
+	deliveries int64
+	delivered  time.Time
+}
+
+// mockStreamer keeps streams in memory with the consumer group semantics
+// of Redis that the queue relies on, for a single group.
+type mockStreamer struct {
+	mu      sync.Mutex
+	streams map[string][]redis.XMessage
+	read    map[string]int
+	pending map[string]*mockPending
+	nextId  int
+}
+
+func newMockStreamer() *mockStreamer {
+	return &mockStreamer{
+		streams: map[string][]redis.XMessage{},
+		read:    map[string]int{},
+		pending: map[string]*mockPending{},
+	}
+}
+
+func (m *mockStreamer) XAdd(ctx context.Context, a *redis.XAddArgs) client.StrResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextId++
+	id := fmt.Sprintf("%d-0", m.nextId)
+
+	m.streams[a.Stream] = append(m.streams[a.Stream], redis.XMessage{
+		ID:     id,
+		Values: a.Values.(map[string]interface{}),
+	})
+
+	return redis.NewStringResult(id, nil)
+}
+
+func (m *mockStreamer) XGroupCreateMkStream(
+	ctx context.Context,
+	stream, group, start string,
+) client.Err {
+	return redis.NewStatusResult("OK", nil)
+}
+
+func (m *mockStreamer) XReadGroup(
+	ctx context.Context,
+	a *redis.XReadGroupArgs,
+) client.XStreamSliceResult {
+	m.mu.Lock()
+
+	stream := a.Streams[0]
+	ms := m.streams[stream][m.read[stream]:]
+
+	if len(ms) == 0 {
+		m.mu.Unlock()
+
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+		}
+
+		return redis.NewXStreamSliceCmdResult(nil, redis.Nil)
+	}
+
+	defer m.mu.Unlock()
+
+	m.read[stream] += len(ms)
+
+	for _, xm := range ms {
+		m.pending[xm.ID] = &mockPending{
+			consumer:   a.Consumer,
+			deliveries: 1,
+			delivered:  time.Now(),
+		}
+	}
+
+	return redis.NewXStreamSliceCmdResult(
+		[]redis.XStream{{Stream: stream, Messages: ms}},
+		nil,
+	)
+}
+
+func (m *mockStreamer) XAck(
+	ctx context.Context,
+	stream, group string,
+	ids ...string,
+) client.IntResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		delete(m.pending, id)
+	}
+
+	return redis.NewIntResult(int64(len(ids)), nil)
+}
+
+type mockPendingResult struct{ ps []redis.XPendingExt }
+
+func (m *mockPendingResult) Result() ([]redis.XPendingExt, error) { return m.ps, nil }
+
+func (m *mockPendingResult) Err() error { return nil }
+
+func (m *mockStreamer) XPendingExt(
+	ctx context.Context,
+	a *redis.XPendingExtArgs,
+) client.XPendingExtResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ps []redis.XPendingExt
+
+	for _, xm := range m.streams[a.Stream] {
+		p, ok := m.pending[xm.ID]
+		if !ok || (a.Start != "-" && idBefore(xm.ID, a.Start)) {
+			continue
+		}
+
+		if int64(len(ps)) == a.Count {
+			break
+		}
+
+		ps = append(ps, redis.XPendingExt{
+			ID:         xm.ID,
+			Consumer:   p.consumer,
+			Idle:       time.Since(p.delivered),
+			RetryCount: p.deliveries,
+		})
+	}
+
+	return &mockPendingResult{ps: ps}
+}
+
+func (m *mockStreamer) XClaim(
+	ctx context.Context,
+	a *redis.XClaimArgs,
+) client.XMessageSliceResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ms []redis.XMessage
+
+	for _, xm := range m.streams[a.Stream] {
+		for _, id := range a.Messages {
+			p, ok := m.pending[id]
+			if id != xm.ID || !ok || time.Since(p.delivered) < a.MinIdle {
+				continue
+			}
+
+			p.consumer = a.Consumer
+			p.deliveries++
+			p.delivered = time.Now()
+
+			ms = append(ms, xm)
+		}
+	}
+
+	return redis.NewXMessageSliceCmdResult(ms, nil)
+}
+
+// idBefore returns whether the stream id a comes before the stream id b.
+func idBefore(a, b string) bool {
+	var an, as, bn, bs int
+
+	_, _ = fmt.Sscanf(a, "%d-%d", &an, &as)
+	_, _ = fmt.Sscanf(b, "%d-%d", &bn, &bs)
+
+	return an < bn || (an == bn && as < bs)
+}
+
+// idle pretends every pending entry was delivered long ago.
+func (m *mockStreamer) idle() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.pending {
+		p.delivered = p.delivered.Add(-2 * minIdle)
+	}
+}
+
+func (m *mockStreamer) numPending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.pending)
+}
+
+func (m *mockStreamer) stream(stream string) []redis.XMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]redis.XMessage(nil), m.streams[stream]...)
+}
+
+const stream = "room"
+
+func newOperation(cardId string) *data.Operation {
+	return &data.Operation{Type: data.OperationVote, RoomId: "test", CardId: cardId}
+}
+
+func receive(t *testing.T, dCh <-chan *Delivery) *Delivery {
+	t.Helper()
+
+	select {
+	case d, ok := <-dCh:
+		if !ok {
+			t.Fatal("expected delivery, channel closed")
+		}
+
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected delivery")
+	}
+
+	return nil
+}
+
+func TestQueue(t *testing.T) {
+	t.Parallel()
+
+	ms := newMockStreamer()
+	q := New(ms, "workers", "worker-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// entries published before any consumer runs are kept
+	for _, id := range []string{"a-pk-0", "b-pk-0"} {
+		if err := q.PublishOperation(ctx, stream, newOperation(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dCh, err := q.Consume(ctx, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+
+	for i := 0; i < 2; i++ {
+		d := receive(t, dCh)
+		got = append(got, d.Operation.CardId)
+
+		if err := d.Ack(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sort.Strings(got)
+	if fmt.Sprint(got) != fmt.Sprint([]string{"a-pk-0", "b-pk-0"}) {
+		t.Fatalf("expected both operations, got: %v", got)
+	}
+
+	if n := ms.numPending(); n != 0 {
+		t.Fatalf("expected no pending entries, got: %d", n)
+	}
+}
+
+func TestQueueRedeliversUnacknowledged(t *testing.T) {
+	t.Parallel()
+
+	ms := newMockStreamer()
+	q := New(ms, "workers", "worker-1")
+
+	if err := q.PublishOperation(
+		context.Background(),
+		stream,
+		newOperation("a-pk-0"),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first consumer crashes before acknowledging the entry
+	ctx, cancel := context.WithCancel(context.Background())
+
+	dCh, err := q.Consume(ctx, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receive(t, dCh)
+	cancel()
+
+	// the same consumer restarting gets its pending entry again
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	dCh, err = q.Consume(ctx, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := receive(t, dCh)
+	if d.Operation.CardId != "a-pk-0" {
+		t.Fatalf("expected 'a-pk-0' to be redelivered, got: %s", d.Operation.CardId)
+	}
+
+	if err := d.Ack(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueReclaimsAbandoned(t *testing.T) {
+	t.Parallel()
+
+	ms := newMockStreamer()
+
+	if err := New(ms, "workers", "worker-1").PublishOperation(
+		context.Background(),
+		stream,
+		newOperation("a-pk-0"),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	dCh, err := New(ms, "workers", "worker-1").Consume(ctx, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receive(t, dCh)
+	cancel()
+
+	ms.idle()
+
+	// another consumer reclaims the entry the first one abandoned
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	dCh, err = New(ms, "workers", "worker-2").Consume(ctx, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := receive(t, dCh)
+	if d.Operation.CardId != "a-pk-0" {
+		t.Fatalf("expected 'a-pk-0' to be reclaimed, got: %s", d.Operation.CardId)
+	}
+}
+
+func TestQueueDeadLetters(t *testing.T) {
+	t.Parallel()
+
+	ms := newMockStreamer()
+	q := New(ms, "workers", "worker-1")
+
+	// a malformed entry is never delivered
+	ms.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: stream,
+		Values: map[string]interface{}{payloadKey: "not json"},
+	})
+
+	if err := q.PublishOperation(
+		context.Background(),
+		stream,
+		newOperation("a-pk-0"),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// an entry that is never acknowledged is delivered maxDeliveries times
+	for i := 0; i < maxDeliveries; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		dCh, err := q.Consume(ctx, stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d := receive(t, dCh)
+		if d.Operation.CardId != "a-pk-0" {
+			t.Fatalf("expected 'a-pk-0', got: %s", d.Operation.CardId)
+		}
+
+		cancel()
+
+		// wait for the consumer to stop
+		for range dCh {
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	dCh, err := q.Consume(ctx, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case d := <-dCh:
+		t.Fatalf("expected no delivery, got: %v", d.Operation)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+
+	dl := ms.stream(DeadLetterStream(stream))
+	if len(dl) != 2 {
+		t.Fatalf("expected 2 dead letters, got: %d", len(dl))
+	}
+
+	if n := ms.numPending(); n != 0 {
+		t.Fatalf("expected no pending entries, got: %d", n)
+	}
+}
+
+func TestDeliveryDeadLetter(t *testing.T) {
+	t.Parallel()
+
+	ms := newMockStreamer()
+	q := New(ms, "workers", "worker-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := q.PublishOperation(ctx, stream, newOperation("a-pk-0")); err != nil {
+		t.Fatal(err)
+	}
+
+	dCh, err := q.Consume(ctx, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := receive(t, dCh)
+	if err := d.DeadLetter(ctx, errors.New("invalid")); err != nil {
+		t.Fatal(err)
+	}
+
+	dl := ms.stream(DeadLetterStream(stream))
+	if len(dl) != 1 || dl[0].Values["reason"] != "invalid" {
+		t.Fatalf("expected 1 dead letter with a reason, got: %v", dl)
+	}
+}
+
+func TestQueueReclaimsEveryPage(t *testing.T) {
+	t.Parallel()
+
+	ms := newMockStreamer()
+
+	n := 2*batchSize + 1
+
+	for i := 0; i < n; i++ {
+		if err := New(ms, "workers", "worker-1").PublishOperation(
+			context.Background(),
+			stream,
+			newOperation(fmt.Sprintf("%d-pk-0", i)),
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	dCh, err := New(ms, "workers", "worker-1").Consume(ctx, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < n; i++ {
+		receive(t, dCh)
+	}
+
+	cancel()
+
+	ms.idle()
+
+	// more entries than fit in a page of XPENDING are all reclaimed
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	dCh, err = New(ms, "workers", "worker-2").Consume(ctx, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]bool{}
+
+	for i := 0; i < n; i++ {
+		d := receive(t, dCh)
+		got[d.Operation.CardId] = true
+
+		if err := d.Ack(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(got) != n {
+		t.Fatalf("expected %d different operations, got: %d", n, len(got))
+	}
+
+	if n := ms.numPending(); n != 0 {
+		t.Fatalf("expected no pending entries, got: %d", n)
+	}
+}
+
+func TestQueueKeepsInFlight(t *testing.T) {
+	t.Parallel()
+
+	ms := newMockStreamer()
+	q := New(ms, "workers", "worker-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := q.PublishOperation(ctx, stream, newOperation("a-pk-0")); err != nil {
+		t.Fatal(err)
+	}
+
+	dCh, err := q.Consume(ctx, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := receive(t, dCh)
+
+	// the entry waits to be handled for longer than minIdle
+	ms.idle()
+
+	pCh := make(chan *Delivery, 1)
+
+	if !q.deliverPending(ctx, stream, false, pCh) {
+		t.Fatal("expected pending entries to be delivered")
+	}
+
+	select {
+	case d := <-pCh:
+		t.Fatalf("expected the entry in flight not to be reclaimed, got: %v", d.Operation)
+	default:
+	}
+
+	// once released without being acknowledged, it is reclaimed
+	d.Release()
+
+	if !q.deliverPending(ctx, stream, false, pCh) {
+		t.Fatal("expected pending entries to be delivered")
+	}
+
+	select {
+	case d := <-pCh:
+		if d.Operation.CardId != "a-pk-0" {
+			t.Fatalf("expected 'a-pk-0' to be reclaimed, got: %s", d.Operation.CardId)
+		}
+	default:
+		t.Fatal("expected the released entry to be reclaimed")
+	}
+}
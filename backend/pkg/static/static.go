@@ -0,0 +1,32 @@
+package static
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+)
+
+// New serves the built frontend the same way the frontend server does:
+// the retrospective page at "/retrospective", and every other file from
+// the root of fsys.
+func New(fsys fs.FS) (http.Handler, error) {
+	b, err := fs.ReadFile(fsys, "retrospective.html")
+	if err != nil {
+		return nil, err
+	}
+
+	page := string(b)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/retrospective", servePage(page))
+	mux.Handle("/", http.FileServer(http.FS(fsys)))
+
+	return mux, nil
+}
+
+func servePage(p string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, p)
+	}
+}
@@ -0,0 +1,286 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/safe-waters/retro-simply/backend/pkg/data"
+)
+
+// StoreTeam stores the team the room belongs to. Creating a room counts
+// as activity of its team.
+func (s *S) StoreTeam(ctx context.Context, rId, tId string) error {
+	ctx, span := tr.Start(ctx, "store team")
+	defer span.End()
+
+	didSet, err := s.b.SetNX(ctx, s.getKey(tmPrefix, rId), []byte(tId), 0)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if !didSet {
+		err := DataAlreadyExistsError{
+			fmt.Errorf("team for room '%s' already exists", rId),
+		}
+		span.RecordError(err)
+
+		return err
+	}
+
+	if err := s.touchTeam(ctx, tId); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// Team returns the id of the team the room belongs to. Rooms created
+// before teams existed are a team of their own, whose id is derived from
+// the room's.
+func (s *S) Team(ctx context.Context, rId string) (string, error) {
+	ctx, span := tr.Start(ctx, "get team")
+	defer span.End()
+
+	v, err := s.b.Get(ctx, s.getKey(tmPrefix, rId))
+	if err != nil {
+		switch err.(type) {
+		case DataDoesNotExistError:
+			return fmt.Sprintf("room-%s", rId), nil
+		default:
+			span.RecordError(err)
+			return "", err
+		}
+	}
+
+	return string(v), nil
+}
+
+// ActionItems returns the action items of the team, in the order they were
+// created.
+func (s *S) ActionItems(ctx context.Context, tId string) ([]*data.ActionItem, error) {
+	ctx, span := tr.Start(ctx, "get action items")
+	defer span.End()
+
+	v, err := s.b.Get(ctx, s.getKey(aPrefix, tId))
+	if err != nil {
+		switch err.(type) {
+		case DataDoesNotExistError:
+			return []*data.ActionItem{}, nil
+		default:
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	var as []*data.ActionItem
+	if err := json.Unmarshal(v, &as); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return as, nil
+}
+
+// StoreActionItem adds the action item to the team's. The card it comes
+// from must be on the board of its room.
+func (s *S) StoreActionItem(ctx context.Context, tId string, a *data.ActionItem) error {
+	ctx, span := tr.Start(ctx, "store action item")
+	defer span.End()
+
+	if a.CardId != "" {
+		st, err := s.State(ctx, a.RoomId)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+
+		if err := a.CheckCard(st); err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
+
+	if err := s.updateActionItems(ctx, tId, func(as []*data.ActionItem) ([]*data.ActionItem, error) {
+		for _, ea := range as {
+			if ea.Id == a.Id {
+				return nil, DataAlreadyExistsError{
+					fmt.Errorf("action item '%s' already exists", a.Id),
+				}
+			}
+		}
+
+		return data.AddActionItem(as, a)
+	}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// UpdateActionItem changes the team's action item with the id of a, and
+// returns it as it is stored. Only its assignee or a facilitator can
+// change it.
+func (s *S) UpdateActionItem(
+	ctx context.Context,
+	tId string,
+	a *data.ActionItem,
+	e data.Editor,
+) (*data.ActionItem, error) {
+	ctx, span := tr.Start(ctx, "update action item")
+	defer span.End()
+
+	var ua *data.ActionItem
+
+	if err := s.updateActionItems(ctx, tId, func(as []*data.ActionItem) ([]*data.ActionItem, error) {
+		for _, ea := range as {
+			if ea.Id == a.Id {
+				if err := ea.CheckEditor(e); err != nil {
+					return nil, err
+				}
+
+				ea.Update(a, time.Now().UTC())
+				ua = ea
+
+				return as, nil
+			}
+		}
+
+		return nil, DataDoesNotExistError{
+			fmt.Errorf("action item '%s' does not exist", a.Id),
+		}
+	}); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return ua, nil
+}
+
+// DeleteActionItem removes the team's action item with the id. Only its
+// assignee or a facilitator can remove it.
+func (s *S) DeleteActionItem(
+	ctx context.Context,
+	tId string,
+	aId string,
+	e data.Editor,
+) error {
+	ctx, span := tr.Start(ctx, "delete action item")
+	defer span.End()
+
+	if err := s.updateActionItems(ctx, tId, func(as []*data.ActionItem) ([]*data.ActionItem, error) {
+		for i, ea := range as {
+			if ea.Id == aId {
+				if err := ea.CheckEditor(e); err != nil {
+					return nil, err
+				}
+
+				return append(as[:i], as[i+1:]...), nil
+			}
+		}
+
+		return nil, DataDoesNotExistError{
+			fmt.Errorf("action item '%s' does not exist", aId),
+		}
+	}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// CarryActionItems adds the open action items of the team to the room's
+// board, so the team starts its retrospective from what is left to do.
+func (s *S) CarryActionItems(ctx context.Context, rId, tId string) error {
+	ctx, span := tr.Start(ctx, "carry action items")
+	defer span.End()
+
+	as, err := s.ActionItems(ctx, tId)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if len(as) == 0 {
+		return nil
+	}
+
+	if err := s.b.Update(ctx, s.getKey(sPrefix, rId), func(v []byte) ([]byte, error) {
+		if v == nil {
+			return nil, DataDoesNotExistError{
+				fmt.Errorf("state for room '%s' does not exist", rId),
+			}
+		}
+
+		var st data.State
+		if err := json.Unmarshal(v, &st); err != nil {
+			return nil, err
+		}
+
+		st.CarryActionItems(as, time.Now().UTC())
+
+		return json.Marshal(&st)
+	}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// updateActionItems changes the action items of the team, which are kept
+// in a single key so they change atomically. Changing them counts as
+// activity of the team.
+func (s *S) updateActionItems(
+	ctx context.Context,
+	tId string,
+	fn func(as []*data.ActionItem) ([]*data.ActionItem, error),
+) error {
+	if err := s.b.Update(ctx, s.getKey(aPrefix, tId), func(v []byte) ([]byte, error) {
+		as := []*data.ActionItem{}
+
+		if v != nil {
+			if err := json.Unmarshal(v, &as); err != nil {
+				return nil, err
+			}
+		}
+
+		as, err := fn(as)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(as)
+	}); err != nil {
+		return err
+	}
+
+	return s.touchTeam(ctx, tId)
+}
+
+// touchTeam records activity of the team, keeping its action items for
+// teamIdle from now, or for as long as rooms are kept if that is longer.
+// A team without action items is stored without any, since expiring a key
+// that does not exist does nothing.
+func (s *S) touchTeam(ctx context.Context, tId string) error {
+	ttl := teamIdle
+	for _, d := range []time.Duration{s.r.Idle, s.r.MaxAge} {
+		if d > ttl {
+			ttl = d
+		}
+	}
+
+	k := s.getKey(aPrefix, tId)
+
+	didSet, err := s.b.SetNX(ctx, k, []byte("[]"), ttl)
+	if err != nil || didSet {
+		return err
+	}
+
+	return s.b.Expire(ctx, k, ttl)
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	BackendRedis  = "redis"
+	BackendMemory = "memory"
+	BackendFile   = "file"
+)
+
+// Backend is the key value store that S keeps rooms in. Values are opaque
+// to the backend, and missing or expired keys are reported with a
+// DataDoesNotExistError.
+type Backend interface {
+	Get(ctx context.Context, k string) ([]byte, error)
+	// SetNX sets the value only if the key does not exist, reporting
+	// whether it did. A ttl of zero never expires.
+	SetNX(ctx context.Context, k string, v []byte, ttl time.Duration) (bool, error)
+	// Update atomically replaces the value of the key with the value
+	// returned by fn, keeping the key's expiry. fn gets a nil value when
+	// the key does not exist, and it may be called more than once. fn must
+	// not call the backend.
+	Update(ctx context.Context, k string, fn func(v []byte) ([]byte, error)) error
+	// Expire sets the expiry of the key to ttl from now, ignoring a key
+	// that does not exist. A ttl of zero never expires.
+	Expire(ctx context.Context, k string, ttl time.Duration) error
+	// Delete removes the keys, ignoring the ones that do not exist.
+	Delete(ctx context.Context, ks ...string) error
+	// Keys returns every key that starts with the prefix.
+	Keys(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Purger is implemented by backends that only drop an expired key when it
+// is read again. Purge removes every expired key, returning how many were
+// removed, so keys that are never read again do not build up. Redis
+// expires keys on its own.
+type Purger interface {
+	Purge(ctx context.Context) (int, error)
+}
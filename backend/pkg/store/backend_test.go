@@ -0,0 +1,1804 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/safe-waters/retro-simply/backend/pkg/data"
+)
+
+func newTestBackends(t *testing.T) map[string]Backend {
+	t.Helper()
+
+	f, err := NewFile(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = f.Close() })
+
+	return map[string]Backend{
+		BackendMemory: NewMemory(),
+		BackendFile:   f,
+	}
+}
+
+func TestBackend(t *testing.T) {
+	t.Parallel()
+
+	for name, b := range newTestBackends(t) {
+		b := b
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			if _, err := b.Get(ctx, "missing"); !errors.As(err, &DataDoesNotExistError{}) {
+				t.Fatalf("expected DataDoesNotExistError, got: %v", err)
+			}
+
+			for i, expected := range []bool{true, false} {
+				didSet, err := b.SetNX(ctx, "k", []byte(strconv.Itoa(i)), 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if didSet != expected {
+					t.Fatalf("expected SetNX %d to return %t", i, expected)
+				}
+			}
+
+			v, err := b.Get(ctx, "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(v) != "0" {
+				t.Fatalf("expected '0', got: '%s'", v)
+			}
+
+			if _, err := b.SetNX(ctx, "expiring", []byte("v"), time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+
+			time.Sleep(5 * time.Millisecond)
+
+			if _, err := b.Get(ctx, "expiring"); !errors.As(err, &DataDoesNotExistError{}) {
+				t.Fatalf("expected expired key to not exist, got: %v", err)
+			}
+
+			if didSet, err := b.SetNX(ctx, "expiring", []byte("v"), 0); err != nil || !didSet {
+				t.Fatalf("expected expired key to be set again, got: %t, %v", didSet, err)
+			}
+
+			// keys that never expired are given an expiry
+			if err := b.Expire(ctx, "expiring", time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+
+			time.Sleep(5 * time.Millisecond)
+
+			if _, err := b.Get(ctx, "expiring"); !errors.As(err, &DataDoesNotExistError{}) {
+				t.Fatalf("expected expired key to not exist, got: %v", err)
+			}
+
+			if err := b.Expire(ctx, "missing", time.Minute); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := b.SetNX(ctx, "kept", []byte("v"), 0); err != nil {
+				t.Fatal(err)
+			}
+
+			ks, err := b.Keys(ctx, "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fmt.Sprint(ks) != fmt.Sprint([]string{"k", "kept"}) {
+				t.Fatalf("expected keys [k kept], got: %v", ks)
+			}
+
+			if err := b.Delete(ctx, "k", "missing"); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := b.Get(ctx, "k"); !errors.As(err, &DataDoesNotExistError{}) {
+				t.Fatalf("expected deleted key to not exist, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestBackendUpdate(t *testing.T) {
+	t.Parallel()
+
+	const numUpdates = 50
+
+	for name, b := range newTestBackends(t) {
+		b := b
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			var wg sync.WaitGroup
+
+			for i := 0; i < numUpdates; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					if err := b.Update(ctx, "counter", func(v []byte) ([]byte, error) {
+						var n int
+
+						if v != nil {
+							var err error
+							if n, err = strconv.Atoi(string(v)); err != nil {
+								return nil, err
+							}
+						}
+
+						return []byte(strconv.Itoa(n + 1)), nil
+					}); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+
+			wg.Wait()
+
+			v, err := b.Get(ctx, "counter")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(v) != strconv.Itoa(numUpdates) {
+				t.Fatalf("expected %d, got: %s", numUpdates, v)
+			}
+
+			// a failing update leaves the value unchanged
+			expected := errors.New("failed")
+			if err := b.Update(ctx, "counter", func(v []byte) ([]byte, error) {
+				return nil, expected
+			}); err != expected {
+				t.Fatalf("expected '%v', got: %v", expected, err)
+			}
+
+			if v, _ := b.Get(ctx, "counter"); string(v) != strconv.Itoa(numUpdates) {
+				t.Fatalf("expected %d after failed update, got: %s", numUpdates, v)
+			}
+		})
+	}
+}
+
+func TestBackendPurge(t *testing.T) {
+	t.Parallel()
+
+	for name, b := range newTestBackends(t) {
+		b := b
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			if _, err := b.SetNX(ctx, "expiring", []byte("v"), time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := b.SetNX(ctx, "kept", []byte("v"), 0); err != nil {
+				t.Fatal(err)
+			}
+
+			time.Sleep(5 * time.Millisecond)
+
+			// the expired key is never read again, so only a purge removes it
+			n, err := b.(Purger).Purge(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != 1 {
+				t.Fatalf("expected 1 purged key, got: %d", n)
+			}
+
+			if n, _ := b.(Purger).Purge(ctx); n != 0 {
+				t.Fatalf("expected nothing left to purge, got: %d", n)
+			}
+
+			if _, err := b.Get(ctx, "kept"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestStoreWithBackend(t *testing.T) {
+	t.Parallel()
+
+	const (
+		rId    = "test"
+		cardId = "some-uuid-pk-0"
+	)
+
+	for name, b := range newTestBackends(t) {
+		b := b
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			s := New(b, Retention{})
+
+			if err := s.StoreHashedPassword(ctx, rId, "hash"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.StoreHashedPassword(ctx, rId, "hash"); !errors.As(err, &DataAlreadyExistsError{}) {
+				t.Fatalf("expected DataAlreadyExistsError, got: %v", err)
+			}
+
+			if h, err := s.HashedPassword(ctx, rId); err != nil || h != "hash" {
+				t.Fatalf("expected 'hash', got: '%s', %v", h, err)
+			}
+
+			tmpl, err := data.TemplateByName("start-stop-continue")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.StoreTemplate(ctx, rId, tmpl); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := s.ApplyOperation(ctx, &data.Operation{
+				Type:   data.OperationAddCard,
+				RoomId: rId,
+				Card: &data.RetroCard{
+					Id:           cardId,
+					ColumnId:     tmpl.Columns[0].Id,
+					Message:      "hello",
+					GroupId:      "default",
+					LastModified: 1,
+				},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			st, err := s.ApplyOperation(ctx, &data.Operation{
+				Type:   data.OperationVote,
+				RoomId: rId,
+				CardId: cardId,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if st.Revision != 2 {
+				t.Fatalf("expected revision 2, got: %d", st.Revision)
+			}
+
+			ms, _, err := s.StoreStates(ctx, st)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := s.State(ctx, rId)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Revision != 3 || len(got.Columns) != len(tmpl.Columns) {
+				t.Fatalf(
+					"expected revision 3 with %d columns, got: %d with %d",
+					len(tmpl.Columns),
+					got.Revision,
+					len(got.Columns),
+				)
+			}
+
+			if n := ms.Columns[0].Groups[0].RetroCards[0].NumVotes; n != 1 {
+				t.Fatalf("expected 1 vote, got: %d", n)
+			}
+		})
+	}
+}
+
+func TestStoreRetention(t *testing.T) {
+	t.Parallel()
+
+	const rId = "test"
+
+	ctx := context.Background()
+	b := NewMemory()
+	s := New(b, Retention{Idle: time.Hour})
+
+	createRoom := func(idle time.Duration) {
+		t.Helper()
+
+		if err := s.StoreHashedPassword(ctx, rId, "hash"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.StoreTemplate(ctx, rId, data.DefaultTemplate()); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.StoreExpiry(ctx, rId, idle); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	createRoom(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := s.HashedPassword(ctx, rId); !errors.As(err, &RoomExpiredError{}) {
+		t.Fatalf("expected RoomExpiredError joining, got: %v", err)
+	}
+
+	if _, err := s.ApplyOperation(ctx, &data.Operation{
+		Type:   data.OperationCreateGroup,
+		RoomId: rId,
+		Group:  &data.Group{Id: "g", ColumnId: "0", Title: "group"},
+	}); !errors.As(err, &RoomExpiredError{}) {
+		t.Fatalf("expected RoomExpiredError applying an operation, got: %v", err)
+	}
+
+	// the expired room can be created again before it is swept, with the
+	// retention policy's idle duration
+	createRoom(0)
+
+	e, err := s.Expiry(ctx, rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Idle != time.Hour {
+		t.Fatalf("expected idle of an hour, got: %v", e.Idle)
+	}
+
+	if n, err := s.Sweep(ctx); err != nil || n != 0 {
+		t.Fatalf("expected no rooms swept, got: %d, %v", n, err)
+	}
+
+	// the sweeper removes every key of the expired room
+	if err := b.Update(ctx, s.getKey(ePrefix, rId), func(v []byte) ([]byte, error) {
+		return json.Marshal(data.NewExpiry(time.Now().Add(-time.Hour), time.Minute, 0))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// keys outside of rooms are removed once they expire, even if they are
+	// never read again
+	if _, err := b.SetNX(ctx, "limit-address", []byte("1"), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if n, err := s.Sweep(ctx); err != nil || n != 1 {
+		t.Fatalf("expected 1 room swept, got: %d, %v", n, err)
+	}
+
+	for _, p := range roomPrefixes {
+		if _, err := b.Get(ctx, s.getKey(p, rId)); !errors.As(err, &DataDoesNotExistError{}) {
+			t.Fatalf("expected '%s' key to be removed, got: %v", p, err)
+		}
+	}
+
+	if _, ok := b.es["limit-address"]; ok {
+		t.Fatal("expected the expired key to be purged")
+	}
+}
+
+func TestStoreTokenGeneration(t *testing.T) {
+	t.Parallel()
+
+	const rId = "test"
+
+	ctx := context.Background()
+	s := New(NewMemory(), Retention{})
+
+	if _, err := s.TokenGeneration(ctx, rId); !errors.As(err, &DataDoesNotExistError{}) {
+		t.Fatalf("expected DataDoesNotExistError without a room, got: %v", err)
+	}
+
+	if _, err := s.ChangeHashedPassword(ctx, rId, "hash"); !errors.As(err, &DataDoesNotExistError{}) {
+		t.Fatalf("expected DataDoesNotExistError changing a missing room, got: %v", err)
+	}
+
+	if err := s.StoreHashedPassword(ctx, rId, "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	// rooms created before token generations existed have an empty one
+	if gen, err := s.TokenGeneration(ctx, rId); err != nil || gen != "" {
+		t.Fatalf("expected an empty generation, got: '%s', %v", gen, err)
+	}
+
+	rotated, err := s.RotateTokenGeneration(ctx, rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := s.ChangeHashedPassword(ctx, rId, "new-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changed == rotated {
+		t.Fatal("expected changing the password to rotate the generation")
+	}
+
+	if gen, err := s.TokenGeneration(ctx, rId); err != nil || gen != changed {
+		t.Fatalf("expected generation '%s', got: '%s', %v", changed, gen, err)
+	}
+
+	if h, err := s.HashedPassword(ctx, rId); err != nil || h != "new-hash" {
+		t.Fatalf("expected 'new-hash', got: '%s', %v", h, err)
+	}
+
+	if err := s.DeleteRoom(ctx, rId); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.TokenGeneration(ctx, rId); !errors.As(err, &DataDoesNotExistError{}) {
+		t.Fatalf("expected DataDoesNotExistError once deleted, got: %v", err)
+	}
+}
+
+func TestStoreLeftoverRoom(t *testing.T) {
+	t.Parallel()
+
+	const rId = "test"
+
+	ctx := context.Background()
+	s := New(NewMemory(), Retention{})
+
+	// a state left over from a deleted room with the same id
+	stale := data.DefaultTemplate().NewState(rId)
+	stale.Columns[0].Groups[0].RetroCards = []*data.RetroCard{
+		{
+			Id:           "stale-pk-0",
+			ColumnId:     "0",
+			Message:      "stale",
+			GroupId:      "default",
+			LastModified: 1,
+		},
+	}
+
+	byt, err := json.Marshal(stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.b.SetNX(ctx, s.getKey(sPrefix, rId), byt, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.StoreHashedPassword(ctx, rId, "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := data.TemplateByName("4ls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.StoreTemplate(ctx, rId, tmpl); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := s.State(ctx, rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(st.Columns) != len(tmpl.Columns) || len(st.Columns[0].Groups[0].RetroCards) != 0 {
+		t.Fatalf("expected the empty board of the template, got: %+v", st)
+	}
+
+	// a state that exists is not replaced
+	if err := s.StoreTemplate(ctx, "other", tmpl); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.b.Delete(ctx, s.getKey(tPrefix, "other")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.StoreTemplate(ctx, "other", tmpl); !errors.As(err, &DataAlreadyExistsError{}) {
+		t.Fatalf("expected DataAlreadyExistsError, got: %v", err)
+	}
+}
+
+func TestStoreDeletedRoom(t *testing.T) {
+	t.Parallel()
+
+	const rId = "test"
+
+	ctx := context.Background()
+
+	for name, b := range newTestBackends(t) {
+		b := b
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s := New(b, Retention{})
+
+			if err := s.StoreHashedPassword(ctx, rId, "hash"); err != nil {
+				t.Fatal(err)
+			}
+
+			tmpl := data.DefaultTemplate()
+			if err := s.StoreTemplate(ctx, rId, tmpl); err != nil {
+				t.Fatal(err)
+			}
+
+			st, err := s.State(ctx, rId)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.DeleteRoom(ctx, rId); err != nil {
+				t.Fatal(err)
+			}
+
+			// messages queued before the room was deleted are handled late
+			if _, err := s.ApplyOperation(ctx, &data.Operation{
+				Type:   data.OperationAddCard,
+				RoomId: rId,
+				Card: &data.RetroCard{
+					Id:           "card-pk-0",
+					ColumnId:     tmpl.Columns[0].Id,
+					Message:      "late",
+					GroupId:      "default",
+					LastModified: 1,
+				},
+			}); !errors.As(err, &DataDoesNotExistError{}) {
+				t.Fatalf("expected DataDoesNotExistError, got: %v", err)
+			}
+
+			if _, _, err := s.StoreStates(ctx, st); !errors.As(
+				err,
+				&DataDoesNotExistError{},
+			) {
+				t.Fatalf("expected DataDoesNotExistError, got: %v", err)
+			}
+
+			if _, err := s.State(ctx, rId); !errors.As(err, &DataDoesNotExistError{}) {
+				t.Fatalf("expected the state not to be recreated, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreSettings(t *testing.T) {
+	t.Parallel()
+
+	const rId = "test"
+
+	ctx := context.Background()
+	s := New(NewMemory(), Retention{})
+
+	// rooms created before settings existed have the zero settings
+	if st, err := s.Settings(ctx, rId); err != nil || *st != (data.Settings{}) {
+		t.Fatalf("expected zero settings, got: %v, %v", st, err)
+	}
+
+	if err := s.StoreSettings(ctx, rId, &data.Settings{Anonymous: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.StoreSettings(ctx, rId, &data.Settings{}); !errors.As(err, &DataAlreadyExistsError{}) {
+		t.Fatalf("expected DataAlreadyExistsError, got: %v", err)
+	}
+
+	if st, err := s.Settings(ctx, rId); err != nil || !st.Anonymous {
+		t.Fatalf("expected anonymous settings, got: %v, %v", st, err)
+	}
+}
+
+func TestStoreAuthors(t *testing.T) {
+	t.Parallel()
+
+	const (
+		rId     = "test"
+		cardId  = "first-pk-0"
+		otherId = "second-pk-0"
+	)
+
+	ctx := context.Background()
+	s := New(NewMemory(), Retention{})
+
+	tmpl := data.DefaultTemplate()
+	if err := s.StoreHashedPassword(ctx, rId, "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.StoreTemplate(ctx, rId, tmpl); err != nil {
+		t.Fatal(err)
+	}
+
+	newCard := func(id string) *data.RetroCard {
+		return &data.RetroCard{
+			Id:           id,
+			ColumnId:     tmpl.Columns[0].Id,
+			Message:      "hello",
+			GroupId:      "default",
+			LastModified: 1,
+			AuthorId:     "forged",
+		}
+	}
+
+	st, err := s.ApplyOperation(ctx, &data.Operation{
+		Type:     data.OperationAddCard,
+		RoomId:   rId,
+		Card:     newCard(cardId),
+		AuthorId: "first",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// states set the author of every card, but only new cards keep it
+	g := st.Columns[0].Groups[0]
+	g.RetroCards = append(g.RetroCards, newCard(otherId))
+	st.SetAuthor("second")
+
+	ms, _, err := s.StoreStates(ctx, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{cardId: "first", otherId: "second"}
+	for _, r := range ms.Columns[0].Groups[0].RetroCards {
+		if r.AuthorId != expected[r.Id] {
+			t.Fatalf(
+				"expected author '%s' of card '%s', got: '%s'",
+				expected[r.Id],
+				r.Id,
+				r.AuthorId,
+			)
+		}
+	}
+}
+
+func TestStoreVoting(t *testing.T) {
+	t.Parallel()
+
+	const (
+		rId    = "test"
+		cardId = "card-pk-0"
+	)
+
+	ctx := context.Background()
+	s := New(NewMemory(), Retention{})
+
+	tmpl := data.DefaultTemplate()
+	if err := s.StoreHashedPassword(ctx, rId, "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.StoreTemplate(ctx, rId, tmpl); err != nil {
+		t.Fatal(err)
+	}
+
+	v := data.Voting{VotesPerParticipant: 2, DenySelfVote: true}
+	if err := s.StoreSettings(ctx, rId, &data.Settings{Voting: v}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ApplyOperation(ctx, &data.Operation{
+		Type:   data.OperationAddCard,
+		RoomId: rId,
+		Card: &data.RetroCard{
+			Id:           cardId,
+			ColumnId:     tmpl.Columns[0].Id,
+			Message:      "hello",
+			GroupId:      "default",
+			LastModified: 1,
+		},
+		AuthorId: "author",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	vote := func(pId string) error {
+		_, err := s.ApplyOperation(ctx, &data.Operation{
+			Type:     data.OperationVote,
+			RoomId:   rId,
+			CardId:   cardId,
+			AuthorId: pId,
+		})
+
+		return err
+	}
+
+	if err := vote("author"); !errors.As(err, &data.VoteRejectedError{}) {
+		t.Fatalf("expected VoteRejectedError for a self vote, got: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := vote("voter"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := vote("voter"); !errors.As(err, &data.VoteRejectedError{}) {
+		t.Fatalf("expected VoteRejectedError once out of votes, got: %v", err)
+	}
+
+	st, err := s.State(ctx, rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// states can neither vote past the budget nor change the votes of
+	// their cards
+	c := st.Columns[0].Groups[0].RetroCards[0]
+	c.NumVotes = 10
+	st.Action = &data.Action{
+		Title:    "upVote",
+		OldCard:  c,
+		NewCard:  c,
+		AuthorId: "voter",
+	}
+
+	ms, rs, err := s.StoreStates(ctx, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rs) != 1 || rs[0].ParticipantId != "voter" || rs[0].CardId != cardId {
+		t.Fatalf("expected the vote of 'voter' to be rejected, got: %+v", rs)
+	}
+
+	if n := ms.Columns[0].Groups[0].RetroCards[0].NumVotes; n != 2 {
+		t.Fatalf("expected 2 votes, got: %d", n)
+	}
+
+	if b := ms.Ballot("voter", &v); b.Votes["card"] != 2 || *b.Remaining != 0 {
+		t.Fatalf("expected the ballot to hold 2 votes, got: %+v", b)
+	}
+}
+
+func TestStoreUnvote(t *testing.T) {
+	t.Parallel()
+
+	const (
+		rId    = "test"
+		cardId = "card-pk-0"
+	)
+
+	ctx := context.Background()
+	s := New(NewMemory(), Retention{})
+
+	tmpl := data.DefaultTemplate()
+	if err := s.StoreHashedPassword(ctx, rId, "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.StoreTemplate(ctx, rId, tmpl); err != nil {
+		t.Fatal(err)
+	}
+
+	apply := func(typ, pId string) {
+		t.Helper()
+
+		op := &data.Operation{Type: typ, RoomId: rId, CardId: cardId, AuthorId: pId}
+		if typ == data.OperationAddCard {
+			op.Card = &data.RetroCard{
+				Id:           cardId,
+				ColumnId:     tmpl.Columns[0].Id,
+				Message:      "hello",
+				GroupId:      "default",
+				LastModified: 1,
+			}
+		}
+
+		if _, err := s.ApplyOperation(ctx, op); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	apply(data.OperationAddCard, "author")
+	apply(data.OperationVote, "voter")
+	apply(data.OperationVote, "voter")
+
+	stale, err := s.State(ctx, rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apply(data.OperationUnvote, "voter")
+
+	// a state sent before the retraction does not bring the vote back
+	ms, _, err := s.StoreStates(ctx, stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := ms.Columns[0].Groups[0].RetroCards[0].NumVotes; n != 1 {
+		t.Fatalf("expected 1 vote, got: %d", n)
+	}
+
+	unVote := func(pId string) *data.State {
+		st, err := s.State(ctx, rId)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := st.Columns[0].Groups[0].RetroCards[0]
+		st.Action = &data.Action{
+			Title:    data.ActionUnVote,
+			OldCard:  c,
+			NewCard:  c,
+			AuthorId: pId,
+		}
+
+		return st
+	}
+
+	ms, rs, err := s.StoreStates(ctx, unVote("other"), unVote("voter"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rs) != 1 || rs[0].ParticipantId != "other" {
+		t.Fatalf("expected the retraction of 'other' to be rejected, got: %+v", rs)
+	}
+
+	if n := ms.Columns[0].Groups[0].RetroCards[0].NumVotes; n != 0 {
+		t.Fatalf("expected no votes, got: %d", n)
+	}
+
+	if len(ms.Ballots) != 0 {
+		t.Fatalf("expected no ballots, got: %v", ms.Ballots)
+	}
+}
+
+func TestStoreRedelivered(t *testing.T) {
+	t.Parallel()
+
+	const (
+		rId    = "test"
+		cardId = "card-pk-0"
+	)
+
+	ctx := context.Background()
+	s := New(NewMemory(), Retention{})
+
+	tmpl := data.DefaultTemplate()
+	if err := s.StoreHashedPassword(ctx, rId, "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.StoreTemplate(ctx, rId, tmpl); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ApplyOperation(ctx, &data.Operation{
+		Type:   data.OperationAddCard,
+		RoomId: rId,
+		Card: &data.RetroCard{
+			Id:           cardId,
+			ColumnId:     tmpl.Columns[0].Id,
+			Message:      "hello",
+			GroupId:      "default",
+			LastModified: 1,
+		},
+		AuthorId:   "author",
+		DeliveryId: "1-0",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	vote := func() error {
+		_, err := s.ApplyOperation(ctx, &data.Operation{
+			Type:       data.OperationVote,
+			RoomId:     rId,
+			CardId:     cardId,
+			AuthorId:   "voter",
+			DeliveryId: "2-0",
+		})
+
+		return err
+	}
+
+	if err := vote(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the vote is delivered again after it was stored
+	if err := vote(); !errors.As(err, &AlreadyStoredError{}) {
+		t.Fatalf("expected AlreadyStoredError, got: %v", err)
+	}
+
+	upVote := func(dId string) *data.State {
+		st, err := s.State(ctx, rId)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(st.Deliveries) != 0 {
+			t.Fatalf("expected no deliveries to be read, got: %v", st.Deliveries)
+		}
+
+		c := st.Columns[0].Groups[0].RetroCards[0]
+		st.Action = &data.Action{
+			Title:    data.ActionUpVote,
+			OldCard:  c,
+			NewCard:  c,
+			AuthorId: "voter",
+		}
+		st.DeliveryId = dId
+
+		return st
+	}
+
+	ms, _, err := s.StoreStates(ctx, upVote("3-0"), upVote("2-0"), upVote("3-0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ms.Deliveries) != 0 {
+		t.Fatalf("expected no deliveries to be returned, got: %v", ms.Deliveries)
+	}
+
+	if _, _, err := s.StoreStates(ctx, upVote("3-0")); !errors.As(
+		err,
+		&AlreadyStoredError{},
+	) {
+		t.Fatalf("expected AlreadyStoredError, got: %v", err)
+	}
+
+	st, err := s.State(ctx, rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := st.Columns[0].Groups[0].RetroCards[0].NumVotes; n != 2 {
+		t.Fatalf("expected 2 votes, got: %d", n)
+	}
+}
+
+func TestStoreFacilitator(t *testing.T) {
+	t.Parallel()
+
+	const (
+		rId    = "test"
+		cardId = "card-pk-0"
+	)
+
+	ctx := context.Background()
+	s := New(NewMemory(), Retention{})
+
+	tmpl := data.DefaultTemplate()
+	if err := s.StoreHashedPassword(ctx, rId, "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.StoreTemplate(ctx, rId, tmpl); err != nil {
+		t.Fatal(err)
+	}
+
+	if f, err := s.Facilitator(ctx, rId); err != nil || f != "" {
+		t.Fatalf("expected no facilitator, got: '%s', %v", f, err)
+	}
+
+	if err := s.StoreFacilitator(ctx, rId, "facilitator"); err != nil {
+		t.Fatal(err)
+	}
+
+	if f, err := s.Facilitator(ctx, rId); err != nil || f != "facilitator" {
+		t.Fatalf("expected facilitator 'facilitator', got: '%s', %v", f, err)
+	}
+
+	if _, err := s.ApplyOperation(ctx, &data.Operation{
+		Type:   data.OperationAddCard,
+		RoomId: rId,
+		Card: &data.RetroCard{
+			Id:           cardId,
+			ColumnId:     tmpl.Columns[0].Id,
+			Message:      "hello",
+			GroupId:      "default",
+			LastModified: 1,
+		},
+		AuthorId: "author",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := s.ApplyOperation(ctx, &data.Operation{
+		Type:         data.OperationDeleteCard,
+		RoomId:       rId,
+		CardId:       cardId,
+		LastModified: 2,
+		AuthorId:     "other",
+	})
+	if _, ok := err.(data.ForbiddenError); !ok {
+		t.Fatalf("expected a forbidden error, got: %v", err)
+	}
+
+	// deleting the cards of others in a state is undone too
+	st, err := s.State(ctx, rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st.Columns[0].Groups[0].RetroCards[0].IsDeleted = true
+	st.AuthorId = "other"
+	st.FacilitatorId = "other"
+
+	ms, _, err := s.StoreStates(ctx, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ms.Columns[0].Groups[0].RetroCards[0].IsDeleted {
+		t.Fatal("expected the card of 'author' to be kept")
+	}
+
+	if ms.FacilitatorId != "facilitator" || ms.AuthorId != "" {
+		t.Fatalf("expected the facilitator to be kept, got: %+v", ms)
+	}
+
+	if _, err := s.ApplyOperation(ctx, &data.Operation{
+		Type:     data.OperationLockBoard,
+		RoomId:   rId,
+		AuthorId: "facilitator",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// only votes are counted from states sent while the board is locked
+	st, err = s.State(ctx, rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := st.Columns[0].Groups[0].RetroCards[0]
+	c.Message = "changed"
+	st.AuthorId = "other"
+	st.Action = &data.Action{
+		Title:    data.ActionUpVote,
+		OldCard:  c,
+		NewCard:  c,
+		AuthorId: "other",
+	}
+
+	ms, _, err = s.StoreStates(ctx, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := ms.Columns[0].Groups[0].RetroCards[0]; c.Message != "hello" || c.NumVotes != 1 {
+		t.Fatalf("expected the vote without the change, got: %+v", c)
+	}
+
+	transfer := func(pId string) error {
+		_, err := s.ApplyOperation(ctx, &data.Operation{
+			Type:          data.OperationTransferFacilitator,
+			RoomId:        rId,
+			ParticipantId: pId,
+			AuthorId:      "facilitator",
+		})
+
+		return err
+	}
+
+	// the room is only handed to a participant in it
+	if err := transfer("made-up"); !errors.As(err, &data.ForbiddenError{}) {
+		t.Fatalf("expected a forbidden error, got: %v", err)
+	}
+
+	if _, err := s.Heartbeat(
+		ctx,
+		rId,
+		"connection",
+		&data.Participant{Id: "other", DisplayName: "Other"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := transfer("other"); err != nil {
+		t.Fatal(err)
+	}
+
+	if f, err := s.Facilitator(ctx, rId); err != nil || f != "other" {
+		t.Fatalf("expected facilitator 'other', got: '%s', %v", f, err)
+	}
+}
+
+func TestStorePhases(t *testing.T) {
+	t.Parallel()
+
+	const (
+		rId    = "test"
+		cardId = "card-pk-0"
+	)
+
+	ctx := context.Background()
+	s := New(NewMemory(), Retention{})
+
+	tmpl := data.DefaultTemplate()
+	if err := s.StoreHashedPassword(ctx, rId, "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.StoreTemplate(ctx, rId, tmpl); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.StoreFacilitator(ctx, rId, "facilitator"); err != nil {
+		t.Fatal(err)
+	}
+
+	apply := func(op *data.Operation) error {
+		t.Helper()
+
+		op.RoomId = rId
+		_, err := s.ApplyOperation(ctx, op)
+
+		return err
+	}
+
+	changePhase := func(p string) {
+		t.Helper()
+
+		if err := apply(&data.Operation{
+			Type:     data.OperationChangePhase,
+			Phase:    p,
+			AuthorId: "facilitator",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changePhase(data.PhaseBrainstorm)
+
+	if err := apply(&data.Operation{
+		Type: data.OperationAddCard,
+		Card: &data.RetroCard{
+			Id:           cardId,
+			ColumnId:     tmpl.Columns[0].Id,
+			Message:      "hello",
+			GroupId:      "default",
+			LastModified: 1,
+		},
+		AuthorId: "author",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	vote := func() *data.State {
+		st, err := s.State(ctx, rId)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := st.Columns[0].Groups[0].RetroCards[0]
+		st.AuthorId = "voter"
+		st.Action = &data.Action{
+			Title:    data.ActionUpVote,
+			OldCard:  c,
+			NewCard:  c,
+			AuthorId: "voter",
+		}
+
+		return st
+	}
+
+	// votes are not counted during the brainstorm
+	_, rs, err := s.StoreStates(ctx, vote())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rs) != 1 {
+		t.Fatalf("expected the vote to be rejected, got: %+v", rs)
+	}
+
+	changePhase(data.PhaseGroup)
+
+	// cards are edited but not added while grouping
+	st, err := s.State(ctx, rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st.AuthorId = "author"
+	st.Columns[0].Groups[0].RetroCards[0].Message = "edited"
+	st.Columns[0].Groups[0].RetroCards = append(
+		st.Columns[0].Groups[0].RetroCards,
+		&data.RetroCard{
+			Id:           "new-pk-0",
+			ColumnId:     tmpl.Columns[0].Id,
+			Message:      "late",
+			GroupId:      "default",
+			LastModified: 2,
+		},
+	)
+
+	ms, _, err := s.StoreStates(ctx, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rs := ms.Columns[0].Groups[0].RetroCards; len(rs) != 1 || rs[0].Message != "edited" {
+		t.Fatalf("expected only the edit, got: %+v", rs)
+	}
+
+	changePhase(data.PhaseVote)
+
+	// cards are not added while voting, but votes are counted
+	st = vote()
+	st.Columns[1].Groups[0].RetroCards = []*data.RetroCard{
+		{
+			Id:           "new-pk-0",
+			ColumnId:     tmpl.Columns[1].Id,
+			Message:      "late",
+			GroupId:      "default",
+			LastModified: 2,
+		},
+	}
+
+	ms, rs, err = s.StoreStates(ctx, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rs) != 0 || ms.Columns[0].Groups[0].RetroCards[0].NumVotes != 1 {
+		t.Fatalf("expected the vote to be counted, got: %+v", rs)
+	}
+
+	if len(ms.Columns[1].Groups[0].RetroCards) != 0 {
+		t.Fatal("expected the new card to be dropped")
+	}
+
+	if ms.Phase != data.PhaseVote || len(ms.PhaseHistory) != 3 {
+		t.Fatalf("expected the vote phase after 3 changes, got: '%s'", ms.Phase)
+	}
+
+	changePhase(data.PhaseDiscuss)
+	changePhase(data.PhaseActions)
+
+	// groups are not created while writing actions, and neither are the
+	// other changes of the state
+	st, err = s.State(ctx, rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st.AuthorId = "author"
+	st.Columns[0].Groups[0].RetroCards[0].Message = "moved"
+	st.Columns[0].Groups = append(st.Columns[0].Groups, &data.Group{
+		Id:         "new",
+		ColumnId:   tmpl.Columns[0].Id,
+		Title:      "new",
+		RetroCards: []*data.RetroCard{},
+	})
+
+	ms, _, err = s.StoreStates(ctx, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ms.Columns[0].Groups) != 1 || ms.Columns[0].Groups[0].RetroCards[0].Message != "edited" {
+		t.Fatalf("expected the state to be dropped, got: %+v", ms.Columns[0].Groups)
+	}
+}
+
+func TestStorePrivateBrainstorm(t *testing.T) {
+	t.Parallel()
+
+	const rId = "test"
+
+	ctx := context.Background()
+	s := New(NewMemory(), Retention{})
+
+	tmpl := data.DefaultTemplate()
+	if err := s.StoreHashedPassword(ctx, rId, "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.StoreTemplate(ctx, rId, tmpl); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, op := range []*data.Operation{
+		{
+			Type:     data.OperationChangePhase,
+			Phase:    data.PhaseBrainstorm,
+			Private:  true,
+			AuthorId: "facilitator",
+		},
+		{
+			Type: data.OperationAddCard,
+			Card: &data.RetroCard{
+				Id:           "card-pk-0",
+				ColumnId:     tmpl.Columns[0].Id,
+				Message:      "secret",
+				GroupId:      "default",
+				LastModified: 1,
+			},
+			AuthorId: "author",
+		},
+	} {
+		op.RoomId = rId
+		if _, err := s.ApplyOperation(ctx, op); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 'other' sends back the card without the message it was never sent
+	st, err := s.State(ctx, rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &data.Message{Type: data.MessageState, State: st}
+	m.HideCards("other")
+
+	st.AuthorId = "other"
+	st.Columns[1].Groups[0].RetroCards = []*data.RetroCard{
+		{
+			Id:           "new-pk-0",
+			ColumnId:     tmpl.Columns[1].Id,
+			Message:      "mine",
+			GroupId:      "default",
+			LastModified: 2,
+			AuthorId:     "other",
+		},
+	}
+
+	ms, _, err := s.StoreStates(ctx, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := ms.Columns[0].Groups[0].RetroCards[0]; c.Message != "secret" || !c.Private {
+		t.Fatalf("expected the private card to be kept, got: %+v", c)
+	}
+
+	if c := ms.Columns[1].Groups[0].RetroCards[0]; !c.Private {
+		t.Fatal("expected the new card to be private")
+	}
+}
+
+func TestStoreEmptyCards(t *testing.T) {
+	t.Parallel()
+
+	const rId = "test"
+
+	ctx := context.Background()
+	s := New(NewMemory(), Retention{})
+
+	if err := s.StoreHashedPassword(ctx, rId, "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.StoreTemplate(ctx, rId, data.DefaultTemplate()); err != nil {
+		t.Fatal(err)
+	}
+
+	prev, err := s.State(ctx, rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byt, err := json.Marshal(prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a card claiming to be private gets past decoding without a message,
+	// but the brainstorm is not private
+	payload := strings.Replace(string(byt), `"retroCards":[]`, `"retroCards":[{
+		"id": "empty-pk-0",
+		"columnId": "0",
+		"groupId": "default",
+		"lastModified": 1,
+		"private": true
+	}]`, 1)
+
+	var st data.State
+	if err := json.Unmarshal([]byte(payload), &st); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(st.Columns[0].Groups[0].RetroCards) != 1 {
+		t.Fatalf("expected the payload to hold the card, got: %s", payload)
+	}
+
+	st.AuthorId = "author"
+
+	if _, _, err := s.StoreStates(ctx, &st); !errors.As(
+		err,
+		&data.OperationInvalidError{},
+	) {
+		t.Fatalf("expected OperationInvalidError, got: %v", err)
+	}
+
+	ms, err := s.State(ctx, rId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(ms.Columns[0].Groups[0].RetroCards); n != 0 {
+		t.Fatalf("expected no cards to be stored, got: %d", n)
+	}
+}
+
+func TestStoreTimer(t *testing.T) {
+	t.Parallel()
+
+	const rId = "test"
+
+	ctx := context.Background()
+
+	for name, b := range newTestBackends(t) {
+		b := b
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s := New(b, Retention{})
+
+			if err := s.StoreHashedPassword(ctx, rId, "hash"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.StoreTemplate(ctx, rId, data.DefaultTemplate()); err != nil {
+				t.Fatal(err)
+			}
+
+			op := &data.Operation{
+				Type:     data.OperationStartTimer,
+				RoomId:   rId,
+				Duration: time.Minute,
+			}
+			if _, err := s.ApplyOperation(ctx, op); err != nil {
+				t.Fatal(err)
+			}
+
+			// clients cannot change the timer with a state
+			st, err := s.State(ctx, rId)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			st.Timer = nil
+
+			if _, _, err := s.StoreStates(ctx, st); err != nil {
+				t.Fatal(err)
+			}
+
+			// the timer is read back by clients that connect later
+			st, err = s.State(ctx, rId)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if st.Timer == nil || !st.Timer.EndsAt.Equal(*op.Timer.EndsAt) {
+				t.Fatalf("expected the timer to end at %s, got: %+v", op.Timer.EndsAt, st.Timer)
+			}
+		})
+	}
+}
+
+func TestStorePresence(t *testing.T) {
+	t.Parallel()
+
+	const rId = "test"
+
+	var (
+		ada   = &data.Participant{Id: "a", DisplayName: "Ada"}
+		grace = &data.Participant{Id: "g", DisplayName: "Grace"}
+	)
+
+	ids := func(ps []*data.Participant) string {
+		var s []string
+		for _, p := range ps {
+			s = append(s, p.Id)
+		}
+
+		return fmt.Sprint(s)
+	}
+
+	for name, b := range newTestBackends(t) {
+		b := b
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			eb := &expireBackend{Backend: b, ttls: map[string]time.Duration{}}
+			s := New(eb, Retention{})
+
+			expectChange := func(pc *PresenceChange, err error, joined, left string) {
+				t.Helper()
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if ids(pc.Joined) != joined || ids(pc.Left) != left {
+					t.Fatalf(
+						"expected joined %s and left %s, got: %s and %s",
+						joined,
+						left,
+						ids(pc.Joined),
+						ids(pc.Left),
+					)
+				}
+			}
+
+			pc, err := s.Heartbeat(ctx, rId, "1", ada)
+			expectChange(pc, err, "[a]", "[]")
+
+			// a participant connected twice only joins once
+			pc, err = s.Heartbeat(ctx, rId, "2", ada)
+			expectChange(pc, err, "[]", "[]")
+
+			pc, err = s.Heartbeat(ctx, rId, "3", grace)
+			expectChange(pc, err, "[g]", "[]")
+
+			ps, err := s.Participants(ctx, rId)
+			if err != nil || ids(ps) != "[a g]" {
+				t.Fatalf("expected participants [a g], got: %s, %v", ids(ps), err)
+			}
+
+			pc, err = s.Leave(ctx, rId, "1")
+			expectChange(pc, err, "[]", "[]")
+
+			pc, err = s.Leave(ctx, rId, "2")
+			expectChange(pc, err, "[]", "[a]")
+
+			// connections that stop sending heartbeats leave once expired
+			if err := b.Update(ctx, s.getKey(prPrefix, rId), func(v []byte) ([]byte, error) {
+				return json.Marshal(map[string]*connection{
+					"3": {Participant: grace, ExpiresAt: time.Now().Add(-time.Second)},
+				})
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if ps, err := s.Participants(ctx, rId); err != nil || len(ps) != 0 {
+				t.Fatalf("expected no participants, got: %s, %v", ids(ps), err)
+			}
+
+			pc, err = s.Heartbeat(ctx, rId, "1", ada)
+			expectChange(pc, err, "[a]", "[g]")
+
+			// rooms nobody is connected to do not keep a key for longer
+			// than their last connection
+			pc, err = s.Leave(ctx, rId, "1")
+			expectChange(pc, err, "[]", "[a]")
+
+			if ttl := eb.ttl(s.getKey(prPrefix, rId)); ttl != PresenceTTL {
+				t.Fatalf("expected presence to expire after %v, got: %v", PresenceTTL, ttl)
+			}
+		})
+	}
+}
+
+func TestStoreActionItems(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	for name, b := range newTestBackends(t) {
+		b := b
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			eb := &expireBackend{Backend: b, ttls: map[string]time.Duration{}}
+			s := New(eb, Retention{})
+
+			// rooms created before teams existed are a team of their own,
+			// which is not stored
+			tId, err := s.Team(ctx, "first")
+			if err != nil || tId == "" {
+				t.Fatalf("expected a team, got: '%s', %v", tId, err)
+			}
+
+			if again, err := s.Team(ctx, "first"); err != nil || again != tId {
+				t.Fatalf("expected team '%s', got: '%s', %v", tId, again, err)
+			}
+
+			if _, err := b.Get(ctx, s.getKey(tmPrefix, "first")); !errors.As(err, &DataDoesNotExistError{}) {
+				t.Fatalf("expected no team to be stored, got: %v", err)
+			}
+
+			if err := s.StoreTeam(ctx, "second", tId); err != nil {
+				t.Fatal(err)
+			}
+
+			// a team without action items expires too
+			if ttl := eb.ttl(s.getKey(aPrefix, tId)); ttl != teamIdle {
+				t.Fatalf("expected the team to expire after %v, got: %v", teamIdle, ttl)
+			}
+
+			if _, ok := s.StoreTeam(ctx, "second", tId).(DataAlreadyExistsError); !ok {
+				t.Fatal("expected DataAlreadyExistsError")
+			}
+
+			if as, err := s.ActionItems(ctx, tId); err != nil || len(as) != 0 {
+				t.Fatalf("expected no action items, got: %v, %v", as, err)
+			}
+
+			open := &data.ActionItem{
+				Id:     "open",
+				Title:  "open",
+				Status: data.ActionItemOpen,
+				RoomId: "first",
+			}
+			done := &data.ActionItem{
+				Id:     "done",
+				Title:  "done",
+				Status: data.ActionItemDone,
+				RoomId: "first",
+			}
+
+			for _, a := range []*data.ActionItem{open, done} {
+				if err := s.StoreActionItem(ctx, tId, a); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, ok := s.StoreActionItem(ctx, tId, open).(DataAlreadyExistsError); !ok {
+				t.Fatal("expected DataAlreadyExistsError")
+			}
+
+			// the action items of a team are kept for a while after its
+			// last activity
+			if ttl := eb.ttl(s.getKey(aPrefix, tId)); ttl != teamIdle {
+				t.Fatalf("expected the action items to expire after %v, got: %v", teamIdle, ttl)
+			}
+
+			if err := s.StoreTemplate(ctx, "first", data.DefaultTemplate()); err != nil {
+				t.Fatal(err)
+			}
+
+			err = s.StoreActionItem(ctx, tId, &data.ActionItem{
+				Id:     "from a card",
+				Title:  "from a card",
+				Status: data.ActionItemOpen,
+				CardId: "missing-pk-0",
+				RoomId: "first",
+			})
+			if !errors.As(err, &data.ActionItemInvalidError{}) {
+				t.Fatalf("expected ActionItemInvalidError, got: %v", err)
+			}
+
+			facilitator := data.Editor{Facilitator: true}
+			ada := data.Editor{Name: "Ada"}
+
+			ua, err := s.UpdateActionItem(ctx, tId, &data.ActionItem{
+				Id:       "open",
+				Title:    "still open",
+				Assignee: "Ada",
+				Status:   data.ActionItemInProgress,
+				RoomId:   "forged",
+			}, facilitator)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ua.Title != "still open" || ua.Assignee != "Ada" || ua.RoomId != "first" {
+				t.Fatalf("expected the action item to be updated, got: %+v", ua)
+			}
+
+			_, err = s.UpdateActionItem(ctx, tId, &data.ActionItem{
+				Id:    "open",
+				Title: "taken",
+			}, data.Editor{Name: "Grace"})
+			if !errors.As(err, &data.ForbiddenError{}) {
+				t.Fatalf("expected ForbiddenError, got: %v", err)
+			}
+
+			if _, err := s.UpdateActionItem(ctx, tId, ua, ada); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = s.UpdateActionItem(ctx, tId, &data.ActionItem{Id: "missing"}, facilitator)
+			if _, ok := err.(DataDoesNotExistError); !ok {
+				t.Fatalf("expected DataDoesNotExistError, got: %v", err)
+			}
+
+			if !errors.As(s.DeleteActionItem(ctx, tId, "done", ada), &data.ForbiddenError{}) {
+				t.Fatal("expected ForbiddenError")
+			}
+
+			if _, ok := s.DeleteActionItem(ctx, tId, "missing", facilitator).(DataDoesNotExistError); !ok {
+				t.Fatal("expected DataDoesNotExistError")
+			}
+
+			if err := s.StoreTemplate(ctx, "second", data.DefaultTemplate()); err != nil {
+				t.Fatal(err)
+			}
+
+			// carrying twice does not add the action items twice
+			for i := 0; i < 2; i++ {
+				if err := s.CarryActionItems(ctx, "second", tId); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			st, err := s.State(ctx, "second")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rs := st.Columns[len(st.Columns)-1].Groups[0].RetroCards
+			if len(rs) != 1 || rs[0].Id != "open-pk-0" || rs[0].Message != "still open" {
+				t.Fatalf("expected the open action item to be carried, got: %+v", rs)
+			}
+
+			// action items outlive the rooms of the team
+			if err := s.DeleteRoom(ctx, "first"); err != nil {
+				t.Fatal(err)
+			}
+
+			if as, err := s.ActionItems(ctx, tId); err != nil || len(as) != 2 {
+				t.Fatalf("expected 2 action items, got: %v, %v", as, err)
+			}
+
+			if err := s.DeleteActionItem(ctx, tId, "done", facilitator); err != nil {
+				t.Fatal(err)
+			}
+
+			as, err := s.ActionItems(ctx, tId)
+			if err != nil || len(as) != 1 || as[0].Id != "open" {
+				t.Fatalf("expected action item 'open', got: %v, %v", as, err)
+			}
+		})
+	}
+}
+
+// expireBackend records the last expiry set on each key, when it is stored
+// or expired.
+type expireBackend struct {
+	Backend
+
+	mu   sync.Mutex
+	ttls map[string]time.Duration
+}
+
+func (e *expireBackend) SetNX(
+	ctx context.Context,
+	k string,
+	v []byte,
+	ttl time.Duration,
+) (bool, error) {
+	didSet, err := e.Backend.SetNX(ctx, k, v, ttl)
+	if didSet {
+		e.mu.Lock()
+		e.ttls[k] = ttl
+		e.mu.Unlock()
+	}
+
+	return didSet, err
+}
+
+func (e *expireBackend) Expire(ctx context.Context, k string, ttl time.Duration) error {
+	e.mu.Lock()
+	e.ttls[k] = ttl
+	e.mu.Unlock()
+
+	return e.Backend.Expire(ctx, k, ttl)
+}
+
+func (e *expireBackend) ttl(k string) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.ttls[k]
+}
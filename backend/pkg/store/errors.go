@@ -3,8 +3,18 @@ package store
 type (
 	DataAlreadyExistsError struct{ Err error }
 	DataDoesNotExistError  struct{ Err error }
+	RoomExpiredError       struct{ Err error }
+	AlreadyStoredError     struct{ Err error }
 )
 
 func (d DataAlreadyExistsError) Error() string { return d.Err.Error() }
 
 func (d DataDoesNotExistError) Error() string { return d.Err.Error() }
+
+// NotExist reports that the data does not exist to packages that do not
+// depend on the store.
+func (d DataDoesNotExistError) NotExist() bool { return true }
+
+func (r RoomExpiredError) Error() string { return r.Err.Error() }
+
+func (a AlreadyStoredError) Error() string { return a.Err.Error() }
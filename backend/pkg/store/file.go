@@ -0,0 +1,279 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var fileBucket = []byte("retro-simply")
+
+// File is a backend that keeps keys in an embedded database file, so rooms
+// survive restarts without running Redis. The file is locked by the
+// process that opens it, so it cannot be shared between processes.
+type File struct {
+	db *bolt.DB
+}
+
+func NewFile(path string) (*File, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(fileBucket)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &File{db: db}, nil
+}
+
+func (f *File) Close() error { return f.db.Close() }
+
+func (f *File) Get(ctx context.Context, k string) ([]byte, error) {
+	_, span := tr.Start(ctx, "file get")
+	defer span.End()
+
+	var v []byte
+
+	if err := f.db.View(func(tx *bolt.Tx) error {
+		var ok bool
+
+		v, _, ok = getFileEntry(tx, k)
+		if !ok {
+			return DataDoesNotExistError{fmt.Errorf("key '%s' does not exist", k)}
+		}
+
+		return nil
+	}); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func (f *File) SetNX(
+	ctx context.Context,
+	k string,
+	v []byte,
+	ttl time.Duration,
+) (bool, error) {
+	_, span := tr.Start(ctx, "file setnx")
+	defer span.End()
+
+	var didSet bool
+
+	if err := f.db.Update(func(tx *bolt.Tx) error {
+		if _, _, ok := getFileEntry(tx, k); ok {
+			return nil
+		}
+
+		var exp time.Time
+		if ttl > 0 {
+			exp = time.Now().Add(ttl)
+		}
+
+		didSet = true
+
+		return putFileEntry(tx, k, v, exp)
+	}); err != nil {
+		span.RecordError(err)
+		return false, err
+	}
+
+	return didSet, nil
+}
+
+// Update runs fn in a write transaction, which bolt serializes, so fn is
+// called exactly once.
+func (f *File) Update(
+	ctx context.Context,
+	k string,
+	fn func(v []byte) ([]byte, error),
+) error {
+	_, span := tr.Start(ctx, "file update")
+	defer span.End()
+
+	if err := f.db.Update(func(tx *bolt.Tx) error {
+		v, exp, _ := getFileEntry(tx, k)
+
+		nv, err := fn(v)
+		if err != nil {
+			return err
+		}
+
+		return putFileEntry(tx, k, nv, exp)
+	}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+func (f *File) Expire(ctx context.Context, k string, ttl time.Duration) error {
+	_, span := tr.Start(ctx, "file expire")
+	defer span.End()
+
+	if err := f.db.Update(func(tx *bolt.Tx) error {
+		v, _, ok := getFileEntry(tx, k)
+		if !ok {
+			return nil
+		}
+
+		var exp time.Time
+		if ttl > 0 {
+			exp = time.Now().Add(ttl)
+		}
+
+		return putFileEntry(tx, k, v, exp)
+	}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+func (f *File) Delete(ctx context.Context, ks ...string) error {
+	_, span := tr.Start(ctx, "file delete")
+	defer span.End()
+
+	if err := f.db.Update(func(tx *bolt.Tx) error {
+		for _, k := range ks {
+			if err := tx.Bucket(fileBucket).Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+func (f *File) Keys(ctx context.Context, prefix string) ([]string, error) {
+	_, span := tr.Start(ctx, "file keys")
+	defer span.End()
+
+	var ks []string
+
+	if err := f.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(fileBucket).Cursor()
+		p := []byte(prefix)
+
+		for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+			if _, _, ok := getFileEntry(tx, string(k)); ok {
+				ks = append(ks, string(k))
+			}
+		}
+
+		return nil
+	}); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+func (f *File) Purge(ctx context.Context) (int, error) {
+	_, span := tr.Start(ctx, "file purge")
+	defer span.End()
+
+	var n int
+
+	if err := f.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(fileBucket)
+		now := time.Now()
+
+		// Keys are collected first, since deleting moves the cursor.
+		var ks [][]byte
+
+		if err := b.ForEach(func(k, v []byte) error {
+			if fileEntryExpired(v, now) {
+				ks = append(ks, append([]byte(nil), k...))
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, k := range ks {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		n = len(ks)
+
+		return nil
+	}); err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Entries are stored as the expiry in unix nanoseconds, or zero for keys
+// that never expire, followed by the value.
+const fileExpLen = 8
+
+func getFileEntry(tx *bolt.Tx, k string) ([]byte, time.Time, bool) {
+	byt := tx.Bucket(fileBucket).Get([]byte(k))
+	if len(byt) < fileExpLen || fileEntryExpired(byt, time.Now()) {
+		return nil, time.Time{}, false
+	}
+
+	exp := fileEntryExpiry(byt)
+
+	// Values are only valid for the life of the transaction.
+	return append([]byte(nil), byt[fileExpLen:]...), exp, true
+}
+
+func fileEntryExpiry(byt []byte) time.Time {
+	n := int64(binary.BigEndian.Uint64(byt[:fileExpLen]))
+	if n == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, n)
+}
+
+// fileEntryExpired reports whether the entry expired. Entries too short
+// to hold an expiry are not valid, so they count as expired.
+func fileEntryExpired(byt []byte, now time.Time) bool {
+	if len(byt) < fileExpLen {
+		return true
+	}
+
+	exp := fileEntryExpiry(byt)
+
+	return !exp.IsZero() && !now.Before(exp)
+}
+
+func putFileEntry(tx *bolt.Tx, k string, v []byte, exp time.Time) error {
+	byt := make([]byte, fileExpLen+len(v))
+
+	if !exp.IsZero() {
+		binary.BigEndian.PutUint64(byt[:fileExpLen], uint64(exp.UnixNano()))
+	}
+
+	copy(byt[fileExpLen:], v)
+
+	return tx.Bucket(fileBucket).Put([]byte(k), byt)
+}
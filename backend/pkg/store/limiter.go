@@ -0,0 +1,198 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const lPrefix = "limit"
+
+// Limit bounds the attempts made under a key, like a client's address.
+type Limit struct {
+	// Attempts is how many attempts are allowed in every window.
+	Attempts int
+	Window   time.Duration
+	// Failures is how many failed attempts are allowed before the key is
+	// locked out.
+	Failures int
+	// Lockout is how long the first lockout lasts. Every further failure
+	// doubles it, up to MaxLockout.
+	Lockout    time.Duration
+	MaxLockout time.Duration
+}
+
+var (
+	// DefaultAddrLimit limits the attempts of a single client.
+	DefaultAddrLimit = Limit{
+		Attempts:   20,
+		Window:     time.Minute,
+		Failures:   5,
+		Lockout:    time.Second,
+		MaxLockout: 15 * time.Minute,
+	}
+	// DefaultRoomLimit limits the attempts on a single room, which a whole
+	// team joins at once, from any number of clients. Its failures are
+	// counted for each client of the room, so a client guessing the
+	// password does not lock the team out.
+	DefaultRoomLimit = Limit{
+		Attempts:   200,
+		Window:     time.Minute,
+		Failures:   50,
+		Lockout:    time.Second,
+		MaxLockout: 5 * time.Minute,
+	}
+)
+
+type attempts struct {
+	Count       int       `json:"count"`
+	WindowEnd   time.Time `json:"windowEnd"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"lockedUntil"`
+}
+
+var errAttemptsExpired = errors.New("attempts expired")
+
+// Limiter keeps the attempts made under keys in a backend, so every API
+// instance shares them.
+type Limiter struct {
+	b Backend
+	l Limit
+}
+
+func NewLimiter(b Backend, l Limit) *Limiter { return &Limiter{b: b, l: l} }
+
+// Allow records an attempt under the key. If the key is locked out or made
+// too many attempts, the attempt is not recorded and Allow returns how long
+// to wait before trying again.
+func (l *Limiter) Allow(ctx context.Context, k string) (time.Duration, error) {
+	ctx, span := tr.Start(ctx, "limiter allow")
+	defer span.End()
+
+	var wait time.Duration
+
+	if err := l.update(ctx, k, func(a *attempts, now time.Time) {
+		wait = 0
+
+		if now.Before(a.LockedUntil) {
+			wait = a.LockedUntil.Sub(now)
+			return
+		}
+
+		if !now.Before(a.WindowEnd) {
+			a.Count = 0
+			a.WindowEnd = now.Add(l.l.Window)
+		}
+
+		if a.Count >= l.l.Attempts {
+			wait = a.WindowEnd.Sub(now)
+			return
+		}
+
+		a.Count++
+	}); err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+
+	return wait, nil
+}
+
+// Fail records a failed attempt under the key, locking it out once it
+// failed too many times. The attempts are kept for as long as a lockout
+// can last after the last failure.
+func (l *Limiter) Fail(ctx context.Context, k string) error {
+	ctx, span := tr.Start(ctx, "limiter fail")
+	defer span.End()
+
+	if err := l.update(ctx, k, func(a *attempts, now time.Time) {
+		a.Failures++
+
+		if a.Failures <= l.l.Failures {
+			return
+		}
+
+		// Guard the shift, so a long series of failures does not overflow.
+		d := l.l.MaxLockout
+		if n := a.Failures - l.l.Failures - 1; n < 32 {
+			if ld := l.l.Lockout << uint(n); ld > 0 && ld < d {
+				d = ld
+			}
+		}
+
+		a.LockedUntil = now.Add(d)
+	}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if err := l.b.Expire(ctx, lPrefix+k, l.ttl()); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// Succeed forgets the failed attempts under the key.
+func (l *Limiter) Succeed(ctx context.Context, k string) error {
+	ctx, span := tr.Start(ctx, "limiter succeed")
+	defer span.End()
+
+	if err := l.update(ctx, k, func(a *attempts, now time.Time) {
+		a.Failures = 0
+		a.LockedUntil = time.Time{}
+	}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// update changes the attempts under the key. Attempts are created with an
+// expiry, so keys that stop making attempts are forgotten, along with how
+// many times they failed.
+func (l *Limiter) update(
+	ctx context.Context,
+	k string,
+	fn func(a *attempts, now time.Time),
+) error {
+	key := lPrefix + k
+
+	init, err := json.Marshal(&attempts{})
+	if err != nil {
+		return err
+	}
+
+	// Update keeps the expiry of existing attempts, but would create them
+	// without one if they expired after SetNX, so they are created again.
+	for i := 0; i < 3; i++ {
+		if _, err := l.b.SetNX(ctx, key, init, l.ttl()); err != nil {
+			return err
+		}
+
+		err = l.b.Update(ctx, key, func(v []byte) ([]byte, error) {
+			if v == nil {
+				return nil, errAttemptsExpired
+			}
+
+			var a attempts
+			if err := json.Unmarshal(v, &a); err != nil {
+				return nil, err
+			}
+
+			fn(&a, time.Now())
+
+			return json.Marshal(&a)
+		})
+		if err != errAttemptsExpired {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (l *Limiter) ttl() time.Duration { return l.l.Window + l.l.MaxLockout }
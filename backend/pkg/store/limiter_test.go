@@ -0,0 +1,131 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	for name, b := range newTestBackends(t) {
+		b := b
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			l := NewLimiter(b, Limit{
+				Attempts:   3,
+				Window:     time.Hour,
+				Failures:   1,
+				Lockout:    time.Minute,
+				MaxLockout: 3 * time.Minute,
+			})
+
+			allow := func(k string) time.Duration {
+				t.Helper()
+
+				wait, err := l.Allow(ctx, k)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return wait
+			}
+
+			for i := 0; i < 3; i++ {
+				if wait := allow("a"); wait != 0 {
+					t.Fatalf("expected attempt %d to be allowed, got wait: %v", i, wait)
+				}
+			}
+
+			if wait := allow("a"); wait <= 0 || wait > time.Hour {
+				t.Fatalf("expected to wait for the window, got: %v", wait)
+			}
+
+			// keys are limited separately
+			if wait := allow("b"); wait != 0 {
+				t.Fatalf("expected another key to be allowed, got wait: %v", wait)
+			}
+
+			// lockouts double with every failure after the allowed ones,
+			// up to the max lockout
+			expected := []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute}
+
+			for i, e := range expected {
+				if err := l.Fail(ctx, "b"); err != nil {
+					t.Fatal(err)
+				}
+
+				wait := allow("b")
+				if wait > e || wait < e-time.Second {
+					t.Fatalf("expected to wait %v after failure %d, got: %v", e, i+1, wait)
+				}
+			}
+
+			if err := l.Succeed(ctx, "b"); err != nil {
+				t.Fatal(err)
+			}
+
+			if wait := allow("b"); wait != 0 {
+				t.Fatalf("expected success to lift the lockout, got wait: %v", wait)
+			}
+		})
+	}
+}
+
+func TestLimiterKeepsLockouts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	l := NewLimiter(NewMemory(), Limit{
+		Attempts:   10,
+		Window:     100 * time.Millisecond,
+		Failures:   0,
+		Lockout:    200 * time.Millisecond,
+		MaxLockout: 200 * time.Millisecond,
+	})
+
+	if _, err := l.Allow(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if err := l.Fail(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the attempts outlive the expiry they were created with, since the
+	// lockout ends after it
+	time.Sleep(150 * time.Millisecond)
+
+	if wait, err := l.Allow(ctx, "a"); err != nil || wait <= 0 {
+		t.Fatalf("expected the lockout to be kept, got: %v, %v", wait, err)
+	}
+}
+
+func TestLimiterForgetsAttempts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	l := NewLimiter(NewMemory(), Limit{
+		Attempts:   1,
+		Window:     time.Millisecond,
+		Failures:   0,
+		Lockout:    time.Millisecond,
+		MaxLockout: time.Millisecond,
+	})
+
+	if err := l.Fail(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if wait, err := l.Allow(ctx, "a"); err != nil || wait != 0 {
+		t.Fatalf("expected expired attempts to be forgotten, got: %v, %v", wait, err)
+	}
+}
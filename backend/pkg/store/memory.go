@@ -0,0 +1,194 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	v []byte
+	// exp is zero for keys that never expire.
+	exp time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.exp.IsZero() && !now.Before(e.exp)
+}
+
+// Memory is a backend that keeps keys in the memory of the process. It is
+// lost on restart and cannot be shared between processes, so it is meant
+// for tests and single binary deployments.
+type Memory struct {
+	mu *sync.Mutex
+	es map[string]*memoryEntry
+}
+
+func NewMemory() *Memory {
+	return &Memory{mu: &sync.Mutex{}, es: map[string]*memoryEntry{}}
+}
+
+func (m *Memory) Get(ctx context.Context, k string) ([]byte, error) {
+	_, span := tr.Start(ctx, "memory get")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entry(k)
+	if !ok {
+		err := DataDoesNotExistError{fmt.Errorf("key '%s' does not exist", k)}
+		span.RecordError(err)
+
+		return nil, err
+	}
+
+	return append([]byte(nil), e.v...), nil
+}
+
+func (m *Memory) SetNX(
+	ctx context.Context,
+	k string,
+	v []byte,
+	ttl time.Duration,
+) (bool, error) {
+	_, span := tr.Start(ctx, "memory setnx")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.entry(k); ok {
+		return false, nil
+	}
+
+	e := &memoryEntry{v: append([]byte(nil), v...)}
+	if ttl > 0 {
+		e.exp = time.Now().Add(ttl)
+	}
+
+	m.es[k] = e
+
+	return true, nil
+}
+
+// Update holds the lock while fn runs, so fn is called exactly once.
+func (m *Memory) Update(
+	ctx context.Context,
+	k string,
+	fn func(v []byte) ([]byte, error),
+) error {
+	_, span := tr.Start(ctx, "memory update")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entry(k)
+	if !ok {
+		e = &memoryEntry{}
+	}
+
+	nv, err := fn(append([]byte(nil), e.v...))
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	m.es[k] = &memoryEntry{v: append([]byte(nil), nv...), exp: e.exp}
+
+	return nil
+}
+
+func (m *Memory) Expire(ctx context.Context, k string, ttl time.Duration) error {
+	_, span := tr.Start(ctx, "memory expire")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entry(k)
+	if !ok {
+		return nil
+	}
+
+	e.exp = time.Time{}
+	if ttl > 0 {
+		e.exp = time.Now().Add(ttl)
+	}
+
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, ks ...string) error {
+	_, span := tr.Start(ctx, "memory delete")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range ks {
+		delete(m.es, k)
+	}
+
+	return nil
+}
+
+func (m *Memory) Keys(ctx context.Context, prefix string) ([]string, error) {
+	_, span := tr.Start(ctx, "memory keys")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ks []string
+
+	for k := range m.es {
+		if _, ok := m.entry(k); ok && strings.HasPrefix(k, prefix) {
+			ks = append(ks, k)
+		}
+	}
+
+	sort.Strings(ks)
+
+	return ks, nil
+}
+
+func (m *Memory) Purge(ctx context.Context) (int, error) {
+	_, span := tr.Start(ctx, "memory purge")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int
+
+	now := time.Now()
+	for k, e := range m.es {
+		if e.expired(now) {
+			delete(m.es, k)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// entry returns the key's entry, removing it if it expired. The lock must
+// be held.
+func (m *Memory) entry(k string) (*memoryEntry, bool) {
+	e, ok := m.es[k]
+	if !ok {
+		return nil, false
+	}
+
+	if e.expired(time.Now()) {
+		delete(m.es, k)
+		return nil, false
+	}
+
+	return e, true
+}
@@ -0,0 +1,218 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/safe-waters/retro-simply/backend/pkg/data"
+)
+
+// PresenceTTL is how long a connection is present after its last
+// heartbeat, so connections of an api that stopped without leaving are
+// forgotten.
+const PresenceTTL = 30 * time.Second
+
+type connection struct {
+	Participant *data.Participant `json:"participant"`
+	ExpiresAt   time.Time         `json:"expiresAt"`
+}
+
+// PresenceChange is who joined or left a room, as far as the room is
+// concerned - a participant connected more than once only leaves with
+// their last connection.
+type PresenceChange struct {
+	Joined []*data.Participant
+	Left   []*data.Participant
+}
+
+// Heartbeat records that the connection of the participant is present
+// until PresenceTTL from now.
+func (s *S) Heartbeat(
+	ctx context.Context,
+	rId string,
+	cId string,
+	p *data.Participant,
+) (*PresenceChange, error) {
+	ctx, span := tr.Start(ctx, "heartbeat")
+	defer span.End()
+
+	pc, err := s.updatePresence(ctx, rId, func(cs map[string]*connection, now time.Time) {
+		cs[cId] = &connection{Participant: p, ExpiresAt: now.Add(PresenceTTL)}
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return pc, nil
+}
+
+// Leave forgets the connection.
+func (s *S) Leave(ctx context.Context, rId, cId string) (*PresenceChange, error) {
+	ctx, span := tr.Start(ctx, "leave")
+	defer span.End()
+
+	pc, err := s.updatePresence(ctx, rId, func(cs map[string]*connection, now time.Time) {
+		delete(cs, cId)
+	})
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return pc, nil
+}
+
+// Participants returns every participant connected to the room, sorted by
+// display name.
+func (s *S) Participants(ctx context.Context, rId string) ([]*data.Participant, error) {
+	ctx, span := tr.Start(ctx, "get participants")
+	defer span.End()
+
+	v, err := s.b.Get(ctx, s.getKey(prPrefix, rId))
+	if err != nil {
+		switch err.(type) {
+		case DataDoesNotExistError:
+			return []*data.Participant{}, nil
+		default:
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	var cs map[string]*connection
+	if err := json.Unmarshal(v, &cs); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return participants(cs, time.Now()), nil
+}
+
+// checkPresent returns a ForbiddenError if the participant is not connected
+// to the room, so the room is only handed to someone who can run it.
+func (s *S) checkPresent(ctx context.Context, rId, pId string) error {
+	ps, err := s.Participants(ctx, rId)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range ps {
+		if p.Id == pId {
+			return nil
+		}
+	}
+
+	return data.ForbiddenError{
+		Err: fmt.Errorf("participant '%s' is not in room '%s'", pId, rId),
+	}
+}
+
+// updatePresence changes the connections of the room, forgetting the ones
+// that expired. The connections are kept in a single key, so the change
+// can be compared with the connections before it. The key expires with
+// the last connection it may hold, so rooms nobody is connected to do not
+// keep it, even once they are deleted.
+func (s *S) updatePresence(
+	ctx context.Context,
+	rId string,
+	fn func(cs map[string]*connection, now time.Time),
+) (*PresenceChange, error) {
+	key := s.getKey(prPrefix, rId)
+
+	var pc *PresenceChange
+
+	if err := s.b.Update(ctx, key, func(v []byte) ([]byte, error) {
+		cs := map[string]*connection{}
+
+		if v != nil {
+			if err := json.Unmarshal(v, &cs); err != nil {
+				return nil, err
+			}
+		}
+
+		// Connections that expired since the last update are still in the
+		// participants before it, so they are reported as left.
+		now := time.Now()
+		before := participants(cs, time.Time{})
+
+		fn(cs, now)
+
+		for id, c := range cs {
+			if !now.Before(c.ExpiresAt) {
+				delete(cs, id)
+			}
+		}
+
+		pc = diffParticipants(before, participants(cs, now))
+
+		return json.Marshal(cs)
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := s.b.Expire(ctx, key, PresenceTTL); err != nil {
+		return nil, err
+	}
+
+	return pc, nil
+}
+
+// participants returns the participants of the connections that have not
+// expired by now, or of every connection for the zero time.
+func participants(cs map[string]*connection, now time.Time) []*data.Participant {
+	seen := map[string]struct{}{}
+	ps := []*data.Participant{}
+
+	for _, c := range cs {
+		if !now.IsZero() && !now.Before(c.ExpiresAt) {
+			continue
+		}
+
+		if _, ok := seen[c.Participant.Id]; ok {
+			continue
+		}
+
+		seen[c.Participant.Id] = struct{}{}
+		ps = append(ps, c.Participant)
+	}
+
+	sort.Slice(ps, func(i, j int) bool {
+		if ps[i].DisplayName != ps[j].DisplayName {
+			return ps[i].DisplayName < ps[j].DisplayName
+		}
+
+		return ps[i].Id < ps[j].Id
+	})
+
+	return ps
+}
+
+func diffParticipants(before, after []*data.Participant) *PresenceChange {
+	pc := &PresenceChange{}
+
+	ids := map[string]struct{}{}
+	for _, p := range before {
+		ids[p.Id] = struct{}{}
+	}
+
+	for _, p := range after {
+		if _, ok := ids[p.Id]; ok {
+			delete(ids, p.Id)
+			continue
+		}
+
+		pc.Joined = append(pc.Joined, p)
+	}
+
+	for _, p := range before {
+		if _, ok := ids[p.Id]; ok {
+			pc.Left = append(pc.Left, p)
+		}
+	}
+
+	return pc
+}
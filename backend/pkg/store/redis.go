@@ -0,0 +1,223 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/safe-waters/retro-simply/backend/pkg/client"
+)
+
+type DatabaseGetWatchSetter interface {
+	Get(ctx context.Context, key string) client.StrResult
+	Watch(ctx context.Context, fn func(*redis.Tx) error, keys ...string) error
+	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) client.BoolResult
+	PExpire(ctx context.Context, key string, expiration time.Duration) client.BoolResult
+	Persist(ctx context.Context, key string) client.BoolResult
+	Del(ctx context.Context, keys ...string) client.IntResult
+	Scan(ctx context.Context, cursor uint64, match string, count int64) client.ScanResult
+}
+
+// Redis is a backend that keeps keys in Redis, so it can be shared by
+// every api and worker.
+type Redis struct {
+	d DatabaseGetWatchSetter
+	// retries counts the transactions retried because of optimistic
+	// locking conflicts.
+	retries uint64
+}
+
+func NewRedis(d DatabaseGetWatchSetter) *Redis { return &Redis{d: d} }
+
+func (r *Redis) Retries() uint64 { return atomic.LoadUint64(&r.retries) }
+
+func (r *Redis) Get(ctx context.Context, k string) ([]byte, error) {
+	ctx, span := tr.Start(ctx, "redis get")
+	defer span.End()
+
+	v, err := r.d.Get(ctx, k).Result()
+	if err != nil {
+		switch err {
+		case redis.Nil:
+			err := DataDoesNotExistError{fmt.Errorf("key '%s' does not exist", k)}
+			span.RecordError(err)
+
+			return nil, err
+		default:
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	return []byte(v), nil
+}
+
+func (r *Redis) SetNX(
+	ctx context.Context,
+	k string,
+	v []byte,
+	ttl time.Duration,
+) (bool, error) {
+	ctx, span := tr.Start(ctx, "redis setnx")
+	defer span.End()
+
+	didSet, err := r.d.SetNX(ctx, k, v, ttl).Result()
+	if err != nil {
+		span.RecordError(err)
+		return false, err
+	}
+
+	return didSet, nil
+}
+
+func (r *Redis) Update(
+	ctx context.Context,
+	k string,
+	fn func(v []byte) ([]byte, error),
+) error {
+	ctx, span := tr.Start(ctx, "redis update")
+	defer span.End()
+
+	txf := func(tx *redis.Tx) error {
+		ctx, span := tr.Start(ctx, "transaction")
+		defer span.End()
+
+		v, err := tx.Get(ctx, k).Bytes()
+		if err != nil {
+			if err != redis.Nil {
+				span.RecordError(err)
+				return err
+			}
+
+			v = nil
+		}
+
+		nv, err := fn(v)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+
+		// Store the new value, returning a redis.TxFailedErr if the
+		// value stored at the key has changed.
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, k, nv, redis.KeepTTL)
+			return nil
+		})
+
+		if err != nil {
+			span.RecordError(err)
+		}
+
+		return err
+	}
+
+	if err := r.watch(ctx, k, txf); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *Redis) Expire(ctx context.Context, k string, ttl time.Duration) error {
+	ctx, span := tr.Start(ctx, "redis expire")
+	defer span.End()
+
+	var err error
+	if ttl > 0 {
+		err = r.d.PExpire(ctx, k, ttl).Err()
+	} else {
+		err = r.d.Persist(ctx, k).Err()
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *Redis) Delete(ctx context.Context, ks ...string) error {
+	ctx, span := tr.Start(ctx, "redis delete")
+	defer span.End()
+
+	if len(ks) == 0 {
+		return nil
+	}
+
+	if err := r.d.Del(ctx, ks...).Err(); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// Keys scans the keys instead of using KEYS, so Redis is not blocked while
+// they are listed.
+func (r *Redis) Keys(ctx context.Context, prefix string) ([]string, error) {
+	ctx, span := tr.Start(ctx, "redis keys")
+	defer span.End()
+
+	const count = 1000
+
+	var (
+		ks     []string
+		cursor uint64
+	)
+
+	for {
+		sks, c, err := r.d.Scan(ctx, cursor, prefix+"*", count).Result()
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		ks = append(ks, sks...)
+
+		if c == 0 {
+			return ks, nil
+		}
+
+		cursor = c
+	}
+}
+
+// Optimistic locking - try to run the transaction up to maxRetries.
+//
+// The transaction will fail if the value stored at the key
+// changes before the transaction stores its value in Redis.
+//
+// Example:
+// https://pkg.go.dev/github.com/go-redis/redis/v8#Client.Watch
+//
+// More information about watch:
+// https://redislabs.com/blog/you-dont-need-transaction-rollbacks-in-redis/
+func (r *Redis) watch(ctx context.Context, k string, txf func(*redis.Tx) error) error {
+	var err error
+	const retries = 10000
+
+	for i := 0; i < retries; i++ {
+		err = r.d.Watch(ctx, txf, k)
+		if err != nil {
+			switch err {
+			case redis.TxFailedErr:
+				// If the transaction failed, try again
+				atomic.AddUint64(&r.retries, 1)
+				continue
+			default:
+				// If failed for any reason unrelated to optimistic locking,
+				// return err
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return err
+}
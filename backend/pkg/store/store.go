@@ -3,13 +3,13 @@ package store
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
 	"time"
 
-	"github.com/go-redis/redis/v8"
-	"github.com/safe-waters/retro-simply/backend/pkg/client"
+	"github.com/google/uuid"
 	"github.com/safe-waters/retro-simply/backend/pkg/data"
 	"go.opentelemetry.io/otel"
 )
@@ -17,49 +17,87 @@ import (
 var tr = otel.Tracer("pkg/store")
 
 const (
-	pPrefix = "password"
-	sPrefix = "state"
+	pPrefix  = "password"
+	sPrefix  = "state"
+	tPrefix  = "template"
+	ePrefix  = "expiry"
+	gPrefix  = "generation"
+	cPrefix  = "settings"
+	prPrefix = "presence"
+	tmPrefix = "team"
+	// The action items of a team are kept by the team's id rather than a
+	// room's, so they outlive the rooms of the team.
+	aPrefix = "actions"
+	// teamIdle is how long the action items of a team are kept after the
+	// last activity in its rooms, which is as long as a room can be kept
+	// idle.
+	teamIdle = data.MaxRetentionDays * 24 * time.Hour
+	// touchInterval bounds how often activity in a room is written, so
+	// every operation does not cost an extra write.
+	touchInterval = time.Minute
 )
 
-type DatabaseGetWatchSetter interface {
-	Get(ctx context.Context, key string) client.StrResult
-	Watch(ctx context.Context, fn func(*redis.Tx) error, keys ...string) error
-	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) client.BoolResult
+// roomPrefixes are the prefixes of every key that belongs to a room.
+var roomPrefixes = []string{
+	pPrefix,
+	sPrefix,
+	tPrefix,
+	ePrefix,
+	gPrefix,
+	cPrefix,
+	prPrefix,
+	tmPrefix,
 }
 
-type S struct{ d DatabaseGetWatchSetter }
+// Retention decides how long rooms are kept. Zero durations keep rooms
+// forever.
+type Retention struct {
+	// Idle is how long a room is kept after its last activity, unless
+	// the room overrides it when it is created.
+	Idle time.Duration
+	// MaxAge is how long a room is kept after it is created, whatever its
+	// activity.
+	MaxAge time.Duration
+}
+
+// S keeps rooms in a backend, merging the states and applying the
+// operations sent by clients.
+type S struct {
+	b Backend
+	r Retention
+}
+
+func New(b Backend, r Retention) *S { return &S{b: b, r: r} }
 
-func New(d DatabaseGetWatchSetter) *S { return &S{d: d} }
+// Retries returns how many transactions were retried because of
+// optimistic locking conflicts, for backends that lock optimistically.
+func (s *S) Retries() uint64 {
+	r, ok := s.b.(interface{ Retries() uint64 })
+	if !ok {
+		return 0
+	}
+
+	return r.Retries()
+}
 
 func (s *S) State(ctx context.Context, rId string) (*data.State, error) {
 	ctx, span := tr.Start(ctx, "get state")
 	defer span.End()
 
-	k := s.getKey(sPrefix, rId)
-
-	v, err := s.d.Get(ctx, k).Result()
+	v, err := s.b.Get(ctx, s.getKey(sPrefix, rId))
 	if err != nil {
 		span.RecordError(err)
-
-		switch err {
-		case redis.Nil:
-			err := DataDoesNotExistError{err}
-			span.RecordError(err)
-
-			return nil, err
-		default:
-			return nil, err
-		}
+		return nil, err
 	}
 
 	var st data.State
-
-	b := []byte(v)
-	if err := json.Unmarshal(b, &st); err != nil {
+	if err := json.Unmarshal(v, &st); err != nil {
 		span.RecordError(err)
 		return nil, err
 	}
 
+	st.Deliveries = nil
+
 	return &st, nil
 }
 
@@ -68,7 +106,25 @@ type retroCardWithIndex struct {
 	index     int
 }
 
-func (s *S) mergeState(ctx context.Context, os *data.State, st *data.State) (*data.State, error) {
+// mergeState merges the state sent by a client into the old state. Votes
+// are not merged - the votes of the old state are kept, and only the vote
+// or retraction of the state's action is counted, so concurrent votes and
+// retractions converge whatever the votes of the client's cards. A vote
+// the room's voting does not allow is returned as a rejection.
+//
+// Changes the state's author is not allowed to make are not merged: only
+// the vote of a participant who is not the facilitator is counted while
+// the board is locked, and their deletions of the cards of others are
+// undone. Phases that do not allow cards to be changed only count votes,
+// and phases that do not allow new cards drop them. The private cards of
+// others are kept as they are.
+func (s *S) mergeState(
+	ctx context.Context,
+	t *data.Template,
+	v *data.Voting,
+	os *data.State,
+	st *data.State,
+) (*data.State, *data.Rejection, error) {
 	_, span := tr.Start(ctx, "merge state")
 	defer span.End()
 
@@ -79,41 +135,70 @@ func (s *S) mergeState(ctx context.Context, os *data.State, st *data.State) (*da
 	msByt, err := json.Marshal(os)
 	if err != nil {
 		span.RecordError(err)
-		return nil, err
+		return nil, nil, err
 	}
 
 	if err := json.Unmarshal(msByt, &ms); err != nil {
 		span.RecordError(err)
-		return nil, err
+		return nil, nil, err
+	}
+
+	// A state that changes groups the phase does not allow is dropped as a
+	// whole, rather than only its groups like new cards are, since the
+	// cards moved into the groups would be lost with them.
+	if (os.Locked && !os.Facilitates(st.AuthorId)) ||
+		os.CheckPhase(data.OperationEditCard) != nil ||
+		os.CheckGroups(st) != nil {
+		r := s.countVote(v, os, &ms, st)
+
+		return &ms, r, nil
+	}
+
+	// Columns are the template's, so they are never added, removed,
+	// reordered or renamed.
+	if err := t.ValidateState(st); err != nil {
+		span.RecordError(err)
+		return nil, nil, err
 	}
 
-	// Adding new columns is not allowed
 	if len(os.Columns) != len(st.Columns) {
-		err := fmt.Errorf(
-			"expected old state columns %d, got state columns %d",
-			len(os.Columns),
-			len(st.Columns),
-		)
+		err := data.TemplateInvalidError{
+			Err: fmt.Errorf(
+				"expected old state columns %d, got state columns %d",
+				len(os.Columns),
+				len(st.Columns),
+			),
+		}
 		span.RecordError(err)
 
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Save any new cards, which will be used to make sure upvotes
-	// are correct, later
-	newCardIds := map[string]struct{}{}
+	allowed := func(rs []*data.RetroCard) []*data.RetroCard {
+		ars := make([]*data.RetroCard, 0, len(rs))
+
+		for _, r := range rs {
+			if os.AllowsCard(r) {
+				ars = append(ars, r)
+			}
+		}
+
+		return ars
+	}
 
 	for i := 0; i < len(st.Columns); i++ {
 		// changing the order of columns is not allowed
 		if os.Columns[i].Id != st.Columns[i].Id {
-			err := fmt.Errorf(
-				"expected old state columns id %s, got state columns id %s",
-				os.Columns[i].Id,
-				st.Columns[i].Id,
-			)
+			err := data.TemplateInvalidError{
+				Err: fmt.Errorf(
+					"expected old state columns id %s, got state columns id %s",
+					os.Columns[i].Id,
+					st.Columns[i].Id,
+				),
+			}
 			span.RecordError(err)
 
-			return nil, err
+			return nil, nil, err
 		}
 
 		for j := 0; j < len(st.Columns[i].Groups); j++ {
@@ -146,44 +231,43 @@ func (s *S) mergeState(ctx context.Context, os *data.State, st *data.State) (*da
 								// set the mergedState card to state's card
 								ms.Columns[i].Groups[k].RetroCards[a] = st.Columns[i].Groups[j].RetroCards[l]
 
-								// take the max of the oldState and state's numVotes,
-								// as numVotes can never decrease.
-								if os.Columns[i].Groups[k].RetroCards[a].NumVotes > ms.Columns[i].Groups[k].RetroCards[a].NumVotes {
-									ms.Columns[i].Groups[k].RetroCards[a].NumVotes = os.Columns[i].Groups[k].RetroCards[a].NumVotes
-								}
-
 								// if the oldState's card was deleted, make sure it is still deleted
 								if os.Columns[i].Groups[k].RetroCards[a].IsDeleted {
 									ms.Columns[i].Groups[k].RetroCards[a].IsDeleted = true
 								}
+
+								// the author of a card never changes
+								ms.Columns[i].Groups[k].RetroCards[a].AuthorId = os.Columns[i].Groups[k].RetroCards[a].AuthorId
 							}
 						}
 
+						// cards that were moved continue a chain of the old
+						// state, so they are not new
+						if !rFound && !os.AllowsCard(st.Columns[i].Groups[j].RetroCards[l]) {
+							continue
+						}
+
 						if !rFound {
 							// add the new card to the group
 							ms.Columns[i].Groups[k].RetroCards = append(
 								ms.Columns[i].Groups[k].RetroCards,
 								st.Columns[i].Groups[j].RetroCards[l],
 							)
-
-							// save the new card
-							newCardIds[st.Columns[i].Groups[j].RetroCards[l].Id] = struct{}{}
 						}
 					}
 				}
 			}
 
 			if !gFound {
+				st.Columns[i].Groups[j].RetroCards = allowed(
+					st.Columns[i].Groups[j].RetroCards,
+				)
+
 				// add the new group to the mergedState
 				ms.Columns[i].Groups = append(
 					ms.Columns[i].Groups,
 					st.Columns[i].Groups[j],
 				)
-
-				// save the new cards
-				for a := 0; a < len(st.Columns[i].Groups[j].RetroCards); a++ {
-					newCardIds[st.Columns[i].Groups[j].RetroCards[a].Id] = struct{}{}
-				}
 			}
 		}
 	}
@@ -218,13 +302,6 @@ func (s *S) mergeState(ctx context.Context, os *data.State, st *data.State) (*da
 			var cardToKeepIndex int
 
 			for i := 1; i < len(cards); i++ {
-				// When deleting a card, always make sure the card to keep has the max numvotes
-				if cards[cardToKeepIndex].retroCard.NumVotes < cards[i].retroCard.NumVotes {
-					cards[cardToKeepIndex].retroCard.NumVotes = cards[i].retroCard.NumVotes
-				} else {
-					cards[i].retroCard.NumVotes = cards[cardToKeepIndex].retroCard.NumVotes
-				}
-
 				switch {
 				// if both cards are deleted, keep the last modified card
 				case cards[cardToKeepIndex].retroCard.IsDeleted && cards[i].retroCard.IsDeleted:
@@ -292,201 +369,378 @@ func (s *S) mergeState(ctx context.Context, os *data.State, st *data.State) (*da
 		}
 	}
 
-	// Store all of the cards, so they can be easily changed if you
-	// need to traverse the previous / next cards.
-	//
-	// Card IDs are of the form "{uuid}-pk-{number}". Moving a card from
-	// one group to another sets the "isDeleted" property to true on the
-	// card in the original group and creates the "next" card in the new
-	// group by incrementing the number after pk. A card "chain" would be
-	// all of the cards that share the same uuid.
-	cardsById := map[string]*data.RetroCard{}
+	ms.KeepOthersCards(os, st.AuthorId)
+	ms.KeepPrivateCards(os, st.AuthorId)
 
-	for i := 0; i < len(ms.Columns); i++ {
-		for j := 0; j < len(ms.Columns[i].Groups); j++ {
-			for k := 0; k < len(ms.Columns[i].Groups[j].RetroCards); k++ {
-				cardsById[ms.Columns[i].Groups[j].RetroCards[k].Id] = ms.Columns[i].Groups[j].RetroCards[k]
-			}
-		}
+	if err := ms.CheckMessages(); err != nil {
+		span.RecordError(err)
+		return nil, nil, err
 	}
 
-	// Make sure all new cards update their card chains.
-	// We want to make sure that all the cards
-	// in the chain have the same number of upvotes, which will always
-	// be the maximum number of any member in the chain.
-	//
-	// Maintaining an equal number of upvotes makes logical sense, since
-	// all the cards are really the same. It also provides peace of mind
-	// that a card that isDeleted could not possibly have more upvotes
-	// than its successor.
-	for id := range newCardIds {
-		numVotes := s.getMaxNumUpvotesInCardChain(id, cardsById)
-		s.applyNumUpvotesToCardChain(id, cardsById, numVotes)
-	}
-
-	if st.Action != nil {
-		switch st.Action.Title {
-		case "upVote":
-			numVotes := s.getMaxNumUpvotesInCardChain(st.Action.NewCard.Id, cardsById)
-
-			for i := 0; i < len(os.Columns); i++ {
-				for j := 0; j < len(os.Columns[i].Groups); j++ {
-					for k := 0; k < len(os.Columns[i].Groups[j].RetroCards); k++ {
-						if os.Columns[i].Groups[j].RetroCards[k].Id == st.Action.NewCard.Id {
-							// If oldState's numVotes is ahead of action's old card numvotes,
-							// add 1 to avoid a lost update.
-							if os.Columns[i].Groups[j].RetroCards[k].NumVotes > st.Action.OldCard.NumVotes {
-								numVotes++
-
-								break
-							}
-						}
-					}
-				}
-			}
+	r := s.countVote(v, os, &ms, st)
 
-			// Ensure the numVotes is reflected in every card in the chain.
-			s.applyNumUpvotesToCardChain(st.Action.NewCard.Id, cardsById, numVotes)
-		}
-	}
+	return &ms, r, nil
+}
 
-	return &ms, nil
+func (s *S) StoreState(
+	ctx context.Context,
+	st *data.State,
+) (*data.State, []*data.Rejection, error) {
+	return s.StoreStates(ctx, st)
 }
 
-func (s *S) StoreState(ctx context.Context, st *data.State) (*data.State, error) {
-	ctx, span := tr.Start(ctx, "store state")
+// StoreStates merges the states, in order, into the stored state of their
+// room in a single transaction, so states queued for the same room only
+// cost one write and one revision. Votes the room's voting does not allow
+// do not fail the transaction - they are returned as rejections instead.
+// States whose queue entry was already stored are skipped, and if every
+// state was, AlreadyStoredError is returned.
+func (s *S) StoreStates(
+	ctx context.Context,
+	sts ...*data.State,
+) (*data.State, []*data.Rejection, error) {
+	ctx, span := tr.Start(ctx, "store states")
 	defer span.End()
 
-	var ms *data.State
-	k := s.getKey(sPrefix, st.RoomId)
+	if len(sts) == 0 {
+		err := errors.New("no states to store")
+		span.RecordError(err)
+
+		return nil, nil, err
+	}
+
+	rId := sts[0].RoomId
+	for _, st := range sts {
+		if st.RoomId != rId {
+			err := fmt.Errorf(
+				"got state for room '%s', expected '%s'",
+				st.RoomId,
+				rId,
+			)
+			span.RecordError(err)
+
+			return nil, nil, err
+		}
+	}
+
+	if err := s.checkRoom(ctx, rId); err != nil {
+		span.RecordError(err)
+		return nil, nil, err
+	}
 
-	txf := func(tx *redis.Tx) error {
-		ctx, span := tr.Start(ctx, "transaction")
+	// The template never changes once the room is created, so it is read
+	// outside of the update.
+	t, err := s.Template(ctx, rId)
+	if err != nil {
+		span.RecordError(err)
+		return nil, nil, err
+	}
+
+	// Settings never change either.
+	cs, err := s.Settings(ctx, rId)
+	if err != nil {
+		span.RecordError(err)
+		return nil, nil, err
+	}
+
+	var (
+		ms *data.State
+		rs []*data.Rejection
+	)
+
+	uf := func(v []byte) ([]byte, error) {
+		ctx, span := tr.Start(ctx, "merge states")
 		defer span.End()
 
 		var err error
 
-		// If oldState does not exist, use state. Otherwise,
-		// merge oldState and state.
-		os, err := s.State(ctx, st.RoomId)
-		if err != nil {
-			span.RecordError(err)
+		// If oldState does not exist, use the first state. Otherwise,
+		// merge oldState and every state.
+		ms = nil
+		rs = nil
+
+		var os *data.State
+
+		if v != nil {
+			os = &data.State{}
+			if err := json.Unmarshal(v, os); err != nil {
+				span.RecordError(err)
+				return nil, err
+			}
+		}
+
+		var (
+			rev uint64
+			ds  data.State
+		)
+
+		if os != nil {
+			rev = os.Revision
+			ds.Deliveries = os.Deliveries
+		}
+
+		for _, st := range sts {
+			if ds.Delivered(st.DeliveryId) {
+				continue
+			}
+
+			ds.AddDelivery(st.DeliveryId)
+
+			// Ballots, whether votes are revealed, the facilitator,
+			// whether the board is locked, the phase and the timer are
+			// only ever changed by the store.
+			st.Ballots = nil
+			st.VotesRevealed = false
+			st.FacilitatorId = ""
+			st.Locked = false
+			st.Phase = ""
+			st.PhaseHistory = nil
+			st.Private = false
+			st.Timer = nil
+			st.Deliveries = nil
+
+			var r *data.Rejection
+
+			if ms == nil && os == nil {
+				if err := t.ValidateState(st); err != nil {
+					span.RecordError(err)
+					return nil, err
+				}
+
+				// A room without a stored state has no private cards.
+				st.KeepPrivateCards(&data.State{}, st.AuthorId)
+
+				if err := st.CheckMessages(); err != nil {
+					span.RecordError(err)
+					return nil, err
+				}
 
-			switch err.(type) {
-			case DataDoesNotExistError:
 				ms = st
-			default:
-				return err
+				r = s.countVote(&cs.Voting, nil, ms, st)
+			} else {
+				if ms == nil {
+					ms = os
+				}
+
+				ms, r, err = s.mergeState(ctx, t, &cs.Voting, ms, st)
+				if err != nil {
+					span.RecordError(err)
+					return nil, err
+				}
+			}
+
+			if r != nil {
+				rs = append(rs, r)
 			}
 		}
 
 		if ms == nil {
-			ms, err = s.mergeState(ctx, os, st)
-			if err != nil {
-				span.RecordError(err)
-				return err
+			return nil, AlreadyStoredError{
+				fmt.Errorf("states for room '%s' already stored", rId),
 			}
 		}
 
-		msByt, err := json.Marshal(ms)
-		if err != nil {
-			span.RecordError(err)
-			return err
-		}
+		ms.Revision = rev + 1
+		ms.AuthorId = ""
+		ms.Deliveries = ds.Deliveries
 
-		// Store the mergedState, returning a redis.TxFailedErr if the
-		// value stored at the key has changed.
-		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
-			pipe.Set(ctx, k, msByt, 0)
-			return nil
-		})
+		return json.Marshal(ms)
+	}
 
-		if err != nil {
-			span.RecordError(err)
-		}
+	if err := s.b.Update(ctx, s.getKey(sPrefix, rId), uf); err != nil {
+		span.RecordError(err)
+		return nil, nil, err
+	}
 
-		return err
+	ms.Deliveries = nil
+
+	if err := s.touch(ctx, rId); err != nil {
+		span.RecordError(err)
 	}
 
-	// Optimistic locking - try to store mergedState up to maxRetries.
-	//
-	// The transaction will fail if the value stored at the key
-	// changes before the transaction stores mergedState in Redis.
-	//
-	// Example:
-	// https://pkg.go.dev/github.com/go-redis/redis/v8#Client.Watch
-	//
-	// More information about watch:
-	// https://redislabs.com/blog/you-dont-need-transaction-rollbacks-in-redis/
-	var err error
-	const retries = 10000
+	return ms, rs, nil
+}
 
-	for i := 0; i < retries; i++ {
-		err = s.d.Watch(ctx, txf, k)
-		if err != nil {
-			span.RecordError(err)
+// countVote sets the votes of the merged state's cards to the votes of the
+// previous state, and counts the vote or retraction of the state's action.
+func (s *S) countVote(v *data.Voting, prev, ms, st *data.State) *data.Rejection {
+	ms.KeepVotes(prev)
 
-			switch err {
-			case redis.TxFailedErr:
-				// If the transaction failed, try again
-				continue
-			default:
-				// If failed for any reason unrelated to optimistic locking,
-				// return err
-				return nil, err
-			}
+	if st.Action == nil {
+		return nil
+	}
+
+	pId, rId := st.Action.AuthorId, st.Action.NewCard.Id
+
+	var err error
+
+	switch st.Action.Title {
+	case data.ActionUpVote:
+		if err = ms.CheckPhase(data.OperationVote); err == nil {
+			err = ms.Vote(pId, rId, v)
 		}
+	case data.ActionUnVote:
+		if err = ms.CheckPhase(data.OperationUnvote); err == nil {
+			err = ms.Unvote(pId, rId)
+		}
+	}
 
-		return ms, nil
+	if err != nil {
+		return &data.Rejection{
+			RoomId:        ms.RoomId,
+			ParticipantId: pId,
+			CardId:        rId,
+			Reason:        err.Error(),
+		}
 	}
 
-	return nil, err
+	return nil
 }
 
-const pkPrefix = "-pk-"
+// ApplyOperation applies the operation to the stored state of its room,
+// returning the resulting state and setting the operation's revision to
+// the state's new revision. Rooms without a stored state start from
+// the empty board of their template. An operation whose queue entry was
+// already stored is not applied again, and AlreadyStoredError is returned.
+func (s *S) ApplyOperation(
+	ctx context.Context,
+	op *data.Operation,
+) (*data.State, error) {
+	ctx, span := tr.Start(ctx, "apply operation")
+	defer span.End()
 
-func (s *S) getMaxNumUpvotesInCardChain(id string, cardsById map[string]*data.RetroCard) uint {
-	idWithoutPk := s.getIdWithoutPk(id)
-	numVotes := cardsById[id].NumVotes
-	pk := 0
+	if err := s.checkRoom(ctx, op.RoomId); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
 
-	for {
-		currId := fmt.Sprintf("%s%s%d", idWithoutPk, pkPrefix, pk)
+	t, err := s.Template(ctx, op.RoomId)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	cs, err := s.Settings(ctx, op.RoomId)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
 
-		card, ok := cardsById[currId]
-		if !ok {
-			break
+	if op.Type == data.OperationTransferFacilitator {
+		if err := s.checkPresent(ctx, op.RoomId, op.ParticipantId); err != nil {
+			span.RecordError(err)
+			return nil, err
 		}
+	}
+
+	var st *data.State
 
-		if card.NumVotes > numVotes {
-			numVotes = card.NumVotes
+	uf := func(v []byte) ([]byte, error) {
+		_, span := tr.Start(ctx, "apply")
+		defer span.End()
+
+		st = nil
+
+		if v == nil {
+			st = t.NewState(op.RoomId)
+		} else {
+			st = &data.State{}
+			if err := json.Unmarshal(v, st); err != nil {
+				span.RecordError(err)
+				return nil, err
+			}
 		}
 
-		pk++
+		if st.Delivered(op.DeliveryId) {
+			err := AlreadyStoredError{
+				fmt.Errorf("operation '%s' already stored", op.DeliveryId),
+			}
+			span.RecordError(err)
+
+			return nil, err
+		}
+
+		if err := op.Apply(st, &cs.Voting); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		st.AddDelivery(op.DeliveryId)
+		st.Revision++
+		op.Revision = st.Revision
+
+		return json.Marshal(st)
+	}
+
+	if err := s.b.Update(ctx, s.getKey(sPrefix, op.RoomId), uf); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	st.Deliveries = nil
+
+	if err := s.touch(ctx, op.RoomId); err != nil {
+		span.RecordError(err)
 	}
 
-	return numVotes
+	return st, nil
 }
 
-func (s *S) applyNumUpvotesToCardChain(id string, cardsById map[string]*data.RetroCard, numVotes uint) {
-	idWithoutPk := s.getIdWithoutPk(id)
-	pk := 0
+// StoreFacilitator makes the participant the facilitator of the room. It
+// must be called once the room's template is stored.
+func (s *S) StoreFacilitator(ctx context.Context, rId, pId string) error {
+	ctx, span := tr.Start(ctx, "store facilitator")
+	defer span.End()
 
-	for {
-		currId := fmt.Sprintf("%s%s%d", idWithoutPk, pkPrefix, pk)
+	t, err := s.Template(ctx, rId)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
 
-		card, ok := cardsById[currId]
-		if !ok {
-			break
+	uf := func(v []byte) ([]byte, error) {
+		var st *data.State
+
+		if v == nil {
+			st = t.NewState(rId)
+		} else {
+			st = &data.State{}
+			if err := json.Unmarshal(v, st); err != nil {
+				return nil, err
+			}
 		}
 
-		card.NumVotes = numVotes
-		pk++
+		st.FacilitatorId = pId
+
+		return json.Marshal(st)
+	}
+
+	if err := s.b.Update(ctx, s.getKey(sPrefix, rId), uf); err != nil {
+		span.RecordError(err)
+		return err
 	}
+
+	return nil
 }
 
-func (s *S) getIdWithoutPk(id string) string {
-	return id[:strings.LastIndex(id, pkPrefix)]
+// Facilitator returns the id of the room's facilitator, which is empty
+// for rooms without one.
+func (s *S) Facilitator(ctx context.Context, rId string) (string, error) {
+	ctx, span := tr.Start(ctx, "get facilitator")
+	defer span.End()
+
+	st, err := s.State(ctx, rId)
+	if err != nil {
+		switch err.(type) {
+		case DataDoesNotExistError:
+			return "", nil
+		default:
+			span.RecordError(err)
+			return "", err
+		}
+	}
+
+	return st.FacilitatorId, nil
 }
 
 func (s *S) StoreHashedPassword(ctx context.Context, rId, h string) error {
@@ -495,12 +749,28 @@ func (s *S) StoreHashedPassword(ctx context.Context, rId, h string) error {
 
 	k := s.getKey(pPrefix, rId)
 
-	didSet, err := s.d.SetNX(ctx, k, []byte(h), 0).Result()
+	didSet, err := s.b.SetNX(ctx, k, []byte(h), 0)
 	if err != nil {
 		span.RecordError(err)
 		return err
 	}
 
+	// A room that expired can be created again before it is swept.
+	if !didSet {
+		if _, ok := s.checkExpiry(ctx, rId).(RoomExpiredError); ok {
+			if err := s.DeleteRoom(ctx, rId); err != nil {
+				span.RecordError(err)
+				return err
+			}
+
+			didSet, err = s.b.SetNX(ctx, k, []byte(h), 0)
+			if err != nil {
+				span.RecordError(err)
+				return err
+			}
+		}
+	}
+
 	if !didSet {
 		err := DataAlreadyExistsError{fmt.Errorf("room '%s' already exists", rId)}
 		span.RecordError(err)
@@ -508,6 +778,21 @@ func (s *S) StoreHashedPassword(ctx context.Context, rId, h string) error {
 		return err
 	}
 
+	// The room is claimed by its password, so any key left over from a
+	// room with the same id, such as a state recreated by a late operation,
+	// is removed for the room to start empty.
+	ks := make([]string, 0, len(roomPrefixes)-1)
+	for _, p := range roomPrefixes {
+		if p != pPrefix {
+			ks = append(ks, s.getKey(p, rId))
+		}
+	}
+
+	if err := s.b.Delete(ctx, ks...); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
 	return nil
 }
 
@@ -517,12 +802,12 @@ func (s *S) HashedPassword(ctx context.Context, rId string) (string, error) {
 
 	k := s.getKey(pPrefix, rId)
 
-	h, err := s.d.Get(ctx, k).Result()
+	h, err := s.b.Get(ctx, k)
 	if err != nil {
 		span.RecordError(err)
 
-		switch err {
-		case redis.Nil:
+		switch err.(type) {
+		case DataDoesNotExistError:
 			err := DataDoesNotExistError{fmt.Errorf("room '%s' does not exist", rId)}
 			span.RecordError(err)
 
@@ -532,7 +817,442 @@ func (s *S) HashedPassword(ctx context.Context, rId string) (string, error) {
 		}
 	}
 
-	return h, nil
+	if err := s.checkExpiry(ctx, rId); err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	return string(h), nil
+}
+
+// ChangeHashedPassword replaces the room's hashed password and rotates its
+// token generation, so tokens issued with the old password stop working.
+// It returns the new generation.
+func (s *S) ChangeHashedPassword(ctx context.Context, rId, h string) (string, error) {
+	ctx, span := tr.Start(ctx, "change hashed password")
+	defer span.End()
+
+	if err := s.b.Update(ctx, s.getKey(pPrefix, rId), func(v []byte) ([]byte, error) {
+		if v == nil {
+			return nil, DataDoesNotExistError{
+				fmt.Errorf("room '%s' does not exist", rId),
+			}
+		}
+
+		return []byte(h), nil
+	}); err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	gen, err := s.RotateTokenGeneration(ctx, rId)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	return gen, nil
+}
+
+// TokenGeneration returns the generation that tokens for the room must
+// carry. Rooms that never rotated their generation have an empty one.
+func (s *S) TokenGeneration(ctx context.Context, rId string) (string, error) {
+	ctx, span := tr.Start(ctx, "get token generation")
+	defer span.End()
+
+	if _, err := s.b.Get(ctx, s.getKey(pPrefix, rId)); err != nil {
+		switch err.(type) {
+		case DataDoesNotExistError:
+			err := DataDoesNotExistError{fmt.Errorf("room '%s' does not exist", rId)}
+			span.RecordError(err)
+
+			return "", err
+		default:
+			span.RecordError(err)
+			return "", err
+		}
+	}
+
+	v, err := s.b.Get(ctx, s.getKey(gPrefix, rId))
+	if err != nil {
+		switch err.(type) {
+		case DataDoesNotExistError:
+			return "", nil
+		default:
+			span.RecordError(err)
+			return "", err
+		}
+	}
+
+	return string(v), nil
+}
+
+// RotateTokenGeneration gives the room a new token generation, which
+// invalidates every token issued for it before. It returns the new
+// generation.
+func (s *S) RotateTokenGeneration(ctx context.Context, rId string) (string, error) {
+	ctx, span := tr.Start(ctx, "rotate token generation")
+	defer span.End()
+
+	gen := uuid.New().String()
+
+	if err := s.b.Update(ctx, s.getKey(gPrefix, rId), func([]byte) ([]byte, error) {
+		return []byte(gen), nil
+	}); err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	return gen, nil
+}
+
+// StoreTemplate stores the template chosen for the room, along with the
+// empty board built from it, so every client starts from the same columns.
+func (s *S) StoreTemplate(ctx context.Context, rId string, t *data.Template) error {
+	ctx, span := tr.Start(ctx, "store template")
+	defer span.End()
+
+	tByt, err := json.Marshal(t)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	didSet, err := s.b.SetNX(ctx, s.getKey(tPrefix, rId), tByt, 0)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if !didSet {
+		err := DataAlreadyExistsError{
+			fmt.Errorf("template for room '%s' already exists", rId),
+		}
+		span.RecordError(err)
+
+		return err
+	}
+
+	stByt, err := json.Marshal(t.NewState(rId))
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	didSet, err = s.b.SetNX(ctx, s.getKey(sPrefix, rId), stByt, 0)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if !didSet {
+		err := DataAlreadyExistsError{
+			fmt.Errorf("state for room '%s' already exists", rId),
+		}
+		span.RecordError(err)
+
+		return err
+	}
+
+	return nil
+}
+
+// Template returns the room's template. Rooms created before templates
+// existed do not have one stored, so they use the default template.
+func (s *S) Template(ctx context.Context, rId string) (*data.Template, error) {
+	ctx, span := tr.Start(ctx, "get template")
+	defer span.End()
+
+	v, err := s.b.Get(ctx, s.getKey(tPrefix, rId))
+	if err != nil {
+		switch err.(type) {
+		case DataDoesNotExistError:
+			return data.DefaultTemplate(), nil
+		default:
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	var t data.Template
+	if err := json.Unmarshal(v, &t); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// StoreSettings stores the settings chosen for the room.
+func (s *S) StoreSettings(ctx context.Context, rId string, st *data.Settings) error {
+	ctx, span := tr.Start(ctx, "store settings")
+	defer span.End()
+
+	byt, err := json.Marshal(st)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	didSet, err := s.b.SetNX(ctx, s.getKey(cPrefix, rId), byt, 0)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if !didSet {
+		err := DataAlreadyExistsError{
+			fmt.Errorf("settings for room '%s' already exist", rId),
+		}
+		span.RecordError(err)
+
+		return err
+	}
+
+	return nil
+}
+
+// Settings returns the room's settings. Rooms created before settings
+// existed do not have them stored, so they use the zero settings.
+func (s *S) Settings(ctx context.Context, rId string) (*data.Settings, error) {
+	ctx, span := tr.Start(ctx, "get settings")
+	defer span.End()
+
+	v, err := s.b.Get(ctx, s.getKey(cPrefix, rId))
+	if err != nil {
+		switch err.(type) {
+		case DataDoesNotExistError:
+			return &data.Settings{}, nil
+		default:
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+
+	var st data.Settings
+	if err := json.Unmarshal(v, &st); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return &st, nil
+}
+
+// StoreExpiry starts tracking when the room expires. An idle duration of
+// zero uses the retention policy's.
+func (s *S) StoreExpiry(ctx context.Context, rId string, idle time.Duration) error {
+	ctx, span := tr.Start(ctx, "store expiry")
+	defer span.End()
+
+	if idle == 0 {
+		idle = s.r.Idle
+	}
+
+	eByt, err := json.Marshal(data.NewExpiry(time.Now().UTC(), idle, s.r.MaxAge))
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	didSet, err := s.b.SetNX(ctx, s.getKey(ePrefix, rId), eByt, 0)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if !didSet {
+		err := DataAlreadyExistsError{
+			fmt.Errorf("expiry for room '%s' already exists", rId),
+		}
+		span.RecordError(err)
+
+		return err
+	}
+
+	return nil
+}
+
+// Expiry returns when the room expires. Rooms created before expiry
+// existed do not have one until their next activity.
+func (s *S) Expiry(ctx context.Context, rId string) (*data.Expiry, error) {
+	ctx, span := tr.Start(ctx, "get expiry")
+	defer span.End()
+
+	v, err := s.b.Get(ctx, s.getKey(ePrefix, rId))
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	var e data.Expiry
+	if err := json.Unmarshal(v, &e); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// checkRoom returns a DataDoesNotExistError if the room does not exist, so
+// messages queued before the room was deleted do not recreate its state,
+// and a RoomExpiredError if it expired.
+func (s *S) checkRoom(ctx context.Context, rId string) error {
+	if _, err := s.b.Get(ctx, s.getKey(pPrefix, rId)); err != nil {
+		switch err.(type) {
+		case DataDoesNotExistError:
+			return DataDoesNotExistError{fmt.Errorf("room '%s' does not exist", rId)}
+		default:
+			return err
+		}
+	}
+
+	return s.checkExpiry(ctx, rId)
+}
+
+// checkExpiry returns a RoomExpiredError if the room expired, even if the
+// sweeper has not removed it yet.
+func (s *S) checkExpiry(ctx context.Context, rId string) error {
+	e, err := s.Expiry(ctx, rId)
+	if err != nil {
+		switch err.(type) {
+		case DataDoesNotExistError:
+			return nil
+		default:
+			return err
+		}
+	}
+
+	if e.Expired(time.Now()) {
+		return RoomExpiredError{fmt.Errorf("room '%s' expired", rId)}
+	}
+
+	return nil
+}
+
+// touch records activity in the room. Rooms without an expiry get one
+// from the retention policy.
+func (s *S) touch(ctx context.Context, rId string) error {
+	ctx, span := tr.Start(ctx, "touch")
+	defer span.End()
+
+	now := time.Now().UTC()
+
+	if e, err := s.Expiry(ctx, rId); err == nil && now.Sub(e.LastActivity) < touchInterval {
+		return nil
+	}
+
+	if err := s.b.Update(ctx, s.getKey(ePrefix, rId), func(v []byte) ([]byte, error) {
+		if v == nil {
+			return json.Marshal(data.NewExpiry(now, s.r.Idle, s.r.MaxAge))
+		}
+
+		var e data.Expiry
+		if err := json.Unmarshal(v, &e); err != nil {
+			return nil, err
+		}
+
+		e.Touch(now)
+
+		return json.Marshal(&e)
+	}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	tId, err := s.Team(ctx, rId)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if err := s.touchTeam(ctx, tId); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
+}
+
+// Sweep removes every key of the rooms that expired, returning how many
+// rooms were removed, and every other expired key of backends that do not
+// expire keys on their own.
+func (s *S) Sweep(ctx context.Context) (int, error) {
+	ctx, span := tr.Start(ctx, "sweep")
+	defer span.End()
+
+	ks, err := s.b.Keys(ctx, ePrefix)
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+
+	var n int
+
+	for _, k := range ks {
+		rId := strings.TrimPrefix(k, ePrefix)
+
+		err := s.checkExpiry(ctx, rId)
+		if _, ok := err.(RoomExpiredError); !ok {
+			if err != nil {
+				span.RecordError(err)
+			}
+
+			continue
+		}
+
+		if err := s.DeleteRoom(ctx, rId); err != nil {
+			span.RecordError(err)
+			return n, err
+		}
+
+		n++
+	}
+
+	// Keys outside of rooms, like the limits of addresses, expire too.
+	if p, ok := s.b.(Purger); ok {
+		if _, err := p.Purge(ctx); err != nil {
+			span.RecordError(err)
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// SweepEvery sweeps expired rooms every interval until the context is
+// done.
+func (s *S) SweepEvery(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			_, _ = s.Sweep(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// DeleteRoom removes every key of the room. Since its token generation is
+// removed with it, tokens issued for the room stop working, even if a room
+// with the same id is created again.
+func (s *S) DeleteRoom(ctx context.Context, rId string) error {
+	ctx, span := tr.Start(ctx, "delete room")
+	defer span.End()
+
+	ks := make([]string, 0, len(roomPrefixes))
+	for _, p := range roomPrefixes {
+		ks = append(ks, s.getKey(p, rId))
+	}
+
+	if err := s.b.Delete(ctx, ks...); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	return nil
 }
 
 func (s *S) getKey(prefix string, identifier string) string {
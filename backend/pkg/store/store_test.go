@@ -922,6 +922,8 @@ func TestRedisDataStoreState(t *testing.T) {
 			),
 		},
 		{
+			// The votes of the state's cards are not trusted, so only its
+			// action is counted.
 			Name: "Upvote State Ahead",
 			OldState: []byte(`
 {
@@ -1103,7 +1105,7 @@ func TestRedisDataStoreState(t *testing.T) {
                             "id": "4a552ac9-c792-458c-bb13-0e9b300475fd-pk-0",
                             "columnId": "0",
                             "message": "hello",
-                            "numVotes": 3,
+                            "numVotes": 2,
                             "isEditable": false,
                             "groupId": "default",
                             "isDeleted": false,
@@ -2181,7 +2183,13 @@ func TestRedisDataStoreState(t *testing.T) {
 
 			s := &S{}
 
-			ms, err := s.mergeState(context.Background(), &os, &st)
+			ms, _, err := s.mergeState(
+				context.Background(),
+				data.DefaultTemplate(),
+				&data.Voting{},
+				&os,
+				&st,
+			)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -2210,6 +2218,98 @@ func TestRedisDataStoreState(t *testing.T) {
 	}
 }
 
+func TestMergeStateTemplate(t *testing.T) {
+	t.Parallel()
+
+	tmpl := &data.Template{
+		Name: "test",
+		Columns: []*data.TemplateColumn{
+			{Id: "0", Title: "Good", CardStyle: &data.CardStyle{BackgroundColor: "bg-success"}},
+			{Id: "1", Title: "Bad", CardStyle: &data.CardStyle{BackgroundColor: "bg-danger"}},
+		},
+		MinColumns: 2,
+		MaxColumns: 2,
+	}
+
+	tests := []struct {
+		Name   string
+		Change func(st *data.State)
+		Valid  bool
+	}{
+		{Name: "Same Columns", Change: func(st *data.State) {}, Valid: true},
+		{
+			Name: "Added Column",
+			Change: func(st *data.State) {
+				st.Columns = append(st.Columns, &data.Column{
+					Id:        "2",
+					Title:     "Extra",
+					CardStyle: &data.CardStyle{BackgroundColor: "bg-info"},
+					Groups: []*data.Group{
+						{Id: "default", ColumnId: "2", Title: "ungrouped cards", RetroCards: []*data.RetroCard{}},
+					},
+				})
+			},
+			Valid: false,
+		},
+		{
+			Name:   "Removed Column",
+			Change: func(st *data.State) { st.Columns = st.Columns[:1] },
+			Valid:  false,
+		},
+		{
+			Name: "Reordered Columns",
+			Change: func(st *data.State) {
+				st.Columns[0], st.Columns[1] = st.Columns[1], st.Columns[0]
+			},
+			Valid: false,
+		},
+		{
+			Name:   "Renamed Column",
+			Change: func(st *data.State) { st.Columns[1].Title = "Renamed" },
+			Valid:  false,
+		},
+		{
+			Name:   "Changed Column Id",
+			Change: func(st *data.State) { st.Columns[1].Id = "5" },
+			Valid:  false,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			os := tmpl.NewState("testroom")
+			st := tmpl.NewState("testroom")
+			test.Change(st)
+
+			s := &S{}
+
+			ms, _, err := s.mergeState(context.Background(), tmpl, &data.Voting{}, os, st)
+			if !test.Valid {
+				// invalid states can never be stored, so they must not be
+				// retried
+				if _, ok := err.(data.TemplateInvalidError); !ok {
+					t.Fatalf("expected a template error, got: %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, c := range ms.Columns {
+				if c.Id != tmpl.Columns[i].Id || c.Title != tmpl.Columns[i].Title {
+					t.Fatalf("expected the template's columns, got: %+v", c)
+				}
+			}
+		})
+	}
+}
+
 func prettify(t *testing.T, v interface{}) (interface{}, error) {
 	t.Helper()
 
@@ -10,6 +10,10 @@ const uKey key = "user"
 
 type U struct {
 	RoomId string
+	// ParticipantId and DisplayName are empty for tokens issued before
+	// participants had ids.
+	ParticipantId string
+	DisplayName   string
 }
 
 func FromContext(ctx context.Context) (U, bool) {
@@ -23,50 +23,8 @@ const store = new Vuex.Store({
     connected: false,
     errorMessage: "",
     roomId: getRoomIdFromQueryString(),
-    columns: [
-      {
-        id: "0",
-        title: "Good",
-        cardStyle: {
-          backgroundColor: "bg-success",
-        },
-        groups: [{
-          id: "default",
-          columnId: "0",
-          isEditable: false,
-          title: "ungrouped cards",
-          retroCards: [],
-        }],
-      },
-      {
-        id: "1",
-        title: "Bad",
-        cardStyle: {
-          backgroundColor: "bg-danger",
-        },
-        groups: [{
-          id: "default",
-          columnId: "1",
-          isEditable: false,
-          title: "ungrouped cards",
-          retroCards: [],
-        }],
-      },
-      {
-        id: "3",
-        title: "Actions",
-        cardStyle: {
-          backgroundColor: "bg-primary",
-        },
-        groups: [{
-          id: "default",
-          columnId: "3",
-          isEditable: false,
-          title: "ungrouped cards",
-          retroCards: [],
-        }],
-      },
-    ],
+    // the columns of the room's template are sent in the snapshot
+    columns: [],
   },
   mutations: mutations,
   getters: {
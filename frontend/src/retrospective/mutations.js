@@ -1,7 +1,8 @@
 import * as helpers from './mutationsHelpers.js'
 
 export function connect(state) {
-  state.ws = new WebSocket("wss://" + window.location.host + "/api/" + state.apiVersion + "/retrospectives/" + state.roomId);
+  const scheme = window.location.protocol === "https:" ? "wss://" : "ws://"
+  state.ws = new WebSocket(scheme + window.location.host + "/api/" + state.apiVersion + "/retrospectives/" + state.roomId);
 }
 
 export function setConnected(state, status) {
@@ -52,10 +53,21 @@ export function updateGroupTitle(state, payload) {
     if (newState.columns[i].id === group.columnId) {
       for (let j = 0; j < newState.columns[i].groups.length; j++) {
         if (newState.columns[i].groups[j].id === group.id) {
+          let isNew = newState.columns[i].groups[j].isEditable
           newState.columns[i].groups[j] = group
           helpers.updateLocalColumns(state, newState.columns)
-          if (send) {
-            helpers.sendState(state.ws, newState)
+          if (send && isNew) {
+            helpers.sendOperation(state.ws, state.roomId, {
+              type: "createGroup",
+              group: { id: group.id, columnId: group.columnId, title: group.title, retroCards: [] },
+            })
+          } else if (send) {
+            helpers.sendOperation(state.ws, state.roomId, {
+              type: "renameGroup",
+              columnId: group.columnId,
+              groupId: group.id,
+              title: group.title,
+            })
           }
           return
         }
@@ -82,6 +94,7 @@ export function switchCardGroup(state, payload) {
   let newCard = JSON.parse(JSON.stringify(newRetroCards[0]))
 
   let changeOccurred = false
+  let movedCard = null
   for (let i = 0; i < newRetroCards.length; i++) {
     let cardFound = false
     for (let j = 0; j < group.retroCards.length; j++) {
@@ -117,6 +130,7 @@ export function switchCardGroup(state, payload) {
       }
 
       changeOccurred = true
+      movedCard = JSON.parse(JSON.stringify(newRetroCards[i]))
       break
     }
   }
@@ -153,9 +167,12 @@ export function switchCardGroup(state, payload) {
     // update the local state so that order is maintained locally
     helpers.updateLocalColumns(state, newState.columns)
 
-    // since this modifies cards across groups, send the state to others
     if (send) {
-      helpers.sendState(state.ws, newState)
+      helpers.sendOperation(state.ws, state.roomId, {
+        type: "moveCard",
+        cardId: newCard.id,
+        card: movedCard,
+      })
     }
   }
 }
@@ -179,10 +196,11 @@ export function switchCardInSameGroup(state, payload) {
 }
 
 export function updateRetroCard(state, payload) {
-  let { card, action, send } = payload
+  let { card, send } = payload
 
   let newState = JSON.parse(JSON.stringify(state))
-  newState.action = action
+
+  let isNew = false
 
   loop:
   for (let i = 0; i < newState.columns.length; i++) {
@@ -191,6 +209,7 @@ export function updateRetroCard(state, payload) {
         if (newState.columns[i].groups[j].id === card.groupId) {
           for (let k = 0; k < newState.columns[i].groups[j].retroCards.length; k++) {
             if (newState.columns[i].groups[j].retroCards[k].id === card.id) {
+              isNew = newState.columns[i].groups[j].retroCards[k].isEditable
               newState.columns[i].groups[j].retroCards[k] = card
               break loop
             }
@@ -200,31 +219,40 @@ export function updateRetroCard(state, payload) {
     }
   }
 
-  if (newState.action && newState.action.title === "upVote") {
-    let cardsById = {}
-    for (let i = 0; i < newState.columns.length; i++) {
-      for (let j = 0; j < newState.columns[i].groups.length; j++) {
-        for (let k = 0; k < newState.columns[i].groups[j].retroCards.length; k++) {
-          cardsById[newState.columns[i].groups[j].retroCards[k].id] = newState.columns[i].groups[j].retroCards[k]
-        }
-      }
-    }
-
-    let numVotes = getMaxNumUpvotesInCardChain(action.newCard.id, cardsById)
-    applyNumUpvotesToCardChain(action.newCard.id, cardsById, numVotes)
-  }
-
   helpers.updateLocalColumns(state, newState.columns)
-  if (send) {
-    helpers.sendState(state.ws, newState)
+  if (send && isNew) {
+    helpers.sendOperation(state.ws, state.roomId, { type: "addCard", card: card })
+  } else if (send) {
+    helpers.sendOperation(state.ws, state.roomId, {
+      type: "editCard",
+      cardId: card.id,
+      message: card.message,
+      lastModified: card.lastModified,
+    })
   }
   return
 }
 
+// vote sends the vote for the card. Votes are only counted once the server
+// broadcasts them, since it may reject them.
+export function vote(state, card) {
+  helpers.sendOperation(state.ws, state.roomId, { type: "vote", cardId: card.id })
+}
+
+export function applyOperation(state, op) {
+  let newState = JSON.parse(JSON.stringify(state))
+  helpers.applyOperation(newState.columns, op)
+  helpers.updateLocalColumns(state, newState.columns)
+}
+
 export function updateColumns(state, columns) {
   let newNonEditableCards = []
   for (let i = 0; i < columns.length; i++) {
-    let { mergedGroups, newCards } = helpers.mergeGroups(state.columns[i].groups, columns[i].groups)
+    // the columns are the ones of the room's template, so they are taken
+    // from the server, keeping the local groups of the column with the same id
+    let local = state.columns.find(column => column.id === columns[i].id)
+    let groups = local ? local.groups : []
+    let { mergedGroups, newCards } = helpers.mergeGroups(groups, columns[i].groups)
     columns[i].groups = mergedGroups
     for (let j = 0; j < newCards.length; j++) {
       newNonEditableCards.push(newCards[j])
@@ -281,7 +309,9 @@ export function updateColumns(state, columns) {
         }
       }
 
-      cardsToDelete = [...cardsToDelete, ...duplicates[key].splice(cardToKeepIndex, 1)]
+      // every copy but the one to keep is deleted
+      duplicates[key].splice(cardToKeepIndex, 1)
+      cardsToDelete = [...cardsToDelete, ...duplicates[key]]
     }
   }
 
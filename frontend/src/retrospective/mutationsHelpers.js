@@ -131,6 +131,9 @@ export function updateLocalColumns(state, columns) {
   state.columns = columns
 }
 
+// sendState sends the whole board. It is the legacy way of syncing the
+// board, kept for clients that do not send operations - changes are sent
+// with sendOperation instead.
 export function sendState(ws, state) {
   let newState = JSON.parse(JSON.stringify(state))
   for (let i = 0; i < newState.columns.length; i++) {
@@ -139,5 +142,127 @@ export function sendState(ws, state) {
       newState.columns[i].groups[j].retroCards = newState.columns[i].groups[j].retroCards.filter(card => !card.isEditable)
     }
   }
-  ws.send(JSON.stringify(newState));
+  sendMessage(ws, { type: "state", state: newState })
+}
+
+// every message over the websocket is an envelope with its type
+export function sendMessage(ws, message) {
+  ws.send(JSON.stringify(message))
+}
+
+// sendOperation sends a single change to the board. The server broadcasts
+// the operations it applies to every client of the room, this one included.
+export function sendOperation(ws, roomId, op) {
+  op.roomId = roomId
+  sendMessage(ws, { type: "operation", operation: op })
+}
+
+const pkSeparator = "-pk-"
+
+function findGroup(columns, columnId, groupId) {
+  for (let i = 0; i < columns.length; i++) {
+    if (columns[i].id === columnId) {
+      for (let j = 0; j < columns[i].groups.length; j++) {
+        if (columns[i].groups[j].id === groupId) {
+          return columns[i].groups[j]
+        }
+      }
+    }
+  }
+
+  return null
+}
+
+function findCards(columns, match) {
+  let cards = []
+  for (let i = 0; i < columns.length; i++) {
+    for (let j = 0; j < columns[i].groups.length; j++) {
+      for (let k = 0; k < columns[i].groups[j].retroCards.length; k++) {
+        if (match(columns[i].groups[j].retroCards[k])) {
+          cards.push(columns[i].groups[j].retroCards[k])
+        }
+      }
+    }
+  }
+
+  return cards
+}
+
+function findCard(columns, id) {
+  let cards = findCards(columns, card => !card.isEditable && card.id === id)
+  return cards.length > 0 ? cards[0] : null
+}
+
+function findCardChain(columns, id) {
+  let chainId = id.substring(0, id.lastIndexOf(pkSeparator) + pkSeparator.length)
+  return findCards(columns, card => !card.isEditable && card.id.startsWith(chainId))
+}
+
+function addCard(columns, card) {
+  let group = findGroup(columns, card.columnId, card.groupId)
+  if (group === null || findCard(columns, card.id) !== null) {
+    return
+  }
+
+  card.isEditable = false
+  group.retroCards.unshift(card)
+}
+
+// applyOperation changes the columns the way the server changed the board
+// when it applied the operation. Operations that do not change the cards
+// or groups are ignored.
+export function applyOperation(columns, op) {
+  switch (op.type) {
+    case "addCard":
+      addCard(columns, op.card)
+      break
+    case "editCard": {
+      let card = findCard(columns, op.cardId)
+      if (card !== null) {
+        card.message = op.message
+        card.lastModified = op.lastModified
+      }
+      break
+    }
+    case "moveCard": {
+      let card = findCard(columns, op.cardId)
+      if (card !== null) {
+        card.isDeleted = true
+        card.lastModified = op.card.lastModified
+        op.card.numVotes = card.numVotes
+      }
+      addCard(columns, op.card)
+      break
+    }
+    case "deleteCard": {
+      let card = findCard(columns, op.cardId)
+      if (card !== null) {
+        card.isDeleted = true
+        card.lastModified = op.lastModified
+      }
+      break
+    }
+    case "vote":
+    case "unvote": {
+      let chain = findCardChain(columns, op.cardId)
+      for (let i = 0; i < chain.length; i++) {
+        chain[i].numVotes = Math.max(chain[i].numVotes + (op.type === "vote" ? 1 : -1), 0)
+      }
+      break
+    }
+    case "createGroup":
+      for (let i = 0; i < columns.length; i++) {
+        if (columns[i].id === op.group.columnId && findGroup(columns, op.group.columnId, op.group.id) === null) {
+          columns[i].groups.push(op.group)
+        }
+      }
+      break
+    case "renameGroup": {
+      let group = findGroup(columns, op.columnId, op.groupId)
+      if (group !== null) {
+        group.title = op.title
+      }
+      break
+    }
+  }
 }
\ No newline at end of file